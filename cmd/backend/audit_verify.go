@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"secure-file-drop/internal/config"
+	"secure-file-drop/internal/server"
+)
+
+// runAuditVerify implements "backend audit-verify [--config file]": it
+// checks the audit_events hash chain in database.url (DATABASE_URL) and
+// prints the result as JSON. Exit status: 0 intact, 1 could not verify,
+// 2 chain broken.
+func runAuditVerify(args []string) int {
+	fs := flag.NewFlagSet("audit-verify", flag.ExitOnError)
+	configPath := configFlag(fs)
+	_ = fs.Parse(args)
+
+	cfg, err := config.Load(*configPath, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit-verify: %v\n", err)
+		return 1
+	}
+	dbConn, err := server.OpenDB(cfg.Database.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit-verify: db connect failed: %v\n", err)
+		return 1
+	}
+	defer func() { _ = dbConn.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	res, err := server.VerifyAuditChain(ctx, dbConn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit-verify: %v\n", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(res)
+	if !res.OK {
+		return 2
+	}
+	return 0
+}
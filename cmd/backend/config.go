@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"secure-file-drop/internal/config"
+	"secure-file-drop/internal/server"
+	"secure-file-drop/web"
+)
+
+// configFlag registers --config on fs. SFD_CONFIG supplies the default so
+// containers can point at a mounted file without changing the command.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", os.Getenv("SFD_CONFIG"), "YAML or TOML configuration file (default $SFD_CONFIG); environment variables override it")
+}
+
+// applyProcessSettings pushes the settings that the server package keeps
+// process-wide: password hashing cost, the sfd-hash path and proxy trust.
+func applyProcessSettings(c config.Config) error {
+	pw := server.DefaultPasswordParams()
+	pw.Memory = c.Password.MemoryKiB
+	pw.Iterations = c.Password.Iterations
+	pw.Parallelism = c.Password.Parallelism
+	if err := pw.Validate(); err != nil {
+		return err
+	}
+	server.SetPasswordParams(pw)
+	server.SetHashTool(c.Hash.Tool)
+	server.SetTrustProxyHeaders(c.Server.TrustProxyHeaders, c.Server.TrustedProxyHops)
+	return nil
+}
+
+// runtimeSettings extracts the settings a running server can reload.
+func runtimeSettings(c config.Config) (server.RuntimeSettings, error) {
+	lc, err := server.ParseLogConfig(c.Log.Format, c.Log.Level)
+	if err != nil {
+		return server.RuntimeSettings{}, err
+	}
+	return server.RuntimeSettings{
+		MaxUploadBytes:  int64(c.Server.MaxUploadBytes),
+		CleanupEnabled:  c.Cleanup.Enabled,
+		CleanupInterval: c.Cleanup.Interval,
+		CleanupMaxAge:   c.Cleanup.MaxAge,
+		RateLimits:      c.RateLimit.Rules,
+		LogLevel:        lc.Level,
+	}, nil
+}
+
+// reloadSource re-reads the configuration file for SIGHUP and
+// /admin/config/reload. The environment of a running process does not
+// change, so in practice reloads pick up file edits. The new configuration
+// must pass the same validation as at startup; changes outside the
+// reloadable subset are reported against the startup configuration.
+func reloadSource(path string, startup config.Config) server.ReloadSource {
+	return func() (server.RuntimeSettings, []string, error) {
+		next, err := config.Load(path, nil)
+		if err != nil {
+			return server.RuntimeSettings{}, nil, err
+		}
+		if err := next.Validate(); err != nil {
+			return server.RuntimeSettings{}, nil, err
+		}
+		rs, err := runtimeSettings(next)
+		if err != nil {
+			return server.RuntimeSettings{}, nil, err
+		}
+		return rs, startup.RestartRequired(next), nil
+	}
+}
+
+// serverConfig maps the validated configuration onto server.Config.
+func serverConfig(c config.Config, db *sql.DB) (server.Config, error) {
+	auth := server.AuthConfig{
+		AdminUser:     c.Auth.AdminUser,
+		AdminPass:     c.Auth.AdminPass,
+		SessionSecret: c.Auth.SessionSecret,
+		SessionTTL:    c.Auth.SessionTTL,
+		CookieName:    "sfd_session",
+		CookieSecure:  c.Auth.CookieSecure,
+		DB:            db,
+	}
+
+	registration, err := server.NewRegistrationPolicy(c.Registration.Mode, c.Registration.Domains)
+	if err != nil {
+		return server.Config{}, err
+	}
+	tracing, err := server.NewTracingConfig(c.Trace.Exporter, c.Trace.File)
+	if err != nil {
+		return server.Config{}, err
+	}
+	// The embedded UI, unless a directory replaces it or the UI is off.
+	var webFS fs.FS
+	var webDir string
+	if c.Server.WebEnabled {
+		webFS, webDir = web.Static(), c.Server.WebDir
+	}
+	rateLimit := server.RateLimitConfig{
+		Disabled: !c.RateLimit.Enabled,
+		Store:    c.RateLimit.Store,
+		Rules:    c.RateLimit.Rules,
+	}
+	limiter, err := server.NewRateLimiter(db, auth, rateLimit)
+	if err != nil {
+		return server.Config{}, err
+	}
+
+	return server.Config{
+		Addr:  c.Server.Addr,
+		Build: server.BuildInfo{Version: c.Build.Version, Commit: c.Build.Commit},
+		Auth:  auth,
+		DB:    db,
+		Mailer: server.NewMailer(server.MailConfig{
+			SMTPAddr: c.Mail.SMTPAddr,
+			From:     c.Mail.From,
+			Username: c.Mail.Username,
+			Password: c.Mail.Password,
+			Dir:      c.Mail.Dir,
+		}),
+		PublicBaseURL:  c.Server.PublicBaseURL,
+		MaxUploadBytes: int64(c.Server.MaxUploadBytes),
+		DownloadSecret: c.Downloads.Secret,
+		WebFS:          webFS,
+		WebDir:         webDir,
+		Storage: server.StorageConfig{
+			Endpoint:  c.Storage.Endpoint,
+			AccessKey: c.Storage.AccessKey,
+			SecretKey: c.Storage.SecretKey,
+			Bucket:    c.Storage.Bucket,
+		},
+		Cleanup: server.CleanupConfig{
+			Enabled:  c.Cleanup.Enabled,
+			Interval: c.Cleanup.Interval,
+			MaxAge:   c.Cleanup.MaxAge,
+		},
+		RateLimiter:  limiter,
+		RateLimit:    rateLimit,
+		Registration: &registration,
+		Metrics:      &server.MetricsConfig{Token: c.Metrics.Token, Addr: c.Metrics.Addr},
+		Tracing:      &tracing,
+		Logger:       slog.Default(),
+		Health:       &server.HealthConfig{CacheTTL: c.Health.ReadyCacheTTL, DrainDelay: c.Health.DrainDelay, UploadDrain: c.Health.UploadDrain},
+		TLS: &server.TLSConfig{
+			CertFile:         c.TLS.CertFile,
+			KeyFile:          c.TLS.KeyFile,
+			ACMEDomains:      c.TLS.ACMEDomains,
+			ACMEEmail:        c.TLS.ACMEEmail,
+			ACMECacheDir:     c.TLS.ACMECacheDir,
+			ACMEDirectoryURL: c.TLS.ACMEDirectoryURL,
+			ACMECAFile:       c.TLS.ACMECAFile,
+			RedirectAddr:     c.TLS.RedirectAddr,
+			HSTSMaxAge:       c.TLS.HSTSMaxAge,
+		},
+	}, nil
+}
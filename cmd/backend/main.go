@@ -2,72 +2,117 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"secure-file-drop/internal/config"
 	"secure-file-drop/internal/db"
 	"secure-file-drop/internal/server"
 )
 
 func main() {
-	addr := getenvDefault("SFD_ADDR", ":8080")
+	// Maintenance subcommands run instead of the server.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "audit-verify":
+			os.Exit(runAuditVerify(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q (available: audit-verify, validate)\n", os.Args[1])
+			os.Exit(1)
+		}
+	}
+
+	fs := flag.NewFlagSet("backend", flag.ExitOnError)
+	configPath := configFlag(fs)
+	printConfig := fs.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	_ = fs.Parse(os.Args[1:])
 
-	build := server.BuildInfo{
-		Version: getenvDefault("SFD_VERSION", "dev"),
-		Commit:  getenvDefault("SFD_COMMIT", "unknown"),
+	// Load and validate everything up front: a bad value must stop the
+	// process here, not surface on the first upload or link.
+	cfg, err := config.Load(*configPath, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		if err := cfg.WriteYAML(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		return
 	}
 
-	auth := server.AuthConfig{
-		AdminUser:     getenvDefault("SFD_ADMIN_USER", "admin"),
-		AdminPass:     getenvDefault("SFD_ADMIN_PASS", ""),
-		SessionSecret: getenvDefault("SFD_SESSION_SECRET", ""),
-		SessionTTL:    12 * time.Hour,
-		CookieName:    "sfd_session",
+	// Structured logging first, so every later line uses it. slog.SetDefault
+	// also routes the standard log package through the same handler.
+	logCfg, err := server.ParseLogConfig(cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging configuration: %v\n", err)
+		os.Exit(1)
+	}
+	logCfg.LevelVar = new(slog.LevelVar) // changed by configuration reloads
+	logCfg.LevelVar.Set(logCfg.Level)
+	slog.SetDefault(server.NewLogger(os.Stderr, logCfg))
+	logger := slog.Default().With("component", "backend")
+	for _, w := range cfg.Warnings() {
+		logger.Warn("config_warning", "detail", w)
 	}
 
-	// Safety: refuse to start if secrets are missing.
-	if auth.AdminPass == "" || auth.SessionSecret == "" {
-		log.Printf("service=backend msg=%q", "missing SFD_ADMIN_PASS or SFD_SESSION_SECRET")
+	if err := applyProcessSettings(cfg); err != nil {
+		logger.Error("invalid_password_params", "error", err)
 		os.Exit(1)
 	}
 
 	// Database
-	dsn := getenvDefault("DATABASE_URL", "")
-	dbConn, err := server.OpenDB(dsn)
+	dbConn, err := server.OpenDB(cfg.Database.URL)
 	if err != nil {
-		log.Printf("service=backend msg=%q err=%v", "db_connect_failed", err)
+		logger.Error("db_connect_failed", "error", err)
 		os.Exit(1)
 	}
 	defer func() { _ = dbConn.Close() }()
 
 	// Run migrations
-	log.Printf("service=backend msg=%q", "running_migrations")
+	logger.Info("running_migrations")
 	if err := db.RunMigrations(dbConn); err != nil {
-		log.Printf("service=backend msg=%q err=%v", "migration_failed", err)
+		logger.Error("migration_failed", "error", err)
 		os.Exit(1)
 	}
-	log.Printf("service=backend msg=%q", "migrations_complete")
+	logger.Info("migrations_complete")
 
-	// Add database to auth config for user authentication
-	auth.DB = dbConn
+	// Migrate the legacy SFD_ADMIN_USER/SFD_ADMIN_PASS pair into a stored account.
+	if err := server.EnsureAdminUser(context.Background(), dbConn, cfg.Auth.AdminUser, cfg.Auth.AdminEmail, cfg.Auth.AdminPass); err != nil {
+		logger.Error("admin_bootstrap_failed", "error", err)
+		os.Exit(1)
+	}
 
-	srv := server.New(server.Config{
-		Addr:  addr,
-		Build: build,
-		Auth:  auth,
-		DB:    dbConn,
-	})
+	srvCfg, err := serverConfig(cfg, dbConn)
+	if err != nil {
+		logger.Error("invalid_config", "error", err)
+		os.Exit(1)
+	}
+	srvCfg.LogLevel = logCfg.LevelVar
+	srvCfg.Reload = reloadSource(*configPath, cfg)
+	srv := server.New(srvCfg)
+	addr, build := srvCfg.Addr, srvCfg.Build
 
 	// Start the HTTP server in a background goroutine.
 	// This allows us to listen for OS signals while the server runs.
 	errCh := make(chan error, 1)
 	go func() {
-		log.Printf("service=backend msg=%q addr=%s version=%s commit=%s",
-			"starting", addr, build.Version, build.Commit)
+		logger.Info("starting", "addr", addr, "tls", cfg.TLS.Enabled(), "version", build.Version, "commit", build.Commit)
 		errCh <- srv.Start()
 	}()
 
@@ -75,38 +120,40 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
-	// Block until either a shutdown signal is received or the server encounters an error.
-	select {
-	case sig := <-sigCh:
-		// Signal received: initiate graceful shutdown.
-		log.Printf("service=backend msg=%q signal=%s", "shutting_down", sig.String())
-		// Give the server 5 seconds to finish in-flight requests and cleanup.
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("service=backend msg=%q err=%v", "shutdown_error", err)
-			os.Exit(1)
-		}
-		log.Printf("service=backend msg=%q", "shutdown_complete")
-	case err := <-errCh:
-		// Server error: exit immediately.
-		if err != nil {
-			log.Printf("service=backend msg=%q err=%v", "server_error", err)
-			os.Exit(1)
-		}
-	}
-}
+	// SIGHUP reloads the reloadable settings; failures are logged and
+	// audited by the server and leave the running configuration in place.
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
 
-// getenvDefault reads an environment variable and returns a default value if not set.
-// This helper avoids importing extra packages and keeps main.go self-contained.
-// NOTE: kept here for clarity and minimal dependencies.
-func getenvDefault(key, def string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		return def
+	// Block until either a shutdown signal is received or the server
+	// encounters an error; a SIGHUP reloads and keeps waiting.
+	for {
+		select {
+		case <-hupCh:
+			logger.Info("reloading_config", "signal", "SIGHUP")
+			_, _ = srv.Reload(context.Background(), "signal")
+			continue
+		case sig := <-sigCh:
+			// Signal received: initiate graceful shutdown.
+			logger.Info("shutting_down", "signal", sig.String())
+			// After /ready has reported "draining" for the configured delay,
+			// uploads get the upload drain to finish; everything else, and
+			// the interrupted uploads, 5 more seconds.
+			ctx, cancel := context.WithTimeout(context.Background(),
+				cfg.Health.DrainDelay+cfg.Health.UploadDrain+5*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Error("shutdown_error", "error", err)
+				os.Exit(1)
+			}
+			logger.Info("shutdown_complete")
+		case err := <-errCh:
+			// Server error: exit immediately.
+			if err != nil {
+				logger.Error("server_error", "error", err)
+				os.Exit(1)
+			}
+		}
+		return
 	}
-	return v
 }
-
-// Compile-time check to ensure we keep using *sql.DB in the server config.
-var _ *sql.DB
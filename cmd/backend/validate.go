@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"secure-file-drop/internal/config"
+)
+
+// runValidate implements "backend validate [--config file] [--env-file
+// .env]": it loads the configuration exactly as the server would, with the
+// env file (if any) overriding the process environment, and prints every
+// error and warning. With an env file it also checks the variables the
+// Docker Compose stack passes to the Postgres and MinIO containers.
+// Exit status: 0 valid (warnings allowed), 1 invalid.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := configFlag(fs)
+	envFile := fs.String("env-file", "", "dotenv file to validate (e.g. .env)")
+	_ = fs.Parse(args)
+	return validateConfig(os.Stdout, *configPath, *envFile)
+}
+
+func validateConfig(w io.Writer, configPath, envFile string) int {
+	lookup := os.LookupEnv
+	var fileVars map[string]string
+	if envFile != "" {
+		var err error
+		if fileVars, err = parseEnvFile(envFile); err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+			return 1
+		}
+		lookup = func(key string) (string, bool) {
+			if v, ok := fileVars[key]; ok {
+				return v, true
+			}
+			return os.LookupEnv(key)
+		}
+	}
+
+	var errs, warns []string
+	cfg, err := config.Load(configPath, lookup)
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		var verr *config.ValidationError
+		if err := cfg.Validate(); errors.As(err, &verr) {
+			errs = append(errs, verr.Problems...)
+		} else if err != nil {
+			errs = append(errs, err.Error())
+		}
+		warns = cfg.Warnings()
+	}
+	if fileVars != nil {
+		e, wn := checkComposeVars(fileVars, cfg.Database.URL)
+		errs, warns = append(errs, e...), append(warns, wn...)
+	}
+
+	for _, e := range errs {
+		fmt.Fprintf(w, "error: %s\n", e)
+	}
+	for _, wn := range warns {
+		fmt.Fprintf(w, "warning: %s\n", wn)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "configuration invalid: %d error(s), %d warning(s)\n", len(errs), len(warns))
+		return 1
+	}
+	fmt.Fprintf(w, "configuration ok: %d warning(s)\n", len(warns))
+	return 0
+}
+
+// checkComposeVars validates the docker-compose variables that only the
+// Postgres and MinIO containers read, and that DATABASE_URL uses the same
+// Postgres credentials.
+func checkComposeVars(vars map[string]string, databaseURL string) (errs, warns []string) {
+	for _, v := range []struct {
+		key    string
+		minLen int
+	}{
+		{"POSTGRES_DB", 0},
+		{"POSTGRES_USER", 0},
+		{"POSTGRES_PASSWORD", 24},
+		{"MINIO_ROOT_USER", 0},
+		{"MINIO_ROOT_PASSWORD", 24},
+	} {
+		val := vars[v.key]
+		switch {
+		case val == "":
+			errs = append(errs, v.key+": is required")
+		case strings.Contains(val, "CHANGE_ME"):
+			errs = append(errs, v.key+": still contains the CHANGE_ME placeholder from .env.example")
+		case len(val) < v.minLen:
+			warns = append(warns, fmt.Sprintf("%s: shorter than the recommended %d characters", v.key, v.minLen))
+		}
+	}
+	if u, err := url.Parse(databaseURL); err == nil && u.User != nil && vars["POSTGRES_USER"] != "" {
+		pass, _ := u.User.Password()
+		if u.User.Username() != vars["POSTGRES_USER"] || pass != vars["POSTGRES_PASSWORD"] {
+			warns = append(warns, "DATABASE_URL: credentials do not match POSTGRES_USER/POSTGRES_PASSWORD")
+		}
+	}
+	return errs, warns
+}
+
+// parseEnvFile reads KEY=VALUE lines as docker compose does: blank lines
+// and # comments are skipped, an optional "export " prefix is allowed,
+// matching single or double quotes around the value are removed and an
+// unquoted value ends at " #".
+func parseEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	vars := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		} else if i := strings.Index(val, " #"); i >= 0 {
+			val = strings.TrimSpace(val[:i])
+		}
+		vars[strings.TrimSpace(key)] = val
+	}
+	return vars, sc.Err()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"secure-file-drop/internal/server"
+)
+
+func filesList(e *env, args []string) error {
+	var f server.FileFilter
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&f.Status, "status", "", "")
+		fs.StringVar(&f.Owner, "owner", "", "")
+		fs.IntVar(&f.Limit, "limit", 100, "")
+	}); err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	files, err := server.ListFiles(ctx, db, f)
+	if err != nil {
+		return err
+	}
+	return e.emit(files, func(w io.Writer) {
+		rows := make([][]string, 0, len(files))
+		for _, fi := range files {
+			rows = append(rows, []string{fi.ID, fi.Status, strconv.FormatInt(fi.SizeBytes, 10),
+				formatTime(fi.CreatedAt), fi.CreatedBy, fi.OrigName})
+		}
+		table(w, []string{"ID", "STATUS", "BYTES", "CREATED", "OWNER", "NAME"}, rows)
+	})
+}
+
+func filesInspect(e *env, args []string) error {
+	rest, err := flags(args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+	ctx, db, mc, bucket, err := e.storage()
+	if err != nil {
+		return err
+	}
+	d, err := server.InspectFile(ctx, db, mc, bucket, rest[0])
+	if err != nil {
+		return err
+	}
+	return e.emit(d, func(w io.Writer) {
+		object := d.ObjectError
+		if d.Object != nil {
+			object = fmt.Sprintf("%d bytes, etag %s, modified %s", d.Object.Size, d.Object.ETag, formatTime(d.Object.LastModified))
+		}
+		table(w, []string{"FIELD", "VALUE"}, [][]string{
+			{"id", d.ID},
+			{"name", d.OrigName},
+			{"content type", d.ContentType},
+			{"status", d.Status},
+			{"bytes", strconv.FormatInt(d.SizeBytes, 10)},
+			{"sha256", d.SHA256Hex},
+			{"owner", d.CreatedBy},
+			{"org", d.OrgID},
+			{"created", formatTime(d.CreatedAt)},
+			{"expires", formatTimePtr(d.ExpiresAt)},
+			{"object key", d.ObjectKey},
+			{"object", object},
+			{"shares", strconv.Itoa(d.Shares)},
+		})
+		if len(d.Links) > 0 {
+			fmt.Fprintln(w)
+			linkTable(w, d.Links)
+		}
+	})
+}
+
+func filesDelete(e *env, args []string) error {
+	rest, err := flags(args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+	ctx, db, mc, bucket, err := e.storage()
+	if err != nil {
+		return err
+	}
+	fi, err := server.DeleteFile(ctx, db, mc, bucket, rest[0])
+	if err != nil {
+		return err
+	}
+	return e.emit(fi, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted %s (%s)\n", fi.ID, fi.OrigName)
+	})
+}
+
+func filesVerify(e *env, args []string) error {
+	rest, err := flags(args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+	ctx, db, mc, bucket, err := e.storage()
+	if err != nil {
+		return err
+	}
+	res, err := server.VerifyFile(ctx, db, mc, bucket, rest[0])
+	if err != nil {
+		return err
+	}
+	if err := e.emit(res, func(w io.Writer) {
+		if res.OK {
+			fmt.Fprintf(w, "ok %s sha256 %s\n", res.FileID, res.Actual)
+			return
+		}
+		fmt.Fprintf(w, "MISMATCH %s: recorded %s, stored object %s\n", res.FileID, res.Expected, res.Actual)
+	}); err != nil {
+		return err
+	}
+	if !res.OK {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func linksList(e *env, args []string) error {
+	var f server.LinkFilter
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&f.FileID, "file", "", "")
+		fs.BoolVar(&f.All, "all", false, "")
+		fs.IntVar(&f.Limit, "limit", 100, "")
+	}); err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	links, err := server.ListLinks(ctx, db, f)
+	if err != nil {
+		return err
+	}
+	return e.emit(links, func(w io.Writer) { linkTable(w, links) })
+}
+
+func linkTable(w io.Writer, links []server.LinkInfo) {
+	rows := make([][]string, 0, len(links))
+	for _, l := range links {
+		rows = append(rows, []string{l.ID, l.FileID, l.Status, formatTime(l.ExpiresAt), l.CreatedBy, l.OrigName})
+	}
+	table(w, []string{"LINK", "FILE", "STATUS", "EXPIRES", "CREATED BY", "NAME"}, rows)
+}
+
+func linksRevoke(e *env, args []string) error {
+	var fileID string
+	rest, err := flags(args, 0, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&fileID, "file", "", "")
+	})
+	if err != nil {
+		return err
+	}
+	linkID := ""
+	if len(rest) == 1 {
+		linkID = rest[0]
+	}
+	if (linkID == "") == (fileID == "") {
+		return errUsage
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	n, err := server.RevokeLinks(ctx, db, linkID, fileID)
+	if err != nil {
+		return err
+	}
+	return e.emit(map[string]int64{"revoked": n}, func(w io.Writer) {
+		fmt.Fprintf(w, "revoked %d link(s)\n", n)
+	})
+}
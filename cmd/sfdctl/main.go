@@ -0,0 +1,343 @@
+// Command sfdctl performs administrative tasks directly against the
+// database and object store of a Secure File Drop instance, with the same
+// configuration as the server (--config / SFD_CONFIG and the environment).
+// Changes are recorded in the audit log with the actor "sfdctl:<os user>".
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"secure-file-drop/internal/config"
+	"secure-file-drop/internal/server"
+)
+
+// Exit statuses.
+const (
+	exitOK     = 0
+	exitError  = 1
+	exitUsage  = 2
+	exitFailed = 3 // a check ran and found a problem (files verify, reconcile)
+)
+
+var (
+	errUsage       = errors.New("usage")
+	errCheckFailed = errors.New("check failed")
+)
+
+// command is one "sfdctl <group> <name>" subcommand.
+type command struct {
+	args    string // synopsis of the arguments, for usage
+	summary string
+	run     func(e *env, args []string) error
+}
+
+// commands maps group and name to the subcommand. A group with a single
+// "" entry takes no subcommand name (sfdctl reconcile).
+var commands = map[string]map[string]command{
+	"migrate": {
+		"status": {"", "show applied and embedded migrations", migrateStatus},
+		"up":     {"[N]", "apply all (or N) pending migrations", migrateUp},
+		"down":   {"N", "revert the last N migrations", migrateDown},
+		"force":  {"VERSION", "mark VERSION applied and clear the dirty flag (-1: none)", migrateForce},
+	},
+	"user": {
+		"create":   {"--username U --email E [--role user|admin] [--password-stdin]", "create an active, verified account", userCreate},
+		"list":     {"[--search S] [--role R] [--status active|inactive] [--limit N]", "list accounts", userList},
+		"disable":  {"USER", "deactivate an account and revoke its sessions", userDisable},
+		"enable":   {"USER", "reactivate an account", userEnable},
+		"set-role": {"USER user|admin", "change an account's role", userSetRole},
+	},
+	"files": {
+		"list":    {"[--status S] [--owner SUBJECT] [--limit N]", "list files, newest first", filesList},
+		"inspect": {"ID", "show a file with its object, shares and links", filesInspect},
+		"delete":  {"ID", "delete a file and its object", filesDelete},
+		"verify":  {"ID", "re-hash the stored object and compare", filesVerify},
+	},
+	"links": {
+		"list":   {"[--file ID] [--all] [--limit N]", "list download links (active only without --all)", linksList},
+		"revoke": {"LINK_ID | --file ID", "revoke one link or every link of a file", linksRevoke},
+	},
+	"backup": {
+		"export": {"--out FILE|- [--base PREVIOUS_ARCHIVE]", "write metadata and objects to a tar archive (incremental with --base)", backupExport},
+		"import": {"ARCHIVE...", "restore archives, oldest first, into an empty instance", backupImport},
+	},
+	"cleanup": {
+		"run": {"[--dry-run] [--limit N]", "delete stale uploads and files past retention", cleanupRun},
+	},
+	"reconcile": {
+		"": {"[--fix] [--min-age D]", "compare the files table with the bucket", reconcile},
+	},
+	"jobs": {
+		"list": {"[--job NAME] [--limit N]", "list background job runs across instances, newest first", jobsList},
+	},
+	"keys": {
+		"list":   {"", "list download link signing keys", keysList},
+		"rotate": {"[--grace D]", "start signing links with a new key and retire the others after D", keysRotate},
+	},
+	"maintenance": {
+		"on":     {"[--message M] [--retry-after D]", "make every replica read-only: writes answer 503, downloads keep working", maintenanceOn},
+		"off":    {"", "leave read-only maintenance mode", maintenanceOff},
+		"status": {"", "show whether maintenance mode is on", maintenanceStatus},
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes one sfdctl invocation and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("sfdctl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", os.Getenv("SFD_CONFIG"), "YAML or TOML configuration file (default $SFD_CONFIG)")
+	jsonOut := fs.Bool("json", false, "print results as JSON")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(stderr, "sfdctl: %v\n", err)
+		usage(stderr)
+		return exitUsage
+	}
+
+	cmd, name, rest, err := lookup(fs.Args())
+	if err != nil {
+		if err != errUsage {
+			fmt.Fprintf(stderr, "sfdctl: %v\n", err)
+		}
+		usage(stderr)
+		return exitUsage
+	}
+
+	e := &env{configPath: *configPath, json: *jsonOut, in: stdin, out: stdout}
+	defer e.close()
+	err = cmd.run(e, rest)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "usage: sfdctl %s %s\n", name, cmd.args)
+		return exitUsage
+	case errors.Is(err, errCheckFailed):
+		return exitFailed
+	}
+	fmt.Fprintf(stderr, "sfdctl %s: %v\n", name, err)
+	return exitError
+}
+
+// lookup resolves the command named by args and returns its full name and
+// remaining arguments.
+func lookup(args []string) (command, string, []string, error) {
+	if len(args) == 0 || args[0] == "help" {
+		return command{}, "", nil, errUsage
+	}
+	group, ok := commands[args[0]]
+	if !ok {
+		return command{}, "", nil, fmt.Errorf("unknown command %q", args[0])
+	}
+	if cmd, ok := group[""]; ok {
+		return cmd, args[0], args[1:], nil
+	}
+	if len(args) < 2 {
+		return command{}, "", nil, fmt.Errorf("%s: missing subcommand", args[0])
+	}
+	cmd, ok := group[args[1]]
+	if !ok {
+		return command{}, "", nil, fmt.Errorf("%s: unknown subcommand %q", args[0], args[1])
+	}
+	return cmd, args[0] + " " + args[1], args[2:], nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sfdctl [--config file] [--json] <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	groups := make([]string, 0, len(commands))
+	for g := range commands {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, g := range groups {
+		names := make([]string, 0, len(commands[g]))
+		for n := range commands[g] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			c := commands[g][n]
+			fmt.Fprintf(tw, "  %s\t%s\n", strings.Join(strings.Fields(g+" "+n+" "+c.args), " "), c.summary)
+		}
+	}
+	_ = tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags go before positional arguments. Exit status: 0 ok, 1 error, 2 usage, 3 check failed.")
+}
+
+// env holds what commands share: configuration, connections opened on
+// first use and the output format.
+type env struct {
+	configPath string
+	json       bool
+	in         io.Reader
+	out        io.Writer
+
+	cfg    *config.Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	db     *sql.DB
+	mc     *minio.Client
+}
+
+// config loads the configuration. Only the settings a command uses need
+// to be present, so it is not validated as a whole.
+func (e *env) config() (config.Config, error) {
+	if e.cfg == nil {
+		cfg, err := config.Load(e.configPath, nil)
+		if err != nil {
+			return cfg, err
+		}
+		pw := server.DefaultPasswordParams()
+		pw.Memory = cfg.Password.MemoryKiB
+		pw.Iterations = cfg.Password.Iterations
+		pw.Parallelism = cfg.Password.Parallelism
+		if err := pw.Validate(); err != nil {
+			return cfg, err
+		}
+		server.SetPasswordParams(pw)
+		server.SetHashTool(cfg.Hash.Tool)
+		e.cfg = &cfg
+	}
+	return *e.cfg, nil
+}
+
+// database connects to database.url (DATABASE_URL) and returns a context
+// that audits changes as the operator.
+func (e *env) database() (context.Context, *sql.DB, error) {
+	if e.db != nil {
+		return e.ctx, e.db, nil
+	}
+	cfg, err := e.config()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Database.URL == "" {
+		return nil, nil, errors.New("database.url (DATABASE_URL) is not set")
+	}
+	db, err := server.OpenDB(cfg.Database.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("db connect failed: %w", err)
+	}
+	lc, _ := server.ParseLogConfig("text", "warn")
+	logger := server.NewLogger(os.Stderr, lc)
+	e.ctx, e.cancel = context.WithTimeout(context.Background(), 30*time.Minute)
+	e.ctx = server.OperatorContext(e.ctx, db, logger, operator())
+	e.db = db
+	return e.ctx, e.db, nil
+}
+
+// storage connects to the object store as well as the database.
+func (e *env) storage() (context.Context, *sql.DB, *minio.Client, string, error) {
+	ctx, db, err := e.database()
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	bucket := e.cfg.Storage.Bucket
+	if e.mc == nil {
+		mc, err := server.OpenStorage(server.StorageConfig{
+			Endpoint:  e.cfg.Storage.Endpoint,
+			AccessKey: e.cfg.Storage.AccessKey,
+			SecretKey: e.cfg.Storage.SecretKey,
+			Bucket:    bucket,
+		})
+		if err != nil {
+			return nil, nil, nil, "", fmt.Errorf("storage: %w", err)
+		}
+		e.mc = mc
+	}
+	return ctx, db, e.mc, bucket, nil
+}
+
+func (e *env) close() {
+	if e.db != nil {
+		_ = e.db.Close()
+	}
+	if e.cancel != nil {
+		e.cancel()
+	}
+}
+
+// operator names the person running sfdctl in the audit log.
+func operator() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+	if name == "" {
+		name = "unknown"
+	}
+	return "sfdctl:" + name
+}
+
+// emit prints v as JSON with --json, else calls text.
+func (e *env) emit(v any, text func(w io.Writer)) error {
+	if e.json {
+		enc := json.NewEncoder(e.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	text(e.out)
+	return nil
+}
+
+// table writes aligned columns under header.
+func table(w io.Writer, header []string, rows [][]string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	_ = tw.Flush()
+}
+
+// flags parses args with the flags defined by define and returns the
+// positional arguments, of which there must be between min and max.
+func flags(args []string, min, max int, define func(fs *flag.FlagSet)) ([]string, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if define != nil {
+		define(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if n := fs.NArg(); n < min || n > max {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return formatTime(*t)
+}
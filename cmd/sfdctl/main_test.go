@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRun_Usage(t *testing.T) {
+	// DATABASE_URL is never reached: usage errors are caught first.
+	t.Setenv("DATABASE_URL", "")
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "Commands:"},
+		{[]string{"help"}, "Commands:"},
+		{[]string{"nope"}, `unknown command "nope"`},
+		{[]string{"user"}, "user: missing subcommand"},
+		{[]string{"user", "nope"}, `unknown subcommand "nope"`},
+		{[]string{"--bogus", "keys", "list"}, "flag provided but not defined"},
+		{[]string{"files", "delete"}, "usage: sfdctl files delete ID"},
+		{[]string{"files", "delete", "a", "b"}, "usage: sfdctl files delete ID"},
+		{[]string{"migrate", "down"}, "usage: sfdctl migrate down N"},
+		{[]string{"migrate", "down", "0"}, "usage: sfdctl migrate down N"},
+		{[]string{"migrate", "up", "x"}, "usage: sfdctl migrate up [N]"},
+		{[]string{"user", "create", "--username", "alice"}, "usage: sfdctl user create"},
+		{[]string{"links", "revoke"}, "usage: sfdctl links revoke"},
+		{[]string{"links", "revoke", "--file", "f", "l"}, "usage: sfdctl links revoke"},
+		{[]string{"keys", "rotate", "--grace", "-1h"}, "usage: sfdctl keys rotate"},
+		{[]string{"reconcile", "--min-age", "soon"}, "usage: sfdctl reconcile"},
+		{[]string{"backup", "export"}, "usage: sfdctl backup export"},
+		{[]string{"backup", "export", "--out", "x", "extra"}, "usage: sfdctl backup export"},
+		{[]string{"backup", "import"}, "usage: sfdctl backup import"},
+		{[]string{"jobs", "list", "extra"}, "usage: sfdctl jobs list"},
+		{[]string{"maintenance", "on", "--retry-after", "0s"}, "usage: sfdctl maintenance on"},
+		{[]string{"maintenance", "off", "extra"}, "usage: sfdctl maintenance off"},
+	} {
+		var stdout, stderr bytes.Buffer
+		code := run(tc.args, strings.NewReader(""), &stdout, &stderr)
+		if code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", tc.args, code, exitUsage)
+		}
+		if !strings.Contains(stderr.String(), tc.want) {
+			t.Errorf("%v: stderr %q does not contain %q", tc.args, stderr.String(), tc.want)
+		}
+	}
+}
+
+func TestRun_MissingDatabase(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("SFD_CONFIG", "")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"keys", "list"}, strings.NewReader(""), &stdout, &stderr); code != exitError {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "DATABASE_URL") {
+		t.Errorf("stderr: %q", stderr.String())
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	seen := map[string]bool{}
+	for range 50 {
+		p, err := generatePassword(20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p) != 20 || !strings.ContainsAny(p, "0123456789") || !strings.ContainsFunc(p, isLetter) {
+			t.Fatalf("password %q does not meet the rules", p)
+		}
+		seen[p] = true
+	}
+	if len(seen) != 50 {
+		t.Error("generated passwords repeat")
+	}
+}
+
+func TestEmit(t *testing.T) {
+	rows := [][]string{{"a1", "ready"}, {"b22222", "failed"}}
+	text := func(w io.Writer) { table(w, []string{"ID", "STATUS"}, rows) }
+
+	var buf bytes.Buffer
+	if err := (&env{out: &buf}).emit(rows, text); err != nil {
+		t.Fatal(err)
+	}
+	want := "ID      STATUS\na1      ready\nb22222  failed\n"
+	if buf.String() != want {
+		t.Errorf("text output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := (&env{out: &buf, json: true}).emit(rows, text); err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil || len(got) != 2 || got[1][1] != "failed" {
+		t.Errorf("json output %q: %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"secure-file-drop/internal/server"
+)
+
+func cleanupRun(e *env, args []string) error {
+	opts := server.CleanupOptions{Trigger: "cli"}
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.BoolVar(&opts.DryRun, "dry-run", false, "")
+		fs.IntVar(&opts.Limit, "limit", 0, "")
+	}); err != nil {
+		return err
+	}
+	ctx, db, mc, bucket, err := e.storage()
+	if err != nil {
+		return err
+	}
+	report, err := server.RunCleanup(ctx, server.CleanupConfig{
+		MaxAge:      e.cfg.Cleanup.MaxAge,
+		DB:          db,
+		MinioClient: mc,
+		Bucket:      bucket,
+	}, opts)
+	if err != nil {
+		return err
+	}
+	return e.emit(report, func(w io.Writer) {
+		rows := make([][]string, 0, len(report.Candidates))
+		for _, c := range report.Candidates {
+			rows = append(rows, []string{c.ID, c.Status, formatTime(c.CreatedAt)})
+		}
+		table(w, []string{"ID", "STATUS", "CREATED"}, rows)
+		if report.DryRun {
+			fmt.Fprintf(w, "dry run: %d file(s) would be deleted\n", len(report.Candidates))
+			return
+		}
+		fmt.Fprintf(w, "deleted %d of %d file(s)\n", report.Deleted, len(report.Candidates))
+	})
+}
+
+func reconcile(e *env, args []string) error {
+	opts := server.ReconcileOptions{}
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.BoolVar(&opts.Fix, "fix", false, "")
+		fs.DurationVar(&opts.MinAge, "min-age", time.Hour, "")
+	}); err != nil {
+		return err
+	}
+	ctx, db, mc, bucket, err := e.storage()
+	if err != nil {
+		return err
+	}
+	report, err := server.Reconcile(ctx, db, mc, bucket, opts)
+	if err != nil {
+		return err
+	}
+	if err := e.emit(report, func(w io.Writer) {
+		if report.Clean() {
+			fmt.Fprintln(w, "database and bucket agree")
+			return
+		}
+		rows := make([][]string, 0, len(report.Orphans)+len(report.Missing))
+		for _, o := range report.Orphans {
+			rows = append(rows, []string{"orphan object", o.Key, strconv.FormatInt(o.Size, 10) + " bytes"})
+		}
+		for _, m := range report.Missing {
+			rows = append(rows, []string{"missing object", m.ObjectKey, "file " + m.FileID + " (" + m.Status + ")"})
+		}
+		table(w, []string{"PROBLEM", "OBJECT", "DETAIL"}, rows)
+		if report.Fixed {
+			fmt.Fprintln(w, "fixed: orphans removed, files without objects marked failed")
+		} else {
+			fmt.Fprintln(w, "run with --fix to remove orphans and mark files without objects failed")
+		}
+	}); err != nil {
+		return err
+	}
+	if !report.Clean() && !report.Fixed {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func jobsList(e *env, args []string) error {
+	var f server.JobRunFilter
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&f.Job, "job", "", "")
+		fs.IntVar(&f.Limit, "limit", 50, "")
+	}); err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	runs, err := server.ListJobRuns(ctx, db, f)
+	if err != nil {
+		return err
+	}
+	return e.emit(runs, func(w io.Writer) {
+		rows := make([][]string, 0, len(runs))
+		for _, r := range runs {
+			rows = append(rows, []string{r.Job, r.Trigger, r.Status, formatTime(r.StartedAt), formatTimePtr(r.FinishedAt),
+				strconv.Itoa(r.Processed), strconv.Itoa(r.Failed), r.Instance, r.Error})
+		}
+		table(w, []string{"JOB", "TRIGGER", "STATUS", "STARTED", "FINISHED", "DONE", "FAILED", "INSTANCE", "ERROR"}, rows)
+	})
+}
+
+func keysList(e *env, args []string) error {
+	if _, err := flags(args, 0, 0, nil); err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	keys, err := server.ListDownloadKeys(ctx, db)
+	if err != nil {
+		return err
+	}
+	return e.emit(keys, func(w io.Writer) {
+		rows := make([][]string, 0, len(keys))
+		for _, k := range keys {
+			id := k.ID
+			if id == "" {
+				id = "(configured secret)"
+			}
+			rows = append(rows, []string{id, k.Status, formatTime(k.CreatedAt), formatTimePtr(k.RetiredAt)})
+		}
+		table(w, []string{"KEY", "STATUS", "CREATED", "RETIRES"}, rows)
+	})
+}
+
+func keysRotate(e *env, args []string) error {
+	grace := server.DefaultKeyGrace
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.DurationVar(&grace, "grace", server.DefaultKeyGrace, "")
+	}); err != nil {
+		return err
+	}
+	if grace < 0 {
+		return errUsage
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	k, err := server.RotateDownloadKey(ctx, db, grace)
+	if err != nil {
+		return err
+	}
+	return e.emit(k, func(w io.Writer) {
+		fmt.Fprintf(w, "new links are signed with key %s; older keys retire at %s\n",
+			k.ID, formatTime(time.Now().Add(grace)))
+	})
+}
+
+func maintenanceOn(e *env, args []string) error {
+	u := server.MaintenanceUpdate{Enabled: true}
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&u.Message, "message", "", "")
+		fs.DurationVar(&u.RetryAfter, "retry-after", 5*time.Minute, "")
+	}); err != nil {
+		return err
+	}
+	if u.RetryAfter < time.Second {
+		return errUsage
+	}
+	return setMaintenance(e, u)
+}
+
+func maintenanceOff(e *env, args []string) error {
+	if _, err := flags(args, 0, 0, nil); err != nil {
+		return err
+	}
+	return setMaintenance(e, server.MaintenanceUpdate{})
+}
+
+func setMaintenance(e *env, u server.MaintenanceUpdate) error {
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	m, err := server.SetMaintenance(ctx, db, u)
+	if err != nil {
+		return err
+	}
+	return e.emit(m, func(w io.Writer) { printMaintenance(w, m) })
+}
+
+func maintenanceStatus(e *env, args []string) error {
+	if _, err := flags(args, 0, 0, nil); err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	m, err := server.GetMaintenance(ctx, db)
+	if err != nil {
+		return err
+	}
+	return e.emit(m, func(w io.Writer) { printMaintenance(w, m) })
+}
+
+func printMaintenance(w io.Writer, m server.Maintenance) {
+	if !m.Enabled {
+		fmt.Fprintf(w, "maintenance mode is off (changed %s by %s)\n", formatTime(m.UpdatedAt), m.UpdatedBy)
+		return
+	}
+	fmt.Fprintf(w, "maintenance mode is on since %s (by %s): writes answer 503, Retry-After %ds\n",
+		formatTime(m.UpdatedAt), m.UpdatedBy, m.RetryAfterSeconds)
+	if m.Message != "" {
+		fmt.Fprintf(w, "message: %s\n", m.Message)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"secure-file-drop/internal/db"
+)
+
+type migrationStatus struct {
+	Version    uint              `json:"version"`
+	Dirty      bool              `json:"dirty"`
+	Latest     uint              `json:"latest"`
+	Migrations []migrationRecord `json:"migrations"`
+}
+
+type migrationRecord struct {
+	db.Migration
+	Applied bool `json:"applied"`
+}
+
+func migrateStatus(e *env, args []string) error {
+	if _, err := flags(args, 0, 0, nil); err != nil {
+		return err
+	}
+	ctx, conn, err := e.database()
+	if err != nil {
+		return err
+	}
+	version, dirty, err := db.CurrentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	ms, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+	st := migrationStatus{Version: version, Dirty: dirty, Migrations: []migrationRecord{}}
+	for _, m := range ms {
+		st.Latest = max(st.Latest, m.Version)
+		st.Migrations = append(st.Migrations, migrationRecord{Migration: m, Applied: m.Version <= version})
+	}
+	return e.emit(st, func(w io.Writer) {
+		state := "clean"
+		if dirty {
+			state = "DIRTY: repair by hand, then sfdctl migrate force VERSION"
+		}
+		fmt.Fprintf(w, "version %d of %d (%s)\n\n", version, st.Latest, state)
+		rows := make([][]string, 0, len(st.Migrations))
+		for _, m := range st.Migrations {
+			applied := "pending"
+			if m.Applied {
+				applied = "applied"
+			}
+			rows = append(rows, []string{strconv.FormatUint(uint64(m.Version), 10), m.Name, applied})
+		}
+		table(w, []string{"VERSION", "NAME", "STATE"}, rows)
+	})
+}
+
+func migrateUp(e *env, args []string) error {
+	rest, err := flags(args, 0, 1, nil)
+	if err != nil {
+		return err
+	}
+	steps := 0
+	if len(rest) == 1 {
+		if steps, err = strconv.Atoi(rest[0]); err != nil || steps < 1 {
+			return errUsage
+		}
+	}
+	_, conn, err := e.database()
+	if err != nil {
+		return err
+	}
+	if err := db.MigrateUp(conn, steps); err != nil {
+		return err
+	}
+	return migrateReport(e)
+}
+
+func migrateDown(e *env, args []string) error {
+	rest, err := flags(args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+	steps, err := strconv.Atoi(rest[0])
+	if err != nil || steps < 1 {
+		return errUsage
+	}
+	_, conn, err := e.database()
+	if err != nil {
+		return err
+	}
+	if err := db.MigrateDown(conn, steps); err != nil {
+		return err
+	}
+	return migrateReport(e)
+}
+
+func migrateForce(e *env, args []string) error {
+	rest, err := flags(args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+	version, err := strconv.Atoi(rest[0])
+	if err != nil {
+		return errUsage
+	}
+	_, conn, err := e.database()
+	if err != nil {
+		return err
+	}
+	if err := db.ForceVersion(conn, version); err != nil {
+		return err
+	}
+	return migrateReport(e)
+}
+
+// migrateReport prints the version a migrate command left the database at.
+func migrateReport(e *env) error {
+	version, dirty, err := db.CurrentVersion(e.ctx, e.db)
+	if err != nil {
+		return err
+	}
+	return e.emit(map[string]any{"version": version, "dirty": dirty}, func(w io.Writer) {
+		fmt.Fprintf(w, "database at version %d\n", version)
+	})
+}
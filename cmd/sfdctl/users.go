@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"secure-file-drop/internal/server"
+)
+
+func userCreate(e *env, args []string) error {
+	var (
+		nu            server.NewUser
+		passwordStdin bool
+	)
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&nu.Username, "username", "", "")
+		fs.StringVar(&nu.Email, "email", "", "")
+		fs.StringVar(&nu.Role, "role", server.RoleUser, "")
+		fs.BoolVar(&passwordStdin, "password-stdin", false, "")
+	}); err != nil {
+		return err
+	}
+	if nu.Username == "" || nu.Email == "" {
+		return errUsage
+	}
+
+	generated := !passwordStdin
+	if passwordStdin {
+		line, err := bufio.NewReader(e.in).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		nu.Password = strings.TrimRight(line, "\r\n")
+	} else {
+		var err error
+		if nu.Password, err = generatePassword(20); err != nil {
+			return err
+		}
+	}
+
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	u, err := server.CreateUser(ctx, db, nu)
+	if err != nil {
+		return err
+	}
+	out := struct {
+		server.AdminUserInfo
+		Password string `json:"password,omitempty"`
+	}{AdminUserInfo: u}
+	if generated {
+		out.Password = nu.Password
+	}
+	return e.emit(out, func(w io.Writer) {
+		fmt.Fprintf(w, "created %s %s (%s, %s)\n", u.Role, u.Username, u.Email, u.ID)
+		if generated {
+			fmt.Fprintf(w, "password: %s\n", nu.Password)
+		}
+	})
+}
+
+// generatePassword returns n random letters and digits that pass the
+// server's password rules (at least one of each).
+func generatePassword(n int) (string, error) {
+	const alphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	for {
+		b := make([]byte, n)
+		for i := range b {
+			k, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return "", err
+			}
+			b[i] = alphabet[k.Int64()]
+		}
+		p := string(b)
+		if strings.ContainsAny(p, "23456789") && strings.ContainsFunc(p, isLetter) {
+			return p, nil
+		}
+	}
+}
+
+func isLetter(r rune) bool { return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') }
+
+func userList(e *env, args []string) error {
+	var f server.UserFilter
+	if _, err := flags(args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&f.Search, "search", "", "")
+		fs.StringVar(&f.Role, "role", "", "")
+		fs.StringVar(&f.Status, "status", "", "")
+		fs.IntVar(&f.Limit, "limit", 200, "")
+		fs.IntVar(&f.Offset, "offset", 0, "")
+	}); err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	list, err := server.ListUsers(ctx, db, f)
+	if err != nil {
+		return err
+	}
+	return e.emit(list, func(w io.Writer) {
+		rows := make([][]string, 0, len(list.Users))
+		for _, u := range list.Users {
+			state := "active"
+			if !u.IsActive {
+				state = "disabled"
+			}
+			rows = append(rows, []string{u.ID, u.Username, u.Email, u.Role, state,
+				strconv.Itoa(u.FileCount), formatTimePtr(u.LastLogin)})
+		}
+		table(w, []string{"ID", "USERNAME", "EMAIL", "ROLE", "STATE", "FILES", "LAST LOGIN"}, rows)
+		if list.Total > len(list.Users) {
+			fmt.Fprintf(w, "(%d of %d; use --offset/--limit for more)\n", len(list.Users), list.Total)
+		}
+	})
+}
+
+func userDisable(e *env, args []string) error { return userSetActive(e, args, false) }
+func userEnable(e *env, args []string) error  { return userSetActive(e, args, true) }
+
+func userSetActive(e *env, args []string, active bool) error {
+	rest, err := flags(args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	u, err := server.SetUserActive(ctx, db, rest[0], active)
+	if err != nil {
+		return err
+	}
+	return e.emit(u, func(w io.Writer) {
+		verb := "disabled"
+		if active {
+			verb = "enabled"
+		}
+		fmt.Fprintf(w, "%s %s (%s)\n", verb, u.Username, u.ID)
+	})
+}
+
+func userSetRole(e *env, args []string) error {
+	rest, err := flags(args, 2, 2, nil)
+	if err != nil {
+		return err
+	}
+	ctx, db, err := e.database()
+	if err != nil {
+		return err
+	}
+	u, err := server.SetUserRole(ctx, db, rest[0], rest[1])
+	if err != nil {
+		return err
+	}
+	return e.emit(u, func(w io.Writer) {
+		fmt.Fprintf(w, "%s is now %s\n", u.Username, u.Role)
+	})
+}
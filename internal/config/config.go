@@ -0,0 +1,510 @@
+// Package config loads the backend configuration once at startup.
+//
+// Values come from three layers, later ones winning: built-in defaults, an
+// optional YAML or TOML file, and the SFD_* environment variables (plus
+// DATABASE_URL) the backend has always read. Validate reports every problem
+// at once, so a misconfigured deployment fails before serving traffic
+// instead of on the first upload or link.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete backend configuration. Every leaf field carries
+// its file key (yaml/toml tags) and the environment variable that
+// overrides it (env tag); fields tagged secret are redacted when printed,
+// and fields tagged reload can change on a running server (SIGHUP).
+type Config struct {
+	Server       Server       `yaml:"server" toml:"server"`
+	TLS          TLS          `yaml:"tls" toml:"tls"`
+	Build        Build        `yaml:"build" toml:"build"`
+	Auth         Auth         `yaml:"auth" toml:"auth"`
+	Password     Password     `yaml:"password" toml:"password"`
+	Database     Database     `yaml:"database" toml:"database"`
+	Storage      Storage      `yaml:"storage" toml:"storage"`
+	Downloads    Downloads    `yaml:"downloads" toml:"downloads"`
+	Hash         Hash         `yaml:"hash" toml:"hash"`
+	Cleanup      Cleanup      `yaml:"cleanup" toml:"cleanup"`
+	Mail         Mail         `yaml:"mail" toml:"mail"`
+	Registration Registration `yaml:"registration" toml:"registration"`
+	RateLimit    RateLimit    `yaml:"rate_limit" toml:"rate_limit"`
+	Metrics      Metrics      `yaml:"metrics" toml:"metrics"`
+	Log          Log          `yaml:"log" toml:"log"`
+	Trace        Trace        `yaml:"trace" toml:"trace"`
+	Health       Health       `yaml:"health" toml:"health"`
+}
+
+// Server holds the HTTP listener and request handling settings.
+type Server struct {
+	Addr              string   `yaml:"addr" toml:"addr" env:"SFD_ADDR"`
+	PublicBaseURL     string   `yaml:"public_base_url" toml:"public_base_url" env:"SFD_PUBLIC_BASE_URL"`
+	TrustProxyHeaders bool     `yaml:"trust_proxy_headers" toml:"trust_proxy_headers" env:"SFD_TRUST_PROXY_HEADERS"`
+	TrustedProxyHops  int      `yaml:"trusted_proxy_hops" toml:"trusted_proxy_hops" env:"SFD_TRUSTED_PROXY_HOPS"`
+	WebEnabled        bool     `yaml:"web_enabled" toml:"web_enabled" env:"SFD_WEB_ENABLED"`
+	WebDir            string   `yaml:"web_dir" toml:"web_dir" env:"SFD_WEB_DIR"`
+	MaxUploadBytes    ByteSize `yaml:"max_upload_bytes" toml:"max_upload_bytes" env:"SFD_MAX_UPLOAD_BYTES" reload:"true"`
+}
+
+// TLS enables HTTPS on server.addr from certificate files (re-read when
+// they change) or from an ACME CA. Both empty means plain HTTP.
+type TLS struct {
+	CertFile         string        `yaml:"cert_file" toml:"cert_file" env:"SFD_TLS_CERT_FILE"`
+	KeyFile          string        `yaml:"key_file" toml:"key_file" env:"SFD_TLS_KEY_FILE"`
+	ACMEDomains      []string      `yaml:"acme_domains" toml:"acme_domains" env:"SFD_ACME_DOMAINS"`
+	ACMEEmail        string        `yaml:"acme_email" toml:"acme_email" env:"SFD_ACME_EMAIL"`
+	ACMECacheDir     string        `yaml:"acme_cache_dir" toml:"acme_cache_dir" env:"SFD_ACME_CACHE_DIR"`
+	ACMEDirectoryURL string        `yaml:"acme_directory_url" toml:"acme_directory_url" env:"SFD_ACME_DIRECTORY_URL"`
+	ACMECAFile       string        `yaml:"acme_ca_file" toml:"acme_ca_file" env:"SFD_ACME_CA_FILE"`
+	RedirectAddr     string        `yaml:"redirect_addr" toml:"redirect_addr" env:"SFD_TLS_REDIRECT_ADDR"`
+	HSTSMaxAge       time.Duration `yaml:"hsts_max_age" toml:"hsts_max_age" env:"SFD_HSTS_MAX_AGE"`
+}
+
+// Enabled reports whether HTTPS is configured.
+func (t TLS) Enabled() bool { return t.CertFile != "" || len(t.ACMEDomains) > 0 }
+
+// Build identifies the running binary in /version and traces.
+type Build struct {
+	Version string `yaml:"version" toml:"version" env:"SFD_VERSION"`
+	Commit  string `yaml:"commit" toml:"commit" env:"SFD_COMMIT"`
+}
+
+// Auth holds the bootstrap administrator and session settings.
+type Auth struct {
+	AdminUser     string        `yaml:"admin_user" toml:"admin_user" env:"SFD_ADMIN_USER"`
+	AdminPass     string        `yaml:"admin_pass" toml:"admin_pass" env:"SFD_ADMIN_PASS" secret:"true"`
+	AdminEmail    string        `yaml:"admin_email" toml:"admin_email" env:"SFD_ADMIN_EMAIL"`
+	SessionSecret string        `yaml:"session_secret" toml:"session_secret" env:"SFD_SESSION_SECRET" secret:"true"`
+	SessionTTL    time.Duration `yaml:"session_ttl" toml:"session_ttl" env:"SFD_SESSION_TTL"`
+	CookieSecure  string        `yaml:"cookie_secure" toml:"cookie_secure" env:"SFD_COOKIE_SECURE"`
+}
+
+// Password holds the Argon2id cost parameters for new password hashes.
+type Password struct {
+	MemoryKiB   uint32 `yaml:"memory_kib" toml:"memory_kib" env:"SFD_ARGON2_MEMORY_KIB"`
+	Iterations  uint32 `yaml:"iterations" toml:"iterations" env:"SFD_ARGON2_ITERATIONS"`
+	Parallelism uint8  `yaml:"parallelism" toml:"parallelism" env:"SFD_ARGON2_PARALLELISM"`
+}
+
+// Database holds the Postgres connection string.
+type Database struct {
+	URL string `yaml:"url" toml:"url" env:"DATABASE_URL" secret:"url"`
+}
+
+// Storage holds the S3-compatible object store settings.
+type Storage struct {
+	Endpoint  string `yaml:"endpoint" toml:"endpoint" env:"SFD_S3_ENDPOINT"`
+	AccessKey string `yaml:"access_key" toml:"access_key" env:"SFD_S3_ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key" env:"SFD_S3_SECRET_KEY" secret:"true"`
+	Bucket    string `yaml:"bucket" toml:"bucket" env:"SFD_BUCKET"`
+}
+
+// Downloads holds the key signing download links.
+type Downloads struct {
+	Secret string `yaml:"secret" toml:"secret" env:"SFD_DOWNLOAD_SECRET" secret:"true"`
+}
+
+// Hash locates the native sfd-hash utility.
+type Hash struct {
+	Tool string `yaml:"tool" toml:"tool" env:"SFD_HASH_TOOL"`
+}
+
+// Cleanup controls the background job removing stale pending/failed files.
+type Cleanup struct {
+	Enabled  bool          `yaml:"enabled" toml:"enabled" env:"SFD_CLEANUP_ENABLED" reload:"true"`
+	Interval time.Duration `yaml:"interval" toml:"interval" env:"SFD_CLEANUP_INTERVAL" reload:"true"`
+	MaxAge   time.Duration `yaml:"max_age" toml:"max_age" env:"SFD_CLEANUP_MAX_AGE" reload:"true"`
+}
+
+// Mail selects how account emails are delivered: SMTP when SMTPAddr is
+// set, else files in Dir, else the log.
+type Mail struct {
+	SMTPAddr string `yaml:"smtp_addr" toml:"smtp_addr" env:"SFD_SMTP_ADDR"`
+	From     string `yaml:"from" toml:"from" env:"SFD_SMTP_FROM"`
+	Username string `yaml:"username" toml:"username" env:"SFD_SMTP_USER"`
+	Password string `yaml:"password" toml:"password" env:"SFD_SMTP_PASS" secret:"true"`
+	Dir      string `yaml:"dir" toml:"dir" env:"SFD_MAIL_DIR"`
+}
+
+// Registration controls who may use /register.
+type Registration struct {
+	Mode    string   `yaml:"mode" toml:"mode" env:"SFD_REGISTRATION_MODE"`
+	Domains []string `yaml:"domains" toml:"domains" env:"SFD_REGISTRATION_DOMAINS"`
+}
+
+// RateLimit controls per-route request budgets.
+type RateLimit struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled" env:"SFD_RATE_LIMIT_ENABLED"`
+	Store   string `yaml:"store" toml:"store" env:"SFD_RATE_LIMIT_STORE"`
+	Rules   string `yaml:"rules" toml:"rules" env:"SFD_RATE_LIMITS" reload:"true"`
+}
+
+// Metrics controls /metrics scrape authentication and its listener.
+type Metrics struct {
+	Token string `yaml:"token" toml:"token" env:"SFD_METRICS_TOKEN" secret:"true"`
+	Addr  string `yaml:"addr" toml:"addr" env:"SFD_METRICS_ADDR"`
+}
+
+// Log selects the log format and minimum level.
+type Log struct {
+	Format string `yaml:"format" toml:"format" env:"SFD_LOG_FORMAT"`
+	Level  string `yaml:"level" toml:"level" env:"SFD_LOG_LEVEL" reload:"true"`
+}
+
+// Trace selects the span exporter.
+type Trace struct {
+	Exporter string `yaml:"exporter" toml:"exporter" env:"SFD_TRACE_EXPORTER"`
+	File     string `yaml:"file" toml:"file" env:"SFD_TRACE_FILE"`
+}
+
+// Health controls readiness caching and how shutdown drains traffic and
+// uploads.
+type Health struct {
+	ReadyCacheTTL time.Duration `yaml:"ready_cache_ttl" toml:"ready_cache_ttl" env:"SFD_READY_CACHE_TTL"`
+	DrainDelay    time.Duration `yaml:"drain_delay" toml:"drain_delay" env:"SFD_SHUTDOWN_DRAIN_DELAY"`
+	UploadDrain   time.Duration `yaml:"upload_drain" toml:"upload_drain" env:"SFD_SHUTDOWN_UPLOAD_DRAIN"`
+}
+
+// Default returns the built-in configuration. The paths match the
+// container image layout.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:             ":8080",
+			TrustedProxyHops: 1,
+			WebEnabled:       true,
+		},
+		TLS:   TLS{HSTSMaxAge: 365 * 24 * time.Hour},
+		Build: Build{Version: "dev", Commit: "unknown"},
+		Auth: Auth{
+			AdminUser:    "admin",
+			SessionTTL:   12 * time.Hour,
+			CookieSecure: "auto",
+		},
+		Password: Password{MemoryKiB: 64 * 1024, Iterations: 3, Parallelism: 2},
+		Hash:     Hash{Tool: "/app/sfd-hash"},
+		Cleanup:  Cleanup{Interval: time.Hour, MaxAge: 24 * time.Hour},
+		Mail:     Mail{From: "no-reply@localhost"},
+		Registration: Registration{
+			Mode: "open",
+		},
+		RateLimit: RateLimit{Enabled: true, Store: "memory"},
+		Log:       Log{Format: "json", Level: "info"},
+		Trace:     Trace{Exporter: "none"},
+		Health:    Health{ReadyCacheTTL: 2 * time.Second, UploadDrain: 30 * time.Second},
+	}
+}
+
+// LookupFunc returns the value of an environment variable and whether it
+// is set; os.LookupEnv has this signature.
+type LookupFunc func(key string) (string, bool)
+
+// Load returns the defaults overlaid with the file at path (skipped when
+// path is empty) and then with the environment seen through lookup (nil
+// means os.LookupEnv). Empty variables count as unset. Load only reports
+// syntax errors; call Validate before using the result.
+func Load(path string, lookup LookupFunc) (Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	if err := applyEnv(&c, lookup); err != nil {
+		return Config{}, err
+	}
+	// Legacy whole-hours form, kept for existing deployments.
+	if v, ok := lookup("SFD_CLEANUP_MAX_AGE_HOURS"); ok && strings.TrimSpace(v) != "" {
+		hours, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("SFD_CLEANUP_MAX_AGE_HOURS: invalid integer %q", v)
+		}
+		c.Cleanup.MaxAge = time.Duration(hours) * time.Hour
+	}
+	for _, s := range []*string{&c.Auth.CookieSecure, &c.Registration.Mode, &c.RateLimit.Store, &c.Log.Format, &c.Trace.Exporter} {
+		*s = strings.ToLower(strings.TrimSpace(*s))
+	}
+	if c.Auth.AdminEmail == "" {
+		c.Auth.AdminEmail = c.Auth.AdminUser + "@localhost"
+	}
+	return c, nil
+}
+
+// loadFile decodes a .yaml/.yml or .toml file over c. Unknown keys are
+// rejected so a typo does not silently fall back to a default.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if extra := md.Undecoded(); len(extra) > 0 {
+			keys := make([]string, len(extra))
+			for i, k := range extra {
+				keys[i] = k.String()
+			}
+			return fmt.Errorf("config file %s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format (want .yaml, .yml or .toml)", path)
+	}
+	return nil
+}
+
+// ValidationError lists every invalid setting found by Validate.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks required settings, enumerations, units and ranges. It
+// returns nil or a *ValidationError naming each offending setting by file
+// key and environment variable.
+func (c Config) Validate() error {
+	v := validator{names: fieldNames()}
+
+	v.hostPort("server.addr", c.Server.Addr, true)
+	if c.Server.PublicBaseURL != "" {
+		u, err := url.Parse(c.Server.PublicBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("server.public_base_url", "must be an absolute http(s) URL, got %q", c.Server.PublicBaseURL)
+		} else if u.RawQuery != "" || u.Fragment != "" {
+			v.add("server.public_base_url", "must not contain a query or fragment")
+		}
+	}
+	if c.Server.MaxUploadBytes < 0 {
+		v.add("server.max_upload_bytes", "must not be negative")
+	}
+	if c.Server.TrustedProxyHops < 1 {
+		v.add("server.trusted_proxy_hops", "must be at least 1")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		v.add("tls.key_file", "tls.cert_file and tls.key_file must be set together")
+	}
+	if len(c.TLS.ACMEDomains) > 0 {
+		if c.TLS.CertFile != "" {
+			v.add("tls.acme_domains", "cannot be combined with tls.cert_file")
+		}
+		for _, d := range c.TLS.ACMEDomains {
+			if d == "" || strings.ContainsAny(d, ":/ *") {
+				v.add("tls.acme_domains", "must be plain host names, got %q", d)
+			}
+		}
+		v.required("tls.acme_cache_dir", c.TLS.ACMECacheDir)
+		if c.TLS.ACMEDirectoryURL != "" {
+			if u, err := url.Parse(c.TLS.ACMEDirectoryURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.add("tls.acme_directory_url", "must be an absolute http(s) URL, got %q", c.TLS.ACMEDirectoryURL)
+			}
+		}
+	}
+	v.hostPort("tls.redirect_addr", c.TLS.RedirectAddr, false)
+	if c.TLS.RedirectAddr != "" && !c.TLS.Enabled() {
+		v.add("tls.redirect_addr", "requires tls.cert_file or tls.acme_domains")
+	}
+	if c.TLS.HSTSMaxAge < 0 {
+		v.add("tls.hsts_max_age", "must not be negative")
+	}
+
+	v.required("auth.admin_user", c.Auth.AdminUser)
+	v.required("auth.admin_pass", c.Auth.AdminPass)
+	v.required("auth.session_secret", c.Auth.SessionSecret)
+	if c.Auth.SessionTTL < time.Minute {
+		v.add("auth.session_ttl", "must be at least 1m, got %s", c.Auth.SessionTTL)
+	}
+	v.oneOf("auth.cookie_secure", c.Auth.CookieSecure, "auto", "true", "false")
+
+	if c.Password.MemoryKiB < 8*1024 {
+		v.add("password.memory_kib", "must be at least 8192 (8 MiB), got %d", c.Password.MemoryKiB)
+	}
+	if c.Password.Iterations < 1 {
+		v.add("password.iterations", "must be at least 1")
+	}
+	if c.Password.Parallelism < 1 {
+		v.add("password.parallelism", "must be at least 1")
+	}
+
+	if v.required("database.url", c.Database.URL) {
+		if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			v.add("database.url", "must be a postgres:// or postgresql:// URL")
+		}
+	}
+
+	v.required("storage.endpoint", c.Storage.Endpoint)
+	v.required("storage.access_key", c.Storage.AccessKey)
+	v.required("storage.secret_key", c.Storage.SecretKey)
+	v.required("storage.bucket", c.Storage.Bucket)
+	v.required("downloads.secret", c.Downloads.Secret)
+	v.required("hash.tool", c.Hash.Tool)
+
+	if c.Cleanup.Enabled {
+		if c.Cleanup.Interval < time.Second {
+			v.add("cleanup.interval", "must be at least 1s, got %s", c.Cleanup.Interval)
+		}
+		if c.Cleanup.MaxAge < time.Minute {
+			v.add("cleanup.max_age", "must be at least 1m, got %s", c.Cleanup.MaxAge)
+		}
+	}
+
+	v.hostPort("mail.smtp_addr", c.Mail.SMTPAddr, false)
+
+	if v.oneOf("registration.mode", c.Registration.Mode, "open", "closed", "invite", "domain") &&
+		c.Registration.Mode == "domain" && len(c.Registration.Domains) == 0 {
+		v.add("registration.domains", "required when registration.mode is domain")
+	}
+
+	v.oneOf("rate_limit.store", c.RateLimit.Store, "memory", "postgres")
+	v.hostPort("metrics.addr", c.Metrics.Addr, false)
+
+	v.oneOf("log.format", c.Log.Format, "json", "text")
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		v.add("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	if v.oneOf("trace.exporter", c.Trace.Exporter, "none", "otlp", "stdout", "file") &&
+		c.Trace.Exporter == "file" && c.Trace.File == "" {
+		v.add("trace.file", "required when trace.exporter is file")
+	}
+
+	if c.Health.ReadyCacheTTL < 0 {
+		v.add("health.ready_cache_ttl", "must not be negative")
+	}
+	if c.Health.DrainDelay < 0 {
+		v.add("health.drain_delay", "must not be negative")
+	}
+	if c.Health.UploadDrain < 0 {
+		v.add("health.upload_drain", "must not be negative")
+	}
+
+	walk(reflectValue(&c), "", func(l leaf) {
+		if l.secret != "" && strings.Contains(l.value.String(), placeholder) {
+			v.add(l.key, "still contains the %s placeholder from .env.example", placeholder)
+		}
+	})
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// placeholder marks values in .env.example that must be replaced.
+const placeholder = "CHANGE_ME"
+
+// Warnings lists settings that are valid but risky: secrets shorter than
+// recommended, a missing or plain-HTTP public URL and insecure cookies over
+// TLS.
+func (c Config) Warnings() []string {
+	names := fieldNames()
+	var out []string
+	for _, s := range []struct {
+		key, value string
+		min        int
+	}{
+		{"auth.session_secret", c.Auth.SessionSecret, 64},
+		{"downloads.secret", c.Downloads.Secret, 64},
+		{"auth.admin_pass", c.Auth.AdminPass, 24},
+	} {
+		if s.value != "" && len(s.value) < s.min {
+			out = append(out, fmt.Sprintf("%s: shorter than the recommended %d characters", names.label(s.key), s.min))
+		}
+	}
+	if c.Server.PublicBaseURL == "" {
+		out = append(out, names.label("server.public_base_url")+": not set; emails with links (verification, password reset, invitations) are not sent")
+	}
+	if strings.HasPrefix(c.Server.PublicBaseURL, "http://") {
+		out = append(out, names.label("server.public_base_url")+": links will use plain HTTP")
+	}
+	if !c.Server.WebEnabled && c.Server.WebDir != "" {
+		out = append(out, names.label("server.web_dir")+": ignored because the web UI is disabled")
+	}
+	if c.TLS.Enabled() && c.Auth.CookieSecure == "false" {
+		out = append(out, names.label("auth.cookie_secure")+": cookies lack the Secure flag although TLS is enabled")
+	}
+	return out
+}
+
+// validator accumulates problems for Validate.
+type validator struct {
+	names    fieldNameMap
+	problems []string
+}
+
+func (v *validator) add(key, format string, args ...any) {
+	v.problems = append(v.problems, v.names.label(key)+": "+fmt.Sprintf(format, args...))
+}
+
+// required reports whether value is set, recording a problem otherwise.
+func (v *validator) required(key, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.add(key, "is required")
+		return false
+	}
+	return true
+}
+
+// oneOf reports whether value is among allowed, recording a problem
+// otherwise.
+func (v *validator) oneOf(key, value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	v.add(key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	return false
+}
+
+func (v *validator) hostPort(key, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add(key, "is required")
+		}
+		return
+	}
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		v.add(key, "must be host:port (e.g. :8080), got %q", value)
+	}
+}
+
+// WriteYAML prints c as YAML with every secret redacted, for --print-config.
+func (c Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
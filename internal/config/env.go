@@ -0,0 +1,222 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redactedValue replaces secrets in printed configuration.
+const redactedValue = "[REDACTED]"
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func reflectValue(ptr any) reflect.Value { return reflect.ValueOf(ptr).Elem() }
+
+// leaf is one configurable setting found by walking Config.
+type leaf struct {
+	key    string // dotted file key, e.g. "server.addr"
+	env    string
+	secret string // "", "true" or "url"
+	reload bool   // applied by a running server on reload
+	value  reflect.Value
+}
+
+// walk calls fn for every leaf field of the struct v, in declaration order.
+func walk(v reflect.Value, prefix string, fn func(leaf)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("yaml")
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		env := f.Tag.Get("env")
+		if env == "" && f.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), key, fn)
+			continue
+		}
+		fn(leaf{key: key, env: env, secret: f.Tag.Get("secret"), reload: f.Tag.Get("reload") == "true", value: v.Field(i)})
+	}
+}
+
+// applyEnv overrides every field whose variable is set and non-empty.
+func applyEnv(c *Config, lookup LookupFunc) error {
+	var err error
+	walk(reflectValue(c), "", func(l leaf) {
+		if err != nil || l.env == "" {
+			return
+		}
+		raw, ok := lookup(l.env)
+		if !ok || strings.TrimSpace(raw) == "" {
+			return
+		}
+		if serr := setValue(l.value, raw); serr != nil {
+			err = fmt.Errorf("%s: %w", l.env, serr)
+		}
+	})
+	return err
+}
+
+// setValue parses raw into v according to its type.
+func setValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (e.g. 30s, 5m, 1h)", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q (want true or false)", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q (max %d bits)", raw, v.Type().Bits())
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// fieldNameMap maps file keys to their environment variables.
+type fieldNameMap map[string]string
+
+func fieldNames() fieldNameMap {
+	m := fieldNameMap{}
+	var c Config
+	walk(reflectValue(&c), "", func(l leaf) { m[l.key] = l.env })
+	return m
+}
+
+// label names a setting in messages: "server.addr (SFD_ADDR)".
+func (m fieldNameMap) label(key string) string {
+	if env := m[key]; env != "" {
+		return key + " (" + env + ")"
+	}
+	return key
+}
+
+// RestartRequired returns the keys of settings that differ between c and
+// next but are only read at startup, i.e. everything a reload cannot apply.
+func (c Config) RestartRequired(next Config) []string {
+	old := map[string]any{}
+	walk(reflectValue(&c), "", func(l leaf) { old[l.key] = l.value.Interface() })
+	var keys []string
+	walk(reflectValue(&next), "", func(l leaf) {
+		if !l.reload && !reflect.DeepEqual(old[l.key], l.value.Interface()) {
+			keys = append(keys, l.key)
+		}
+	})
+	return keys
+}
+
+// Redacted returns a copy of c with secrets replaced by "[REDACTED]" and
+// passwords removed from URLs. Unset secrets stay empty, so the output
+// still shows what is missing.
+func (c Config) Redacted() Config {
+	walk(reflectValue(&c), "", func(l leaf) {
+		if l.value.Kind() != reflect.String || l.value.String() == "" {
+			return
+		}
+		switch l.secret {
+		case "true":
+			l.value.SetString(redactedValue)
+		case "url":
+			if u, err := url.Parse(l.value.String()); err == nil {
+				l.value.SetString(u.Redacted())
+			} else {
+				l.value.SetString(redactedValue)
+			}
+		}
+	})
+	return c
+}
+
+// ByteSize is a size in bytes. It accepts a plain integer or a number with
+// a unit: KB, MB, GB, TB (powers of 1000) or KiB, MiB, GiB, TiB (powers of
+// 1024).
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	// Longest suffixes first so "MiB" is not read as "B".
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// UnmarshalText parses sizes such as "1048576", "500MB" or "2GiB".
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	mult := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToLower(s), strings.ToLower(u.suffix)) {
+			s, mult = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return fmt.Errorf("invalid size %q (e.g. 1048576, 500MB, 2GiB)", string(text))
+	}
+	if f*float64(mult) > float64(1<<62) {
+		return fmt.Errorf("size %q is too large", string(text))
+	}
+	*b = ByteSize(f * float64(mult))
+	return nil
+}
+
+// MarshalText prints b with the largest binary unit that divides it
+// exactly, e.g. "100MiB"; other values are printed in bytes.
+func (b ByteSize) MarshalText() ([]byte, error) {
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if b != 0 && int64(b)%u.mult == 0 {
+			return []byte(strconv.FormatInt(int64(b)/u.mult, 10) + u.suffix), nil
+		}
+	}
+	return []byte(strconv.FormatInt(int64(b), 10)), nil
+}
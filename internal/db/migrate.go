@@ -1,10 +1,14 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
@@ -14,27 +18,175 @@ import (
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
-// RunMigrations applies all pending migrations to the database.
-// Returns nil if migrations succeed or are already at the latest version.
-func RunMigrations(db *sql.DB) error {
+// newMigrate returns a migrate instance over the embedded migrations.
+func newMigrate(db *sql.DB) (*migrate.Migrate, error) {
 	sourceDriver, err := iofs.New(migrationsFS, "migrations")
 	if err != nil {
-		return fmt.Errorf("failed to create migration source: %w", err)
+		return nil, fmt.Errorf("failed to create migration source: %w", err)
 	}
 
 	dbDriver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
-		return fmt.Errorf("failed to create database driver: %w", err)
+		return nil, fmt.Errorf("failed to create database driver: %w", err)
 	}
 
 	m, err := migrate.NewWithInstance("iofs", sourceDriver, "postgres", dbDriver)
 	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %w", err)
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	return m, nil
+}
+
+// RunMigrations applies all pending migrations to the database.
+// Returns nil if migrations succeed or are already at the latest version.
+func RunMigrations(db *sql.DB) error {
+	return MigrateUp(db, 0)
+}
+
+// MigrateUp applies up to steps pending migrations, or all of them when
+// steps is 0. Being up to date already is not an error.
+func MigrateUp(db *sql.DB, steps int) error {
+	if steps < 0 {
+		return errors.New("steps must not be negative")
+	}
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	if steps == 0 {
+		err = m.Up()
+	} else {
+		err = m.Steps(steps)
+	}
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migration failed: %w", err)
 	}
+	return nil
+}
 
-	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+// MigrateDown reverts the last steps applied migrations. Reverting
+// everything must be asked for explicitly with the migration count.
+func MigrateDown(db *sql.DB, steps int) error {
+	if steps < 1 {
+		return errors.New("steps must be at least 1")
+	}
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	if err := m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return fmt.Errorf("migration failed: %w", err)
 	}
+	return nil
+}
 
+// ForceVersion records version as applied and clears the dirty flag
+// without running any migration, after a failed migration was repaired by
+// hand. -1 records that no migration is applied.
+func ForceVersion(db *sql.DB, version int) error {
+	if version < -1 {
+		return errors.New("version must be -1 or greater")
+	}
+	if version > 0 {
+		ms, err := Migrations()
+		if err != nil {
+			return err
+		}
+		known := false
+		for _, mg := range ms {
+			known = known || mg.Version == uint(version)
+		}
+		if !known {
+			return fmt.Errorf("no embedded migration has version %d", version)
+		}
+	}
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	if err := m.Force(version); err != nil {
+		return fmt.Errorf("force version: %w", err)
+	}
 	return nil
 }
+
+// Migration is one embedded migration.
+type Migration struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+}
+
+// Migrations lists the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	var out []Migration
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".up.sql")
+		if !ok {
+			continue
+		}
+		prefix, rest, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, Migration{Version: uint(v), Name: rest})
+	}
+	return out, nil // ReadDir sorts by name, and versions are zero-padded
+}
+
+// LatestVersion returns the highest migration version embedded in the
+// binary, i.e. the version RunMigrations brings the database to.
+func LatestVersion() (uint, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	var latest uint
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		if uint(v) > latest {
+			latest = uint(v)
+		}
+	}
+	if latest == 0 {
+		return 0, errors.New("no embedded migrations")
+	}
+	return latest, nil
+}
+
+// CurrentVersion reads the applied migration version from the
+// schema_migrations table maintained by golang-migrate. dirty is true when
+// the last migration failed part-way and needs manual repair. Version 0
+// means no migration has been applied yet.
+func CurrentVersion(ctx context.Context, db *sql.DB) (version uint, dirty bool, err error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, false, fmt.Errorf("failed to read migration version: %w", err)
+	}
+	if !exists {
+		return 0, false, nil
+	}
+	var v int64
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read migration version: %w", err)
+	}
+	return uint(v), dirty, nil
+}
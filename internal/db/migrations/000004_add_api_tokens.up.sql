@@ -0,0 +1,33 @@
+-- Personal access tokens for scripts and CI
+-- Migration: 000004_add_api_tokens
+
+BEGIN;
+
+-- Tokens are stored hashed (SHA-256 of the plaintext, lowercase hex); the
+-- plaintext is only ever returned once, at creation time.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id           UUID PRIMARY KEY,
+
+    -- Session subject that owns the token (user id or legacy admin username).
+    subject      TEXT NOT NULL,
+
+    -- Human-readable label chosen by the owner.
+    name         TEXT NOT NULL,
+
+    -- Leading characters of the plaintext, used to recognise a token in listings.
+    prefix       TEXT NOT NULL,
+
+    token_hash   CHAR(64) NOT NULL UNIQUE,
+
+    -- Space-separated scope list, e.g. "files:write links:create".
+    scopes       TEXT NOT NULL,
+
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_subject ON api_tokens (subject);
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Rollback email verification and password reset
+BEGIN;
+
+DROP INDEX IF EXISTS idx_user_tokens_user_purpose;
+DROP TABLE IF EXISTS user_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+
+COMMIT;
@@ -0,0 +1,25 @@
+-- Email verification and password reset
+-- Migration: 000005_add_account_recovery
+
+BEGIN;
+
+-- NULL means the address has not been verified yet. Accounts that existed
+-- before verification was introduced are treated as verified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+UPDATE users SET email_verified_at = now() WHERE email_verified_at IS NULL;
+
+-- Single-use, time-limited tokens mailed to users. Only the SHA-256 of the
+-- plaintext token is stored.
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id         UUID PRIMARY KEY,
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose    TEXT NOT NULL CHECK (purpose IN ('verify_email','password_reset')),
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_purpose ON user_tokens (user_id, purpose);
+
+COMMIT;
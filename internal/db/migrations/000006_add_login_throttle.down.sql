@@ -0,0 +1,7 @@
+-- Rollback login brute-force protection
+BEGIN;
+
+DROP INDEX IF EXISTS idx_login_throttle_locked_until;
+DROP TABLE IF EXISTS login_throttle;
+
+COMMIT;
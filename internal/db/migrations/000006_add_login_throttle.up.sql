@@ -0,0 +1,18 @@
+-- Login brute-force protection
+-- Migration: 000006_add_login_throttle
+
+BEGIN;
+
+-- Failure counters shared by all replicas. Keys are namespaced:
+--   account:<lowercased username or email>
+--   ip:<client ip>
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key             TEXT PRIMARY KEY,
+    failures        INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttle_locked_until ON login_throttle (locked_until);
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Rollback shared rate limiter state
+BEGIN;
+
+DROP INDEX IF EXISTS idx_rate_limit_buckets_updated_at;
+DROP TABLE IF EXISTS rate_limit_buckets;
+
+COMMIT;
@@ -0,0 +1,16 @@
+-- Shared rate limiter state
+-- Migration: 000007_add_rate_limit_buckets
+
+BEGIN;
+
+-- Token buckets used when SFD_RATE_LIMIT_STORE=postgres, so that all
+-- replicas enforce a single budget per key.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key        TEXT PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
+
+COMMIT;
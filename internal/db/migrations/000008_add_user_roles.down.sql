@@ -0,0 +1,10 @@
+-- Rollback user roles and administrative account controls
+BEGIN;
+
+DROP INDEX IF EXISTS idx_files_created_by;
+DROP INDEX IF EXISTS idx_users_role;
+ALTER TABLE users DROP COLUMN IF EXISTS password_reset_required;
+ALTER TABLE users DROP COLUMN IF EXISTS sessions_revoked_at;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+
+COMMIT;
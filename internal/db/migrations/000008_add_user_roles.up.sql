@@ -0,0 +1,21 @@
+-- User roles and administrative account controls
+-- Migration: 000008_add_user_roles
+
+BEGIN;
+
+-- Only 'admin' accounts may use admin:* scopes. The bootstrap admin
+-- (SFD_ADMIN_USER) is promoted at startup.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin'));
+
+-- Sessions issued at or before this instant are rejected.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMPTZ;
+
+-- Set by an administrator; the user must complete a password reset before
+-- logging in again.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role);
+CREATE INDEX IF NOT EXISTS idx_files_created_by ON files (created_by);
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Rollback registration invites
+BEGIN;
+
+DROP INDEX IF EXISTS idx_invites_created_at;
+DROP TABLE IF EXISTS invites;
+
+COMMIT;
@@ -0,0 +1,24 @@
+-- Registration invites
+-- Migration: 000009_add_invites
+
+BEGIN;
+
+-- Single-use invite codes for SFD_REGISTRATION_MODE=invite. Only the SHA-256
+-- of the code is stored. email, when set, restricts the invite to that
+-- address; role is assigned to the account created with it.
+CREATE TABLE IF NOT EXISTS invites (
+    id          UUID PRIMARY KEY,
+    code_hash   CHAR(64) NOT NULL UNIQUE,
+    email       TEXT,
+    role        TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin')),
+    created_by  TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at  TIMESTAMPTZ NOT NULL,
+    used_at     TIMESTAMPTZ,
+    used_by     UUID REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_invites_created_at ON invites (created_at DESC);
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Rollback organizations
+BEGIN;
+
+DROP INDEX IF EXISTS idx_files_expires_at;
+DROP INDEX IF EXISTS idx_files_org_id;
+ALTER TABLE files DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE files DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
+
+COMMIT;
@@ -0,0 +1,39 @@
+-- Organizations with shared file spaces
+-- Migration: 000010_add_organizations
+
+BEGIN;
+
+-- Policy columns are optional; NULL means "no org-specific limit".
+--   max_link_ttl_seconds   caps download link lifetime for org files
+--   allowed_content_types  space-separated list, entries may be "type/*"
+--   retention_days         org files are deleted this long after upload
+CREATE TABLE IF NOT EXISTS organizations (
+    id                    UUID PRIMARY KEY,
+    name                  TEXT NOT NULL,
+    slug                  TEXT NOT NULL UNIQUE,
+    created_by            TEXT NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT now(),
+    max_link_ttl_seconds  INTEGER CHECK (max_link_ttl_seconds IS NULL OR max_link_ttl_seconds > 0),
+    allowed_content_types TEXT,
+    retention_days        INTEGER CHECK (retention_days IS NULL OR retention_days > 0)
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id     UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role       TEXT NOT NULL CHECK (role IN ('owner', 'member', 'viewer')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members (user_id);
+
+-- A file belongs to its uploader (created_by) unless org_id is set, in which
+-- case the organization owns it. Organizations with files cannot be deleted.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS org_id UUID REFERENCES organizations(id) ON DELETE RESTRICT;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_files_org_id ON files (org_id);
+CREATE INDEX IF NOT EXISTS idx_files_expires_at ON files (expires_at) WHERE expires_at IS NOT NULL;
+
+COMMIT;
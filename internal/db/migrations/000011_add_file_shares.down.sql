@@ -0,0 +1,7 @@
+-- Rollback per-file sharing and notifications
+BEGIN;
+
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS file_shares;
+
+COMMIT;
@@ -0,0 +1,32 @@
+-- Per-file sharing with registered users, and in-app notifications
+-- Migration: 000011_add_file_shares
+
+BEGIN;
+
+-- Grants access to one file for one user on top of ownership and org
+-- membership. viewer: download through the authenticated endpoint;
+-- editor: additionally create links and delete.
+CREATE TABLE IF NOT EXISTS file_shares (
+    file_id    UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission TEXT NOT NULL CHECK (permission IN ('viewer', 'editor')),
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (file_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_shares_user_id ON file_shares (user_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id         UUID PRIMARY KEY,
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind       TEXT NOT NULL,
+    message    TEXT NOT NULL,
+    file_id    UUID REFERENCES files(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    read_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id, created_at DESC);
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Rollback tamper-evident audit log
+BEGIN;
+
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+
+COMMIT;
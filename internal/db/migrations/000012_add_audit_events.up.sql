@@ -0,0 +1,44 @@
+-- Tamper-evident audit log
+-- Migration: 000012_add_audit_events
+
+BEGIN;
+
+-- One row per security-relevant event. seq is assigned by the writer as
+-- previous seq + 1 under an advisory lock, so a missing number means a
+-- deleted row. hash = sha256 over the row's canonical JSON, which includes
+-- prev_hash; the first row chains from 64 zeros.
+CREATE TABLE IF NOT EXISTS audit_events (
+    seq         BIGINT PRIMARY KEY CHECK (seq > 0),
+    occurred_at TIMESTAMPTZ NOT NULL,
+    event       TEXT NOT NULL,
+    actor       TEXT NOT NULL DEFAULT '',
+    request_id  TEXT NOT NULL DEFAULT '',
+    fields      JSONB NOT NULL DEFAULT '{}'::jsonb,
+    prev_hash   TEXT NOT NULL,
+    hash        TEXT NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred_at ON audit_events (occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_event ON audit_events (event, seq DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor, seq DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_file_id ON audit_events ((fields->>'file_id'));
+
+-- Append-only: reject changes through the application role. Verification
+-- still detects edits made by someone able to bypass the trigger.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_no_update ON audit_events;
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+COMMIT;
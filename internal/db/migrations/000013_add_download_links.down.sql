@@ -0,0 +1,7 @@
+-- Rollback revocable download links and signing keys
+BEGIN;
+
+DROP TABLE IF EXISTS download_links;
+DROP TABLE IF EXISTS download_keys;
+
+COMMIT;
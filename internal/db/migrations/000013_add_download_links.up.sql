@@ -0,0 +1,32 @@
+-- Revocable download links and rotatable link signing keys
+-- Migration: 000013_add_download_links
+
+BEGIN;
+
+-- Keys signing download links. The newest key without a retirement date
+-- signs new links; tokens name their key, so older keys keep verifying
+-- links until retired_at. The row with id '' and no secret stands for the
+-- configured SFD_DOWNLOAD_SECRET once it has been rotated out.
+CREATE TABLE IF NOT EXISTS download_keys (
+    id         TEXT PRIMARY KEY,
+    secret     BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    retired_at TIMESTAMPTZ,
+    CHECK (id = '' OR secret IS NOT NULL)
+);
+
+CREATE TABLE IF NOT EXISTS download_links (
+    id         UUID PRIMARY KEY,
+    file_id    UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    key_id     TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    revoked_by TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_links_file_id ON download_links (file_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_download_links_expires_at ON download_links (expires_at);
+
+COMMIT;
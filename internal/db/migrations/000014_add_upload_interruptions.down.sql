@@ -0,0 +1,7 @@
+-- Rollback upload interruption tracking
+BEGIN;
+
+DROP INDEX IF EXISTS idx_files_interrupted_at;
+ALTER TABLE files DROP COLUMN IF EXISTS interrupted_at;
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Uploads interrupted by a graceful shutdown
+-- Migration: 000014_add_upload_interruptions
+
+BEGIN;
+
+-- Set when shutdown cancels an upload past its drain deadline. Pending rows
+-- can be uploaded again (or are removed by cleanup); stored rows are hashed
+-- when the server next starts.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS interrupted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_files_interrupted_at ON files (interrupted_at)
+    WHERE interrupted_at IS NOT NULL;
+
+COMMIT;
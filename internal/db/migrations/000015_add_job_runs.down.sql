@@ -0,0 +1,6 @@
+-- Rollback job run history
+BEGIN;
+
+DROP TABLE IF EXISTS job_runs;
+
+COMMIT;
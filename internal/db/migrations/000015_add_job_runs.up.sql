@@ -0,0 +1,25 @@
+-- Background job run history
+-- Migration: 000015_add_job_runs
+
+BEGIN;
+
+-- One row per run of a background job (cleanup, upload resumption), from
+-- whichever instance ran it. Rows left 'running' belong to an instance that
+-- stopped without finishing the run.
+CREATE TABLE IF NOT EXISTS job_runs (
+    id          UUID PRIMARY KEY,
+    job         TEXT NOT NULL,
+    trigger     TEXT NOT NULL,
+    instance    TEXT NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded', 'failed')),
+    started_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ,
+    processed   INT NOT NULL DEFAULT 0,
+    failed      INT NOT NULL DEFAULT 0,
+    error       TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_job_started_at ON job_runs (job, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_job_runs_started_at ON job_runs (started_at DESC);
+
+COMMIT;
@@ -0,0 +1,6 @@
+-- Rollback maintenance mode
+BEGIN;
+
+DROP TABLE IF EXISTS maintenance_mode;
+
+COMMIT;
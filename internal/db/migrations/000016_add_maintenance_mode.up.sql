@@ -0,0 +1,19 @@
+-- Read-only maintenance mode shared by all replicas
+-- Migration: 000016_add_maintenance_mode
+
+BEGIN;
+
+-- A single row: while enabled, write endpoints answer 503 with Retry-After
+-- and downloads keep working.
+CREATE TABLE IF NOT EXISTS maintenance_mode (
+    id                  BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    enabled             BOOLEAN NOT NULL DEFAULT FALSE,
+    message             TEXT NOT NULL DEFAULT '',
+    retry_after_seconds INT NOT NULL DEFAULT 300 CHECK (retry_after_seconds > 0),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_by          TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO maintenance_mode (id) VALUES (TRUE) ON CONFLICT (id) DO NOTHING;
+
+COMMIT;
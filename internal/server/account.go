@@ -0,0 +1,498 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes for single-use tokens stored in user_tokens.
+const (
+	tokenPurposeVerifyEmail   = "verify_email"
+	tokenPurposePasswordReset = "password_reset"
+)
+
+// Lifetimes of mailed tokens. Reset tokens are deliberately short-lived.
+const (
+	verifyEmailTokenTTL   = 48 * time.Hour
+	passwordResetTokenTTL = 1 * time.Hour
+)
+
+var errEmailNotVerified = errors.New("email not verified")
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// mailer returns the configured Mailer, falling back to logging only.
+func (cfg Config) mailer() Mailer {
+	if cfg.Mailer == nil {
+		return LogMailer{}
+	}
+	return cfg.Mailer
+}
+
+// randomURLToken returns 32 random bytes encoded as base64url.
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// issueUserToken creates a single-use token for userID and returns its
+// plaintext. Any outstanding tokens for the same purpose are invalidated so
+// that only the most recently mailed link works.
+func issueUserToken(ctx context.Context, db *sql.DB, userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := db.ExecContext(ctx,
+		`UPDATE user_tokens SET used_at = now() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID, purpose,
+	); err != nil {
+		return "", err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO user_tokens (id, user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New(), userID, purpose, hashToken(token), time.Now().UTC().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken atomically marks a valid, unexpired, unused token as used
+// and returns the user it belongs to.
+func consumeUserToken(ctx context.Context, q queryRower, token, purpose string) (string, error) {
+	var userID string
+	err := q.QueryRowContext(ctx, `
+		UPDATE user_tokens SET used_at = now()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id
+	`, hashToken(token), purpose).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errBadToken
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+// sendVerificationEmail issues a verification token and mails the link.
+func (cfg Config) sendVerificationEmail(r *http.Request, userID, email string) error {
+	base, err := mailBaseURL(cfg.PublicBaseURL)
+	if err != nil {
+		return err
+	}
+	token, err := issueUserToken(r.Context(), cfg.DB, userID, tokenPurposeVerifyEmail, verifyEmailTokenTTL)
+	if err != nil {
+		return fmt.Errorf("issue token: %w", err)
+	}
+	link := base + "/verify-email?token=" + token
+	return cfg.mailer().Send(r.Context(), MailMessage{
+		To:      email,
+		Subject: "Verify your Secure File Drop email address",
+		Body: "Confirm your email address by opening the link below:\n\n" + link +
+			"\n\nThe link expires in 48 hours. If you did not create an account, ignore this message.\n",
+	})
+}
+
+// isEmailVerified reports whether the subject may use features that require a
+// verified address. The legacy admin account has no email and always passes.
+func isEmailVerified(ctx context.Context, db *sql.DB, subject string) (bool, error) {
+	if db == nil {
+		return true, nil
+	}
+	if _, err := uuid.Parse(subject); err != nil {
+		return true, nil
+	}
+	var verified bool
+	err := db.QueryRowContext(ctx,
+		`SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`,
+		subject,
+	).Scan(&verified)
+	if err != nil {
+		return false, err
+	}
+	return verified, nil
+}
+
+// requireVerified rejects principals whose email address is unverified. It
+// must be wrapped by requireAuth (or requireScope).
+func (a AuthConfig) requireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := isEmailVerified(r.Context(), a.DB, PrincipalFromContext(r.Context()).Subject)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, errEmailNotVerified.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyEmailHandler handles /verify-email.
+//
+// GET ?token=... is the link mailed to users; it redirects to the web UI with
+// the outcome in the query string. POST {"token": "..."} returns JSON.
+func (cfg Config) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var token string
+	switch r.Method {
+	case http.MethodGet:
+		token = r.URL.Query().Get("token")
+	case http.MethodPost:
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		token = body.Token
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := cfg.verifyEmail(r.Context(), strings.TrimSpace(token))
+
+	if r.Method == http.MethodGet {
+		outcome := "1"
+		if err != nil {
+			outcome = "0"
+		}
+		http.Redirect(w, r, "/?email_verified="+outcome, http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		if errors.Is(err, errBadToken) {
+			http.Error(w, "invalid or expired token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status": "verified",
+	})
+}
+
+func (cfg Config) verifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return errBadToken
+	}
+	tx, err := cfg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	userID, err := consumeUserToken(ctx, tx, token, tokenPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET email_verified_at = now(), updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND email_verified_at IS NULL`,
+		userID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// resendVerificationHandler handles POST /verify-email/resend for the
+// logged-in user.
+func (cfg Config) resendVerificationHandler() http.Handler {
+	return cfg.Auth.requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		subject := PrincipalFromContext(r.Context()).Subject
+		var (
+			email    string
+			verified bool
+		)
+		err := cfg.DB.QueryRowContext(r.Context(),
+			`SELECT email, email_verified_at IS NOT NULL FROM users WHERE id::text = $1`,
+			subject,
+		).Scan(&email, &verified)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "not a registered user", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if verified {
+			http.Error(w, "already verified", http.StatusConflict)
+			return
+		}
+
+		if err := cfg.sendVerificationEmail(r, subject, email); err != nil {
+			logFor(r.Context(), "account").Error("resend_verification_failed", errAttr(err))
+			http.Error(w, "failed to send email", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status": "sent",
+		})
+	}))
+}
+
+// forgotPasswordHandler handles POST /password/forgot {"email": "..."}.
+//
+// It always answers 202 so that the endpoint cannot be used to discover which
+// addresses are registered.
+func (cfg Config) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(strings.ToLower(body.Email))
+
+	if validateEmail(email) {
+		if err := cfg.sendPasswordReset(r, email); err != nil {
+			logFor(r.Context(), "account").Error("password_reset_mail_failed", errAttr(err))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+	})
+}
+
+func (cfg Config) sendPasswordReset(r *http.Request, email string) error {
+	var userID string
+	err := cfg.DB.QueryRowContext(r.Context(),
+		`SELECT id FROM users WHERE email = $1 AND is_active = TRUE`,
+		email,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	return mailPasswordReset(r, cfg.DB, cfg.mailer(), cfg.PublicBaseURL, userID, email)
+}
+
+// mailPasswordReset issues a reset token for userID and mails the link. It is
+// shared by the self-service flow and administrator-forced resets.
+func mailPasswordReset(r *http.Request, db *sql.DB, m Mailer, baseURL, userID, email string) error {
+	base, err := mailBaseURL(baseURL)
+	if err != nil {
+		return err
+	}
+	token, err := issueUserToken(r.Context(), db, userID, tokenPurposePasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		return fmt.Errorf("issue token: %w", err)
+	}
+	link := base + "/?reset_token=" + token
+	return m.Send(r.Context(), MailMessage{
+		To:      email,
+		Subject: "Reset your Secure File Drop password",
+		Body: "A password reset was requested for your account. Choose a new password here:\n\n" + link +
+			"\n\nThe link expires in 1 hour and can be used once. If you did not request this, ignore this message.\n",
+	})
+}
+
+// resetPasswordHandler handles POST /password/reset {"token": "...", "password": "..."}.
+func (cfg Config) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	body.Token = strings.TrimSpace(body.Token)
+	body.Password = strings.TrimSpace(body.Password)
+
+	if body.Token == "" {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if valid, msg := validatePassword(body.Password); !valid {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := hashPassword(body.Password)
+	if err != nil {
+		logFor(r.Context(), "account").Error("hash_failed", errAttr(err))
+		http.Error(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := cfg.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	userID, err := consumeUserToken(r.Context(), tx, body.Token, tokenPurposePasswordReset)
+	if err != nil {
+		if errors.Is(err, errBadToken) {
+			http.Error(w, "invalid or expired token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	// Receiving the reset mail proves ownership of the address as well. A
+	// reset also ends every existing session, so one held by an attacker
+	// does not outlive it.
+	if _, err := tx.ExecContext(r.Context(), `
+		UPDATE users
+		SET password_hash = $2, updated_at = CURRENT_TIMESTAMP,
+		    email_verified_at = COALESCE(email_verified_at, now()),
+		    password_reset_required = FALSE, sessions_revoked_at = now()
+		WHERE id = $1
+	`, userID, passwordHash); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	logFor(r.Context(), "account").Info("password_reset", slog.String(logKeyUserID, userID))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+	})
+}
+
+// changePasswordHandler handles POST /password/change for the logged-in user:
+// {"current_password": "...", "new_password": "..."}.
+func (cfg Config) changePasswordHandler() http.Handler {
+	return cfg.Auth.requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body.NewPassword = strings.TrimSpace(body.NewPassword)
+
+		if valid, msg := validatePassword(body.NewPassword); !valid {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		subject := PrincipalFromContext(r.Context()).Subject
+		var currentHash string
+		err := cfg.DB.QueryRowContext(r.Context(),
+			`SELECT password_hash FROM users WHERE id::text = $1 AND is_active = TRUE`,
+			subject,
+		).Scan(&currentHash)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "not a registered user", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		if !verifyPassword(strings.TrimSpace(body.CurrentPassword), currentHash) {
+			http.Error(w, "current password is incorrect", http.StatusForbidden)
+			return
+		}
+
+		newHash, err := hashPassword(body.NewPassword)
+		if err != nil {
+			logFor(r.Context(), "account").Error("hash_failed", errAttr(err))
+			http.Error(w, "Failed to process password", http.StatusInternalServerError)
+			return
+		}
+
+		// Every other session ends with the old password; the caller gets a
+		// fresh one issued at the revocation time, taken from the database
+		// so that clock skew with the application cannot revoke it too.
+		var revokedAt time.Time
+		if err := cfg.DB.QueryRowContext(r.Context(),
+			`UPDATE users SET password_hash = $2, sessions_revoked_at = now(), updated_at = CURRENT_TIMESTAMP
+			 WHERE id::text = $1 RETURNING sessions_revoked_at`,
+			subject, newHash,
+		).Scan(&revokedAt); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		logFor(r.Context(), "account").Info("password_changed", slog.String(logKeyUserID, subject))
+
+		csrf, err := cfg.Auth.startSession(w, r, subject, revokedAt)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status":     "ok",
+			"csrf_token": csrf,
+		})
+	}))
+}
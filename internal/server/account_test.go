@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomURLToken(t *testing.T) {
+	a, err := randomURLToken()
+	if err != nil {
+		t.Fatalf("randomURLToken error: %v", err)
+	}
+	b, _ := randomURLToken()
+	if a == b {
+		t.Fatal("expected unique tokens")
+	}
+	if len(a) != 43 {
+		t.Fatalf("unexpected token length: %d", len(a))
+	}
+}
+
+func TestIsEmailVerified_LegacyAdmin(t *testing.T) {
+	ok, err := isEmailVerified(context.Background(), nil, "admin")
+	if err != nil || !ok {
+		t.Fatalf("expected legacy admin to pass without DB, got %v %v", ok, err)
+	}
+}
+
+func TestRequireVerified_LegacyAdminPasses(t *testing.T) {
+	a := AuthConfig{}
+	called := false
+	h := a.requireVerified(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/files", nil)
+	req = req.WithContext(context.WithValue(req.Context(), principalKey, Principal{Subject: "admin"}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !called {
+		t.Fatal("expected handler to be called for legacy admin")
+	}
+}
+
+func TestAccountHandlers_InvalidMethod(t *testing.T) {
+	cfg := Config{}
+	handlers := map[string]http.HandlerFunc{
+		"/password/forgot": cfg.forgotPasswordHandler,
+		"/password/reset":  cfg.resetPasswordHandler,
+		"/verify-email":    cfg.verifyEmailHandler,
+	}
+	for path, h := range handlers {
+		req := httptest.NewRequest(http.MethodPut, path, nil)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: expected 405, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestResetPasswordHandler_Validation(t *testing.T) {
+	cfg := Config{}
+	cases := []struct {
+		name string
+		body string
+	}{
+		{"bad json", `{`},
+		{"missing token", `{"password":"abcdefg123"}`},
+		{"weak password", `{"token":"x","password":"short"}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/password/reset", bytes.NewBufferString(c.body))
+			rr := httptest.NewRecorder()
+			cfg.resetPasswordHandler(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailHandler_GetWithoutTokenRedirects(t *testing.T) {
+	cfg := Config{}
+	req := httptest.NewRequest(http.MethodGet, "/verify-email", nil)
+	rr := httptest.NewRecorder()
+	cfg.verifyEmailHandler(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d", rr.Code)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/?email_verified=0" {
+		t.Fatalf("unexpected redirect: %s", loc)
+	}
+}
+
+func TestChangePassword_RevokesOtherSessions(t *testing.T) {
+	db := openTestDB(t)
+	useTestPasswordParams(t)
+	cfg := Config{DB: db, Auth: AuthConfig{DB: db, SessionSecret: "s", SessionTTL: time.Hour}}
+
+	hash, err := hashPassword("old-password-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	if err := db.QueryRow(`INSERT INTO users (email, username, password_hash) VALUES ('bob@example.com', 'bob', $1) RETURNING id`, hash).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	issued := time.Now().Add(-time.Minute)
+	mine, _, _ := cfg.Auth.makeTokenAt(id, issued)
+	other, _, _ := cfg.Auth.makeTokenAt(id, issued)
+	sessionReq := func(method, path, tok, body string) *http.Request {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.AddCookie(&http.Cookie{Name: cfg.Auth.cookieName(), Value: tok})
+		return r
+	}
+
+	rr := httptest.NewRecorder()
+	cfg.changePasswordHandler().ServeHTTP(rr, sessionReq(http.MethodPost, "/password/change", mine,
+		`{"current_password":"old-password-1","new_password":"new-password-2"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("change: got %d %s", rr.Code, rr.Body.String())
+	}
+	var fresh string
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == cfg.Auth.cookieName() {
+			fresh = c.Value
+		}
+	}
+	if fresh == "" {
+		t.Fatal("no new session cookie")
+	}
+
+	me := cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	for _, c := range []struct {
+		name, tok string
+		want      int
+	}{{"other session", other, http.StatusUnauthorized}, {"reissued session", fresh, http.StatusOK}} {
+		rr := httptest.NewRecorder()
+		me.ServeHTTP(rr, sessionReq(http.MethodGet, "/me", c.tok, ""))
+		if rr.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rr.Code, c.want)
+		}
+	}
+}
+
+func TestResetPassword_RevokesSessions(t *testing.T) {
+	db := openTestDB(t)
+	useTestPasswordParams(t)
+	cfg := Config{DB: db, Auth: AuthConfig{DB: db, SessionSecret: "s", SessionTTL: time.Hour}}
+
+	var id string
+	if err := db.QueryRow(`INSERT INTO users (email, username, password_hash) VALUES ('bob@example.com', 'bob', 'x') RETURNING id`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	session, _, _ := cfg.Auth.makeTokenAt(id, time.Now().Add(-time.Minute))
+	token, err := issueUserToken(context.Background(), db, id, tokenPurposePasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	cfg.resetPasswordHandler(rr, httptest.NewRequest(http.MethodPost, "/password/reset",
+		strings.NewReader(`{"token":"`+token+`","password":"new-password-2"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reset: got %d %s", rr.Code, rr.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.AddCookie(&http.Cookie{Name: cfg.Auth.cookieName(), Value: session})
+	rr = httptest.NewRecorder()
+	cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})).ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("session from before the reset: got %d, want 401", rr.Code)
+	}
+}
+
+// recordingMailer keeps sent messages for inspection.
+type recordingMailer struct{ sent []MailMessage }
+
+func (m *recordingMailer) Send(_ context.Context, msg MailMessage) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestMailPasswordReset_RequiresPublicBaseURL(t *testing.T) {
+	m := &recordingMailer{}
+	r := httptest.NewRequest(http.MethodPost, "/password/forgot", nil)
+	r.Header.Set("X-Forwarded-Host", "evil.example")
+	if err := mailPasswordReset(r, nil, m, "", "user-id", "bob@example.com"); err != errNoPublicBaseURL {
+		t.Fatalf("expected errNoPublicBaseURL, got %v", err)
+	}
+	if len(m.sent) != 0 {
+		t.Fatalf("mail sent without a public base URL: %+v", m.sent)
+	}
+}
+
+func TestForgotPassword_IgnoresForwardedHost(t *testing.T) {
+	db := openTestDB(t)
+	m := &recordingMailer{}
+	cfg := Config{DB: db, Mailer: m, PublicBaseURL: "https://files.example.com"}
+	if _, err := db.Exec(`INSERT INTO users (email, username, password_hash) VALUES ('bob@example.com', 'bob', 'x')`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTrustProxyHeaders(false, 1) })
+	SetTrustProxyHeaders(true, 1)
+
+	r := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"email":"bob@example.com"}`))
+	r.Header.Set("X-Forwarded-Host", "evil.example")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	cfg.forgotPasswordHandler(rr, r)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	if len(m.sent) != 1 {
+		t.Fatalf("expected one mail, got %d", len(m.sent))
+	}
+	if body := m.sent[0].Body; !strings.Contains(body, "https://files.example.com/?reset_token=") || strings.Contains(body, "evil.example") {
+		t.Fatalf("unexpected reset link:\n%s", body)
+	}
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,59 +16,181 @@ import (
 
 // FileInfo represents a file record for admin listing
 type FileInfo struct {
-	ID          string    `json:"id"`
-	OrigName    string    `json:"orig_name"`
-	ContentType string    `json:"content_type"`
-	SizeBytes   int64     `json:"size_bytes"`
-	Status      string    `json:"status"`
-	SHA256Hex   string    `json:"sha256_hex,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string     `json:"id"`
+	ObjectKey   string     `json:"object_key"`
+	OrigName    string     `json:"orig_name"`
+	ContentType string     `json:"content_type"`
+	SizeBytes   int64      `json:"size_bytes"`
+	Status      string     `json:"status"`
+	SHA256Hex   string     `json:"sha256_hex,omitempty"`
+	CreatedBy   string     `json:"created_by"`
+	OrgID       string     `json:"org_id,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
-// AdminListFilesHandler returns all files for admin dashboard
-func (s *Server) AdminListFilesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// fileInfoColumns are the columns of FileInfo in scan order.
+const fileInfoColumns = `id, object_key, orig_name, content_type, size_bytes, status,
+	COALESCE(sha256_hex, ''), created_by, COALESCE(org_id::text, ''), expires_at, created_at`
+
+func scanFileInfo(sc interface{ Scan(...any) error }) (FileInfo, error) {
+	var (
+		f       FileInfo
+		expires sql.NullTime
+	)
+	err := sc.Scan(&f.ID, &f.ObjectKey, &f.OrigName, &f.ContentType, &f.SizeBytes, &f.Status,
+		&f.SHA256Hex, &f.CreatedBy, &f.OrgID, &expires, &f.CreatedAt)
+	if expires.Valid {
+		f.ExpiresAt = &expires.Time
 	}
+	return f, err
+}
 
-	// Query all files ordered by creation time (newest first)
-	rows, err := s.db.Query(`
-		SELECT id, orig_name, content_type, size_bytes, status, 
-		       COALESCE(sha256_hex, '') as sha256_hex, created_at, updated_at
-		FROM files 
-		ORDER BY created_at DESC
-		LIMIT 100
-	`)
+// FileFilter narrows ListFiles.
+type FileFilter struct {
+	Status string // pending, stored, hashed, ready or failed
+	Owner  string // created_by: the uploader's subject
+	Limit  int    // 0 means 100
+}
+
+// ListFiles returns files, newest first.
+func ListFiles(ctx context.Context, db *sql.DB, f FileFilter) ([]FileInfo, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, "status = $"+strconv.Itoa(len(args)))
+	}
+	if f.Owner != "" {
+		args = append(args, f.Owner)
+		where = append(where, "created_by = $"+strconv.Itoa(len(args)))
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	args = append(args, f.Limit)
+
+	rows, err := db.QueryContext(ctx, "SELECT "+fileInfoColumns+" FROM files"+cond+
+		" ORDER BY created_at DESC LIMIT $"+strconv.Itoa(len(args)), args...)
 	if err != nil {
-		log.Printf("admin list files: query failed: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
-	var files []FileInfo
+	files := []FileInfo{}
 	for rows.Next() {
-		var f FileInfo
-		if err := rows.Scan(&f.ID, &f.OrigName, &f.ContentType, &f.SizeBytes,
-			&f.Status, &f.SHA256Hex, &f.CreatedAt, &f.UpdatedAt); err != nil {
-			log.Printf("admin list files: scan failed: %v", err)
+		fi, err := scanFileInfo(rows)
+		if err != nil {
+			logFor(ctx, "admin").Error("list_files_scan_failed", errAttr(err))
 			continue
 		}
-		files = append(files, f)
+		files = append(files, fi)
 	}
+	return files, rows.Err()
+}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("admin list files: rows error: %v", err)
+// AdminListFilesHandler returns all files for admin dashboard
+func (s *Server) AdminListFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The 100 newest files
+	files, err := ListFiles(r.Context(), s.db, FileFilter{})
+	if err != nil {
+		logFor(r.Context(), "admin").Error("list_files_query_failed", errAttr(err))
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(files); err != nil {
-		log.Printf("admin list files: encode failed: %v", err)
+		logFor(r.Context(), "admin").Error("list_files_encode_failed", errAttr(err))
+	}
+}
+
+// ObjectInfo is what the object store reports for a file's object.
+type ObjectInfo struct {
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// FileDetails is a file with its stored object, shares and links.
+type FileDetails struct {
+	FileInfo
+	Object      *ObjectInfo `json:"object,omitempty"`
+	ObjectError string      `json:"object_error,omitempty"` // why Object is missing
+	Shares      int         `json:"shares"`
+	Links       []LinkInfo  `json:"links"`
+}
+
+// InspectFile gathers everything known about file id.
+func InspectFile(ctx context.Context, db *sql.DB, mc *minio.Client, bucket, id string) (FileDetails, error) {
+	fi, err := scanFileInfo(db.QueryRowContext(ctx, "SELECT "+fileInfoColumns+" FROM files WHERE id::text = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return FileDetails{}, errFileNotFound
+	}
+	if err != nil {
+		return FileDetails{}, err
+	}
+	d := FileDetails{FileInfo: fi}
+
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM file_shares WHERE file_id = $1`, fi.ID).Scan(&d.Shares); err != nil {
+		return d, err
+	}
+	if d.Links, err = ListLinks(ctx, db, LinkFilter{FileID: fi.ID, All: true}); err != nil {
+		return d, err
 	}
+
+	st, err := mc.StatObject(ctx, bucket, fi.ObjectKey, minio.StatObjectOptions{})
+	switch {
+	case err == nil:
+		d.Object = &ObjectInfo{Size: st.Size, ETag: st.ETag, LastModified: st.LastModified}
+	case minio.ToErrorResponse(err).Code == "NoSuchKey":
+		d.ObjectError = "object missing"
+	default:
+		d.ObjectError = err.Error()
+	}
+	return d, nil
+}
+
+// DeleteFile removes file id: first the row (with its shares and links),
+// then the stored object, so a failure never leaves a row pointing at a
+// deleted object. A failed object removal is logged; reconcile finds the
+// orphan later.
+func DeleteFile(ctx context.Context, db *sql.DB, mc *minio.Client, bucket, id string) (FileInfo, error) {
+	fi, err := scanFileInfo(db.QueryRowContext(ctx,
+		"DELETE FROM files WHERE id::text = $1 RETURNING "+fileInfoColumns, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return FileInfo{}, errFileNotFound
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	// Pending files were never uploaded
+	if fi.Status != "pending" && mc != nil {
+		rmCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := mc.RemoveObject(rmCtx, bucket, fi.ObjectKey, minio.RemoveObjectOptions{}); err != nil {
+			logFor(ctx, "admin").Error("delete_file_minio_removal_failed",
+				slog.String(logKeyFileID, fi.ID), errAttr(err))
+		}
+	}
+
+	recordAudit(ctx, "admin_file_deleted", map[string]string{
+		"file_id": fi.ID,
+		"status":  fi.Status,
+	})
+	return fi, nil
 }
 
 // AdminDeleteFileHandler deletes a specific file from both MinIO and database
@@ -83,47 +208,59 @@ func (s *Server) AdminDeleteFileHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	fileID := parts[0]
+	addLogAttrs(r.Context(), slog.String(logKeyFileID, fileID))
 
-	// Get file info before deletion (for MinIO cleanup)
-	var status string
-	err := s.db.QueryRow("SELECT status FROM files WHERE id = $1", fileID).Scan(&status)
-	if err != nil {
-		log.Printf("admin delete file: query failed: %v", err)
-		http.Error(w, "File not found", http.StatusNotFound)
+	if _, err := DeleteFile(r.Context(), s.db, s.minio, s.bucket, fileID); err != nil {
+		if errors.Is(err, errFileNotFound) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		logFor(r.Context(), "admin").Error("delete_file_db_delete_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Delete from MinIO if file was stored
-	if status != "pending" {
-		objectName := fileID
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	s.metrics.RecordFileStateTransition("deleted")
+	logFor(r.Context(), "admin").Info("file_deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		err := s.minio.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
-		if err != nil {
-			log.Printf("admin delete file: MinIO removal failed: %v", err)
-			// Continue with database deletion even if MinIO fails
-		} else {
-			log.Printf("admin delete file: removed from MinIO: %s", fileID)
-		}
-	}
+// VerifyResult compares a file's recorded hash with its stored object.
+type VerifyResult struct {
+	FileID   string `json:"file_id"`
+	Expected string `json:"expected_sha256"`
+	Actual   string `json:"actual_sha256"`
+	Size     uint64 `json:"size_bytes"`
+	OK       bool   `json:"ok"`
+}
 
-	// Delete from database
-	result, err := s.db.Exec("DELETE FROM files WHERE id = $1", fileID)
+// VerifyFile re-hashes the stored object of file id and compares it with
+// the hash recorded at upload.
+func VerifyFile(ctx context.Context, db *sql.DB, mc *minio.Client, bucket, id string) (VerifyResult, error) {
+	var objectKey, expected string
+	err := db.QueryRowContext(ctx,
+		`SELECT id, object_key, COALESCE(sha256_hex, '') FROM files WHERE id::text = $1`, id,
+	).Scan(&id, &objectKey, &expected)
+	if errors.Is(err, sql.ErrNoRows) {
+		return VerifyResult{}, errFileNotFound
+	}
 	if err != nil {
-		log.Printf("admin delete file: db delete failed: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		return VerifyResult{}, err
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+	if expected == "" {
+		return VerifyResult{}, errors.New("file has no recorded hash")
 	}
 
-	log.Printf("admin delete file: deleted file %s", fileID)
-	w.WriteHeader(http.StatusNoContent)
+	actual, _, size, err := sha256FromMinioObject(ctx, mc, bucket, objectKey)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	res := VerifyResult{FileID: id, Expected: expected, Actual: actual, Size: size, OK: actual == expected}
+	recordAudit(ctx, "file_verified", map[string]string{
+		"file_id": id,
+		"ok":      strconv.FormatBool(res.OK),
+	})
+	return res, nil
 }
 
 // CleanupResult represents the result of a cleanup operation
@@ -138,84 +275,121 @@ func (s *Server) AdminManualCleanupHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Get cleanup config from environment
-	cfg := GetCleanupConfigFromEnv(s.db, s.minio, s.bucket)
+	cfg := s.cleanupConfig()
 
 	if !cfg.Enabled {
-		log.Printf("admin manual cleanup: cleanup is disabled in config")
+		logFor(r.Context(), "admin").Warn("manual_cleanup_disabled")
 		http.Error(w, "Cleanup is disabled", http.StatusServiceUnavailable)
 		return
 	}
 
 	// Run cleanup synchronously for manual trigger
-	deletedCount := 0
-	cutoff := time.Now().Add(-cfg.MaxAge)
+	// A client disconnect does not abort the pass halfway.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 60*time.Second)
+	defer cancel()
+	report, err := RunCleanup(ctx, cfg, CleanupOptions{Trigger: "manual"})
+	if err != nil {
+		logFor(r.Context(), "admin").Error("manual_cleanup_query_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CleanupResult{DeletedCount: report.Deleted})
+}
 
-	log.Printf("admin manual cleanup: scanning for files older than %s", cutoff.Format(time.RFC3339))
+// LockoutInfo describes an active login lockout
+type LockoutInfo struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
 
-	rows, err := s.db.Query(`
-		SELECT id, status 
-		FROM files 
-		WHERE status IN ('pending', 'failed') 
-		  AND created_at < $1
-	`, cutoff)
+// AdminLockoutsHandler lists active login lockouts (GET) or clears them
+// (DELETE ?username=...&ip=...)
+func (s *Server) AdminLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.adminListLockouts(w, r)
+	case http.MethodDelete:
+		s.adminUnlock(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) adminListLockouts(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_throttle
+		WHERE locked_until > now()
+		ORDER BY locked_until DESC
+		LIMIT 100
+	`)
 	if err != nil {
-		log.Printf("admin manual cleanup: query failed: %v", err)
+		logFor(r.Context(), "admin").Error("lockouts_query_failed", errAttr(err))
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var toDelete []struct {
-		ID     string
-		Status string
-	}
-
+	lockouts := []LockoutInfo{}
 	for rows.Next() {
-		var item struct {
-			ID     string
-			Status string
-		}
-		if err := rows.Scan(&item.ID, &item.Status); err != nil {
-			log.Printf("admin manual cleanup: scan failed: %v", err)
+		var l LockoutInfo
+		if err := rows.Scan(&l.Key, &l.Failures, &l.LastFailureAt, &l.LockedUntil); err != nil {
+			logFor(r.Context(), "admin").Error("lockouts_scan_failed", errAttr(err))
 			continue
 		}
-		toDelete = append(toDelete, item)
+		lockouts = append(lockouts, l)
 	}
-
 	if err := rows.Err(); err != nil {
-		log.Printf("admin manual cleanup: rows error: %v", err)
+		logFor(r.Context(), "admin").Error("lockouts_rows_error", errAttr(err))
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Delete each file
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(lockouts); err != nil {
+		logFor(r.Context(), "admin").Error("lockouts_encode_failed", errAttr(err))
+	}
+}
 
-	for _, item := range toDelete {
-		// Remove from MinIO if not pending (pending files were never uploaded)
-		if item.Status != "pending" {
-			err := s.minio.RemoveObject(ctx, s.bucket, item.ID, minio.RemoveObjectOptions{})
-			if err != nil {
-				log.Printf("admin manual cleanup: MinIO removal failed for %s: %v", item.ID, err)
-			}
-		}
+func (s *Server) adminUnlock(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	ip := strings.TrimSpace(r.URL.Query().Get("ip"))
+	if username == "" && ip == "" {
+		http.Error(w, "username or ip required", http.StatusBadRequest)
+		return
+	}
+	if s.throttle == nil {
+		http.Error(w, "Login throttling is disabled", http.StatusServiceUnavailable)
+		return
+	}
 
-		// Remove from database
-		_, err := s.db.Exec("DELETE FROM files WHERE id = $1", item.ID)
-		if err != nil {
-			log.Printf("admin manual cleanup: db delete failed for %s: %v", item.ID, err)
-			continue
-		}
+	var keys []string
+	if username != "" {
+		keys = append(keys, s.throttle.accountKey(r.Context(), username))
+	}
+	if ip != "" {
+		keys = append(keys, ipThrottleKey(ip))
+	}
 
-		deletedCount++
-		log.Printf("admin manual cleanup: deleted file %s (status=%s, age > %s)",
-			item.ID, item.Status, cfg.MaxAge)
+	cleared, err := s.throttle.Reset(r.Context(), keys...)
+	if err != nil {
+		logFor(r.Context(), "admin").Error("unlock_reset_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
-	log.Printf("admin manual cleanup: completed, deleted %d files", deletedCount)
+	recordAudit(r.Context(), "login_unlock", map[string]string{
+		"actor":    PrincipalFromContext(r.Context()).Subject,
+		"username": username,
+		"ip":       ip,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CleanupResult{DeletedCount: deletedCount})
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"cleared": cleared,
+	})
 }
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEventList is a page of audit events, newest first.
+type AuditEventList struct {
+	Events []AuditEvent `json:"events"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+// auditFilter builds the WHERE clause shared by the audit listing and the
+// export from the query parameters event, actor, file_id, request_id,
+// since and until (RFC 3339).
+func auditFilter(q url.Values) (cond string, args []any, err error) {
+	var where []string
+	for _, f := range []struct{ param, column string }{
+		{"event", "event"},
+		{"actor", "actor"},
+		{"file_id", "fields->>'file_id'"},
+		{"request_id", "request_id"},
+	} {
+		if v := strings.TrimSpace(q.Get(f.param)); v != "" {
+			args = append(args, v)
+			where = append(where, f.column+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		v := q.Get(f.param)
+		if v == "" {
+			continue
+		}
+		t, perr := time.Parse(time.RFC3339, v)
+		if perr != nil {
+			return "", nil, perr
+		}
+		args = append(args, t)
+		where = append(where, "occurred_at "+f.op+" $"+strconv.Itoa(len(args)))
+	}
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	return cond, args, nil
+}
+
+// AdminAuditHandler handles the audit endpoints:
+//
+//	GET /admin/audit          filtered, paginated listing (limit, offset)
+//	GET /admin/audit/export   the filtered events as NDJSON, oldest first
+//	GET /admin/audit/verify   check the hash chain
+func (s *Server) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/admin/audit":
+		s.adminListAudit(w, r)
+	case "/admin/audit/export":
+		s.adminExportAudit(w, r)
+	case "/admin/audit/verify":
+		s.adminVerifyAudit(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) adminListAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, offset := parsePagination(q)
+	cond, args, err := auditFilter(q)
+	if err != nil {
+		http.Error(w, "invalid since/until (want RFC 3339)", http.StatusBadRequest)
+		return
+	}
+
+	list := AuditEventList{Events: []AuditEvent{}, Limit: limit, Offset: offset}
+	if err := s.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM audit_events"+cond, args...).Scan(&list.Total); err != nil {
+		logFor(r.Context(), "admin").Error("audit_count_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	pageArgs := append(args, limit, offset)
+	rows, err := s.db.QueryContext(r.Context(),
+		auditEventSelect+cond+" ORDER BY seq DESC"+
+			" LIMIT $"+strconv.Itoa(len(args)+1)+" OFFSET $"+strconv.Itoa(len(args)+2),
+		pageArgs...)
+	if err != nil {
+		logFor(r.Context(), "admin").Error("audit_query_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			logFor(r.Context(), "admin").Error("audit_scan_failed", errAttr(err))
+			continue
+		}
+		list.Events = append(list.Events, e)
+	}
+	if err := rows.Err(); err != nil {
+		logFor(r.Context(), "admin").Error("audit_rows_error", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		logFor(r.Context(), "admin").Error("audit_encode_failed", errAttr(err))
+	}
+}
+
+// adminExportAudit streams matching events one JSON object per line. Each
+// line carries seq, prev_hash and hash, so an unfiltered export can be
+// verified offline.
+func (s *Server) adminExportAudit(w http.ResponseWriter, r *http.Request) {
+	cond, args, err := auditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "invalid since/until (want RFC 3339)", http.StatusBadRequest)
+		return
+	}
+	rows, err := s.db.QueryContext(r.Context(), auditEventSelect+cond+" ORDER BY seq", args...)
+	if err != nil {
+		logFor(r.Context(), "admin").Error("audit_export_query_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.ndjson"`)
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			logFor(r.Context(), "admin").Error("audit_export_scan_failed", errAttr(err))
+			return
+		}
+		if err := enc.Encode(e); err != nil {
+			return // client went away
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logFor(r.Context(), "admin").Error("audit_export_rows_error", errAttr(err))
+	}
+}
+
+func (s *Server) adminVerifyAudit(w http.ResponseWriter, r *http.Request) {
+	res, err := VerifyAuditChain(r.Context(), s.db)
+	if err != nil {
+		logFor(r.Context(), "admin").Error("audit_verify_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -104,3 +104,29 @@ func TestFileInfo_JSONSerialization(t *testing.T) {
 		t.Errorf("expected 'test.txt', got %s", decoded.OrigName)
 	}
 }
+
+func TestAdminLockoutsHandler_InvalidMethod(t *testing.T) {
+	s := &Server{db: nil, minio: nil}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/lockouts", nil)
+	w := httptest.NewRecorder()
+
+	s.AdminLockoutsHandler(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+}
+
+func TestAdminLockoutsHandler_UnlockRequiresTarget(t *testing.T) {
+	s := &Server{db: nil, minio: nil}
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/lockouts", nil)
+	w := httptest.NewRecorder()
+
+	s.AdminLockoutsHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
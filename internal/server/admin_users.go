@@ -0,0 +1,686 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	minio "github.com/minio/minio-go/v7"
+)
+
+// AdminUserInfo describes an account in the admin user listing.
+type AdminUserInfo struct {
+	ID                    string     `json:"id"`
+	Username              string     `json:"username"`
+	Email                 string     `json:"email"`
+	Role                  string     `json:"role"`
+	IsActive              bool       `json:"is_active"`
+	EmailVerified         bool       `json:"email_verified"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	CreatedAt             time.Time  `json:"created_at"`
+	LastLogin             *time.Time `json:"last_login,omitempty"`
+	FileCount             int        `json:"file_count"`
+	StorageBytes          int64      `json:"storage_bytes"`
+	ActiveTokens          int        `json:"active_tokens"`
+}
+
+// AdminUserList is a page of AdminUserInfo.
+type AdminUserList struct {
+	Users  []AdminUserInfo `json:"users"`
+	Total  int             `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+// Pagination bounds shared by admin listings.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// parsePagination reads limit/offset query parameters, clamping limit to
+// (0, maxPageLimit] and offset to >= 0.
+func parsePagination(q url.Values) (limit, offset int) {
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	offset, err = strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// adminUserSelect returns the columns of AdminUserInfo in scan order. Files
+// are attributed to users through files.created_by (the uploader's subject).
+const adminUserSelect = `
+	SELECT u.id, u.username, u.email, u.role, u.is_active,
+	       u.email_verified_at IS NOT NULL, u.password_reset_required,
+	       u.created_at, u.last_login,
+	       (SELECT COUNT(*) FROM files f WHERE f.created_by = u.id::text),
+	       (SELECT COALESCE(SUM(f.size_bytes), 0) FROM files f WHERE f.created_by = u.id::text),
+	       (SELECT COUNT(*) FROM api_tokens t WHERE t.subject = u.id::text
+	            AND t.revoked_at IS NULL AND t.expires_at > now())
+	FROM users u`
+
+func scanAdminUser(sc interface{ Scan(...any) error }) (AdminUserInfo, error) {
+	var (
+		u         AdminUserInfo
+		lastLogin sql.NullTime
+	)
+	err := sc.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &u.IsActive,
+		&u.EmailVerified, &u.PasswordResetRequired,
+		&u.CreatedAt, &lastLogin,
+		&u.FileCount, &u.StorageBytes, &u.ActiveTokens)
+	if lastLogin.Valid {
+		u.LastLogin = &lastLogin.Time
+	}
+	return u, err
+}
+
+var (
+	errInvalidRole   = errors.New("invalid role")
+	errInvalidStatus = errors.New("invalid status")
+	errUserNotFound  = errors.New("user not found")
+	errLastAdmin     = errors.New("cannot remove the last active admin")
+)
+
+// UserFilter narrows ListUsers: Search is a substring of username or
+// email, Role is user or admin, Status is active or inactive.
+type UserFilter struct {
+	Search string
+	Role   string
+	Status string
+	Limit  int
+	Offset int
+}
+
+// ListUsers returns a page of accounts, newest first. An unknown role or
+// status yields errInvalidRole or errInvalidStatus.
+func ListUsers(ctx context.Context, db *sql.DB, f UserFilter) (AdminUserList, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if search := strings.TrimSpace(f.Search); search != "" {
+		args = append(args, "%"+escapeLike(search)+"%")
+		where = append(where, "(u.username ILIKE $"+strconv.Itoa(len(args))+" OR u.email ILIKE $"+strconv.Itoa(len(args))+")")
+	}
+	if f.Role != "" {
+		if f.Role != RoleUser && f.Role != RoleAdmin {
+			return AdminUserList{}, errInvalidRole
+		}
+		args = append(args, f.Role)
+		where = append(where, "u.role = $"+strconv.Itoa(len(args)))
+	}
+	switch f.Status {
+	case "":
+	case "active":
+		where = append(where, "u.is_active = TRUE")
+	case "inactive":
+		where = append(where, "u.is_active = FALSE")
+	default:
+		return AdminUserList{}, errInvalidStatus
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultPageLimit
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	list := AdminUserList{Users: []AdminUserInfo{}, Limit: f.Limit, Offset: f.Offset}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users u"+cond, args...).Scan(&list.Total); err != nil {
+		return list, err
+	}
+
+	pageArgs := append(args, f.Limit, f.Offset)
+	rows, err := db.QueryContext(ctx,
+		adminUserSelect+cond+" ORDER BY u.created_at DESC, u.id"+
+			" LIMIT $"+strconv.Itoa(len(args)+1)+" OFFSET $"+strconv.Itoa(len(args)+2),
+		pageArgs...)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			logFor(ctx, "admin").Error("list_users_scan_failed", errAttr(err))
+			continue
+		}
+		list.Users = append(list.Users, u)
+	}
+	return list, rows.Err()
+}
+
+// AdminUsersHandler handles GET /admin/users with optional filters:
+// q (substring of username or email), role, status (active|inactive),
+// limit and offset.
+func (s *Server) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset := parsePagination(q)
+	list, err := ListUsers(r.Context(), s.db, UserFilter{
+		Search: q.Get("q"),
+		Role:   q.Get("role"),
+		Status: q.Get("status"),
+		Limit:  limit,
+		Offset: offset,
+	})
+	switch {
+	case errors.Is(err, errInvalidRole), errors.Is(err, errInvalidStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		logFor(r.Context(), "admin").Error("list_users_query_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		logFor(r.Context(), "admin").Error("list_users_encode_failed", errAttr(err))
+	}
+}
+
+// NewUser is an account created by an operator (sfdctl user create).
+type NewUser struct {
+	Username string
+	Email    string
+	Password string
+	Role     string // defaults to user
+}
+
+// CreateUser creates an active account with a verified address, bypassing
+// the registration policy; it is how the first admin is bootstrapped.
+func CreateUser(ctx context.Context, db *sql.DB, nu NewUser) (AdminUserInfo, error) {
+	nu.Username = strings.TrimSpace(nu.Username)
+	nu.Email = strings.TrimSpace(strings.ToLower(nu.Email))
+	if nu.Role == "" {
+		nu.Role = RoleUser
+	}
+	if nu.Role != RoleUser && nu.Role != RoleAdmin {
+		return AdminUserInfo{}, errInvalidRole
+	}
+	if ok, msg := validateUsername(nu.Username); !ok {
+		return AdminUserInfo{}, errors.New(msg)
+	}
+	if !validateEmail(nu.Email) {
+		return AdminUserInfo{}, errors.New("invalid email address")
+	}
+	if ok, msg := validatePassword(nu.Password); !ok {
+		return AdminUserInfo{}, errors.New(msg)
+	}
+	hash, err := hashPassword(nu.Password)
+	if err != nil {
+		return AdminUserInfo{}, err
+	}
+
+	userID := uuid.NewString()
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO users (id, email, username, password_hash, role, email_verified_at)
+		VALUES ($1, $2, $3, $4, $5, now())
+		ON CONFLICT DO NOTHING
+	`, userID, nu.Email, nu.Username, hash, nu.Role)
+	if err != nil {
+		return AdminUserInfo{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return AdminUserInfo{}, errors.New("email or username already registered")
+	}
+	recordAudit(ctx, "admin_user_created", map[string]string{
+		"user_id": userID,
+		"role":    nu.Role,
+	})
+	return scanAdminUser(db.QueryRowContext(ctx, adminUserSelect+" WHERE u.id = $1", userID))
+}
+
+// resolveUser finds an account by id, username or email.
+func resolveUser(ctx context.Context, db *sql.DB, ref string) (AdminUserInfo, error) {
+	u, err := scanAdminUser(db.QueryRowContext(ctx,
+		adminUserSelect+" WHERE u.id::text = $1 OR u.username = $1 OR u.email = lower($1) ORDER BY u.id::text = $1 DESC LIMIT 1",
+		strings.TrimSpace(ref)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, errUserNotFound
+	}
+	return u, err
+}
+
+// SetUserActive disables or re-enables the account ref (id, username or
+// email). Disabling revokes its sessions.
+func SetUserActive(ctx context.Context, db *sql.DB, ref string, active bool) (AdminUserInfo, error) {
+	u, err := resolveUser(ctx, db, ref)
+	if err != nil {
+		return u, err
+	}
+	query, event := `UPDATE users SET is_active = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, "admin_user_reactivated"
+	if !active {
+		if err := checkNotLastAdmin(ctx, db, u); err != nil {
+			return u, err
+		}
+		query, event = `UPDATE users SET is_active = FALSE, sessions_revoked_at = now(), updated_at = CURRENT_TIMESTAMP WHERE id = $1`, "admin_user_deactivated"
+	}
+	if _, err := db.ExecContext(ctx, query, u.ID); err != nil {
+		return u, err
+	}
+	recordAudit(ctx, event, map[string]string{"user_id": u.ID})
+	u.IsActive = active
+	return u, nil
+}
+
+// SetUserRole changes the role of the account ref (id, username or email).
+func SetUserRole(ctx context.Context, db *sql.DB, ref, role string) (AdminUserInfo, error) {
+	if role != RoleUser && role != RoleAdmin {
+		return AdminUserInfo{}, errInvalidRole
+	}
+	u, err := resolveUser(ctx, db, ref)
+	if err != nil {
+		return u, err
+	}
+	if role != RoleAdmin {
+		if err := checkNotLastAdmin(ctx, db, u); err != nil {
+			return u, err
+		}
+	}
+	if _, err := db.ExecContext(ctx,
+		`UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, u.ID, role); err != nil {
+		return u, err
+	}
+	recordAudit(ctx, "admin_user_role_changed", map[string]string{"user_id": u.ID, "role": role})
+	u.Role = role
+	return u, nil
+}
+
+// checkNotLastAdmin refuses to demote or disable the only active admin,
+// which would leave nobody able to administer the instance.
+func checkNotLastAdmin(ctx context.Context, db *sql.DB, u AdminUserInfo) error {
+	if u.Role != RoleAdmin || !u.IsActive {
+		return nil
+	}
+	var others int
+	if err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM users WHERE role = 'admin' AND is_active AND id <> $1`, u.ID,
+	).Scan(&others); err != nil {
+		return err
+	}
+	if others == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+// escapeLike escapes LIKE wildcards so that search terms match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// AdminUserHandler handles the per-user endpoints:
+//
+//	GET    /admin/users/{id}
+//	POST   /admin/users/{id}/deactivate
+//	POST   /admin/users/{id}/reactivate
+//	POST   /admin/users/{id}/role                  {"role": "user"|"admin"}
+//	POST   /admin/users/{id}/force-password-reset
+//	POST   /admin/users/{id}/revoke-sessions
+//	DELETE /admin/users/{id}?files=delete|reassign[&reassign_to=<user>]
+func (s *Server) AdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/users/"), "/"), "/")
+	if len(parts) > 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := uuid.Parse(parts[0]); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	userID := parts[0]
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.adminGetUser(w, r, userID)
+		case http.MethodDelete:
+			s.adminDeleteUser(w, r, userID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if (parts[1] == "deactivate" || parts[1] == "role") && isSelf(r.Context(), userID) {
+		http.Error(w, "cannot change your own account", http.StatusBadRequest)
+		return
+	}
+	switch parts[1] {
+	case "deactivate":
+		_, err := SetUserActive(r.Context(), s.db, userID, false)
+		writeUserChange(w, r, err)
+	case "reactivate":
+		_, err := SetUserActive(r.Context(), s.db, userID, true)
+		writeUserChange(w, r, err)
+	case "revoke-sessions":
+		s.adminUpdateUser(w, r, userID, "admin_user_sessions_revoked", nil,
+			`UPDATE users SET sessions_revoked_at = now(), updated_at = CURRENT_TIMESTAMP WHERE id = $1`)
+	case "role":
+		var body struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_, err := SetUserRole(r.Context(), s.db, userID, body.Role)
+		writeUserChange(w, r, err)
+	case "force-password-reset":
+		s.adminForcePasswordReset(w, r, userID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// isSelf reports whether the admin is acting on their own account. Admins
+// may not lock themselves out (deactivate, demote, delete).
+func isSelf(ctx context.Context, userID string) bool {
+	return PrincipalFromContext(ctx).Subject == userID
+}
+
+func (s *Server) adminGetUser(w http.ResponseWriter, r *http.Request, userID string) {
+	u, err := scanAdminUser(s.db.QueryRowContext(r.Context(), adminUserSelect+" WHERE u.id = $1", userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logFor(r.Context(), "admin").Error("get_user_query_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		logFor(r.Context(), "admin").Error("get_user_encode_failed", errAttr(err))
+	}
+}
+
+// writeUserChange answers a SetUserActive or SetUserRole call.
+func writeUserChange(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status": "ok",
+		})
+	case errors.Is(err, errUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, errInvalidRole):
+		http.Error(w, "invalid role", http.StatusBadRequest)
+	case errors.Is(err, errLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logFor(r.Context(), "admin").Error("update_user_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// adminUpdateUser runs a single-row UPDATE keyed on the user id ($1) and
+// audits it as event.
+func (s *Server) adminUpdateUser(w http.ResponseWriter, r *http.Request, userID, event string, extra map[string]string, query string, args ...any) {
+	res, err := s.db.ExecContext(r.Context(), query, append([]any{userID}, args...)...)
+	if err != nil {
+		logFor(r.Context(), "admin").Error("update_user_failed", slog.String("event", event), errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	fields := map[string]string{
+		"actor":   PrincipalFromContext(r.Context()).Subject,
+		"user_id": userID,
+	}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	recordAudit(r.Context(), event, fields)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+	})
+}
+
+// adminForcePasswordReset blocks password logins for the user until they
+// complete a reset, revokes their sessions and mails a reset link.
+func (s *Server) adminForcePasswordReset(w http.ResponseWriter, r *http.Request, userID string) {
+	var email string
+	err := s.db.QueryRowContext(r.Context(), `
+		UPDATE users
+		SET password_reset_required = TRUE, sessions_revoked_at = now(), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING email
+	`, userID).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logFor(r.Context(), "admin").Error("force_reset_update_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	emailSent := true
+	m := s.mailer
+	if m == nil {
+		m = LogMailer{}
+	}
+	if err := mailPasswordReset(r, s.db, m, s.publicBaseURL, userID, email); err != nil {
+		logFor(r.Context(), "admin").Error("force_reset_mail_failed", errAttr(err))
+		emailSent = false
+	}
+
+	recordAudit(r.Context(), "admin_user_password_reset_forced", map[string]string{
+		"actor":      PrincipalFromContext(r.Context()).Subject,
+		"user_id":    userID,
+		"email_sent": strconv.FormatBool(emailSent),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status":     "ok",
+		"email_sent": emailSent,
+	})
+}
+
+// adminDeleteUser deletes an account. The caller must decide what happens to
+// the user's files: files=reassign moves them to reassign_to (a user id or
+// username), files=delete removes them from storage and the database.
+// Files uploaded to an organization stay with it either way, and the only
+// owner of an organization cannot be deleted until ownership is transferred.
+func (s *Server) adminDeleteUser(w http.ResponseWriter, r *http.Request, userID string) {
+	if isSelf(r.Context(), userID) {
+		http.Error(w, "cannot delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	mode := q.Get("files")
+	if mode != "reassign" && mode != "delete" {
+		http.Error(w, "files must be 'reassign' or 'delete'", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var username string
+	if err := tx.QueryRowContext(r.Context(), `SELECT username FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&username); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logFor(r.Context(), "admin").Error("delete_user_lookup_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var soleOwned string
+	err = tx.QueryRowContext(r.Context(), `
+		SELECT o.name FROM org_members m JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = $1 AND m.role = 'owner'
+		  AND NOT EXISTS (SELECT 1 FROM org_members x
+		                  WHERE x.org_id = m.org_id AND x.role = 'owner' AND x.user_id <> m.user_id)
+		ORDER BY o.name LIMIT 1`, userID).Scan(&soleOwned)
+	switch {
+	case err == nil:
+		http.Error(w, fmt.Sprintf("user is the only owner of organization %q; transfer ownership first", soleOwned), http.StatusConflict)
+		return
+	case err != sql.ErrNoRows:
+		logFor(r.Context(), "admin").Error("delete_user_owner_check_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		targetID string
+		objects  []string
+		affected int64
+	)
+	switch mode {
+	case "reassign":
+		target := strings.TrimSpace(q.Get("reassign_to"))
+		if target == "" {
+			http.Error(w, "reassign_to required", http.StatusBadRequest)
+			return
+		}
+		err := tx.QueryRowContext(r.Context(),
+			`SELECT id FROM users WHERE id::text = $1 OR username = $1`, target,
+		).Scan(&targetID)
+		if err != nil || targetID == userID {
+			http.Error(w, "invalid reassign_to user", http.StatusBadRequest)
+			return
+		}
+		res, err := tx.ExecContext(r.Context(),
+			`UPDATE files SET created_by = $2, user_id = $2::uuid WHERE (created_by = $1 OR user_id::text = $1) AND org_id IS NULL`,
+			userID, targetID)
+		if err != nil {
+			logFor(r.Context(), "admin").Error("delete_user_reassign_failed", errAttr(err))
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		affected, _ = res.RowsAffected()
+
+	case "delete":
+		rows, err := tx.QueryContext(r.Context(),
+			`DELETE FROM files WHERE (created_by = $1 OR user_id::text = $1) AND org_id IS NULL RETURNING object_key, status`, userID)
+		if err != nil {
+			logFor(r.Context(), "admin").Error("delete_user_file_delete_failed", errAttr(err))
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			var key, status string
+			if err := rows.Scan(&key, &status); err != nil {
+				continue
+			}
+			affected++
+			if status != "pending" {
+				objects = append(objects, key)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if _, err := tx.ExecContext(r.Context(), `DELETE FROM api_tokens WHERE subject = $1`, userID); err != nil {
+		logFor(r.Context(), "admin").Error("delete_user_token_delete_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		logFor(r.Context(), "admin").Error("delete_user_delete_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if mode == "delete" {
+		for i := int64(0); i < affected; i++ {
+			s.metrics.RecordFileStateTransition("deleted")
+		}
+	}
+
+	// Objects go only after the rows are gone, so a failed commit never
+	// leaves rows pointing at deleted objects.
+	if len(objects) > 0 && s.minio != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+		for _, key := range objects {
+			if err := s.minio.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+				logFor(r.Context(), "admin").Error("delete_user_minio_removal_failed", slog.String("object_key", key), errAttr(err))
+			}
+		}
+	}
+
+	fields := map[string]string{
+		"actor":    PrincipalFromContext(r.Context()).Subject,
+		"user_id":  userID,
+		"username": username,
+		"files":    mode,
+		"count":    strconv.FormatInt(affected, 10),
+	}
+	if targetID != "" {
+		fields["reassign_to"] = targetID
+	}
+	recordAudit(r.Context(), "admin_user_deleted", fields)
+
+	resp := map[string]any{"status": "deleted"}
+	if mode == "reassign" {
+		resp["files_reassigned"] = affected
+		resp["reassigned_to"] = targetID
+	} else {
+		resp["files_deleted"] = affected
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
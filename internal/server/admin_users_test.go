@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePagination(t *testing.T) {
+	cases := []struct {
+		query         string
+		limit, offset int
+	}{
+		{"", defaultPageLimit, 0},
+		{"limit=10&offset=20", 10, 20},
+		{"limit=0&offset=-5", defaultPageLimit, 0},
+		{"limit=100000", maxPageLimit, 0},
+		{"limit=abc&offset=xyz", defaultPageLimit, 0},
+	}
+	for _, c := range cases {
+		q, _ := url.ParseQuery(c.query)
+		limit, offset := parsePagination(q)
+		if limit != c.limit || offset != c.offset {
+			t.Errorf("%q: got (%d, %d), want (%d, %d)", c.query, limit, offset, c.limit, c.offset)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Fatalf("unexpected escape: %q", got)
+	}
+}
+
+func TestRequireScope_AdminScopeNeedsAdminRole(t *testing.T) {
+	cfg := AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}
+	tok, _, _ := cfg.makeToken("alice")
+
+	called := false
+	h := cfg.requireScope(ScopeAdminRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	req.AddCookie(&http.Cookie{Name: cfg.cookieName(), Value: tok})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden || called {
+		t.Fatalf("expected 403 for non-admin, got %d (called=%v)", rr.Code, called)
+	}
+
+	// Non-admin scopes are unaffected by the role.
+	rr = httptest.NewRecorder()
+	cfg.requireScope(ScopeFilesWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for files:write, got %d", rr.Code)
+	}
+}
+
+func TestLoadAccount_LegacySubjectRole(t *testing.T) {
+	a := AuthConfig{AdminUser: "admin"}
+
+	p := Principal{Subject: "admin"}
+	if err := a.loadAccount(context.Background(), &p, time.Time{}); err != nil || p.Role != RoleAdmin {
+		t.Fatalf("expected admin role, got %q (%v)", p.Role, err)
+	}
+	p = Principal{Subject: "bob"}
+	if err := a.loadAccount(context.Background(), &p, time.Time{}); err != nil || p.Role != RoleUser {
+		t.Fatalf("expected user role, got %q (%v)", p.Role, err)
+	}
+}
+
+func TestAdminUserHandler_Routing(t *testing.T) {
+	s := &Server{}
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/admin/users/not-a-uuid", http.StatusNotFound},
+		{http.MethodGet, "/admin/users/", http.StatusNotFound},
+		{http.MethodPut, "/admin/users/6f1c2a9e-8d4b-4f5a-9c3e-2b7d1e0a4c11", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/users/6f1c2a9e-8d4b-4f5a-9c3e-2b7d1e0a4c11/deactivate", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/users/6f1c2a9e-8d4b-4f5a-9c3e-2b7d1e0a4c11/unknown", http.StatusNotFound},
+		{http.MethodDelete, "/admin/users/6f1c2a9e-8d4b-4f5a-9c3e-2b7d1e0a4c11", http.StatusBadRequest}, // files= missing
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		s.AdminUserHandler(rr, httptest.NewRequest(c.method, c.path, nil))
+		if rr.Code != c.want {
+			t.Errorf("%s %s: got %d, want %d", c.method, c.path, rr.Code, c.want)
+		}
+	}
+}
+
+func TestAdminUserHandler_RejectsSelfChanges(t *testing.T) {
+	const id = "6f1c2a9e-8d4b-4f5a-9c3e-2b7d1e0a4c11"
+	s := &Server{}
+	ctx := context.WithValue(context.Background(), principalKey, Principal{Subject: id, Role: RoleAdmin})
+
+	for _, path := range []string{"/admin/users/" + id + "/deactivate", "/admin/users/" + id + "/role"} {
+		rr := httptest.NewRecorder()
+		s.AdminUserHandler(rr, httptest.NewRequest(http.MethodPost, path, nil).WithContext(ctx))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	s.AdminUserHandler(rr, httptest.NewRequest(http.MethodDelete, "/admin/users/"+id+"?files=delete", nil).WithContext(ctx))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("self delete: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestListUsers_RejectsUnknownFilters(t *testing.T) {
+	// Filters are checked before the database is touched.
+	if _, err := ListUsers(context.Background(), nil, UserFilter{Role: "root"}); err != errInvalidRole {
+		t.Errorf("role: %v", err)
+	}
+	if _, err := ListUsers(context.Background(), nil, UserFilter{Status: "banned"}); err != errInvalidStatus {
+		t.Errorf("status: %v", err)
+	}
+	if _, err := SetUserRole(context.Background(), nil, "alice", "root"); err != errInvalidRole {
+		t.Errorf("set role: %v", err)
+	}
+	if _, err := CreateUser(context.Background(), nil, NewUser{Username: "alice", Email: "a@example.com", Password: "short"}); err == nil {
+		t.Error("weak password accepted")
+	}
+}
+
+func TestAdminDeleteUser_KeepsOrgFiles(t *testing.T) {
+	db := openTestDB(t)
+	s := &Server{db: db}
+	ctx := context.WithValue(context.Background(), principalKey, Principal{Subject: "admin", Role: RoleAdmin})
+
+	var owner, member, org string
+	for _, u := range []struct {
+		name string
+		id   *string
+	}{{"owner", &owner}, {"member", &member}} {
+		if err := db.QueryRow(`INSERT INTO users (email, username, password_hash) VALUES ($1 || '@example.com', $1, 'x') RETURNING id`,
+			u.name).Scan(u.id); err != nil {
+			t.Fatalf("create %s: %v", u.name, err)
+		}
+	}
+	if err := db.QueryRow(`INSERT INTO organizations (id, name, slug, created_by) VALUES (gen_random_uuid(), 'Acme', 'acme', $1) RETURNING id`,
+		owner).Scan(&org); err != nil {
+		t.Fatalf("create org: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, 'owner'), ($1, $3, 'member')`, org, owner, member); err != nil {
+		t.Fatalf("add members: %v", err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO files (id, object_key, orig_name, content_type, size_bytes, created_by, user_id, org_id) VALUES
+		(gen_random_uuid(), 'uploads/personal', 'personal.txt', 'text/plain', 1, $1, $1::uuid, NULL),
+		(gen_random_uuid(), 'uploads/shared', 'shared.txt', 'text/plain', 1, $1, $1::uuid, $2::uuid)`, member, org); err != nil {
+		t.Fatalf("create files: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	s.AdminUserHandler(rr, httptest.NewRequest(http.MethodDelete, "/admin/users/"+owner+"?files=delete", nil).WithContext(ctx))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("only owner: expected 409, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	s.AdminUserHandler(rr, httptest.NewRequest(http.MethodDelete, "/admin/users/"+member+"?files=delete", nil).WithContext(ctx))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete member: got %d %s", rr.Code, rr.Body.String())
+	}
+	var keys []string
+	rows, err := db.Query(`SELECT object_key FROM files ORDER BY object_key`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		_ = rows.Scan(&k)
+		keys = append(keys, k)
+	}
+	if len(keys) != 1 || keys[0] != "uploads/shared" {
+		t.Fatalf("remaining files: %v, want only the org file", keys)
+	}
+}
+
+func TestAdminUserHandler_InvalidRole(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&Server{}).AdminUserHandler(rr, httptest.NewRequest(http.MethodPost,
+		"/admin/users/6f1c2a9e-8d4b-4f5a-9c3e-2b7d1e0a4c11/role", strings.NewReader(`{"role":"root"}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestAdminUserHandler_KeepsLastAdmin(t *testing.T) {
+	db := openTestDB(t)
+	s := &Server{db: db}
+	// The legacy environment admin has no database row of its own.
+	ctx := context.WithValue(context.Background(), principalKey, Principal{Subject: "admin", Role: RoleAdmin})
+
+	var id string
+	if err := db.QueryRow(`INSERT INTO users (email, username, password_hash, role) VALUES ('root@example.com', 'root', 'x', 'admin') RETURNING id`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ path, body string }{
+		{"/admin/users/" + id + "/deactivate", ""},
+		{"/admin/users/" + id + "/role", `{"role":"user"}`},
+	} {
+		rr := httptest.NewRecorder()
+		s.AdminUserHandler(rr, httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body)).WithContext(ctx))
+		if rr.Code != http.StatusConflict {
+			t.Errorf("%s: expected 409, got %d %s", c.path, rr.Code, rr.Body.String())
+		}
+	}
+	var role string
+	var active bool
+	if err := db.QueryRow(`SELECT role, is_active FROM users WHERE id = $1`, id).Scan(&role, &active); err != nil {
+		t.Fatal(err)
+	}
+	if role != RoleAdmin || !active {
+		t.Fatalf("last admin changed: role=%s active=%v", role, active)
+	}
+}
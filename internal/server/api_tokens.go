@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes that can be granted to personal API tokens. Session-authenticated
+// requests implicitly hold every scope.
+const (
+	ScopeFilesWrite  = "files:write"
+	ScopeLinksCreate = "links:create"
+	ScopeAdminRead   = "admin:read"
+	ScopeAdminWrite  = "admin:write"
+)
+
+// apiTokenPrefix marks plaintext tokens so they are easy to spot in logs,
+// CI configuration and secret scanners.
+const apiTokenPrefix = "sfd_pat_"
+
+var knownScopes = map[string]bool{
+	ScopeFilesWrite:  true,
+	ScopeLinksCreate: true,
+	ScopeAdminRead:   true,
+	ScopeAdminWrite:  true,
+}
+
+var errUnknownScope = errors.New("unknown scope")
+
+// createTokenReq is the JSON payload for POST /tokens.
+type createTokenReq struct {
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	TTLSeconds int      `json:"ttl_seconds"`
+}
+
+// createTokenResp is returned once on creation; it is the only time the
+// plaintext token is ever shown.
+type createTokenResp struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Token     string   `json:"token"`
+	Prefix    string   `json:"prefix"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+// APITokenInfo describes a stored token for listings (never includes the secret).
+type APITokenInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// clampTokenTTLSeconds enforces lifetime constraints for API tokens.
+// Default: 30 days if omitted or invalid. Maximum: 365 days.
+func clampTokenTTLSeconds(n int) int {
+	const day = 86400
+	if n <= 0 {
+		return 30 * day
+	}
+	if n > 365*day {
+		return 365 * day
+	}
+	return n
+}
+
+// generateAPIToken returns a new random plaintext token and its display prefix.
+func generateAPIToken() (token, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, token[:len(apiTokenPrefix)+6], nil
+}
+
+// hashToken returns the lowercase hex SHA-256 of a plaintext token.
+// Tokens carry 256 bits of entropy, so a fast hash is sufficient here.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// normaliseScopes validates, de-duplicates and sorts a requested scope list.
+func normaliseScopes(in []string) ([]string, error) {
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			continue
+		}
+		if !knownScopes[s] {
+			return nil, errUnknownScope
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// lookupAPIToken resolves a bearer token to a Principal, rejecting revoked or
+// expired tokens, and records the time of use.
+func lookupAPIToken(ctx context.Context, db *sql.DB, token string) (Principal, error) {
+	var (
+		p         Principal
+		scopes    string
+		expiresAt time.Time
+		revokedAt sql.NullTime
+	)
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return p, errBadToken
+	}
+
+	err := db.QueryRowContext(ctx,
+		`SELECT id, subject, scopes, expires_at, revoked_at FROM api_tokens WHERE token_hash = $1`,
+		hashToken(token),
+	).Scan(&p.TokenID, &p.Subject, &scopes, &expiresAt, &revokedAt)
+	if err != nil {
+		return Principal{}, errBadToken
+	}
+	if revokedAt.Valid {
+		return Principal{}, errBadToken
+	}
+	if !time.Now().Before(expiresAt) {
+		return Principal{}, errTokenExpired
+	}
+	p.Scopes = strings.Fields(scopes)
+
+	// Best-effort usage tracking; a failure here must not block the request.
+	if _, err := db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = now() WHERE id = $1`, p.TokenID); err != nil {
+		logFor(ctx, "api_tokens").Error("last_used_update_failed", errAttr(err))
+	}
+
+	return p, nil
+}
+
+// tokensHandler handles GET /tokens (list the caller's tokens) and
+// POST /tokens (create a new token).
+//
+// Authentication: session cookie only; a token cannot be used to mint or
+// enumerate other tokens.
+func (cfg Config) tokensHandler(db *sql.DB) http.Handler {
+	return cfg.Auth.requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listTokens(w, r, db)
+		case http.MethodPost:
+			createToken(w, r, db)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func createToken(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var req createTokenReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "bad name", http.StatusBadRequest)
+		return
+	}
+
+	scopes, err := normaliseScopes(req.Scopes)
+	if err != nil || len(scopes) == 0 {
+		http.Error(w, "bad scopes", http.StatusBadRequest)
+		return
+	}
+	if PrincipalFromContext(r.Context()).Role != RoleAdmin {
+		for _, s := range scopes {
+			if isAdminScope(s) {
+				http.Error(w, "admin scopes require the admin role", http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	token, prefix, err := generateAPIToken()
+	if err != nil {
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	ttl := clampTokenTTLSeconds(req.TTLSeconds)
+	expiresAt := time.Now().UTC().Add(time.Duration(ttl) * time.Second)
+	id := uuid.New()
+	subject := PrincipalFromContext(r.Context()).Subject
+
+	_, err = db.ExecContext(r.Context(), `
+		INSERT INTO api_tokens (id, subject, name, prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, id, subject, req.Name, prefix, hashToken(token), strings.Join(scopes, " "), expiresAt)
+	if err != nil {
+		logFor(r.Context(), "api_tokens").Error("insert_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(createTokenResp{
+		ID:        id.String(),
+		Name:      req.Name,
+		Token:     token,
+		Prefix:    prefix,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+	})
+}
+
+func listTokens(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	subject := PrincipalFromContext(r.Context()).Subject
+
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at
+		FROM api_tokens
+		WHERE subject = $1
+		ORDER BY created_at DESC
+	`, subject)
+	if err != nil {
+		logFor(r.Context(), "api_tokens").Error("list_query_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []APITokenInfo{}
+	for rows.Next() {
+		var (
+			t        APITokenInfo
+			scopes   string
+			lastUsed sql.NullTime
+			revoked  sql.NullTime
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.ExpiresAt, &lastUsed, &revoked); err != nil {
+			logFor(r.Context(), "api_tokens").Error("scan_failed", errAttr(err))
+			continue
+		}
+		t.Scopes = strings.Fields(scopes)
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		if revoked.Valid {
+			t.RevokedAt = &revoked.Time
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		logFor(r.Context(), "api_tokens").Error("rows_error", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tokens)
+}
+
+// revokeTokenHandler handles DELETE /tokens/{id}. Tokens are soft-revoked so
+// that listings keep an audit trail of what existed.
+func (cfg Config) revokeTokenHandler(db *sql.DB) http.Handler {
+	return cfg.Auth.requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/tokens/"))
+		if err != nil {
+			http.Error(w, "bad id", http.StatusBadRequest)
+			return
+		}
+
+		subject := PrincipalFromContext(r.Context()).Subject
+		res, err := db.ExecContext(r.Context(),
+			`UPDATE api_tokens SET revoked_at = now() WHERE id = $1 AND subject = $2 AND revoked_at IS NULL`,
+			id, subject,
+		)
+		if err != nil {
+			logFor(r.Context(), "api_tokens").Error("revoke_failed", errAttr(err))
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateAPIToken(t *testing.T) {
+	tok, prefix, err := generateAPIToken()
+	if err != nil {
+		t.Fatalf("generateAPIToken error: %v", err)
+	}
+	if !strings.HasPrefix(tok, apiTokenPrefix) {
+		t.Fatalf("token missing prefix: %s", tok)
+	}
+	if !strings.HasPrefix(tok, prefix) {
+		t.Fatalf("display prefix %q is not a prefix of token", prefix)
+	}
+
+	other, _, _ := generateAPIToken()
+	if tok == other {
+		t.Fatal("expected unique tokens")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	h := hashToken("sfd_pat_abc")
+	if len(h) != 64 {
+		t.Fatalf("unexpected hash length: %d", len(h))
+	}
+	if h != hashToken("sfd_pat_abc") {
+		t.Fatal("hash is not deterministic")
+	}
+	if h == hashToken("sfd_pat_abd") {
+		t.Fatal("different tokens produced the same hash")
+	}
+}
+
+func TestNormaliseScopes(t *testing.T) {
+	got, err := normaliseScopes([]string{" links:create", "files:write", "links:create", ""})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(got, " ") != "files:write links:create" {
+		t.Fatalf("unexpected scopes: %v", got)
+	}
+
+	if _, err := normaliseScopes([]string{"files:delete"}); err == nil {
+		t.Fatal("expected error for unknown scope")
+	}
+}
+
+func TestClampTokenTTLSeconds(t *testing.T) {
+	cases := []struct{ in, out int }{{0, 30 * 86400}, {-5, 30 * 86400}, {3600, 3600}, {400 * 86400, 365 * 86400}}
+	for _, c := range cases {
+		if got := clampTokenTTLSeconds(c.in); got != c.out {
+			t.Fatalf("clampTokenTTLSeconds(%d) = %d, want %d", c.in, got, c.out)
+		}
+	}
+}
+
+func TestPrincipalHasScope(t *testing.T) {
+	session := Principal{Subject: "admin"}
+	if !session.HasScope(ScopeAdminWrite) {
+		t.Fatal("session principal should hold every scope")
+	}
+
+	token := Principal{Subject: "u1", TokenID: "t1", Scopes: []string{ScopeFilesWrite}}
+	if !token.HasScope(ScopeFilesWrite) {
+		t.Fatal("expected files:write")
+	}
+	if token.HasScope(ScopeAdminRead) {
+		t.Fatal("token should not hold admin:read")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := bearerToken(r); ok {
+		t.Fatal("expected no bearer token")
+	}
+	r.Header.Set("Authorization", "bearer sfd_pat_xyz")
+	if tok, ok := bearerToken(r); !ok || tok != "sfd_pat_xyz" {
+		t.Fatalf("unexpected bearer parse: %q %v", tok, ok)
+	}
+	r.Header.Set("Authorization", "Basic abc")
+	if _, ok := bearerToken(r); ok {
+		t.Fatal("basic auth must not parse as bearer")
+	}
+}
+
+func TestRequireScope_SessionCookie(t *testing.T) {
+	cfg := AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}
+	tok, _, err := cfg.makeToken("admin")
+	if err != nil {
+		t.Fatalf("makeToken error: %v", err)
+	}
+
+	var got Principal
+	h := cfg.requireScope(ScopeAdminWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = PrincipalFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cleanup", nil)
+	req.AddCookie(&http.Cookie{Name: cfg.cookieName(), Value: tok})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if got.Subject != "admin" {
+		t.Fatalf("unexpected principal: %+v", got)
+	}
+}
+
+func TestRequireAuth_BearerWithoutDB(t *testing.T) {
+	cfg := AuthConfig{SessionSecret: "s"}
+	h := cfg.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer sfd_pat_whatever")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
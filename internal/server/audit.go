@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// auditGenesisHash is the prev_hash of the first audit event.
+var auditGenesisHash = strings.Repeat("0", 64)
+
+// auditLockKey serialises appends across instances (pg_advisory_xact_lock),
+// so every event sees the hash of the one before it.
+const auditLockKey int64 = 0x5346444155444954 // "SFDAUDIT"
+
+// auditWriteTimeout bounds a single append; the request may already be
+// cancelled when the event is recorded.
+const auditWriteTimeout = 5 * time.Second
+
+// AuditEvent is one row of the audit_events hash chain.
+type AuditEvent struct {
+	Seq        int64             `json:"seq"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	Event      string            `json:"event"`
+	Actor      string            `json:"actor"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Fields     map[string]string `json:"fields"`
+	PrevHash   string            `json:"prev_hash"`
+	Hash       string            `json:"hash"`
+}
+
+// computeHash returns the chain hash of e: sha256 over a canonical JSON
+// encoding of every column except hash itself. encoding/json sorts map
+// keys, and times are fixed to UTC microseconds (Postgres precision), so
+// the value can be recomputed from a stored row.
+func (e AuditEvent) computeHash() string {
+	fields := e.Fields
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	b, _ := json.Marshal(struct {
+		Seq        int64             `json:"seq"`
+		OccurredAt string            `json:"occurred_at"`
+		Event      string            `json:"event"`
+		Actor      string            `json:"actor"`
+		RequestID  string            `json:"request_id"`
+		Fields     map[string]string `json:"fields"`
+		PrevHash   string            `json:"prev_hash"`
+	}{e.Seq, e.OccurredAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano), e.Event, e.Actor, e.RequestID, fields, e.PrevHash})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainAfter fills in Seq, PrevHash and Hash so that e follows the event
+// with sequence number prevSeq and hash prevHash.
+func (e AuditEvent) chainAfter(prevSeq int64, prevHash string) AuditEvent {
+	e.OccurredAt = e.OccurredAt.UTC().Truncate(time.Microsecond)
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	e.Seq = prevSeq + 1
+	e.PrevHash = prevHash
+	e.Hash = e.computeHash()
+	return e
+}
+
+// AuditLog appends events to the audit_events table.
+type AuditLog struct {
+	DB *sql.DB
+}
+
+// NewAuditLog returns an AuditLog writing to db.
+func NewAuditLog(db *sql.DB) *AuditLog {
+	return &AuditLog{DB: db}
+}
+
+// Append chains e onto the latest stored event and inserts it, returning
+// the stored row.
+func (a *AuditLog) Append(ctx context.Context, e AuditEvent) (AuditEvent, error) {
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditLockKey); err != nil {
+		return AuditEvent{}, fmt.Errorf("audit lock: %w", err)
+	}
+	prevSeq, prevHash := int64(0), auditGenesisHash
+	err = tx.QueryRowContext(ctx,
+		`SELECT seq, hash FROM audit_events ORDER BY seq DESC LIMIT 1`,
+	).Scan(&prevSeq, &prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return AuditEvent{}, fmt.Errorf("audit head: %w", err)
+	}
+
+	e = e.chainAfter(prevSeq, prevHash)
+	fields, err := json.Marshal(e.Fields)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_events (seq, occurred_at, event, actor, request_id, fields, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $8)
+	`, e.Seq, e.OccurredAt, e.Event, e.Actor, e.RequestID, string(fields), e.PrevHash, e.Hash); err != nil {
+		return AuditEvent{}, fmt.Errorf("audit insert: %w", err)
+	}
+	return e, tx.Commit()
+}
+
+// withAuditLog makes a reachable from recordAudit through ctx.
+func withAuditLog(ctx context.Context, a *AuditLog) context.Context {
+	if a == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, auditLogKey, a)
+}
+
+func auditLogFrom(ctx context.Context) *AuditLog {
+	a, _ := ctx.Value(auditLogKey).(*AuditLog)
+	return a
+}
+
+// auditMiddleware attaches the audit log to every request context.
+func auditMiddleware(a *AuditLog, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withAuditLog(r.Context(), a)))
+	})
+}
+
+// auditActor picks who performed the event: the authenticated principal,
+// else an explicit actor/subject field, else the claimed username (failed
+// logins).
+func auditActor(ctx context.Context, fields map[string]string) string {
+	if s := PrincipalFromContext(ctx).Subject; s != "" {
+		return s
+	}
+	for _, k := range []string{"actor", "subject", "username"} {
+		if v := fields[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// recordAudit writes a security-relevant event (logins, uploads, links,
+// downloads, deletions, cleanup) to the audit trail: a log line through the
+// request-scoped logger and, when an AuditLog is attached to ctx, a row in
+// the audit_events hash chain. A failed append is logged but never fails
+// the operation being audited.
+func recordAudit(ctx context.Context, event string, fields map[string]string) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys)+1)
+	attrs = append(attrs, slog.String("event", event))
+	for _, k := range keys {
+		attrs = append(attrs, slog.String(k, fields[k]))
+	}
+	logFor(ctx, "audit").LogAttrs(ctx, slog.LevelInfo, "audit_event", attrs...)
+
+	a := auditLogFrom(ctx)
+	if a == nil || a.DB == nil {
+		return
+	}
+	wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditWriteTimeout)
+	defer cancel()
+	if _, err := a.Append(wctx, AuditEvent{
+		OccurredAt: time.Now(),
+		Event:      event,
+		Actor:      auditActor(ctx, fields),
+		RequestID:  RequestIDFromContext(ctx),
+		Fields:     fields,
+	}); err != nil {
+		logFor(ctx, "audit").Error("audit_write_failed", slog.String("event", event), errAttr(err))
+	}
+}
+
+// Problems reported by VerifyAuditChain.
+const (
+	AuditProblemGap      = "gap"                // seq does not follow the previous row
+	AuditProblemPrevHash = "prev_hash_mismatch" // row does not point at the previous hash
+	AuditProblemHash     = "hash_mismatch"      // row content does not match its hash
+)
+
+// maxAuditProblems caps the problems listed in a verification result.
+const maxAuditProblems = 100
+
+// AuditProblem is one inconsistency found while verifying the chain.
+type AuditProblem struct {
+	Seq    int64  `json:"seq"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+// AuditVerifyResult summarises a verification run. LastSeq and LastHash
+// identify the head of the chain; recording them elsewhere lets a later
+// run detect truncation of the newest rows.
+type AuditVerifyResult struct {
+	OK       bool           `json:"ok"`
+	Checked  int64          `json:"checked"`
+	LastSeq  int64          `json:"last_seq"`
+	LastHash string         `json:"last_hash"`
+	Problems []AuditProblem `json:"problems,omitempty"`
+}
+
+// auditVerifier checks events fed to it in seq order.
+type auditVerifier struct {
+	res      AuditVerifyResult
+	prevSeq  int64
+	prevHash string
+}
+
+func newAuditVerifier() *auditVerifier {
+	return &auditVerifier{res: AuditVerifyResult{OK: true}, prevHash: auditGenesisHash}
+}
+
+func (v *auditVerifier) problem(seq int64, kind, detail string) {
+	v.res.OK = false
+	if len(v.res.Problems) < maxAuditProblems {
+		v.res.Problems = append(v.res.Problems, AuditProblem{Seq: seq, Kind: kind, Detail: detail})
+	}
+}
+
+func (v *auditVerifier) check(e AuditEvent) {
+	v.res.Checked++
+	if e.Seq != v.prevSeq+1 {
+		v.problem(e.Seq, AuditProblemGap, fmt.Sprintf("expected seq %d", v.prevSeq+1))
+	}
+	if e.PrevHash != v.prevHash {
+		v.problem(e.Seq, AuditProblemPrevHash, "prev_hash does not match the preceding event")
+	}
+	if got := e.computeHash(); got != e.Hash {
+		v.problem(e.Seq, AuditProblemHash, "stored hash does not match the event content")
+	}
+	// Continue from the stored values so one edit is reported once.
+	v.prevSeq, v.prevHash = e.Seq, e.Hash
+	v.res.LastSeq, v.res.LastHash = e.Seq, e.Hash
+}
+
+// VerifyAuditChain walks audit_events in seq order and reports gaps,
+// broken links and rows whose content no longer matches their hash.
+func VerifyAuditChain(ctx context.Context, db *sql.DB) (AuditVerifyResult, error) {
+	rows, err := db.QueryContext(ctx, auditEventSelect+` ORDER BY seq`)
+	if err != nil {
+		return AuditVerifyResult{}, err
+	}
+	defer rows.Close()
+
+	v := newAuditVerifier()
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return AuditVerifyResult{}, err
+		}
+		v.check(e)
+	}
+	if err := rows.Err(); err != nil {
+		return AuditVerifyResult{}, err
+	}
+	return v.res, nil
+}
+
+// auditEventSelect returns the columns of AuditEvent in scan order.
+const auditEventSelect = `
+	SELECT seq, occurred_at, event, actor, request_id, fields, prev_hash, hash
+	FROM audit_events`
+
+func scanAuditEvent(sc interface{ Scan(...any) error }) (AuditEvent, error) {
+	var (
+		e      AuditEvent
+		fields []byte
+	)
+	if err := sc.Scan(&e.Seq, &e.OccurredAt, &e.Event, &e.Actor, &e.RequestID, &fields, &e.PrevHash, &e.Hash); err != nil {
+		return AuditEvent{}, err
+	}
+	e.OccurredAt = e.OccurredAt.UTC()
+	if err := json.Unmarshal(fields, &e.Fields); err != nil {
+		return AuditEvent{}, fmt.Errorf("audit fields for seq %d: %w", e.Seq, err)
+	}
+	return e, nil
+}
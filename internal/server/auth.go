@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"database/sql"
@@ -8,28 +9,99 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // AuthConfig holds authentication-related configuration used by the
 // HTTP handlers (admin credentials, session secrets and cookie settings).
 //
 // It is intentionally lightweight for the MVP and used by unit tests.
-// Now also supports database-backed user authentication.
+// AdminUser/AdminPass only bootstrap the admin account (EnsureAdminUser);
+// logins are always checked against the database.
 type AuthConfig struct {
 	AdminUser     string
 	AdminPass     string
 	SessionSecret string
 	SessionTTL    time.Duration
 	CookieName    string
-	DB            *sql.DB // Database connection for user authentication
+	CookieSecure  string         // "true", "false" or "auto" (detect TLS); see cookieSecure
+	DB            *sql.DB        // Database connection for user authentication
+	Throttle      *LoginThrottle // Brute-force protection; nil disables it
+	Metrics       *Metrics       // Login metrics; nil disables recording
+}
+
+// Roles stored in users.role. Only admins may use admin:* scopes.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var (
+	errAccountDisabled = errors.New("account disabled")
+	errSessionRevoked  = errors.New("session revoked")
+)
+
+// Principal identifies the caller of an authenticated request.
+//
+// Subject is the session subject (a user id or the legacy admin username).
+// Scopes is nil for session-authenticated requests, which may do anything
+// the subject may do; bearer-token requests carry the token's scopes.
+// Role is the subject's account role.
+type Principal struct {
+	Subject string
+	Scopes  []string
+	TokenID string
+	Role    string
+}
+
+// HasScope reports whether the principal is allowed to act within scope.
+func (p Principal) HasScope(scope string) bool {
+	if p.TokenID == "" {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalFromContext returns the authenticated principal, if any.
+func PrincipalFromContext(ctx context.Context) Principal {
+	p, _ := ctx.Value(principalKey).(Principal)
+	return p
+}
+
+// OperatorContext returns ctx acting as the operator actor (an admin
+// outside any HTTP request, such as sfdctl): logs go to logger and audit
+// events to db, attributed to actor.
+func OperatorContext(ctx context.Context, db *sql.DB, logger *slog.Logger, actor string) context.Context {
+	ctx = withLogger(ctx, logger)
+	ctx = withAuditLog(ctx, NewAuditLog(db))
+	return context.WithValue(ctx, principalKey, Principal{Subject: actor, Role: RoleAdmin})
 }
 
 type sessionPayload struct {
-	Sub string `json:"sub"`
-	Exp int64  `json:"exp"`
+	Sub   string `json:"sub"`
+	Iat   int64  `json:"iat"`
+	IatMs int64  `json:"iat_ms,omitempty"` // Iat in milliseconds, for revocation checks
+	Exp   int64  `json:"exp"`
+}
+
+// issuedAt returns when the session was issued, to the millisecond for
+// sessions that carry iat_ms.
+func (p sessionPayload) issuedAt() time.Time {
+	if p.IatMs > 0 {
+		return time.UnixMilli(p.IatMs)
+	}
+	return time.Unix(p.Iat, 0)
 }
 
 func (a AuthConfig) cookieName() string {
@@ -78,8 +150,13 @@ func decodeSession(token string) (sessionPayload, error) {
 
 // makeToken returns "payload.signature"
 func (a AuthConfig) makeToken(sub string) (string, time.Time, error) {
-	exp := time.Now().Add(a.ttl())
-	p := sessionPayload{Sub: sub, Exp: exp.Unix()}
+	return a.makeTokenAt(sub, time.Now())
+}
+
+// makeTokenAt is makeToken for a session issued at now.
+func (a AuthConfig) makeTokenAt(sub string, now time.Time) (string, time.Time, error) {
+	exp := now.Add(a.ttl())
+	p := sessionPayload{Sub: sub, Iat: now.Unix(), IatMs: now.UnixMilli(), Exp: exp.Unix()}
 	payload, err := encodeSession(p)
 	if err != nil {
 		return "", time.Time{}, err
@@ -110,7 +187,7 @@ func (a AuthConfig) verifyToken(tok string) (sessionPayload, error) {
 	return decoded, nil
 }
 
-// loginHandler handles both legacy admin login and database user login
+// loginHandler authenticates database users and issues a session cookie
 func (a AuthConfig) loginHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -127,67 +204,207 @@ func (a AuthConfig) loginHandler() http.HandlerFunc {
 			return
 		}
 
+		ip := clientIP(r)
+		account := a.Throttle.accountKey(r.Context(), body.Username)
+		if until := a.Throttle.checkLogin(r.Context(), account, ip); !until.IsZero() {
+			a.Metrics.RecordLoginAttempt(false)
+			recordAudit(r.Context(), "login_blocked", map[string]string{
+				"username": body.Username,
+				"ip":       ip,
+			})
+			retry := int(time.Until(until).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+
 		var authenticated bool
 		var userID string
 
-		// First, try database authentication if DB is available
+		// All accounts, including the bootstrap admin (see EnsureAdminUser),
+		// are stored in the database with a real password hash.
 		if a.DB != nil {
-			userID, authenticated = authenticateUser(a.DB, body.Username, body.Password)
-		}
-
-		// Fallback to legacy admin authentication if DB auth failed or no DB
-		if !authenticated && a.AdminUser != "" && a.AdminPass != "" {
-			uOK := body.Username == a.AdminUser
-			pwHash := sha256.Sum256([]byte(body.Password))
-			adminHash := sha256.Sum256([]byte(a.AdminPass))
-			pOK := hmac.Equal(pwHash[:], adminHash[:])
-
-			if uOK && pOK {
-				authenticated = true
-				userID = a.AdminUser
-			}
+			userID, authenticated = authenticateUser(r.Context(), a.DB, body.Username, body.Password)
 		}
 
 		if !authenticated {
+			a.Metrics.RecordLoginAttempt(false)
+			a.Throttle.loginFailed(r.Context(), account, ip)
+			recordAudit(r.Context(), "login_failed", map[string]string{
+				"username": body.Username,
+				"ip":       ip,
+			})
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		tok, exp, err := a.makeToken(userID)
+		a.Metrics.RecordLoginAttempt(true)
+		a.Throttle.loginSucceeded(r.Context(), account)
+		recordAudit(r.Context(), "login_succeeded", map[string]string{
+			"subject": userID,
+			"ip":      ip,
+		})
+
+		csrf, err := a.startSession(w, r, userID, time.Now())
 		if err != nil {
 			http.Error(w, "server error", http.StatusInternalServerError)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     a.cookieName(),
-			Value:    tok,
-			Path:     "/",
-			Expires:  exp,
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-			// Secure will be true once HTTPS is enforced at proxy; for local dev it can remain false.
-			Secure: false,
-		})
-
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
-			"status": "ok",
+			"status":     "ok",
+			"csrf_token": csrf,
 		})
 	}
 }
 
+// startSession sets the session and CSRF cookies for sub, issued at now,
+// and returns the CSRF token.
+func (a AuthConfig) startSession(w http.ResponseWriter, r *http.Request, sub string, now time.Time) (string, error) {
+	tok, exp, err := a.makeTokenAt(sub, now)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.cookieName(),
+		Value:    tok,
+		Path:     "/",
+		Expires:  exp,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.cookieSecure(r),
+	})
+
+	return a.setCSRFCookie(w, r, tok, exp)
+}
+
+// bearerToken extracts the credential from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+// authenticate resolves the request's credentials to a Principal. A bearer
+// token takes precedence over the session cookie when both are present.
+func (a AuthConfig) authenticate(r *http.Request) (Principal, error) {
+	if tok, ok := bearerToken(r); ok {
+		if a.DB == nil {
+			return Principal{}, errors.New("token auth unavailable")
+		}
+		p, err := lookupAPIToken(r.Context(), a.DB, tok)
+		if err != nil {
+			return Principal{}, err
+		}
+		// API tokens are not sessions: only deactivation invalidates them.
+		if err := a.loadAccount(r.Context(), &p, time.Time{}); err != nil {
+			return Principal{}, err
+		}
+		return p, nil
+	}
+
+	c, err := r.Cookie(a.cookieName())
+	if err != nil {
+		return Principal{}, err
+	}
+	sp, err := a.verifyToken(c.Value)
+	if err != nil {
+		return Principal{}, err
+	}
+	p := Principal{Subject: sp.Sub}
+	if err := a.loadAccount(r.Context(), &p, sp.issuedAt()); err != nil {
+		return Principal{}, err
+	}
+	return p, nil
+}
+
+// loadAccount fills in the principal's role and rejects credentials of
+// deactivated or deleted accounts, and sessions issued before their sessions
+// were revoked, compared to the millisecond (a zero issuedAt skips that
+// check). Subjects that are not user ids (the legacy admin username) are
+// resolved without the DB.
+func (a AuthConfig) loadAccount(ctx context.Context, p *Principal, issuedAt time.Time) error {
+	if _, err := uuid.Parse(p.Subject); err != nil || a.DB == nil {
+		p.Role = RoleUser
+		if a.AdminUser != "" && p.Subject == a.AdminUser {
+			p.Role = RoleAdmin
+		}
+		return nil
+	}
+
+	var (
+		active  bool
+		revoked sql.NullTime
+	)
+	err := a.DB.QueryRowContext(ctx,
+		`SELECT role, is_active, sessions_revoked_at FROM users WHERE id = $1`,
+		p.Subject,
+	).Scan(&p.Role, &active, &revoked)
+	if err == sql.ErrNoRows {
+		return errAccountDisabled
+	}
+	if err != nil {
+		return err
+	}
+	if !active {
+		return errAccountDisabled
+	}
+	if !issuedAt.IsZero() && revoked.Valid && issuedAt.UnixMilli() < revoked.Time.UnixMilli() {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+// requireAuth accepts either a session cookie or an API bearer token and
+// stores the resulting Principal in the request context.
 func (a AuthConfig) requireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie(a.cookieName())
+		p, err := a.authenticate(r)
 		if err != nil {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if _, err := a.verifyToken(c.Value); err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		addLogAttrs(r.Context(), slog.String(logKeyUserID, p.Subject))
+		ctx := context.WithValue(r.Context(), principalKey, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isAdminScope reports whether scope is reserved for admin accounts.
+func isAdminScope(scope string) bool {
+	return strings.HasPrefix(scope, "admin:")
+}
+
+// requireScope is requireAuth plus a check that the principal holds scope.
+// Admin scopes additionally require the admin role.
+func (a AuthConfig) requireScope(scope string, next http.Handler) http.Handler {
+	return a.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := PrincipalFromContext(r.Context())
+		if !p.HasScope(scope) {
+			http.Error(w, "insufficient scope", http.StatusForbidden)
+			return
+		}
+		if isAdminScope(scope) && p.Role != RoleAdmin {
+			http.Error(w, "admin role required", http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
-	})
+	}))
+}
+
+// requireSession is requireAuth restricted to cookie sessions, for endpoints
+// that must not be reachable with an API token (e.g. token management).
+func (a AuthConfig) requireSession(next http.Handler) http.Handler {
+	return a.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if PrincipalFromContext(r.Context()).TokenID != "" {
+			http.Error(w, "session required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"testing"
@@ -41,3 +42,47 @@ func TestVerifyTokenExpired(t *testing.T) {
 		t.Fatalf("expected error for expired token")
 	}
 }
+
+func TestSessionPayload_IssuedAt(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+	cfg := AuthConfig{SessionSecret: "s", SessionTTL: time.Hour}
+	tok, _, _ := cfg.makeTokenAt("admin", now)
+	p, err := cfg.verifyToken(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.issuedAt().Equal(now) {
+		t.Errorf("issuedAt = %v, want %v", p.issuedAt(), now)
+	}
+	// Sessions from before iat_ms fall back to whole seconds.
+	if got := (sessionPayload{Iat: 1_700_000_000}).issuedAt(); !got.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Errorf("legacy issuedAt = %v", got)
+	}
+}
+
+func TestLoadAccount_RevocationToTheMillisecond(t *testing.T) {
+	db := openTestDB(t)
+	a := AuthConfig{DB: db}
+	var (
+		id      string
+		revoked time.Time
+	)
+	if err := db.QueryRow(`INSERT INTO users (email, username, password_hash, sessions_revoked_at)
+		VALUES ('bob@example.com', 'bob', 'x', now()) RETURNING id, sessions_revoked_at`).Scan(&id, &revoked); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name   string
+		issued time.Time
+		want   error
+	}{
+		{"before the revocation", revoked.Add(-time.Millisecond), errSessionRevoked},
+		{"at the revocation", revoked, nil},
+		{"later in the same second", revoked.Add(time.Millisecond), nil},
+	} {
+		p := Principal{Subject: id}
+		if err := a.loadAccount(context.Background(), &p, c.issued); err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,691 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	sfddb "secure-file-drop/internal/db"
+)
+
+// backupFormat is the archive layout version written to manifests.
+const backupFormat = 1
+
+// Archive entries: the manifest comes first so a reader learns what
+// follows (and incremental exports can read their base cheaply), then the
+// table dumps in restore order, then the objects.
+const (
+	backupManifestEntry = "manifest.json"
+	backupTablePrefix   = "tables/"
+	backupObjectPrefix  = "objects/"
+)
+
+// backupFileFilter selects the files a backup carries: those whose object
+// is complete and hashed. Pending and stored rows belong to uploads in
+// flight and failed rows are left to cleanup.
+const backupFileFilter = `status IN ('hashed', 'ready') AND sha256_hex IS NOT NULL`
+
+// backupTables are dumped in this order, which satisfies the foreign keys
+// on restore. Sessions, API tokens, invites, notifications and throttling
+// state are not backed up.
+var backupTables = []struct{ name, where string }{
+	{"users", ""},
+	{"organizations", ""},
+	{"org_members", ""},
+	{"files", backupFileFilter},
+	{"file_shares", "file_id IN (SELECT id FROM files WHERE " + backupFileFilter + ")"},
+	{"download_keys", ""},
+	{"download_links", "file_id IN (SELECT id FROM files WHERE " + backupFileFilter + ")"},
+	{"audit_events", ""},
+}
+
+func isBackupTable(name string) bool {
+	for _, t := range backupTables {
+		if t.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupManifest describes an archive written by ExportBackup.
+type BackupManifest struct {
+	Format        int       `json:"format"`
+	ID            string    `json:"id"`
+	Base          string    `json:"base,omitempty"` // the export an incremental archive builds on
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion uint      `json:"schema_version"`
+	// SkippedFiles counts files left out because they were not hashed.
+	SkippedFiles int            `json:"skipped_files"`
+	Tables       []BackupTable  `json:"tables"`
+	Objects      []BackupObject `json:"objects"`
+}
+
+// BackupTable is one NDJSON table dump in the archive.
+type BackupTable struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// BackupObject is the object of a backed-up file. Archive is the id of the
+// archive holding its bytes: this one, or an earlier export in the chain
+// of an incremental backup.
+type BackupObject struct {
+	FileID    string `json:"file_id"`
+	ObjectKey string `json:"object_key"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	Archive   string `json:"archive"`
+}
+
+// Included returns how many objects, and bytes, the archive itself holds.
+func (m BackupManifest) Included() (n int, bytes int64) {
+	for _, o := range m.Objects {
+		if o.Archive == m.ID {
+			n++
+			bytes += o.Size
+		}
+	}
+	return n, bytes
+}
+
+// ExportOptions controls ExportBackup.
+type ExportOptions struct {
+	// Base makes the export incremental: objects already in the base
+	// archive (or its own chain) with the same hash are referenced
+	// instead of copied. Table dumps are always complete.
+	Base *BackupManifest
+}
+
+// ExportBackup writes a tar archive of the metadata and objects to w.
+// Tables are dumped from one snapshot, so they are consistent with each
+// other; every object is hashed while it is copied and must match the
+// sha256_hex recorded at upload, or the export fails.
+func ExportBackup(ctx context.Context, db *sql.DB, mc *minio.Client, bucket string, w io.Writer, opts ExportOptions) (BackupManifest, error) {
+	id, err := newBackupID()
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	m := BackupManifest{Format: backupFormat, ID: id, CreatedAt: time.Now().UTC(), Objects: []BackupObject{}}
+	if opts.Base != nil {
+		m.Base = opts.Base.ID
+	}
+
+	tmpDir, err := os.MkdirTemp("", "sfd-backup-*")
+	if err != nil {
+		return m, err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return m, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var version int64
+	if err := tx.QueryRowContext(ctx, `SELECT version FROM schema_migrations WHERE NOT dirty`).Scan(&version); err != nil {
+		return m, fmt.Errorf("schema version: %w", err)
+	}
+	m.SchemaVersion = uint(version)
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM files WHERE NOT (`+backupFileFilter+`)`).Scan(&m.SkippedFiles); err != nil {
+		return m, err
+	}
+
+	var files []backupFileRow
+	for _, t := range backupTables {
+		bt, rows, err := dumpBackupTable(ctx, tx, tmpDir, t.name, t.where)
+		if err != nil {
+			return m, fmt.Errorf("dump %s: %w", t.name, err)
+		}
+		m.Tables = append(m.Tables, bt)
+		if t.name == "files" {
+			files = rows
+		}
+	}
+	// The snapshot has served its purpose; objects are immutable once
+	// hashed.
+	_ = tx.Rollback()
+
+	m.Objects = planBackupObjects(files, opts.Base, m.ID)
+
+	tw := tar.NewWriter(w)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	if err := writeTarEntry(tw, backupManifestEntry, int64(len(manifest)), m.CreatedAt, bytes.NewReader(manifest)); err != nil {
+		return m, err
+	}
+	for _, t := range m.Tables {
+		if err := copyFileToTar(tw, backupTablePrefix+t.Name+".ndjson", filepath.Join(tmpDir, t.Name+".ndjson"), m.CreatedAt); err != nil {
+			return m, err
+		}
+	}
+	for _, o := range m.Objects {
+		if o.Archive != m.ID {
+			continue
+		}
+		if err := exportObject(ctx, tw, mc, bucket, o, m.CreatedAt); err != nil {
+			return m, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return m, err
+	}
+
+	n, size := m.Included()
+	recordAudit(ctx, "backup_exported", map[string]string{
+		"backup_id": m.ID,
+		"base":      m.Base,
+		"objects":   strconv.Itoa(n),
+		"bytes":     strconv.FormatInt(size, 10),
+	})
+	return m, nil
+}
+
+// backupFileRow is the part of a files dump line the export needs.
+type backupFileRow struct {
+	ID          string `json:"id"`
+	ObjectKey   string `json:"object_key"`
+	SHA256Hex   string `json:"sha256_hex"`
+	SizeBytes   int64  `json:"size_bytes"`
+	ContentType string `json:"content_type"`
+}
+
+// dumpBackupTable writes table as one JSON object per line to a file in
+// dir. For files it also returns the parsed rows.
+func dumpBackupTable(ctx context.Context, tx *sql.Tx, dir, table, where string) (BackupTable, []backupFileRow, error) {
+	bt := BackupTable{Name: table}
+	f, err := os.Create(filepath.Join(dir, table+".ndjson"))
+	if err != nil {
+		return bt, nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	query := `SELECT row_to_json(t)::text FROM ` + table + ` t`
+	if where != "" {
+		query += ` WHERE ` + where
+	}
+	if table == "audit_events" {
+		query += ` ORDER BY seq`
+	}
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return bt, nil, err
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(f, h))
+	var files []backupFileRow
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return bt, nil, err
+		}
+		if table == "files" {
+			var fr backupFileRow
+			if err := json.Unmarshal([]byte(line), &fr); err != nil {
+				return bt, nil, err
+			}
+			files = append(files, fr)
+		}
+		if _, err := out.WriteString(line + "\n"); err != nil {
+			return bt, nil, err
+		}
+		bt.Records++
+	}
+	if err := rows.Err(); err != nil {
+		return bt, nil, err
+	}
+	if err := out.Flush(); err != nil {
+		return bt, nil, err
+	}
+	bt.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return bt, files, f.Close()
+}
+
+// planBackupObjects lists the objects of files for archive id, pointing
+// at the base chain for objects it already holds unchanged.
+func planBackupObjects(files []backupFileRow, base *BackupManifest, id string) []BackupObject {
+	prior := map[string]BackupObject{}
+	if base != nil {
+		for _, o := range base.Objects {
+			prior[o.FileID] = o
+		}
+	}
+	objects := make([]BackupObject, 0, len(files))
+	for _, f := range files {
+		o := BackupObject{FileID: f.ID, ObjectKey: f.ObjectKey, SHA256: strings.TrimSpace(f.SHA256Hex), Size: f.SizeBytes, Archive: id}
+		if p, ok := prior[f.ID]; ok && p.SHA256 == o.SHA256 {
+			o.Archive = p.Archive
+		}
+		objects = append(objects, o)
+	}
+	return objects
+}
+
+func exportObject(ctx context.Context, tw *tar.Writer, mc *minio.Client, bucket string, o BackupObject, mod time.Time) error {
+	obj, err := mc.GetObject(ctx, bucket, o.ObjectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("object of file %s: %w", o.FileID, err)
+	}
+	defer func() { _ = obj.Close() }()
+	st, err := obj.Stat()
+	if err != nil {
+		return fmt.Errorf("object of file %s: %w", o.FileID, err)
+	}
+	if st.Size != o.Size {
+		return fmt.Errorf("object of file %s is %d bytes, recorded %d", o.FileID, st.Size, o.Size)
+	}
+	h := sha256.New()
+	if err := writeTarEntry(tw, backupObjectPrefix+o.FileID, st.Size, mod, io.TeeReader(obj, h)); err != nil {
+		return fmt.Errorf("object of file %s: %w", o.FileID, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != o.SHA256 {
+		return fmt.Errorf("object of file %s does not match its recorded sha256 (got %s); check it with sfdctl files verify", o.FileID, got)
+	}
+	return nil
+}
+
+func writeTarEntry(tw *tar.Writer, name string, size int64, mod time.Time, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: size, ModTime: mod, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func copyFileToTar(tw *tar.Writer, name, file string, mod time.Time) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarEntry(tw, name, st.Size(), mod, f)
+}
+
+func newBackupID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b), nil
+}
+
+// ReadBackupManifest reads the manifest at the start of an archive.
+func ReadBackupManifest(r io.Reader) (BackupManifest, error) {
+	return readBackupManifest(tar.NewReader(r))
+}
+
+func readBackupManifest(tr *tar.Reader) (BackupManifest, error) {
+	var m BackupManifest
+	hdr, err := tr.Next()
+	if err != nil {
+		return m, fmt.Errorf("not a backup archive: %w", err)
+	}
+	if hdr.Name != backupManifestEntry {
+		return m, fmt.Errorf("not a backup archive: first entry is %q", hdr.Name)
+	}
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return m, fmt.Errorf("manifest: %w", err)
+	}
+	if m.Format != backupFormat {
+		return m, fmt.Errorf("unsupported backup format %d", m.Format)
+	}
+	return m, nil
+}
+
+// ImportReport summarizes a restore.
+type ImportReport struct {
+	BackupID     string         `json:"backup_id"`
+	Tables       map[string]int `json:"tables"`
+	Objects      int            `json:"objects"`
+	Bytes        int64          `json:"bytes"`
+	RemappedKeys int            `json:"remapped_keys"`
+	AuditChainOK bool           `json:"audit_chain_ok"`
+}
+
+// ImportBackup restores archives into an empty instance (migrated, with no
+// users, files or audit events). archives is the chain of an incremental
+// backup, oldest first; a full backup is a chain of one. The tables of the
+// last archive are restored, and the objects are collected from whichever
+// archive holds them. Every table dump and object is checked against the
+// manifest; object keys already taken in the bucket are remapped. Nothing
+// is committed unless everything verifies, and objects written by a failed
+// import are removed again.
+func ImportBackup(ctx context.Context, db *sql.DB, mc *minio.Client, bucket string, archives []string) (ImportReport, error) {
+	if len(archives) == 0 {
+		return ImportReport{}, errors.New("no archive given")
+	}
+	manifests := make([]BackupManifest, len(archives))
+	for i, p := range archives {
+		m, err := readBackupManifestFile(p)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("%s: %w", p, err)
+		}
+		manifests[i] = m
+	}
+	target := manifests[len(manifests)-1]
+	if err := checkBackupChain(target, manifests); err != nil {
+		return ImportReport{}, err
+	}
+
+	version, dirty, err := sfddb.CurrentVersion(ctx, db)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	if dirty || version != target.SchemaVersion {
+		return ImportReport{}, fmt.Errorf("backup has schema version %d, database is at %d: migrate to the same version first", target.SchemaVersion, version)
+	}
+	var existing int
+	if err := db.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM files) + (SELECT COUNT(*) FROM audit_events)`,
+	).Scan(&existing); err != nil {
+		return ImportReport{}, err
+	}
+	if existing > 0 {
+		return ImportReport{}, errors.New("instance is not empty: restore needs a freshly migrated database")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	imp := &backupImport{
+		ctx:     ctx,
+		tx:      tx,
+		mc:      mc,
+		bucket:  bucket,
+		target:  target,
+		pending: map[string]BackupObject{},
+		keys:    map[string]string{},
+		types:   map[string]string{},
+		report:  ImportReport{BackupID: target.ID, Tables: map[string]int{}},
+	}
+	for _, o := range target.Objects {
+		imp.pending[o.FileID] = o
+	}
+	fail := func(err error) (ImportReport, error) {
+		imp.removeWritten()
+		return imp.report, err
+	}
+
+	// The target archive first: its tables decide the object keys.
+	last := len(archives) - 1
+	order := []int{last}
+	for i := 0; i < last; i++ {
+		order = append(order, i)
+	}
+	for _, i := range order {
+		if err := imp.readArchive(archives[i], manifests[i].ID, i == last); err != nil {
+			return fail(fmt.Errorf("%s: %w", archives[i], err))
+		}
+	}
+	if len(imp.pending) > 0 {
+		return fail(fmt.Errorf("%d object(s) missing from the archives", len(imp.pending)))
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+
+	recordAudit(ctx, "backup_imported", map[string]string{
+		"backup_id":     target.ID,
+		"objects":       strconv.Itoa(imp.report.Objects),
+		"remapped_keys": strconv.Itoa(imp.report.RemappedKeys),
+	})
+	res, err := VerifyAuditChain(ctx, db)
+	if err != nil {
+		return imp.report, err
+	}
+	imp.report.AuditChainOK = res.OK
+	return imp.report, nil
+}
+
+func readBackupManifestFile(p string) (BackupManifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	defer func() { _ = f.Close() }()
+	return ReadBackupManifest(f)
+}
+
+// checkBackupChain verifies that archives hold every object target needs.
+func checkBackupChain(target BackupManifest, archives []BackupManifest) error {
+	have := map[string]bool{}
+	for _, m := range archives {
+		have[m.ID] = true
+	}
+	missing := map[string]int{}
+	for _, o := range target.Objects {
+		if !have[o.Archive] {
+			missing[o.Archive]++
+		}
+	}
+	for id, n := range missing {
+		return fmt.Errorf("archive %s is needed for %d object(s) but was not given", id, n)
+	}
+	return nil
+}
+
+// backupImport is the state of one ImportBackup run.
+type backupImport struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	mc      *minio.Client
+	bucket  string
+	target  BackupManifest
+	pending map[string]BackupObject // file id -> object still to restore
+	keys    map[string]string       // file id -> object key in this instance
+	types   map[string]string       // file id -> content type
+	written []string                // object keys written so far
+	report  ImportReport
+}
+
+// readArchive restores the tables (from the target archive only) and the
+// objects that archive id holds.
+func (imp *backupImport) readArchive(p, id string, isTarget bool) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	tr := tar.NewReader(bufio.NewReaderSize(f, 1<<20))
+	if _, err := readBackupManifest(tr); err != nil {
+		return err
+	}
+
+	tables := map[string]BackupTable{}
+	for _, t := range imp.target.Tables {
+		tables[t.Name] = t
+	}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(hdr.Name, backupTablePrefix):
+			if !isTarget {
+				continue
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, backupTablePrefix), ".ndjson")
+			t, ok := tables[name]
+			if !ok || !isBackupTable(name) {
+				return fmt.Errorf("unexpected entry %s", hdr.Name)
+			}
+			if err := imp.restoreTable(t, tr); err != nil {
+				return fmt.Errorf("table %s: %w", name, err)
+			}
+			delete(tables, name)
+		case strings.HasPrefix(hdr.Name, backupObjectPrefix):
+			fileID := strings.TrimPrefix(hdr.Name, backupObjectPrefix)
+			o, ok := imp.pending[fileID]
+			if !ok || o.Archive != id {
+				continue // superseded by a later archive
+			}
+			if err := imp.restoreObject(o, hdr.Size, tr); err != nil {
+				return fmt.Errorf("object of file %s: %w", fileID, err)
+			}
+			delete(imp.pending, fileID)
+		default:
+			return fmt.Errorf("unexpected entry %s", hdr.Name)
+		}
+	}
+	if isTarget && len(tables) > 0 {
+		return fmt.Errorf("%d table dump(s) missing", len(tables))
+	}
+	return nil
+}
+
+// restoreTable inserts every line of a table dump. Lines are rows as
+// row_to_json wrote them, so json_populate_record reverses them exactly.
+func (imp *backupImport) restoreTable(t BackupTable, r io.Reader) error {
+	h := sha256.New()
+	sc := bufio.NewScanner(io.TeeReader(r, h))
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	insert := `INSERT INTO ` + t.Name + ` SELECT * FROM json_populate_record(NULL::` + t.Name + `, $1::json)`
+	n := 0
+	for sc.Scan() {
+		line := sc.Bytes()
+		if t.Name == "files" {
+			var err error
+			if line, err = imp.mapFileRow(line); err != nil {
+				return err
+			}
+		}
+		if _, err := imp.tx.ExecContext(imp.ctx, insert, string(line)); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != t.SHA256 || n != t.Records {
+		return fmt.Errorf("dump does not match the manifest (%d records, sha256 %s)", n, got)
+	}
+	imp.report.Tables[t.Name] = n
+	return nil
+}
+
+// mapFileRow gives a file row an object key that is free in the bucket.
+func (imp *backupImport) mapFileRow(line []byte) ([]byte, error) {
+	var row map[string]any
+	if err := json.Unmarshal(line, &row); err != nil {
+		return nil, err
+	}
+	var fr backupFileRow
+	if err := json.Unmarshal(line, &fr); err != nil {
+		return nil, err
+	}
+	key, err := remapObjectKey(fr.ObjectKey, fr.ID, imp.objectExists)
+	if err != nil {
+		return nil, err
+	}
+	imp.keys[fr.ID] = key
+	imp.types[fr.ID] = fr.ContentType
+	if key == fr.ObjectKey {
+		return line, nil
+	}
+	imp.report.RemappedKeys++
+	row["object_key"] = key
+	return json.Marshal(row)
+}
+
+func (imp *backupImport) objectExists(key string) (bool, error) {
+	_, err := imp.mc.StatObject(imp.ctx, imp.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, err
+}
+
+// remapObjectKey returns key, or a fresh key under uploads/ when key is
+// already taken.
+func remapObjectKey(key, fileID string, exists func(string) (bool, error)) (string, error) {
+	candidates := []string{key, "uploads/" + fileID}
+	for i := 0; i < 3; i++ {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		candidates = append(candidates, "uploads/"+fileID+"-"+hex.EncodeToString(b))
+	}
+	for _, c := range candidates {
+		taken, err := exists(c)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("no free object key for file %s", fileID)
+}
+
+// restoreObject uploads an object and removes it again unless its bytes
+// hash to the manifest's sha256.
+func (imp *backupImport) restoreObject(o BackupObject, size int64, r io.Reader) error {
+	key, ok := imp.keys[o.FileID]
+	if !ok {
+		return errors.New("no file row")
+	}
+	if size != o.Size {
+		return fmt.Errorf("archive holds %d bytes, manifest says %d", size, o.Size)
+	}
+	h := sha256.New()
+	if _, err := imp.mc.PutObject(imp.ctx, imp.bucket, key, io.TeeReader(r, h), size,
+		minio.PutObjectOptions{ContentType: imp.types[o.FileID]}); err != nil {
+		return err
+	}
+	imp.written = append(imp.written, key)
+	if got := hex.EncodeToString(h.Sum(nil)); got != o.SHA256 {
+		return fmt.Errorf("sha256 %s does not match the manifest", got)
+	}
+	imp.report.Objects++
+	imp.report.Bytes += size
+	return nil
+}
+
+// removeWritten deletes the objects of a failed import.
+func (imp *backupImport) removeWritten() {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(imp.ctx), time.Minute)
+	defer cancel()
+	for _, key := range imp.written {
+		if err := imp.mc.RemoveObject(ctx, imp.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			logFor(ctx, "backup").Error("restore_cleanup_failed", errAttr(err))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanBackupObjects_Incremental(t *testing.T) {
+	files := []backupFileRow{
+		{ID: "1", ObjectKey: "uploads/1", SHA256Hex: "aa", SizeBytes: 1},
+		{ID: "2", ObjectKey: "uploads/2", SHA256Hex: "bb", SizeBytes: 2},
+		{ID: "3", ObjectKey: "uploads/3", SHA256Hex: "cc", SizeBytes: 3},
+	}
+	if full := planBackupObjects(files, nil, "new"); len(full) != 3 || full[0].Archive != "new" {
+		t.Fatalf("full: %+v", full)
+	}
+
+	base := &BackupManifest{ID: "b2", Objects: []BackupObject{
+		{FileID: "1", SHA256: "aa", Archive: "b1"}, // carried over from an older archive
+		{FileID: "2", SHA256: "old", Archive: "b2"},
+		{FileID: "9", SHA256: "zz", Archive: "b2"}, // deleted since
+	}}
+	objects := planBackupObjects(files, base, "new")
+	got := map[string]string{}
+	for _, o := range objects {
+		got[o.FileID] = o.Archive
+	}
+	want := map[string]string{"1": "b1", "2": "new", "3": "new"}
+	if len(got) != len(want) {
+		t.Fatalf("objects: %+v", objects)
+	}
+	for id, archive := range want {
+		if got[id] != archive {
+			t.Errorf("file %s in archive %q, want %q", id, got[id], archive)
+		}
+	}
+
+	m := BackupManifest{ID: "new", Objects: objects}
+	if n, size := m.Included(); n != 2 || size != 5 {
+		t.Errorf("included %d objects, %d bytes", n, size)
+	}
+	if err := checkBackupChain(m, []BackupManifest{{ID: "b1"}, m}); err != nil {
+		t.Errorf("complete chain: %v", err)
+	}
+	if err := checkBackupChain(m, []BackupManifest{m}); err == nil || !strings.Contains(err.Error(), "b1") {
+		t.Errorf("chain without b1: %v", err)
+	}
+}
+
+func TestReadBackupManifest(t *testing.T) {
+	archive := func(first string, body []byte) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := writeTarEntry(tw, first, int64(len(body)), time.Now(), bytes.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return &buf
+	}
+	want := BackupManifest{Format: backupFormat, ID: "x", SchemaVersion: 13,
+		Tables: []BackupTable{{Name: "users", Records: 2, SHA256: "ab"}}}
+	body, _ := json.Marshal(want)
+
+	got, err := ReadBackupManifest(archive(backupManifestEntry, body))
+	if err != nil || got.ID != "x" || got.SchemaVersion != 13 || len(got.Tables) != 1 {
+		t.Errorf("manifest %+v: %v", got, err)
+	}
+	if _, err := ReadBackupManifest(archive("tables/users.ndjson", body)); err == nil {
+		t.Error("archive not starting with the manifest accepted")
+	}
+	body, _ = json.Marshal(BackupManifest{Format: backupFormat + 1})
+	if _, err := ReadBackupManifest(archive(backupManifestEntry, body)); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if _, err := ReadBackupManifest(strings.NewReader("not a tar")); err == nil {
+		t.Error("garbage accepted")
+	}
+}
+
+func TestRemapObjectKey(t *testing.T) {
+	taken := map[string]bool{}
+	exists := func(k string) (bool, error) { return taken[k], nil }
+
+	if k, err := remapObjectKey("uploads/a", "f1", exists); err != nil || k != "uploads/a" {
+		t.Errorf("free key: %q %v", k, err)
+	}
+	taken["custom/a"] = true
+	if k, err := remapObjectKey("custom/a", "f1", exists); err != nil || k != "uploads/f1" {
+		t.Errorf("taken key: %q %v", k, err)
+	}
+	taken["uploads/f1"] = true
+	if k, err := remapObjectKey("uploads/f1", "f1", exists); err != nil || !strings.HasPrefix(k, "uploads/f1-") {
+		t.Errorf("taken default key: %q %v", k, err)
+	}
+
+	boom := errors.New("boom")
+	if _, err := remapObjectKey("k", "f1", func(string) (bool, error) { return false, boom }); !errors.Is(err, boom) {
+		t.Errorf("stat error: %v", err)
+	}
+	if _, err := remapObjectKey("k", "f1", func(string) (bool, error) { return true, nil }); err == nil {
+		t.Error("no free key but no error")
+	}
+}
@@ -3,15 +3,14 @@ package server
 import (
 	"context"
 	"database/sql"
-	"log"
-	"os"
-	"strconv"
+	"log/slog"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 )
 
-// CleanupConfig holds configuration for the cleanup job
+// CleanupConfig holds configuration for the cleanup job. Server fills in
+// DB, MinioClient, Bucket and Metrics from its own dependencies.
 type CleanupConfig struct {
 	Enabled     bool
 	Interval    time.Duration
@@ -19,125 +18,285 @@ type CleanupConfig struct {
 	DB          *sql.DB
 	MinioClient *minio.Client
 	Bucket      string
+	Metrics     *Metrics // records deleted files; nil disables recording
+
+	// leader elects the replica that runs the job; nil runs it here.
+	leader *leaderElector
+
+	// maintenance pauses the job while read-only mode is on; nil never does.
+	maintenance *maintenanceMode
 }
 
 // StartCleanupJob starts a background goroutine that periodically cleans up expired files
 func StartCleanupJob(ctx context.Context, cfg CleanupConfig) {
-	if !cfg.Enabled {
-		log.Printf("service=cleanup msg=%q", "disabled")
-		return
-	}
+	runCleanupJob(ctx, cfg, nil)
+}
 
-	log.Printf("service=cleanup msg=%q interval=%s max_age=%s",
-		"starting", cfg.Interval, cfg.MaxAge)
+// cleanupRun performs one cleanup pass; tests replace it.
+var cleanupRun = runCleanup
 
-	ticker := time.NewTicker(cfg.Interval)
-	defer ticker.Stop()
+// runCleanupJob runs the cleanup loop until ctx is done. Settings received
+// on updates (configuration reloads) replace cfg: the ticker is reset to the
+// new interval, and a job enabled by a reload runs immediately as on start.
+// Without updates a disabled job returns at once.
+func runCleanupJob(ctx context.Context, cfg CleanupConfig, updates <-chan CleanupConfig) {
+	var (
+		ticker *time.Ticker
+		tick   <-chan time.Time
+	)
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	apply := func(c CleanupConfig) {
+		cfg = c
+		if !cfg.Enabled {
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+			logFor(ctx, "cleanup").Info("disabled")
+			return
+		}
+		logFor(ctx, "cleanup").Info("starting",
+			slog.Duration("interval", cfg.Interval), slog.Duration("max_age", cfg.MaxAge))
+		if ticker == nil {
+			ticker = time.NewTicker(cfg.Interval)
+			tick = ticker.C
+		} else {
+			ticker.Reset(cfg.Interval)
+		}
+	}
+
+	apply(cfg)
+	if !cfg.Enabled && updates == nil {
+		return
+	}
 
-	// Run immediately on start
-	runCleanup(ctx, cfg)
+	// Run immediately on start, once it is known whether this replica leads
+	select {
+	case <-cfg.leader.Ready():
+	case <-ctx.Done():
+		return
+	}
+	if cfg.Enabled {
+		cleanupRun(ctx, cfg)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("service=cleanup msg=%q", "shutting_down")
+			logFor(ctx, "cleanup").Info("shutting_down")
 			return
-		case <-ticker.C:
-			runCleanup(ctx, cfg)
+		case c := <-updates:
+			if c.Enabled == cfg.Enabled && c.Interval == cfg.Interval && c.MaxAge == cfg.MaxAge {
+				continue // another setting changed; keep the current schedule
+			}
+			wasEnabled := cfg.Enabled
+			apply(c)
+			if cfg.Enabled && !wasEnabled {
+				cleanupRun(ctx, cfg)
+			}
+		case <-tick:
+			cleanupRun(ctx, cfg)
 		}
 	}
 }
 
 func runCleanup(ctx context.Context, cfg CleanupConfig) {
+	if !cfg.leader.IsLeader() {
+		logFor(ctx, "cleanup").Debug("skipped_not_leader")
+		return
+	}
+	if cfg.maintenance.get(ctx).Enabled {
+		logFor(ctx, "cleanup").Info("skipped_maintenance")
+		return
+	}
+	if _, err := RunCleanup(ctx, cfg, CleanupOptions{Limit: 100, Trigger: "job"}); err != nil {
+		logFor(ctx, "cleanup").Error("query_failed", errAttr(err))
+	}
+	if err := pruneJobRuns(ctx, cfg.DB); err != nil {
+		logFor(ctx, "cleanup").Error("job_runs_prune_failed", errAttr(err))
+	}
+}
+
+// CleanupOptions controls one RunCleanup pass.
+type CleanupOptions struct {
+	DryRun  bool   // report what would be deleted without deleting
+	Limit   int    // at most this many files; 0 means no limit
+	Trigger string // job, manual or cli; recorded in the audit log
+}
+
+// CleanupCandidate is a file selected for deletion by cleanup.
+type CleanupCandidate struct {
+	ID        string    `json:"id"`
+	ObjectKey string    `json:"object_key"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+
+	deleted bool
+}
+
+// CleanupReport lists the files a cleanup pass selected and how many it
+// deleted (always 0 for a dry run).
+type CleanupReport struct {
+	Candidates []CleanupCandidate `json:"candidates"`
+	Deleted    int                `json:"deleted"`
+	DryRun     bool               `json:"dry_run"`
+}
+
+// cleanupCondition selects expired files: stale uploads ($1 is the
+// cutoff) and files past their retention.
+const cleanupCondition = `((created_at < $1 AND status IN ('pending', 'failed')) OR expires_at < now())`
+
+// RunCleanup deletes expired files: stale uploads (created more than
+// MaxAge ago and still pending or failed) and files past their retention
+// (expires_at, set from the owning organization's policy). It ignores
+// cfg.Enabled, which only governs the background job.
+//
+// Each file is claimed with FOR UPDATE SKIP LOCKED in its own transaction,
+// so concurrent passes (the job on another replica, a manual run) split
+// the work instead of deleting the same files twice. Manual and CLI passes
+// are recorded in job_runs; scheduled passes only when they found a file
+// or failed, like resume_uploads, so idle ticks leave no rows.
+func RunCleanup(ctx context.Context, cfg CleanupConfig, opts CleanupOptions) (CleanupReport, error) {
 	start := time.Now()
-	log.Printf("service=cleanup msg=%q", "starting_cleanup_run")
+	logger := logFor(ctx, "cleanup").With(slog.String("trigger", opts.Trigger))
+	logger.Debug("run_started", slog.Bool("dry_run", opts.DryRun))
 
 	cutoff := time.Now().Add(-cfg.MaxAge)
+	report := CleanupReport{Candidates: []CleanupCandidate{}, DryRun: opts.DryRun}
+	if opts.DryRun {
+		err := listCleanupCandidates(ctx, cfg.DB, cutoff, opts.Limit, &report)
+		return report, err
+	}
+
+	var (
+		run   *JobRun
+		after CleanupCandidate // keyset position: files before it were handled
+		err   error
+	)
+	record := func() {
+		if run == nil {
+			run = startJobRun(ctx, cfg.DB, "cleanup", opts.Trigger)
+		}
+	}
+	if opts.Trigger != "job" {
+		record()
+	}
+	for opts.Limit <= 0 || len(report.Candidates) < opts.Limit {
+		var c CleanupCandidate
+		var ok bool
+		c, ok, err = cleanupNext(ctx, cfg, cutoff, after, opts.Trigger, logger)
+		if err != nil || !ok {
+			break
+		}
+		record()
+		report.Candidates = append(report.Candidates, c)
+		if c.deleted {
+			report.Deleted++
+		}
+		after = c
+	}
+	if err != nil {
+		record()
+	}
+	run.finish(ctx, cfg.DB, report.Deleted, len(report.Candidates)-report.Deleted, err)
+	if err != nil {
+		return report, err
+	}
+
+	logger.Info("run_complete",
+		slog.Int("deleted", report.Deleted), slog.Int64(logKeyDuration, time.Since(start).Milliseconds()))
+	return report, nil
+}
 
-	// Find expired files (created more than MaxAge ago, not in 'ready' state)
-	rows, err := cfg.DB.QueryContext(ctx, `
+// listCleanupCandidates fills report with the files a pass would delete.
+func listCleanupCandidates(ctx context.Context, db *sql.DB, cutoff time.Time, limit int, report *CleanupReport) error {
+	query := `
 		SELECT id, object_key, status, created_at
 		FROM files
-		WHERE created_at < $1
-		  AND status IN ('pending', 'failed')
-		ORDER BY created_at ASC
-		LIMIT 100
-	`, cutoff)
+		WHERE ` + cleanupCondition + `
+		ORDER BY created_at, id`
+	args := []any{cutoff}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("service=cleanup msg=%q err=%v", "query_failed", err)
-		return
+		return err
 	}
 	defer rows.Close()
-
-	deleted := 0
 	for rows.Next() {
-		var (
-			id        string
-			objectKey string
-			status    string
-			createdAt time.Time
-		)
-
-		if err := rows.Scan(&id, &objectKey, &status, &createdAt); err != nil {
-			log.Printf("service=cleanup msg=%q err=%v", "scan_failed", err)
-			continue
-		}
-
-		age := time.Since(createdAt)
-		log.Printf("service=cleanup msg=%q id=%s status=%s age=%s",
-			"deleting_expired_file", id, status, age)
-
-		// Delete from MinIO (if exists)
-		if err := cfg.MinioClient.RemoveObject(ctx, cfg.Bucket, objectKey, minio.RemoveObjectOptions{}); err != nil {
-			log.Printf("service=cleanup msg=%q id=%s err=%v", "minio_delete_failed", id, err)
-			// Continue anyway - record might be orphaned
-		}
-
-		// Delete from database
-		if _, err := cfg.DB.ExecContext(ctx, `DELETE FROM files WHERE id = $1`, id); err != nil {
-			log.Printf("service=cleanup msg=%q id=%s err=%v", "db_delete_failed", id, err)
-			continue
+		var c CleanupCandidate
+		if err := rows.Scan(&c.ID, &c.ObjectKey, &c.Status, &c.CreatedAt); err != nil {
+			return err
 		}
-
-		deleted++
+		report.Candidates = append(report.Candidates, c)
 	}
-
-	duration := time.Since(start)
-	log.Printf("service=cleanup msg=%q deleted=%d duration_ms=%d",
-		"cleanup_complete", deleted, duration.Milliseconds())
+	return rows.Err()
 }
 
-// GetCleanupConfigFromEnv reads cleanup configuration from environment variables
-func GetCleanupConfigFromEnv(db *sql.DB, mc *minio.Client, bucket string) CleanupConfig {
-	enabled := os.Getenv("SFD_CLEANUP_ENABLED") == "true"
+// cleanupNext claims the next expired file after the keyset position
+// after, skipping files locked by a concurrent pass, and deletes its object
+// and row. ok is false when no file is left; a file that could not be
+// deleted is returned with deleted unset.
+func cleanupNext(ctx context.Context, cfg CleanupConfig, cutoff time.Time, after CleanupCandidate, trigger string, logger *slog.Logger) (c CleanupCandidate, ok bool, err error) {
+	tx, err := cfg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return c, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
 
-	interval := 1 * time.Hour
-	if v := os.Getenv("SFD_CLEANUP_INTERVAL"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			interval = d
-		}
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, object_key, status, created_at
+		FROM files
+		WHERE `+cleanupCondition+`
+		  AND (created_at, id::text) > ($2, $3)
+		ORDER BY created_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`,
+		cutoff, after.CreatedAt, after.ID,
+	).Scan(&c.ID, &c.ObjectKey, &c.Status, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return c, false, nil
+	}
+	if err != nil {
+		return c, false, err
 	}
 
-	maxAge := 24 * time.Hour
-	if v := os.Getenv("SFD_CLEANUP_MAX_AGE"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			maxAge = d
-		}
+	fileLog := logger.With(logKeyFileID, c.ID)
+	fileLog.Info("deleting_expired_file",
+		slog.String("status", c.Status), slog.Duration("age", time.Since(c.CreatedAt)))
+
+	// Delete from MinIO (if exists)
+	if err := cfg.MinioClient.RemoveObject(ctx, cfg.Bucket, c.ObjectKey, minio.RemoveObjectOptions{}); err != nil {
+		fileLog.Error("minio_delete_failed", errAttr(err))
+		// Continue anyway - record might be orphaned
 	}
 
-	// Also support hours as a fallback
-	if v := os.Getenv("SFD_CLEANUP_MAX_AGE_HOURS"); v != "" {
-		if hours, err := strconv.Atoi(v); err == nil {
-			maxAge = time.Duration(hours) * time.Hour
-		}
+	// Delete from database
+	if _, err := tx.ExecContext(ctx, `DELETE FROM files WHERE id = $1`, c.ID); err != nil {
+		fileLog.Error("db_delete_failed", errAttr(err))
+		return c, true, nil
+	}
+	if err := tx.Commit(); err != nil {
+		fileLog.Error("db_delete_failed", errAttr(err))
+		return c, true, nil
 	}
 
-	return CleanupConfig{
-		Enabled:     enabled,
-		Interval:    interval,
-		MaxAge:      maxAge,
-		DB:          db,
-		MinioClient: mc,
-		Bucket:      bucket,
+	c.deleted = true
+	cfg.Metrics.RecordFileStateTransition("deleted")
+	fields := map[string]string{
+		"file_id": c.ID,
+		"status":  c.Status,
+		"trigger": trigger,
+	}
+	if trigger == "job" {
+		fields["actor"] = "system"
 	}
+	recordAudit(ctx, "file_cleaned_up", fields)
+	return c, true, nil
 }
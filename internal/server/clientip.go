@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// trustProxyHeaders mirrors server.trust_proxy_headers and trustedProxyHops
+// server.trusted_proxy_hops; clientIP is called from places without a
+// Config, so they are process-wide like the password parameters.
+var (
+	trustProxyHeaders atomic.Bool
+	trustedProxyHops  atomic.Int32
+)
+
+// SetTrustProxyHeaders controls whether clientIP honours X-Forwarded-For,
+// and how many proxies in front of the server append to it (at least 1).
+func SetTrustProxyHeaders(trust bool, hops int) {
+	if hops < 1 {
+		hops = 1
+	}
+	trustProxyHeaders.Store(trust)
+	trustedProxyHops.Store(int32(hops))
+}
+
+// clientIP returns the address of the client that sent r.
+//
+// X-Forwarded-For is only honoured with SetTrustProxyHeaders(true, n), i.e.
+// when the backend is reachable exclusively through reverse proxies that
+// append to it; otherwise any client could pick its own address. Proxies
+// keep whatever the client sent, so only the n rightmost entries were
+// written by them: the client is the n-th entry from the right.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders.Load() {
+		if ip := forwardedFor(r.Header.Values("X-Forwarded-For"), int(trustedProxyHops.Load())); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor returns the hops-th address from the right of the
+// X-Forwarded-For header values, or "" when there are fewer entries or that
+// one is not an IP address.
+func forwardedFor(values []string, hops int) string {
+	var entries []string
+	for _, v := range values {
+		entries = append(entries, strings.Split(v, ",")...)
+	}
+	if hops < 1 {
+		hops = 1
+	}
+	if len(entries) < hops {
+		return ""
+	}
+	ip := net.ParseIP(strings.TrimSpace(entries[len(entries)-hops]))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
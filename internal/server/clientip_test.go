@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:54321"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	t.Cleanup(func() { SetTrustProxyHeaders(false, 1) })
+	SetTrustProxyHeaders(false, 1)
+	if got := clientIP(r); got != "10.0.0.1" {
+		t.Fatalf("untrusted proxy headers: got %s", got)
+	}
+
+	SetTrustProxyHeaders(true, 1)
+	if got := clientIP(r); got != "203.0.113.7" {
+		t.Fatalf("trusted proxy headers: got %s", got)
+	}
+
+	r.Header.Set("X-Forwarded-For", "not-an-ip")
+	if got := clientIP(r); got != "10.0.0.1" {
+		t.Fatalf("invalid forwarded address should fall back: got %s", got)
+	}
+}
+
+func TestClientIP_IgnoresClientSuppliedForwardedFor(t *testing.T) {
+	t.Cleanup(func() { SetTrustProxyHeaders(false, 1) })
+
+	cases := []struct {
+		name   string
+		hops   int
+		values []string
+		want   string
+	}{
+		// The client sent "X-Forwarded-For: 198.51.100.1, 198.51.100.2";
+		// the proxy appended the address it saw.
+		{"one proxy", 1, []string{"198.51.100.1, 198.51.100.2, 203.0.113.7"}, "203.0.113.7"},
+		{"repeated headers", 1, []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"cdn and load balancer", 2, []string{"198.51.100.1, 203.0.113.7, 192.0.2.50"}, "203.0.113.7"},
+		{"fewer entries than hops", 2, []string{"203.0.113.7"}, "10.0.0.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			SetTrustProxyHeaders(true, c.hops)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.0.0.1:54321"
+			for _, v := range c.values {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r); got != c.want {
+				t.Fatalf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CSRF protection uses signed double-submit tokens. At login the server sets
+// a JavaScript-readable cookie holding "<nonce>.<mac>", where mac is an HMAC
+// of the nonce bound to the session cookie value. Unsafe requests that carry a
+// session cookie must echo the token in the X-CSRF-Token header. Because the
+// MAC is tied to the session, a token planted by a sibling subdomain or taken
+// from another session does not validate.
+const (
+	csrfCookieName = "sfd_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfExemptPaths do not act on behalf of an existing session, so a forged
+// request gains nothing; /login is exempt so a stale cookie cannot block
+// signing in again.
+var csrfExemptPaths = map[string]bool{
+	"/login":           true,
+	"/register":        true,
+	"/password/forgot": true,
+	"/password/reset":  true,
+	"/verify-email":    true,
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// makeCSRFToken returns a token bound to the given session cookie value.
+func (a AuthConfig) makeCSRFToken(session string) (string, error) {
+	nonce, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+	return nonce + "." + signPayload(a.secretBytes(), "csrf:"+nonce+":"+session), nil
+}
+
+// validCSRFToken checks that tok was issued for session.
+func (a AuthConfig) validCSRFToken(tok, session string) bool {
+	nonce, mac, ok := strings.Cut(tok, ".")
+	if !ok || nonce == "" || mac == "" {
+		return false
+	}
+	want := signPayload(a.secretBytes(), "csrf:"+nonce+":"+session)
+	return hmac.Equal([]byte(mac), []byte(want))
+}
+
+// setCSRFCookie issues a fresh CSRF token for session. The cookie is
+// readable by scripts on purpose: the web UI copies it into the header.
+func (a AuthConfig) setCSRFCookie(w http.ResponseWriter, r *http.Request, session string, exp time.Time) (string, error) {
+	tok, err := a.makeCSRFToken(session)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    tok,
+		Path:     "/",
+		Expires:  exp,
+		HttpOnly: false,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   a.cookieSecure(r),
+	})
+	return tok, nil
+}
+
+// cookieSecure decides the Secure attribute for cookies. CookieSecure may be
+// "true" or "false" to force it; anything else (including empty) means auto:
+// secure when the request arrived over TLS, directly or, when proxy headers
+// are trusted (see clientIP), via a proxy that sets X-Forwarded-Proto.
+func (a AuthConfig) cookieSecure(r *http.Request) bool {
+	switch strings.ToLower(strings.TrimSpace(a.CookieSecure)) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if r.TLS != nil {
+		return true
+	}
+	return trustProxyHeaders.Load() &&
+		strings.EqualFold(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")), "https")
+}
+
+// csrfMiddleware rejects unsafe requests authenticated by the session cookie
+// unless they carry a matching X-CSRF-Token header. Bearer-token requests are
+// exempt: browsers never attach Authorization headers on their own.
+func (a AuthConfig) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) || csrfExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := r.Cookie(a.cookieName())
+		if err != nil || c.Value == "" {
+			// No ambient credential: the handler's own auth check applies.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !a.validCSRFToken(r.Header.Get(csrfHeaderName), c.Value) {
+			http.Error(w, "csrf token missing or invalid", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfHandler handles GET /csrf, re-issuing the token for the current session
+// (e.g. after the CSRF cookie was cleared).
+func (a AuthConfig) csrfHandler() http.Handler {
+	return a.requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c, err := r.Cookie(a.cookieName())
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		sp, err := a.verifyToken(c.Value)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tok, err := a.setCSRFCookie(w, r, c.Value, time.Unix(sp.Exp, 0))
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"csrf_token": tok,
+		})
+	}))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCSRFToken_BoundToSession(t *testing.T) {
+	a := AuthConfig{SessionSecret: "s"}
+	tok, err := a.makeCSRFToken("session-a")
+	if err != nil {
+		t.Fatalf("makeCSRFToken error: %v", err)
+	}
+	if !a.validCSRFToken(tok, "session-a") {
+		t.Fatal("token should validate for its own session")
+	}
+	if a.validCSRFToken(tok, "session-b") {
+		t.Fatal("token must not validate for another session")
+	}
+	for _, bad := range []string{"", "nodot", ".mac", "nonce."} {
+		if a.validCSRFToken(bad, "session-a") {
+			t.Errorf("malformed token %q validated", bad)
+		}
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	a := AuthConfig{SessionSecret: "s", SessionTTL: time.Hour}
+	session, _, _ := a.makeToken("admin")
+	csrf, _ := a.makeCSRFToken(session)
+
+	h := a.csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		cookie bool
+		header string
+		bearer bool
+		want   int
+	}{
+		{"safe method", http.MethodGet, "/admin/files", true, "", false, http.StatusOK},
+		{"no session cookie", http.MethodPost, "/files", false, "", false, http.StatusOK},
+		{"missing header", http.MethodPost, "/files", true, "", false, http.StatusForbidden},
+		{"wrong header", http.MethodDelete, "/admin/files/x", true, "abc.def", false, http.StatusForbidden},
+		{"valid header", http.MethodPost, "/links", true, csrf, false, http.StatusOK},
+		{"bearer exempt", http.MethodPost, "/upload", true, "", true, http.StatusOK},
+		{"login exempt", http.MethodPost, "/login", true, "", false, http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, nil)
+			if c.cookie {
+				req.AddCookie(&http.Cookie{Name: a.cookieName(), Value: session})
+			}
+			if c.header != "" {
+				req.Header.Set(csrfHeaderName, c.header)
+			}
+			if c.bearer {
+				req.Header.Set("Authorization", "Bearer sfd_pat_x")
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != c.want {
+				t.Fatalf("expected %d, got %d", c.want, rr.Code)
+			}
+		})
+	}
+}
+
+func TestCookieSecure(t *testing.T) {
+	plain := httptest.NewRequest(http.MethodPost, "/login", nil)
+	tlsReq := httptest.NewRequest(http.MethodPost, "/login", nil)
+	tlsReq.TLS = &tls.ConnectionState{}
+	proxied := httptest.NewRequest(http.MethodPost, "/login", nil)
+	proxied.Header.Set("X-Forwarded-Proto", "https")
+
+	auto := AuthConfig{CookieSecure: "auto"}
+	if auto.cookieSecure(plain) {
+		t.Error("auto: plain HTTP should not be secure")
+	}
+	t.Cleanup(func() { SetTrustProxyHeaders(false, 1) })
+	SetTrustProxyHeaders(false, 1)
+	if !auto.cookieSecure(tlsReq) || auto.cookieSecure(proxied) {
+		t.Error("auto: TLS should be secure, untrusted X-Forwarded-Proto ignored")
+	}
+	SetTrustProxyHeaders(true, 1)
+	if !auto.cookieSecure(proxied) {
+		t.Error("auto: trusted proxied HTTPS should be secure")
+	}
+	if !(AuthConfig{CookieSecure: "true"}).cookieSecure(plain) {
+		t.Error("forced true should be secure")
+	}
+	if (AuthConfig{CookieSecure: "false"}).cookieSecure(tlsReq) {
+		t.Error("forced false should not be secure")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
+
 	// Register the pgx driver with database/sql. The blank import ensures the
 	// driver initialization runs when the package is used.
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -37,3 +39,18 @@ func OpenDB(databaseURL string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// Postgres error codes the handlers react to.
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// pgErrorCode returns the SQLSTATE of a Postgres error, or "".
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 )
 
@@ -18,9 +20,13 @@ import (
 // checks the file status (must be "hashed" or "ready"), and streams the file directly
 // from MinIO to the client without buffering in memory.
 //
-// Required query parameter: token (HMAC-signed token with file ID and expiry)
+// GET /download?id={uuid} serves the same stream to authenticated users with
+// access to the file (uploader, org members, share recipients, admins), so
+// internal sharing does not need a public token.
+//
+// Required query parameter: token (HMAC-signed token with file ID and expiry) or id
 // Response: Binary file stream with Content-Type, Content-Length, Content-Disposition headers
-// Authentication: Not required (uses signed token for authorization)
+// Authentication: Not required for token (uses signed token for authorization); required for id
 func (cfg Config) downloadHandler(db *sql.DB, mc *minio.Client, bucket string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -29,20 +35,36 @@ func (cfg Config) downloadHandler(db *sql.DB, mc *minio.Client, bucket string) h
 		}
 
 		token := r.URL.Query().Get("token")
+		if token == "" && r.URL.Query().Get("id") != "" {
+			cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				downloadByID(w, r, db, mc, bucket, cfg.Registry)
+			})).ServeHTTP(w, r)
+			return
+		}
 		if token == "" {
 			http.Error(w, "missing token", http.StatusBadRequest)
 			return
 		}
 
-		claims, err := verifyDownloadToken(token, time.Now().UTC())
+		keys := downloadKeyRing{db, cfg.DownloadSecret}
+		claims, err := verifyDownloadTokenWith(keys.secretFor(r.Context()), token, time.Now().UTC())
+		if err == nil {
+			err = checkLink(r.Context(), db, claims)
+		}
 		if err != nil {
-			if errors.Is(err, errTokenExpired) {
+			switch {
+			case errors.Is(err, errTokenExpired):
 				http.Error(w, "token expired", http.StatusGone)
-				return
+			case errors.Is(err, errLinkRevoked):
+				http.Error(w, "link revoked", http.StatusGone)
+			case errors.Is(err, errBadToken), errors.Is(err, errLinkNotFound), errors.Is(err, errDownloadSecretMissing):
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+			default:
+				http.Error(w, "db error", http.StatusInternalServerError)
 			}
-			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
+		addLogAttrs(r.Context(), slog.String(logKeyFileID, claims.FileID))
 
 		var (
 			objectKey   string
@@ -67,45 +89,104 @@ func (cfg Config) downloadHandler(db *sql.DB, mc *minio.Client, bucket string) h
 			return
 		}
 
-		// Only allow downloads after file integrity has been verified via hashing.
-		// Status must be "hashed" (hash complete) or "ready" (verified and approved).
-		if status != "hashed" && status != "ready" {
-			http.Error(w, "file not ready", http.StatusConflict)
-			return
+		if n, ok := streamFile(w, r, mc, bucket, objectKey, status, contentType, origName, sizeBytes, cfg.Registry); ok {
+			recordAudit(r.Context(), "file_downloaded", map[string]string{
+				"file_id": claims.FileID,
+				"via":     "link",
+				"bytes":   strconv.FormatInt(n, 10),
+				"ip":      clientIP(r),
+			})
 		}
+	})
+}
 
-		// Set a generous timeout for large file downloads (30 minutes for up to 50GB files)
-		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
-		defer cancel()
-
-		// Stream the object directly from MinIO (no memory buffering)
-		obj, err := mc.GetObject(ctx, bucket, objectKey, minio.GetObjectOptions{})
-		if err != nil {
-			http.Error(w, "storage error", http.StatusBadGateway)
+// downloadByID serves GET /download?id={uuid} for an authenticated caller
+// holding at least read access to the file.
+func downloadByID(w http.ResponseWriter, r *http.Request, db *sql.DB, mc *minio.Client, bucket string, m *Metrics) {
+	id, err := uuid.Parse(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	addLogAttrs(r.Context(), slog.String(logKeyFileID, id.String()))
+	f, perm, err := fileAccess(r.Context(), db, PrincipalFromContext(r.Context()), id.String())
+	if err != nil {
+		if err == errFileNotFound {
+			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		defer func() { _ = obj.Close() }()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if perm < permRead {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if n, ok := streamFile(w, r, mc, bucket, f.ObjectKey, f.Status, f.ContentType, f.OrigName, f.SizeBytes, m); ok {
+		recordAudit(r.Context(), "file_downloaded", map[string]string{
+			"file_id": f.ID,
+			"via":     "id",
+			"bytes":   strconv.FormatInt(n, 10),
+			"ip":      clientIP(r),
+		})
+	}
+}
 
-		// Force an early error for missing object / auth issues.
-		if _, statErr := obj.Stat(); statErr != nil {
-			http.Error(w, "storage error", http.StatusBadGateway)
-			return
-		}
+// streamFile copies a stored object to the response once its integrity has
+// been verified, recording the download (or its failure) in m. It reports
+// the bytes sent and whether the whole object was delivered.
+func streamFile(w http.ResponseWriter, r *http.Request, mc *minio.Client, bucket, objectKey, status, contentType, origName string, sizeBytes int64, m *Metrics) (int64, bool) {
+	// Only allow downloads after file integrity has been verified via hashing.
+	// Status must be "hashed" (hash complete) or "ready" (verified and approved).
+	if status != "hashed" && status != "ready" {
+		http.Error(w, "file not ready", http.StatusConflict)
+		return 0, false
+	}
 
-		if contentType != "" {
-			w.Header().Set("Content-Type", contentType)
-		} else {
-			w.Header().Set("Content-Type", "application/octet-stream")
-		}
-		if sizeBytes > 0 {
-			w.Header().Set("Content-Length", strconv.FormatInt(sizeBytes, 10))
-		}
+	// Set a generous timeout for large file downloads (30 minutes for up to 50GB files)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
 
-		// Encourage safe download behavior in browsers.
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, origName))
+	start := time.Now()
 
-		w.WriteHeader(http.StatusOK)
+	// Stream the object directly from MinIO (no memory buffering)
+	obj, err := mc.GetObject(ctx, bucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		m.RecordDownloadError()
+		logFor(r.Context(), "download").Error("get_object_failed", errAttr(err))
+		http.Error(w, "storage error", http.StatusBadGateway)
+		return 0, false
+	}
+	defer func() { _ = obj.Close() }()
 
-		_, _ = io.Copy(w, obj)
-	})
+	// Force an early error for missing object / auth issues.
+	if _, statErr := obj.Stat(); statErr != nil {
+		m.RecordDownloadError()
+		logFor(r.Context(), "download").Error("stat_object_failed", errAttr(statErr))
+		http.Error(w, "storage error", http.StatusBadGateway)
+		return 0, false
+	}
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if sizeBytes > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(sizeBytes, 10))
+	}
+
+	// Encourage safe download behavior in browsers.
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, origName))
+
+	w.WriteHeader(http.StatusOK)
+
+	n, err := io.Copy(w, obj)
+	if err != nil {
+		// Typically the client went away; the status line is already sent.
+		m.RecordDownloadError()
+		return n, false
+	}
+	m.RecordDownload(n, time.Since(start))
+	return n, true
 }
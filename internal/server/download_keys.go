@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// configKeyID is the download_keys id standing for the configured
+// DownloadSecret; tokens signed with it carry no key id.
+const configKeyID = ""
+
+// downloadKeyLockKey serializes key rotations (pg_advisory_xact_lock).
+const downloadKeyLockKey int64 = 0x534644444b455953 // "SFDDKEYS"
+
+// DefaultKeyGrace keeps a rotated-out key verifying links for the longest
+// link lifetime, so rotation does not break links already handed out.
+const DefaultKeyGrace = maxLinkTTLSeconds * time.Second
+
+// downloadKeyRing signs and verifies download tokens with the keys in
+// download_keys, falling back to the configured secret until the first
+// rotation.
+type downloadKeyRing struct {
+	db       *sql.DB
+	fallback string // Config.DownloadSecret
+}
+
+// signingKey returns the key new links are signed with: the newest key
+// that is not retired, else the configured secret.
+func (k downloadKeyRing) signingKey(ctx context.Context) (id, secret string, err error) {
+	if k.db == nil {
+		return configKeyID, k.fallback, nil
+	}
+	var raw []byte
+	err = k.db.QueryRowContext(ctx, `
+		SELECT id, secret FROM download_keys
+		WHERE secret IS NOT NULL AND (retired_at IS NULL OR retired_at > now())
+		ORDER BY created_at DESC
+		LIMIT 1
+	`).Scan(&id, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return configKeyID, k.fallback, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return id, string(raw), nil
+}
+
+// secretFor returns the secret for a token's key id. Unknown and retired
+// keys yield errBadToken; the configured secret verifies until it has
+// been rotated out and its grace period is over.
+func (k downloadKeyRing) secretFor(ctx context.Context) func(string) (string, error) {
+	return func(id string) (string, error) {
+		if k.db == nil {
+			if id != configKeyID {
+				return "", errBadToken
+			}
+			return k.fallback, nil
+		}
+		var (
+			raw     []byte
+			retired sql.NullTime
+		)
+		err := k.db.QueryRowContext(ctx,
+			`SELECT secret, retired_at FROM download_keys WHERE id = $1`, id,
+		).Scan(&raw, &retired)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if id == configKeyID {
+				return k.fallback, nil // never rotated
+			}
+			return "", errBadToken
+		case err != nil:
+			return "", err
+		case retired.Valid && !retired.Time.After(time.Now()):
+			return "", errBadToken
+		case id == configKeyID:
+			return k.fallback, nil
+		}
+		return string(raw), nil
+	}
+}
+
+// DownloadKey describes a link signing key; secrets are never listed.
+type DownloadKey struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	Status    string     `json:"status"` // active, retiring, retired
+}
+
+func (k *DownloadKey) setStatus(now time.Time) {
+	switch {
+	case k.RetiredAt == nil:
+		k.Status = "active"
+	case k.RetiredAt.After(now):
+		k.Status = "retiring"
+	default:
+		k.Status = "retired"
+	}
+}
+
+// ListDownloadKeys returns the signing keys, newest first. The configured
+// secret is listed with an empty id once it has been rotated out.
+func ListDownloadKeys(ctx context.Context, db *sql.DB) ([]DownloadKey, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, created_at, retired_at FROM download_keys ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	keys := []DownloadKey{}
+	for rows.Next() {
+		var (
+			k       DownloadKey
+			retired sql.NullTime
+		)
+		if err := rows.Scan(&k.ID, &k.CreatedAt, &retired); err != nil {
+			return nil, err
+		}
+		if retired.Valid {
+			k.RetiredAt = &retired.Time
+		}
+		k.setStatus(now)
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateDownloadKey creates a new link signing key and retires every other
+// key, including the configured secret, after grace. Links signed with a
+// retired key stop working, so a grace of 0 invalidates all outstanding
+// links at once (e.g. after a leak).
+func RotateDownloadKey(ctx context.Context, db *sql.DB, grace time.Duration) (DownloadKey, error) {
+	if grace < 0 {
+		return DownloadKey{}, errors.New("grace must not be negative")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return DownloadKey{}, err
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return DownloadKey{}, err
+	}
+	key := DownloadKey{ID: hex.EncodeToString(idBytes), Status: "active"}
+	retireAt := time.Now().Add(grace)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return DownloadKey{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, downloadKeyLockKey); err != nil {
+		return DownloadKey{}, fmt.Errorf("key lock: %w", err)
+	}
+	// An earlier retirement date wins: rotating again never extends the
+	// life of a key.
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO download_keys (id, secret, retired_at) VALUES ('', NULL, $1)
+		ON CONFLICT (id) DO UPDATE SET retired_at = LEAST(download_keys.retired_at, EXCLUDED.retired_at)
+	`, retireAt); err != nil {
+		return DownloadKey{}, fmt.Errorf("retire configured key: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE download_keys SET retired_at = $1
+		WHERE id <> '' AND (retired_at IS NULL OR retired_at > $1)
+	`, retireAt); err != nil {
+		return DownloadKey{}, fmt.Errorf("retire keys: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO download_keys (id, secret) VALUES ($1, $2) RETURNING created_at`,
+		key.ID, secret,
+	).Scan(&key.CreatedAt); err != nil {
+		return DownloadKey{}, fmt.Errorf("insert key: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return DownloadKey{}, err
+	}
+
+	recordAudit(ctx, "download_key_rotated", map[string]string{
+		"key_id":    key.ID,
+		"retire_at": retireAt.UTC().Format(time.RFC3339),
+	})
+	return key, nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errLinkNotFound = errors.New("link not found")
+	errLinkRevoked  = errors.New("link revoked")
+)
+
+// LinkInfo describes an issued download link; the token itself is never
+// stored.
+type LinkInfo struct {
+	ID        string     `json:"id"`
+	FileID    string     `json:"file_id"`
+	OrigName  string     `json:"orig_name"`
+	KeyID     string     `json:"key_id"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy string     `json:"revoked_by,omitempty"`
+	Status    string     `json:"status"` // active, expired, revoked
+}
+
+func (l *LinkInfo) setStatus(now time.Time) {
+	switch {
+	case l.RevokedAt != nil:
+		l.Status = "revoked"
+	case !l.ExpiresAt.After(now):
+		l.Status = "expired"
+	default:
+		l.Status = "active"
+	}
+}
+
+// LinkFilter narrows ListLinks. Without All only links that still work
+// are listed.
+type LinkFilter struct {
+	FileID string
+	All    bool
+	Limit  int // 0 means defaultPageLimit
+}
+
+// ListLinks returns issued download links, newest first.
+func ListLinks(ctx context.Context, db *sql.DB, f LinkFilter) ([]LinkInfo, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.FileID != "" {
+		args = append(args, f.FileID)
+		where = append(where, "l.file_id::text = $"+strconv.Itoa(len(args)))
+	}
+	if !f.All {
+		where = append(where, "l.revoked_at IS NULL AND l.expires_at > now()")
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT l.id, l.file_id, f.orig_name, l.key_id, l.created_by, l.created_at,
+		       l.expires_at, l.revoked_at, COALESCE(l.revoked_by, '')
+		FROM download_links l JOIN files f ON f.id = l.file_id`+cond+`
+		ORDER BY l.created_at DESC, l.id
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	links := []LinkInfo{}
+	for rows.Next() {
+		var (
+			l       LinkInfo
+			revoked sql.NullTime
+		)
+		if err := rows.Scan(&l.ID, &l.FileID, &l.OrigName, &l.KeyID, &l.CreatedBy, &l.CreatedAt,
+			&l.ExpiresAt, &revoked, &l.RevokedBy); err != nil {
+			return nil, err
+		}
+		if revoked.Valid {
+			l.RevokedAt = &revoked.Time
+		}
+		l.setStatus(now)
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// RevokeLinks revokes the link linkID, or every unrevoked link of fileID
+// when linkID is empty, and returns how many were revoked. Revoked links
+// answer 410 from /download.
+func RevokeLinks(ctx context.Context, db *sql.DB, linkID, fileID string) (int64, error) {
+	if (linkID == "") == (fileID == "") {
+		return 0, errors.New("exactly one of link id and file id is required")
+	}
+	actor := PrincipalFromContext(ctx).Subject
+	query, arg := `UPDATE download_links SET revoked_at = now(), revoked_by = $2
+		WHERE id::text = $1 AND revoked_at IS NULL`, linkID
+	if linkID == "" {
+		query, arg = `UPDATE download_links SET revoked_at = now(), revoked_by = $2
+			WHERE file_id::text = $1 AND revoked_at IS NULL`, fileID
+	}
+	res, err := db.ExecContext(ctx, query, arg, actor)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 && linkID != "" {
+		return 0, errLinkNotFound
+	}
+
+	fields := map[string]string{"count": strconv.FormatInt(n, 10)}
+	if linkID != "" {
+		fields["link_id"] = linkID
+	} else {
+		fields["file_id"] = fileID
+	}
+	recordAudit(ctx, "link_revoked", fields)
+	return n, nil
+}
+
+// recordLink stores a link about to be handed out so it can be listed and
+// revoked.
+func recordLink(ctx context.Context, db *sql.DB, l LinkInfo) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO download_links (id, file_id, key_id, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, l.ID, l.FileID, l.KeyID, l.CreatedBy, l.ExpiresAt)
+	return err
+}
+
+// checkLink rejects tokens whose link was revoked or no longer exists (its
+// file was deleted). Tokens from before links were recorded carry no link
+// id and are not checked.
+func checkLink(ctx context.Context, db *sql.DB, c downloadClaims) error {
+	if c.LinkID == "" || db == nil {
+		return nil
+	}
+	var revoked sql.NullTime
+	err := db.QueryRowContext(ctx,
+		`SELECT revoked_at FROM download_links WHERE id::text = $1 AND file_id::text = $2`,
+		c.LinkID, c.FileID,
+	).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errLinkNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if revoked.Valid {
+		return errLinkRevoked
+	}
+	return nil
+}
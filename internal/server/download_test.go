@@ -49,19 +49,19 @@ func TestDownloadHandler_MissingToken(t *testing.T) {
 }
 
 func TestDownloadHandler_ExpiredToken(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "test-secret-for-download")
+	secret := "test-secret-for-download"
 
 	// Create an expired token
 	fileID := uuid.New().String()
 	expiredTime := time.Now().Add(-1 * time.Hour)
 
-	token, err := signDownloadToken(fileID, expiredTime)
+	token, err := signDownloadToken(secret, fileID, expiredTime)
 	if err != nil {
 		t.Fatalf("Failed to sign token: %v", err)
 	}
 
 	// Verify it's expired
-	_, err = verifyDownloadToken(token, time.Now())
+	_, err = verifyDownloadToken(secret, token, time.Now())
 	if err == nil {
 		t.Error("Expected error for expired token, got nil")
 	}
@@ -71,10 +71,10 @@ func TestDownloadHandler_ExpiredToken(t *testing.T) {
 }
 
 func TestDownloadHandler_InvalidToken(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "test-secret-for-download")
+	secret := "test-secret-for-download"
 
 	// Test with completely invalid token
-	_, err := verifyDownloadToken("invalid.token", time.Now())
+	_, err := verifyDownloadToken(secret, "invalid.token", time.Now())
 	if err == nil {
 		t.Error("Expected error for invalid token, got nil")
 	}
@@ -84,19 +84,19 @@ func TestDownloadHandler_InvalidToken(t *testing.T) {
 }
 
 func TestDownloadHandler_ValidTokenFlow(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "test-secret-for-download")
+	secret := "test-secret-for-download"
 
 	fileID := uuid.New().String()
 	expiry := time.Now().Add(1 * time.Hour)
 
 	// Sign token
-	token, err := signDownloadToken(fileID, expiry)
+	token, err := signDownloadToken(secret, fileID, expiry)
 	if err != nil {
 		t.Fatalf("Failed to sign token: %v", err)
 	}
 
 	// Verify token
-	claims, err := verifyDownloadToken(token, time.Now())
+	claims, err := verifyDownloadToken(secret, token, time.Now())
 	if err != nil {
 		t.Fatalf("Failed to verify token: %v", err)
 	}
@@ -216,7 +216,7 @@ func TestDownloadHandler_ContentDisposition(t *testing.T) {
 }
 
 func TestDownloadHandler_TokenVerificationErrors(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "test-secret")
+	secret := "test-secret"
 
 	tests := []struct {
 		name          string
@@ -242,7 +242,7 @@ func TestDownloadHandler_TokenVerificationErrors(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := verifyDownloadToken(tt.token, time.Now())
+			_, err := verifyDownloadToken(secret, tt.token, time.Now())
 			if err == nil {
 				t.Errorf("Expected error for %s, got nil", tt.name)
 				return
@@ -6,42 +6,40 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"os"
 	"time"
 )
 
 var (
-	errDownloadSecretMissing = errors.New("SFD_DOWNLOAD_SECRET missing")
+	errDownloadSecretMissing = errors.New("download secret not configured")
 	errBadToken              = errors.New("bad token")
 	errTokenExpired          = errors.New("token expired")
 )
 
+// downloadClaims is the signed payload of a download token. KeyID names the
+// download_keys entry that signed it (empty: the configured secret) and
+// LinkID the download_links row that can revoke it; tokens issued before
+// either existed carry neither.
 type downloadClaims struct {
 	FileID string `json:"file_id"`
 	Exp    int64  `json:"exp"` // unix seconds
-}
-
-// downloadSecret returns the raw secret bytes from env.
-func downloadSecret() ([]byte, error) {
-	sec := os.Getenv("SFD_DOWNLOAD_SECRET")
-	if sec == "" {
-		return nil, errDownloadSecretMissing
-	}
-	return []byte(sec), nil
+	KeyID  string `json:"kid,omitempty"`
+	LinkID string `json:"lid,omitempty"`
 }
 
 // signDownloadToken creates a compact token: base64url(payload).base64url(sig)
 // where sig = HMAC-SHA256(secret, payloadBytes).
-func signDownloadToken(fileID string, expiresAt time.Time) (string, error) {
-	sec, err := downloadSecret()
-	if err != nil {
-		return "", err
+func signDownloadToken(secret, fileID string, expiresAt time.Time) (string, error) {
+	return signDownloadClaims(secret, downloadClaims{FileID: fileID, Exp: expiresAt.Unix()})
+}
+
+// signDownloadClaims signs c with secret in the signDownloadToken format.
+func signDownloadClaims(secret string, c downloadClaims) (string, error) {
+	if secret == "" {
+		return "", errDownloadSecretMissing
 	}
+	sec := []byte(secret)
 
-	payload, err := json.Marshal(downloadClaims{
-		FileID: fileID,
-		Exp:    expiresAt.Unix(),
-	})
+	payload, err := json.Marshal(c)
 	if err != nil {
 		return "", err
 	}
@@ -55,13 +53,18 @@ func signDownloadToken(fileID string, expiresAt time.Time) (string, error) {
 }
 
 // verifyDownloadToken validates signature + expiry and returns claims.
-func verifyDownloadToken(token string, now time.Time) (downloadClaims, error) {
-	var c downloadClaims
-
-	sec, err := downloadSecret()
-	if err != nil {
-		return c, err
+func verifyDownloadToken(secret, token string, now time.Time) (downloadClaims, error) {
+	if secret == "" {
+		return downloadClaims{}, errDownloadSecretMissing
 	}
+	return verifyDownloadTokenWith(func(string) (string, error) { return secret, nil }, token, now)
+}
+
+// verifyDownloadTokenWith is verifyDownloadToken with the secret chosen by
+// the key id in the token; secretFor reports unknown or retired keys as
+// errBadToken.
+func verifyDownloadTokenWith(secretFor func(keyID string) (string, error), token string, now time.Time) (downloadClaims, error) {
+	var c downloadClaims
 
 	// token format: payload.sig
 	dot := -1
@@ -85,17 +88,28 @@ func verifyDownloadToken(token string, now time.Time) (downloadClaims, error) {
 		return c, errBadToken
 	}
 
-	mac := hmac.New(sha256.New, sec)
+	// The key id is read before the signature is checked, but only selects
+	// which secret the signature must match.
+	var unverified downloadClaims
+	if err := json.Unmarshal(payloadB, &unverified); err != nil {
+		return c, errBadToken
+	}
+	secret, err := secretFor(unverified.KeyID)
+	if err != nil {
+		return c, err
+	}
+	if secret == "" {
+		return c, errDownloadSecretMissing
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
 	_, _ = mac.Write(payloadB)
 	want := mac.Sum(nil)
 
 	if !hmac.Equal(sigB, want) {
 		return c, errBadToken
 	}
-
-	if err := json.Unmarshal(payloadB, &c); err != nil {
-		return c, errBadToken
-	}
+	c = unverified
 
 	if c.FileID == "" || c.Exp == 0 {
 		return c, errBadToken
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/base64"
 	"strings"
 	"testing"
@@ -8,16 +9,16 @@ import (
 )
 
 func TestSignAndVerifyDownloadToken(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "testsecret")
+	secret := "testsecret"
 
 	now := time.Now()
 	exp := now.Add(1 * time.Hour)
-	tok, err := signDownloadToken("file-123", exp)
+	tok, err := signDownloadToken(secret, "file-123", exp)
 	if err != nil {
 		t.Fatalf("signDownloadToken error: %v", err)
 	}
 
-	claims, err := verifyDownloadToken(tok, now)
+	claims, err := verifyDownloadToken(secret, tok, now)
 	if err != nil {
 		t.Fatalf("verifyDownloadToken error: %v", err)
 	}
@@ -30,16 +31,16 @@ func TestSignAndVerifyDownloadToken(t *testing.T) {
 }
 
 func TestVerifyExpiredDownloadToken(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "testsecret")
+	secret := "testsecret"
 
 	now := time.Now()
 	exp := now.Add(-1 * time.Hour)
-	tok, err := signDownloadToken("file-456", exp)
+	tok, err := signDownloadToken(secret, "file-456", exp)
 	if err != nil {
 		t.Fatalf("signDownloadToken error: %v", err)
 	}
 
-	_, err = verifyDownloadToken(tok, now)
+	_, err = verifyDownloadToken(secret, tok, now)
 	if err == nil {
 		t.Fatalf("expected error for expired token, got nil")
 	}
@@ -49,11 +50,11 @@ func TestVerifyExpiredDownloadToken(t *testing.T) {
 }
 
 func TestVerifyTamperedSignature(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "testsecret")
+	secret := "testsecret"
 
 	now := time.Now()
 	exp := now.Add(1 * time.Hour)
-	tok, err := signDownloadToken("file-789", exp)
+	tok, err := signDownloadToken(secret, "file-789", exp)
 	if err != nil {
 		t.Fatalf("signDownloadToken error: %v", err)
 	}
@@ -74,7 +75,7 @@ func TestVerifyTamperedSignature(t *testing.T) {
 	sigBad := base64.RawURLEncoding.EncodeToString(sig)
 	badTok := payload + "." + sigBad
 
-	_, err = verifyDownloadToken(badTok, now)
+	_, err = verifyDownloadToken(secret, badTok, now)
 	if err == nil {
 		t.Fatalf("expected error for tampered signature, got nil")
 	}
@@ -84,10 +85,10 @@ func TestVerifyTamperedSignature(t *testing.T) {
 }
 
 func TestDownloadSecretMissing(t *testing.T) {
-	// Ensure env is not set
-	t.Setenv("SFD_DOWNLOAD_SECRET", "")
+	// No secret configured
+	secret := ""
 
-	_, err := signDownloadToken("file-000", time.Now().Add(1*time.Hour))
+	_, err := signDownloadToken(secret, "file-000", time.Now().Add(1*time.Hour))
 	if err == nil {
 		t.Fatalf("expected error when secret missing for signDownloadToken, got nil")
 	}
@@ -96,7 +97,7 @@ func TestDownloadSecretMissing(t *testing.T) {
 	}
 
 	// verify should also fail early due to missing secret
-	_, err = verifyDownloadToken("invalid.token", time.Now())
+	_, err = verifyDownloadToken(secret, "invalid.token", time.Now())
 	if err == nil {
 		t.Fatalf("expected error when secret missing for verifyDownloadToken, got nil")
 	}
@@ -106,10 +107,10 @@ func TestDownloadSecretMissing(t *testing.T) {
 }
 
 func TestVerifyMalformedToken(t *testing.T) {
-	t.Setenv("SFD_DOWNLOAD_SECRET", "testsecret")
+	secret := "testsecret"
 
 	// missing dot
-	_, err := verifyDownloadToken("badtoken", time.Now())
+	_, err := verifyDownloadToken(secret, "badtoken", time.Now())
 	if err == nil {
 		t.Fatalf("expected error for malformed token, got nil")
 	}
@@ -118,7 +119,7 @@ func TestVerifyMalformedToken(t *testing.T) {
 	}
 
 	// invalid base64 payload
-	_, err = verifyDownloadToken("!!."+base64.RawURLEncoding.EncodeToString([]byte("sig")), time.Now())
+	_, err = verifyDownloadToken(secret, "!!."+base64.RawURLEncoding.EncodeToString([]byte("sig")), time.Now())
 	if err == nil {
 		t.Fatalf("expected error for invalid base64 payload, got nil")
 	}
@@ -126,3 +127,82 @@ func TestVerifyMalformedToken(t *testing.T) {
 		t.Fatalf("unexpected error for invalid base64 payload: got %v want %v", err, errBadToken)
 	}
 }
+
+func TestVerifyDownloadTokenWith_KeyID(t *testing.T) {
+	keys := map[string]string{"": "configured", "k2": "rotated"}
+	secretFor := func(id string) (string, error) {
+		s, ok := keys[id]
+		if !ok {
+			return "", errBadToken
+		}
+		return s, nil
+	}
+	now := time.Now()
+	exp := now.Add(time.Hour).Unix()
+
+	tok, err := signDownloadClaims("rotated", downloadClaims{FileID: "f", Exp: exp, KeyID: "k2", LinkID: "l1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := verifyDownloadTokenWith(secretFor, tok, now)
+	if err != nil || c.KeyID != "k2" || c.LinkID != "l1" {
+		t.Fatalf("rotated key: %+v %v", c, err)
+	}
+
+	// Tokens from before rotation carry no key id and use the configured secret.
+	legacy, _ := signDownloadToken("configured", "f", time.Unix(exp, 0))
+	if _, err := verifyDownloadTokenWith(secretFor, legacy, now); err != nil {
+		t.Fatalf("legacy token: %v", err)
+	}
+
+	// Claiming another key id does not help a token signed with the wrong secret.
+	forged, _ := signDownloadClaims("configured", downloadClaims{FileID: "f", Exp: exp, KeyID: "k2"})
+	if _, err := verifyDownloadTokenWith(secretFor, forged, now); err != errBadToken {
+		t.Fatalf("forged key id: %v", err)
+	}
+	unknown, _ := signDownloadClaims("x", downloadClaims{FileID: "f", Exp: exp, KeyID: "gone"})
+	if _, err := verifyDownloadTokenWith(secretFor, unknown, now); err != errBadToken {
+		t.Fatalf("unknown key id: %v", err)
+	}
+}
+
+func TestDownloadKeyRing_WithoutDB(t *testing.T) {
+	k := downloadKeyRing{fallback: "configured"}
+	id, secret, err := k.signingKey(context.Background())
+	if err != nil || id != configKeyID || secret != "configured" {
+		t.Fatalf("signingKey: %q %q %v", id, secret, err)
+	}
+	secretFor := k.secretFor(context.Background())
+	if s, err := secretFor(""); err != nil || s != "configured" {
+		t.Fatalf("configured key: %q %v", s, err)
+	}
+	if _, err := secretFor("k2"); err != errBadToken {
+		t.Fatalf("unknown key: %v", err)
+	}
+}
+
+func TestDownloadKey_Status(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	for _, tc := range []struct {
+		retired *time.Time
+		want    string
+	}{{nil, "active"}, {&future, "retiring"}, {&past, "retired"}} {
+		k := DownloadKey{RetiredAt: tc.retired}
+		k.setStatus(now)
+		if k.Status != tc.want {
+			t.Errorf("retired_at %v: got %q want %q", tc.retired, k.Status, tc.want)
+		}
+	}
+
+	l := LinkInfo{ExpiresAt: past}
+	l.setStatus(now)
+	if l.Status != "expired" {
+		t.Errorf("expired link: %q", l.Status)
+	}
+	l = LinkInfo{ExpiresAt: future, RevokedAt: &past}
+	l.setStatus(now)
+	if l.Status != "revoked" {
+		t.Errorf("revoked link: %q", l.Status)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mime"
+	"strings"
+)
+
+// filePerm is what a principal may do with a file. Levels are ordered: each
+// includes the ones below it.
+type filePerm int
+
+const (
+	permNone  filePerm = iota
+	permRead           // download through the authenticated endpoint
+	permView           // see metadata, create download links
+	permEdit           // upload content, delete
+	permOwner          // everything, including managing access
+)
+
+// Share permissions stored in file_shares.permission.
+const (
+	SharePermViewer = "viewer"
+	SharePermEditor = "editor"
+)
+
+var errFileNotFound = errors.New("file not found")
+
+// fileRecord is the subset of a files row needed for access decisions.
+type fileRecord struct {
+	ID          string
+	ObjectKey   string
+	Status      string
+	ContentType string
+	OrigName    string
+	SizeBytes   int64
+	CreatedBy   string
+	OrgID       string // empty for personally owned files
+}
+
+// orgRolePerm maps an organization membership role to a file permission.
+func orgRolePerm(role string) filePerm {
+	switch role {
+	case OrgRoleOwner:
+		return permOwner
+	case OrgRoleMember:
+		return permEdit
+	case OrgRoleViewer:
+		return permView
+	}
+	return permNone
+}
+
+// sharePerm maps a file_shares permission to a file permission. Viewers may
+// only download; they cannot turn an internal share into a public link.
+func sharePerm(perm string) filePerm {
+	switch perm {
+	case SharePermEditor:
+		return permEdit
+	case SharePermViewer:
+		return permRead
+	}
+	return permNone
+}
+
+// filePermFor computes p's permission on f, given p's role in the owning
+// organization (empty when not a member or the file is personal) and any
+// direct share. Admins may do anything; personal files belong to their
+// uploader. The strongest grant wins.
+func filePermFor(p Principal, f fileRecord, orgRole, share string) filePerm {
+	if p.Role == RoleAdmin {
+		return permOwner
+	}
+	perm := sharePerm(share)
+	if f.OrgID != "" {
+		return max(perm, orgRolePerm(orgRole))
+	}
+	if p.Subject != "" && f.CreatedBy == p.Subject {
+		return permOwner
+	}
+	return perm
+}
+
+// fileAccess loads file id and the caller's permission on it. It returns
+// errFileNotFound when the row does not exist.
+func fileAccess(ctx context.Context, db *sql.DB, p Principal, id string) (fileRecord, filePerm, error) {
+	var (
+		f              fileRecord
+		orgRole, share string
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT f.id, f.object_key, f.status, f.content_type, f.orig_name, f.size_bytes, f.created_by,
+		       COALESCE(f.org_id::text, ''), COALESCE(m.role, ''), COALESCE(s.permission, '')
+		FROM files f
+		LEFT JOIN org_members m ON m.org_id = f.org_id AND m.user_id::text = $2
+		LEFT JOIN file_shares s ON s.file_id = f.id AND s.user_id::text = $2
+		WHERE f.id = $1
+	`, id, p.Subject).Scan(&f.ID, &f.ObjectKey, &f.Status, &f.ContentType, &f.OrigName, &f.SizeBytes,
+		&f.CreatedBy, &f.OrgID, &orgRole, &share)
+	if err == sql.ErrNoRows {
+		return f, permNone, errFileNotFound
+	}
+	if err != nil {
+		return f, permNone, err
+	}
+	return f, filePermFor(p, f, orgRole, share), nil
+}
+
+// contentTypeAllowed reports whether contentType matches one of allowed,
+// where entries are exact media types or "type/*". Parameters such as
+// charset are ignored. An empty allowlist admits everything.
+func contentTypeAllowed(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mt {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mt, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,20 +1,26 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
 )
 
 // createFileReq represents the JSON payload for creating a new file record.
 // This is the first step in the upload flow - creating metadata before the actual upload.
+// OrgID, when set, places the file in that organization's space.
 type createFileReq struct {
 	OrigName    string `json:"orig_name"`
 	ContentType string `json:"content_type"`
 	SizeBytes   int64  `json:"size_bytes"`
+	OrgID       string `json:"org_id,omitempty"`
 }
 
 // createFileResp is the JSON response returned when a file record is successfully created.
@@ -29,11 +35,12 @@ type createFileResp struct {
 // This is step 1 of the upload flow: register file metadata in the database with status "pending".
 // The client must then upload the actual file data via POST /upload?id={uuid}.
 //
-// Request body: JSON with orig_name, content_type, size_bytes
+// Request body: JSON with orig_name, content_type, size_bytes and optional org_id
 // Response: JSON with id (UUID), object_key, status ("pending")
-// Authentication: Required (checked by requireAuth middleware)
+// Authentication: Required, scope files:write and a verified email (checked by middleware);
+// org files additionally need the member or owner role in the organization.
 func (cfg Config) createFileHandler(db *sql.DB) http.Handler {
-	return cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return cfg.Auth.requireScope(ScopeFilesWrite, cfg.Auth.requireVerified(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -61,14 +68,50 @@ func (cfg Config) createFileHandler(db *sql.DB) http.Handler {
 		// Uses "uploads/" prefix + UUID to avoid path traversal attacks.
 		objectKey := "uploads/" + id.String()
 
+		// Record who created the file: the session subject or the owner of the API token.
+		createdBy := PrincipalFromContext(r.Context()).Subject
+		if createdBy == "" {
+			createdBy = cfg.Auth.AdminUser
+		}
+
+		// Org files: the caller must be able to edit the org's space, and the
+		// declared type is checked against the org allowlist up front.
+		var orgID sql.NullString
+		if req.OrgID = strings.TrimSpace(req.OrgID); req.OrgID != "" {
+			if _, err := uuid.Parse(req.OrgID); err != nil {
+				http.Error(w, "bad org_id", http.StatusBadRequest)
+				return
+			}
+			role, err := orgRole(r.Context(), db, req.OrgID, createdBy)
+			if err != nil {
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if orgRolePerm(role) < permEdit {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			policy, err := loadOrgPolicy(r.Context(), db, req.OrgID)
+			if err != nil {
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if !contentTypeAllowed(policy.AllowedContentTypes, req.ContentType) {
+				http.Error(w, "content type not allowed", http.StatusUnsupportedMediaType)
+				return
+			}
+			orgID = sql.NullString{String: req.OrgID, Valid: true}
+		}
+
 		_, err := db.Exec(`
-			INSERT INTO files (id, object_key, orig_name, content_type, size_bytes, created_by, status)
-			VALUES ($1, $2, $3, $4, $5, $6, 'pending')
-		`, id, objectKey, req.OrigName, req.ContentType, req.SizeBytes, cfg.Auth.AdminUser)
+			INSERT INTO files (id, object_key, orig_name, content_type, size_bytes, created_by, status, org_id)
+			VALUES ($1, $2, $3, $4, $5, $6, 'pending', $7)
+		`, id, objectKey, req.OrigName, req.ContentType, req.SizeBytes, createdBy, orgID)
 		if err != nil {
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
+		cfg.Registry.RecordFileStateTransition("pending")
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -77,5 +120,96 @@ func (cfg Config) createFileHandler(db *sql.DB) http.Handler {
 			ObjectKey: objectKey,
 			Status:    "pending",
 		})
+	})))
+}
+
+// fileItemHandler routes the per-file endpoints below /files/:
+//
+//	GET    /files/shared                   files shared with the caller
+//	DELETE /files/{id}                     uploader, org member/owner, share editor or admin
+//	GET    /files/{id}/shares              list shares (file owner)
+//	POST   /files/{id}/shares              {"user": "<username or email>", "permission": "viewer|editor", "notify_email": true}
+//	DELETE /files/{id}/shares/{user_id}    revoke (file owner, or the recipient)
+//
+// Reads need any authentication; changes need scope files:write.
+func (cfg Config) fileItemHandler(db *sql.DB, mc *minio.Client, bucket string) http.Handler {
+	return cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/files/"), "/"), "/")
+		if len(parts) == 1 && parts[0] == "shared" {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			listSharedWithMe(w, r, db)
+			return
+		}
+		if _, err := uuid.Parse(parts[0]); err != nil || len(parts) > 3 || (len(parts) > 1 && parts[1] != "shares") {
+			http.NotFound(w, r)
+			return
+		}
+		id := parts[0]
+		addLogAttrs(r.Context(), slog.String(logKeyFileID, id))
+
+		if r.Method != http.MethodGet && !PrincipalFromContext(r.Context()).HasScope(ScopeFilesWrite) {
+			http.Error(w, "insufficient scope", http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			deleteFile(w, r, db, mc, bucket, id, cfg.Registry)
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			listShares(w, r, db, id)
+		case len(parts) == 2 && r.Method == http.MethodPost:
+			cfg.createShare(w, r, db, id)
+		case len(parts) == 3 && r.Method == http.MethodDelete:
+			revokeShare(w, r, db, id, parts[2])
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
 	}))
 }
+
+// deleteFile removes a file record and its object. The uploader of a personal
+// file, org members and owners of an org file, share editors, and admins may
+// delete.
+func deleteFile(w http.ResponseWriter, r *http.Request, db *sql.DB, mc *minio.Client, bucket, id string, m *Metrics) {
+	p := PrincipalFromContext(r.Context())
+	f, perm, err := fileAccess(r.Context(), db, p, id)
+	if err != nil {
+		if err == errFileNotFound {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if perm == permNone {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if perm < permEdit {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if f.Status != "pending" && mc != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if err := mc.RemoveObject(ctx, bucket, f.ObjectKey, minio.RemoveObjectOptions{}); err != nil {
+			logFor(r.Context(), "files").Error("minio_removal_failed", errAttr(err))
+		}
+	}
+	if _, err := db.ExecContext(r.Context(), `DELETE FROM files WHERE id = $1`, f.ID); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	m.RecordFileStateTransition("deleted")
+	recordAudit(r.Context(), "file_deleted", map[string]string{
+		"actor":   p.Subject,
+		"file_id": f.ID,
+		"org_id":  f.OrgID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -10,9 +10,11 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // hashToolOutput represents the JSON output from the native C hash utility (sfd-hash).
@@ -24,9 +26,23 @@ type hashToolOutput struct {
 	Bytes     uint64 `json:"bytes"`
 }
 
+// hashTool holds the sfd-hash path (hash.tool); hashing runs in background
+// goroutines without a Config, so it is process-wide like the password
+// parameters.
+var hashTool atomic.Value // string
+
+// SetHashTool sets the path of the sfd-hash binary.
+func SetHashTool(path string) { hashTool.Store(path) }
+
+// hashToolPath returns the configured sfd-hash binary, or "" when unset.
+func hashToolPath() string {
+	p, _ := hashTool.Load().(string)
+	return p
+}
+
 // runHashTool executes the native C hash utility (sfd-hash) to calculate the SHA-256
-// hash of a local file. The tool path is read from SFD_HASH_TOOL env var, defaulting
-// to "/app/sfd-hash". The function validates the JSON output and ensures the hash is
+// hash of a local file. The tool path is set with SetHashTool (hash.tool,
+// SFD_HASH_TOOL). The function validates the JSON output and ensures the hash is
 // valid hex-encoded SHA-256 (64 characters).
 //
 // Returns hashToolOutput with algorithm ("sha256"), hash (hex string), and byte count.
@@ -36,15 +52,19 @@ func runHashTool(ctx context.Context, filePath string) (hashToolOutput, error) {
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancel()
 
-	toolPath := os.Getenv("SFD_HASH_TOOL")
+	toolPath := hashToolPath()
 	if toolPath == "" {
-		toolPath = "/app/sfd-hash"
+		return hashToolOutput{}, errors.New("hash tool not configured")
 	}
+	ctx, span := startSpan(ctx, "hash.exec", attribute.String("sfd.hash_tool", toolPath))
 	cmd := exec.CommandContext(ctx, toolPath, filePath)
 	out, err := cmd.Output()
 	if err != nil {
-		return hashToolOutput{}, fmt.Errorf("hash tool failed: %w", err)
+		err = fmt.Errorf("hash tool failed: %w", err)
+		endSpan(span, err)
+		return hashToolOutput{}, err
 	}
+	endSpan(span, nil)
 
 	var parsed hashToolOutput
 	if err := json.Unmarshal(out, &parsed); err != nil {
@@ -69,6 +89,32 @@ func runHashTool(ctx context.Context, filePath string) (hashToolOutput, error) {
 	return parsed, nil
 }
 
+// downloadToTemp copies the object into tmp and closes it, inside a
+// "hash.download_temp" span carrying the number of bytes written.
+func downloadToTemp(ctx context.Context, mc *minio.Client, bucket, objectKey string, tmp *os.File) (err error) {
+	ctx, span := startSpan(ctx, "hash.download_temp")
+	defer func() { endSpan(span, err) }()
+
+	obj, err := mc.GetObject(ctx, bucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("get object: %w", err)
+	}
+	defer func() { _ = obj.Close() }()
+
+	n, err := io.Copy(tmp, obj)
+	span.SetAttributes(attribute.Int64("sfd.bytes", n))
+	if err != nil {
+		return fmt.Errorf("copy object to temp: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("sync temp: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp: %w", err)
+	}
+	return nil
+}
+
 // sha256FromMinioObject downloads a file from MinIO to a temporary local file,
 // runs the C hash utility on it, and returns the SHA-256 hash in both hex string
 // and raw byte formats, plus the file size. This is used during the upload flow
@@ -77,6 +123,9 @@ func runHashTool(ctx context.Context, filePath string) (hashToolOutput, error) {
 // The temporary file is automatically cleaned up after hashing.
 // Returns error if MinIO stream fails or hash calculation fails.
 func sha256FromMinioObject(ctx context.Context, mc *minio.Client, bucket, objectKey string) (sha256Hex string, sha256Bytes []byte, size uint64, err error) {
+	ctx, span := startSpan(ctx, "hash.sha256_object", attribute.String("sfd.object_key", objectKey))
+	defer func() { endSpan(span, err) }()
+
 	// Validate required parameters
 	if mc == nil {
 		return "", nil, 0, errors.New("minio client is nil")
@@ -96,20 +145,8 @@ func sha256FromMinioObject(ctx context.Context, mc *minio.Client, bucket, object
 		_ = os.Remove(tmpPath)
 	}()
 
-	obj, err := mc.GetObject(ctx, bucket, objectKey, minio.GetObjectOptions{})
-	if err != nil {
-		return "", nil, 0, fmt.Errorf("get object: %w", err)
-	}
-	defer func() { _ = obj.Close() }()
-
-	if _, err := io.Copy(tmp, obj); err != nil {
-		return "", nil, 0, fmt.Errorf("copy object to temp: %w", err)
-	}
-	if err := tmp.Sync(); err != nil {
-		return "", nil, 0, fmt.Errorf("sync temp: %w", err)
-	}
-	if err := tmp.Close(); err != nil {
-		return "", nil, 0, fmt.Errorf("close temp: %w", err)
+	if err := downloadToTemp(ctx, mc, bucket, objectKey, tmp); err != nil {
+		return "", nil, 0, err
 	}
 
 	out, err := runHashTool(ctx, tmpPath)
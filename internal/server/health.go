@@ -0,0 +1,373 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	sfddb "secure-file-drop/internal/db"
+)
+
+// Health check and report statuses.
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusFail     = "fail"
+	HealthStatusDraining = "draining"
+)
+
+// defaultCheckTimeout bounds a check that does not set its own Timeout.
+const defaultCheckTimeout = 2 * time.Second
+
+// HealthConfig controls readiness caching and shutdown draining. The zero
+// value disables all of them.
+type HealthConfig struct {
+	// CacheTTL is how long /ready reuses a check result, so frequent
+	// probes from several load balancers do not hammer the dependencies.
+	CacheTTL time.Duration
+
+	// DrainDelay is how long Shutdown keeps serving with /ready reporting
+	// "draining" before closing listeners, giving load balancers time to
+	// take the instance out of rotation.
+	DrainDelay time.Duration
+
+	// UploadDrain is how long Shutdown waits, after the drain delay, for
+	// uploads in flight before cancelling them; 0 cancels them at once.
+	UploadDrain time.Duration
+}
+
+// HealthCheck is one dependency probed by /ready and /health/deep.
+type HealthCheck struct {
+	Name string
+
+	// Critical checks decide readiness; the others are only reported by
+	// /health/deep.
+	Critical bool
+
+	// Timeout bounds one run; defaults to defaultCheckTimeout.
+	Timeout time.Duration
+
+	// Run returns nil when the dependency is usable. detail is optional
+	// extra information shown in the report (e.g. a schema version).
+	Run func(ctx context.Context) (detail map[string]any, err error)
+}
+
+// CheckResult is the outcome of one HealthCheck run.
+type CheckResult struct {
+	Name      string         `json:"name"`
+	Status    string         `json:"status"`
+	Critical  bool           `json:"critical"`
+	LatencyMs float64        `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Detail    map[string]any `json:"detail,omitempty"`
+	CheckedAt time.Time      `json:"checked_at"`
+}
+
+// HealthReport is the JSON body of /ready and /health/deep. /ready, being
+// public, leaves out Checks. Maintenance is set while read-only maintenance
+// mode is on; it does not change Status, since downloads keep being served.
+type HealthReport struct {
+	Status      string        `json:"status"`
+	Checks      []CheckResult `json:"checks,omitempty"`
+	Maintenance *Maintenance  `json:"maintenance,omitempty"`
+}
+
+// HealthRegistry runs the registered checks and caches their results.
+type HealthRegistry struct {
+	cacheTTL time.Duration
+	draining atomic.Bool
+
+	// mu serialises runs, so concurrent probes after the cache expires
+	// trigger a single round of checks; it also guards checks and cache.
+	mu     sync.Mutex
+	checks []HealthCheck
+	cache  map[string]CheckResult
+
+	// maintenance, when set, reports read-only mode in /ready.
+	maintenance *maintenanceMode
+}
+
+// NewHealthRegistry returns an empty registry reusing results for cacheTTL.
+func NewHealthRegistry(cacheTTL time.Duration) *HealthRegistry {
+	return &HealthRegistry{cacheTTL: cacheTTL, cache: map[string]CheckResult{}}
+}
+
+// Register adds c to the registry.
+func (h *HealthRegistry) Register(c HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, c)
+}
+
+// SetDraining makes /ready fail from now on; Shutdown calls it first.
+func (h *HealthRegistry) SetDraining() { h.draining.Store(true) }
+
+// Draining reports whether SetDraining has been called.
+func (h *HealthRegistry) Draining() bool { return h.draining.Load() }
+
+// Run executes the checks concurrently and returns the report. Only
+// critical checks run unless all is set; cached results younger than the
+// TTL are reused unless fresh is set. The report status is "fail" if any
+// critical check failed and "draining" once shutdown has begun.
+//
+// Checks do not inherit ctx's cancellation: results are shared through the
+// cache, so a probe that hangs up early must not leave "context canceled"
+// failures behind for every other caller.
+func (h *HealthRegistry) Run(ctx context.Context, all, fresh bool) HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ctx = context.WithoutCancel(ctx)
+
+	var selected []HealthCheck
+	for _, c := range h.checks {
+		if all || c.Critical {
+			selected = append(selected, c)
+		}
+	}
+
+	now := time.Now()
+	results := make([]CheckResult, len(selected))
+	var wg sync.WaitGroup
+	for i, c := range selected {
+		if r, ok := h.cache[c.Name]; ok && !fresh && now.Sub(r.CheckedAt) < h.cacheTTL {
+			results[i] = r
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	rep := HealthReport{Status: HealthStatusOK, Checks: results}
+	for _, r := range results {
+		h.cache[r.Name] = r
+		if r.Critical && r.Status != HealthStatusOK {
+			rep.Status = HealthStatusFail
+		}
+	}
+	if h.Draining() {
+		rep.Status = HealthStatusDraining
+	}
+	return rep
+}
+
+func runHealthCheck(ctx context.Context, c HealthCheck) CheckResult {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := c.Run(ctx)
+	res := CheckResult{
+		Name:      c.Name,
+		Status:    HealthStatusOK,
+		Critical:  c.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+		CheckedAt: start,
+	}
+	if err != nil {
+		res.Status = HealthStatusFail
+		res.Error = err.Error()
+		logFor(ctx, "health").Warn("check_failed", slog.String("check", c.Name), errAttr(err))
+	}
+	return res
+}
+
+// writeHealthReport writes rep with 200 when ok and 503 otherwise.
+func writeHealthReport(w http.ResponseWriter, rep HealthReport) {
+	code := http.StatusOK
+	if rep.Status != HealthStatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(rep)
+}
+
+// readyHandler serves /ready: the status of the cached critical checks, or
+// an immediate 503 once the server is draining. It is unauthenticated, so
+// the per-check results (errors, paths, versions) are left to /health/deep.
+func (h *HealthRegistry) readyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Draining() {
+			writeHealthReport(w, HealthReport{Status: HealthStatusDraining})
+			return
+		}
+		report := HealthReport{Status: h.Run(r.Context(), false, false).Status}
+		if m := h.maintenance.get(r.Context()); m.Enabled {
+			report.Maintenance = &m
+		}
+		writeHealthReport(w, report)
+	})
+}
+
+// deepHandler serves /health/deep: every check, run now.
+func (h *HealthRegistry) deepHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeHealthReport(w, h.Run(r.Context(), true, true))
+	})
+}
+
+// registerDefaultChecks adds the server's dependency checks to h.
+func registerDefaultChecks(h *HealthRegistry, db *sql.DB, mc *minio.Client, bucket, downloadSecret string) {
+	h.Register(HealthCheck{Name: "postgres", Critical: true, Run: postgresCheck(db)})
+	h.Register(HealthCheck{Name: "migrations", Critical: true, Run: migrationsCheck(db)})
+	h.Register(HealthCheck{Name: "minio", Critical: true, Run: minioCheck(mc, bucket)})
+	h.Register(HealthCheck{Name: "hash_tool", Critical: true, Timeout: 5 * time.Second, Run: hashToolCheck})
+	h.Register(HealthCheck{Name: "temp_dir", Critical: true, Run: tempDirCheck})
+	h.Register(HealthCheck{Name: "download_secret", Critical: true, Run: downloadSecretCheck(downloadSecret)})
+	h.Register(HealthCheck{Name: "db_pool", Run: dbPoolCheck(db)})
+}
+
+var errDBNotConfigured = errors.New("db not configured")
+
+func postgresCheck(db *sql.DB) func(context.Context) (map[string]any, error) {
+	return func(ctx context.Context) (map[string]any, error) {
+		if db == nil {
+			return nil, errDBNotConfigured
+		}
+		return nil, db.PingContext(ctx)
+	}
+}
+
+// migrationsCheck fails unless the schema is at the latest version embedded
+// in this binary and not left dirty by a failed migration.
+func migrationsCheck(db *sql.DB) func(context.Context) (map[string]any, error) {
+	return func(ctx context.Context) (map[string]any, error) {
+		if db == nil {
+			return nil, errDBNotConfigured
+		}
+		want, err := sfddb.LatestVersion()
+		if err != nil {
+			return nil, err
+		}
+		got, dirty, err := sfddb.CurrentVersion(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		detail := map[string]any{"version": got, "expected": want, "dirty": dirty}
+		switch {
+		case dirty:
+			return detail, fmt.Errorf("migration %d is dirty", got)
+		case got != want:
+			return detail, fmt.Errorf("schema at version %d, expected %d", got, want)
+		}
+		return detail, nil
+	}
+}
+
+func minioCheck(mc *minio.Client, bucket string) func(context.Context) (map[string]any, error) {
+	return func(ctx context.Context) (map[string]any, error) {
+		detail := map[string]any{"bucket": bucket}
+		exists, err := mc.BucketExists(ctx, bucket)
+		if err != nil {
+			return detail, err
+		}
+		if !exists {
+			return detail, fmt.Errorf("bucket %q does not exist", bucket)
+		}
+		return detail, nil
+	}
+}
+
+// hashToolCheck runs sfd-hash on a small temp file and compares its output
+// with Go's own sha256, proving the tool exists, executes and is correct.
+func hashToolCheck(ctx context.Context) (map[string]any, error) {
+	path := hashToolPath()
+	detail := map[string]any{"path": path}
+	if _, err := os.Stat(path); err != nil {
+		return detail, err
+	}
+
+	sample := []byte("sfd-health-check\n")
+	tmp, err := os.CreateTemp("", "sfd-health-*")
+	if err != nil {
+		return detail, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(sample); err != nil {
+		_ = tmp.Close()
+		return detail, err
+	}
+	if err := tmp.Close(); err != nil {
+		return detail, err
+	}
+
+	out, err := runHashTool(ctx, tmp.Name())
+	if err != nil {
+		return detail, err
+	}
+	sum := sha256.Sum256(sample)
+	if out.Hash != hex.EncodeToString(sum[:]) {
+		return detail, errors.New("hash tool returned a wrong digest")
+	}
+	return detail, nil
+}
+
+// tempDirCheck creates, writes and removes a file in the temp directory
+// used for hashing.
+func tempDirCheck(context.Context) (map[string]any, error) {
+	detail := map[string]any{"dir": os.TempDir()}
+	f, err := os.CreateTemp("", "sfd-health-*")
+	if err != nil {
+		return detail, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write([]byte("ok")); err != nil {
+		_ = f.Close()
+		return detail, err
+	}
+	return detail, f.Close()
+}
+
+// downloadSecretCheck fails when the download secret is unset, which would
+// otherwise only surface when the first link is created.
+func downloadSecretCheck(secret string) func(context.Context) (map[string]any, error) {
+	return func(context.Context) (map[string]any, error) {
+		if secret == "" {
+			return nil, errDownloadSecretMissing
+		}
+		return nil, nil
+	}
+}
+
+// dbPoolCheck reports connection pool statistics for /health/deep.
+func dbPoolCheck(db *sql.DB) func(context.Context) (map[string]any, error) {
+	return func(context.Context) (map[string]any, error) {
+		if db == nil {
+			return nil, errDBNotConfigured
+		}
+		st := db.Stats()
+		return map[string]any{
+			"open":          st.OpenConnections,
+			"in_use":        st.InUse,
+			"idle":          st.Idle,
+			"max_open":      st.MaxOpenConnections,
+			"wait_count":    st.WaitCount,
+			"wait_duration": st.WaitDuration.String(),
+		}, nil
+	}
+}
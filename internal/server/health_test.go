@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCheck returns a check that counts its runs and fails with err.
+func countingCheck(name string, critical bool, runs *atomic.Int32, err error) HealthCheck {
+	return HealthCheck{Name: name, Critical: critical, Run: func(context.Context) (map[string]any, error) {
+		runs.Add(1)
+		return map[string]any{"n": runs.Load()}, err
+	}}
+}
+
+// setHashTool points the process-wide hash tool at path for one test.
+func setHashTool(t *testing.T, path string) {
+	t.Helper()
+	prev := hashToolPath()
+	t.Cleanup(func() { SetHashTool(prev) })
+	SetHashTool(path)
+}
+
+func decodeHealthReport(t *testing.T, rr *httptest.ResponseRecorder) HealthReport {
+	t.Helper()
+	var rep HealthReport
+	if err := json.NewDecoder(rr.Body).Decode(&rep); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return rep
+}
+
+func TestHealthRegistry_CachesResults(t *testing.T) {
+	var runs atomic.Int32
+	h := NewHealthRegistry(time.Hour)
+	h.Register(countingCheck("db", true, &runs, nil))
+
+	for i := 0; i < 3; i++ {
+		rep := h.Run(context.Background(), false, false)
+		if rep.Status != HealthStatusOK || len(rep.Checks) != 1 {
+			t.Fatalf("report: %+v", rep)
+		}
+	}
+	if runs.Load() != 1 {
+		t.Fatalf("cached check ran %d times", runs.Load())
+	}
+
+	rep := h.Run(context.Background(), false, true)
+	if runs.Load() != 2 || rep.Checks[0].Detail["n"] != int32(2) {
+		t.Fatalf("fresh run should bypass the cache: runs=%d %+v", runs.Load(), rep.Checks[0])
+	}
+}
+
+func TestHealthRegistry_CriticalDecidesStatus(t *testing.T) {
+	var a, b atomic.Int32
+	h := NewHealthRegistry(0)
+	h.Register(countingCheck("ok", true, &a, nil))
+	h.Register(countingCheck("extra", false, &b, errors.New("boom")))
+
+	rep := h.Run(context.Background(), false, false)
+	if rep.Status != HealthStatusOK || len(rep.Checks) != 1 || b.Load() != 0 {
+		t.Fatalf("non-critical check must not run for /ready: %+v", rep)
+	}
+
+	rep = h.Run(context.Background(), true, true)
+	if rep.Status != HealthStatusOK || len(rep.Checks) != 2 {
+		t.Fatalf("non-critical failure must not fail the report: %+v", rep)
+	}
+	if c := rep.Checks[1]; c.Status != HealthStatusFail || c.Error != "boom" || c.Critical {
+		t.Fatalf("extra check: %+v", c)
+	}
+}
+
+func TestHealthRegistry_Timeout(t *testing.T) {
+	h := NewHealthRegistry(0)
+	h.Register(HealthCheck{Name: "slow", Critical: true, Timeout: 20 * time.Millisecond,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}})
+	rep := h.Run(context.Background(), false, false)
+	if rep.Status != HealthStatusFail || rep.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("report: %+v", rep)
+	}
+}
+
+func TestHealthRegistry_IgnoresCallerCancellation(t *testing.T) {
+	var runs atomic.Int32
+	h := NewHealthRegistry(time.Hour)
+	h.Register(countingCheck("db", true, &runs, nil))
+
+	// A probe that hung up must not cache a failure for everyone else.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.Register(HealthCheck{Name: "ctx", Critical: true, Run: func(ctx context.Context) (map[string]any, error) {
+		return nil, ctx.Err()
+	}})
+	if rep := h.Run(ctx, false, false); rep.Status != HealthStatusOK {
+		t.Fatalf("cancelled caller: %+v", rep)
+	}
+	if rep := h.Run(context.Background(), false, false); rep.Status != HealthStatusOK {
+		t.Fatalf("next caller: %+v", rep)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	var runs atomic.Int32
+	h := NewHealthRegistry(0)
+	h.Register(countingCheck("minio", true, &runs, errors.New("bucket missing")))
+
+	rr := httptest.NewRecorder()
+	h.readyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("failing check: expected 503, got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "bucket missing") {
+		t.Fatalf("public /ready leaked check details: %s", rr.Body.String())
+	}
+	rep := decodeHealthReport(t, rr)
+	if rep.Status != HealthStatusFail || len(rep.Checks) != 0 {
+		t.Fatalf("report: %+v", rep)
+	}
+
+	h = NewHealthRegistry(0)
+	h.Register(countingCheck("db", true, &runs, nil))
+	rr = httptest.NewRecorder()
+	h.readyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("healthy: %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	before := runs.Load()
+	h.SetDraining()
+	rr = httptest.NewRecorder()
+	h.readyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable || decodeHealthReport(t, rr).Status != HealthStatusDraining {
+		t.Fatalf("draining: expected 503 draining, got %d", rr.Code)
+	}
+	if runs.Load() != before {
+		t.Error("draining /ready should not run checks")
+	}
+}
+
+func TestDeepHandler_RequiresAdmin(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}}
+	var runs atomic.Int32
+	h := NewHealthRegistry(time.Hour)
+	h.Register(countingCheck("db", true, &runs, nil))
+	deep := cfg.Auth.requireScope(ScopeAdminRead, h.deepHandler())
+
+	rr := httptest.NewRecorder()
+	deep.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health/deep", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: expected 401, got %d", rr.Code)
+	}
+
+	tok, _, err := cfg.Auth.makeToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/health/deep", nil)
+		req.AddCookie(&http.Cookie{Name: cfg.Auth.cookieName(), Value: tok})
+		rr = httptest.NewRecorder()
+		deep.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("admin: expected 200, got %d", rr.Code)
+		}
+	}
+	if runs.Load() != 2 {
+		t.Errorf("deep checks should not be cached, ran %d times", runs.Load())
+	}
+}
+
+func TestDefaultChecks(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := downloadSecretCheck("")(ctx); err == nil {
+		t.Error("missing download secret should fail")
+	}
+	if _, err := downloadSecretCheck("s3cret")(ctx); err != nil {
+		t.Errorf("download secret: %v", err)
+	}
+
+	t.Setenv("TMPDIR", t.TempDir())
+	if _, err := tempDirCheck(ctx); err != nil {
+		t.Errorf("temp dir: %v", err)
+	}
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	if _, err := tempDirCheck(ctx); err == nil {
+		t.Error("unwritable temp dir should fail")
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+
+	setHashTool(t, filepath.Join(t.TempDir(), "missing-sfd-hash"))
+	if _, err := hashToolCheck(ctx); err == nil {
+		t.Error("missing hash tool should fail")
+	}
+
+	// A tool that runs but reports the wrong digest.
+	tool := filepath.Join(t.TempDir(), "sfd-hash")
+	script := "#!/bin/sh\necho '{\"algorithm\":\"sha256\",\"hash\":\"" +
+		"0000000000000000000000000000000000000000000000000000000000000000\",\"bytes\":17}'\n"
+	if err := os.WriteFile(tool, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	setHashTool(t, tool)
+	if _, err := hashToolCheck(ctx); err == nil {
+		t.Error("wrong digest should fail")
+	}
+
+	if _, err := postgresCheck(nil)(ctx); err != errDBNotConfigured {
+		t.Errorf("nil db: %v", err)
+	}
+}
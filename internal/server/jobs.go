@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cleanupLockKey is the session advisory lock held by the instance that
+// runs the cleanup job; the other replicas skip their ticks.
+const cleanupLockKey int64 = 0x534644434c45414e // "SFDCLEAN"
+
+// leaderRetry is how often a follower tries to take over leadership, and
+// how often the leader checks that its lock connection is still alive.
+const leaderRetry = 15 * time.Second
+
+// instanceID names this process in job_runs: host and pid.
+var instanceID = func() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return host + ":" + strconv.Itoa(os.Getpid())
+}()
+
+// leaderElector holds a Postgres session advisory lock for a singleton job.
+// The lock lives as long as the dedicated connection holding it, so an
+// instance that dies or loses its connection gives up leadership and
+// another replica takes over on its next attempt.
+type leaderElector struct {
+	db    *sql.DB
+	name  string
+	key   int64
+	retry time.Duration
+
+	ready  chan struct{} // closed after the first attempt
+	once   sync.Once
+	leader atomic.Bool
+
+	conn *sql.Conn // holds the lock while leader; owned by run
+}
+
+func newLeaderElector(db *sql.DB, name string, key int64) *leaderElector {
+	return &leaderElector{db: db, name: name, key: key, retry: leaderRetry, ready: make(chan struct{})}
+}
+
+// IsLeader reports whether this instance currently holds the lock. A nil
+// elector (no database) always leads.
+func (e *leaderElector) IsLeader() bool {
+	return e == nil || e.leader.Load()
+}
+
+// Ready is closed once the first election attempt has finished.
+func (e *leaderElector) Ready() <-chan struct{} {
+	if e == nil {
+		c := make(chan struct{})
+		close(c)
+		return c
+	}
+	return e.ready
+}
+
+// run campaigns until ctx is done, then releases the lock.
+func (e *leaderElector) run(ctx context.Context) {
+	defer e.release()
+	t := time.NewTicker(e.retry)
+	defer t.Stop()
+	for {
+		e.step(ctx)
+		e.once.Do(func() { close(e.ready) })
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// step tries to become leader, or checks that the lock connection of the
+// current leader is still alive.
+func (e *leaderElector) step(ctx context.Context) {
+	logger := logFor(ctx, "jobs").With(slog.String("job", e.name))
+	if e.conn != nil {
+		err := e.conn.PingContext(ctx)
+		if err == nil {
+			return
+		}
+		if ctx.Err() == nil {
+			logger.Warn("leadership_lost", errAttr(err))
+		}
+		e.leader.Store(false)
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("leader_election_failed", errAttr(err))
+		}
+		return
+	}
+	var ok bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, e.key).Scan(&ok); err != nil || !ok {
+		if err != nil && ctx.Err() == nil {
+			logger.Error("leader_election_failed", errAttr(err))
+		}
+		_ = conn.Close()
+		return
+	}
+	e.conn = conn
+	e.leader.Store(true)
+	logger.Info("leadership_acquired", slog.String("instance", instanceID))
+}
+
+func (e *leaderElector) release() {
+	if e.conn == nil {
+		return
+	}
+	e.leader.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = e.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, e.key)
+	_ = e.conn.Close()
+	e.conn = nil
+}
+
+// JobRun is one row of job_runs.
+type JobRun struct {
+	ID         string     `json:"id"`
+	Job        string     `json:"job"`
+	Trigger    string     `json:"trigger"`
+	Instance   string     `json:"instance"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// startJobRun records the start of a run. The run is not recorded (nil)
+// when the insert fails, so a job history problem never stops the job.
+func startJobRun(ctx context.Context, db *sql.DB, job, trigger string) *JobRun {
+	if db == nil {
+		return nil
+	}
+	run := &JobRun{ID: uuid.NewString(), Job: job, Trigger: trigger, Instance: instanceID, Status: "running"}
+	if err := db.QueryRowContext(ctx, `
+		INSERT INTO job_runs (id, job, trigger, instance) VALUES ($1, $2, $3, $4)
+		RETURNING started_at`,
+		run.ID, job, trigger, instanceID,
+	).Scan(&run.StartedAt); err != nil {
+		logFor(ctx, "jobs").Error("job_run_insert_failed", slog.String("job", job), errAttr(err))
+		return nil
+	}
+	return run
+}
+
+// finish records the outcome of a run; err marks it failed.
+func (run *JobRun) finish(ctx context.Context, db *sql.DB, processed, failed int, err error) {
+	if run == nil {
+		return
+	}
+	run.Status, run.Processed, run.Failed = "succeeded", processed, failed
+	if err != nil {
+		run.Status, run.Error = "failed", err.Error()
+	}
+	// The run may end because ctx was cancelled; record it regardless.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if _, uerr := db.ExecContext(ctx, `
+		UPDATE job_runs
+		SET status = $2, finished_at = now(), processed = $3, failed = $4, error = NULLIF($5, '')
+		WHERE id = $1`,
+		run.ID, run.Status, processed, failed, run.Error,
+	); uerr != nil {
+		logFor(ctx, "jobs").Error("job_run_update_failed", slog.String("job", run.Job), errAttr(uerr))
+	}
+}
+
+// jobRunRetention is how long job_runs rows are kept.
+const jobRunRetention = 30 * 24 * time.Hour
+
+// pruneJobRuns deletes job runs that started more than jobRunRetention ago;
+// the cleanup job calls it after each pass.
+func pruneJobRuns(ctx context.Context, db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.ExecContext(ctx,
+		`DELETE FROM job_runs WHERE started_at < now() - make_interval(secs => $1)`,
+		jobRunRetention.Seconds())
+	return err
+}
+
+// JobRunFilter selects job runs for ListJobRuns.
+type JobRunFilter struct {
+	Job    string
+	Limit  int
+	Offset int
+}
+
+// ListJobRuns returns job runs, newest first.
+func ListJobRuns(ctx context.Context, db *sql.DB, f JobRunFilter) ([]JobRun, error) {
+	if f.Limit <= 0 {
+		f.Limit = defaultPageLimit
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, job, trigger, instance, status, started_at, finished_at, processed, failed, COALESCE(error, '')
+		FROM job_runs
+		WHERE $1 = '' OR job = $1
+		ORDER BY started_at DESC
+		LIMIT $2 OFFSET $3`,
+		f.Job, f.Limit, f.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []JobRun{}
+	for rows.Next() {
+		var r JobRun
+		if err := rows.Scan(&r.ID, &r.Job, &r.Trigger, &r.Instance, &r.Status, &r.StartedAt,
+			&r.FinishedAt, &r.Processed, &r.Failed, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// JobsStatus is the response of GET /admin/jobs.
+type JobsStatus struct {
+	Instance string   `json:"instance"`
+	Leading  []string `json:"leading"` // singleton jobs this instance runs
+	Runs     []JobRun `json:"runs"`
+}
+
+// AdminJobsHandler handles GET /admin/jobs?job=&limit=&offset=: recent job
+// runs across all instances, and the singleton jobs this instance leads.
+func (s *Server) AdminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	limit, offset := parsePagination(q)
+	runs, err := ListJobRuns(r.Context(), s.db, JobRunFilter{Job: q.Get("job"), Limit: limit, Offset: offset})
+	if err != nil {
+		logFor(r.Context(), "admin").Error("job_runs_query_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	status := JobsStatus{Instance: instanceID, Leading: []string{}, Runs: runs}
+	for name, e := range s.leaders {
+		if e.IsLeader() {
+			status.Leading = append(status.Leading, name)
+		}
+	}
+	sort.Strings(status.Leading)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logFor(r.Context(), "admin").Error("job_runs_encode_failed", errAttr(err))
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLeaderElector_Nil(t *testing.T) {
+	var e *leaderElector
+	if !e.IsLeader() {
+		t.Error("nil elector does not lead")
+	}
+	select {
+	case <-e.Ready():
+	default:
+		t.Error("nil elector not ready")
+	}
+}
+
+func TestRunCleanup_SkipsWhenNotLeader(t *testing.T) {
+	// No database: a follower must not touch it.
+	runCleanup(context.Background(), CleanupConfig{leader: &leaderElector{ready: make(chan struct{})}})
+}
+
+func TestRunCleanupJob_WaitsForElection(t *testing.T) {
+	runs := make(chan CleanupConfig, 4)
+	prev := cleanupRun
+	cleanupRun = func(_ context.Context, c CleanupConfig) { runs <- c }
+	t.Cleanup(func() { cleanupRun = prev })
+
+	e := &leaderElector{ready: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runCleanupJob(ctx, CleanupConfig{Enabled: true, Interval: time.Hour, leader: e}, nil)
+
+	select {
+	case <-runs:
+		t.Fatal("ran before the first election attempt")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(e.ready)
+	select {
+	case <-runs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no run after the election")
+	}
+}
+
+func TestJobRun_FinishNil(t *testing.T) {
+	var run *JobRun
+	run.finish(context.Background(), nil, 1, 0, nil) // an unrecorded run is a no-op
+}
+
+func TestAdminJobsHandler_MethodNotAllowed(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&Server{}).AdminJobsHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/jobs", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rr.Code)
+	}
+}
+
+func TestRunCleanup_RecordsOnlyUsefulRuns(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	cfg := CleanupConfig{DB: db, MaxAge: time.Hour}
+
+	if _, err := db.Exec(`INSERT INTO job_runs (id, job, trigger, instance, status, started_at)
+		VALUES ($1, 'cleanup', 'job', 'old:1', 'succeeded', now() - interval '31 days')`, uuid.NewString()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunCleanup(ctx, cfg, CleanupOptions{Trigger: "job"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunCleanup(ctx, cfg, CleanupOptions{Trigger: "manual"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneJobRuns(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := ListJobRuns(ctx, db, JobRunFilter{Job: "cleanup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Trigger != "manual" {
+		t.Fatalf("want only the manual run, got %+v", runs)
+	}
+}
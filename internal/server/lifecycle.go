@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lifecycle runs the server's background workers under one context, so
+// stopping it cancels every worker and waits for them to return.
+type Lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	done   chan struct{}
+
+	mu      sync.Mutex
+	stopped bool
+	running map[string]int // worker name -> instances still running
+}
+
+// NewLifecycle returns a Lifecycle whose workers inherit the values (and
+// cancellation) of parent.
+func NewLifecycle(parent context.Context) *Lifecycle {
+	ctx, cancel := context.WithCancel(parent)
+	return &Lifecycle{ctx: ctx, cancel: cancel, done: make(chan struct{}), running: map[string]int{}}
+}
+
+// Go runs fn in a goroutine with a context cancelled by Stop. It returns
+// false, without running fn, once Stop has been called.
+func (l *Lifecycle) Go(name string, fn func(ctx context.Context)) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return false
+	}
+	l.running[name]++
+	l.wg.Add(1)
+	go func() {
+		defer func() {
+			l.mu.Lock()
+			if l.running[name]--; l.running[name] == 0 {
+				delete(l.running, name)
+			}
+			l.mu.Unlock()
+			l.wg.Done()
+		}()
+		fn(l.ctx)
+	}()
+	return true
+}
+
+// Stop cancels the workers and waits until they have returned or ctx is
+// done; in the latter case the error names the workers still running.
+// Stop may be called more than once.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.stopped {
+		l.stopped = true
+		l.cancel()
+		go func() {
+			l.wg.Wait()
+			close(l.done)
+		}()
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background workers still running: %s: %w", strings.Join(l.Running(), ", "), ctx.Err())
+	}
+}
+
+// Done is closed once Stop has been called and every worker has returned.
+func (l *Lifecycle) Done() <-chan struct{} { return l.done }
+
+// Running returns the names of the workers that have not returned, sorted.
+func (l *Lifecycle) Running() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.running))
+	for n := range l.running {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errUploadInterrupted is the cancellation cause of uploads still running
+// when the shutdown drain deadline passes.
+var errUploadInterrupted = errors.New("upload interrupted by shutdown")
+
+// uploadAbortGrace is how long draining waits, after cancelling the
+// remaining uploads, for their handlers to record the interruption.
+const uploadAbortGrace = 5 * time.Second
+
+// uploadTracker counts uploads in flight. Draining it refuses new uploads,
+// waits for the running ones and, past the deadline, cancels them with
+// errUploadInterrupted. A nil tracker tracks nothing.
+type uploadTracker struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu     sync.Mutex
+	closed bool
+	active int
+	idle   chan struct{} // closed when active drops to 0 after closing
+}
+
+func newUploadTracker() *uploadTracker {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &uploadTracker{ctx: ctx, cancel: cancel, idle: make(chan struct{})}
+}
+
+// begin registers an upload. The returned context is parent, also
+// cancelled when draining gives up; done must be called when the upload
+// has finished. ok is false once draining has begun.
+func (t *uploadTracker) begin(parent context.Context) (ctx context.Context, done func(), ok bool) {
+	ctx, cancel := context.WithCancelCause(parent)
+	if t == nil {
+		return ctx, func() { cancel(nil) }, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		cancel(nil)
+		return nil, nil, false
+	}
+	t.active++
+	stop := context.AfterFunc(t.ctx, func() { cancel(context.Cause(t.ctx)) })
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			stop()
+			cancel(nil)
+			t.mu.Lock()
+			if t.active--; t.active == 0 && t.closed {
+				close(t.idle)
+			}
+			t.mu.Unlock()
+		})
+	}, true
+}
+
+// drain refuses new uploads and waits for the running ones until ctx is
+// done, then cancels them and returns how many were interrupted.
+func (t *uploadTracker) drain(ctx context.Context) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		if t.active == 0 {
+			close(t.idle)
+		}
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.idle:
+		return 0
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	n := t.active
+	t.mu.Unlock()
+	t.cancel(errUploadInterrupted)
+
+	timer := time.NewTimer(uploadAbortGrace)
+	defer timer.Stop()
+	select {
+	case <-t.idle:
+	case <-timer.C:
+		logFor(ctx, "upload").Warn("interrupted_uploads_still_running", slog.Int("count", n))
+	}
+	return n
+}
+
+// uploadInterrupted reports whether ctx, from uploadTracker.begin, was
+// cancelled by draining.
+func uploadInterrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errUploadInterrupted)
+}
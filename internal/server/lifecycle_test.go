@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLifecycle_StopCancelsWorkers(t *testing.T) {
+	l := NewLifecycle(context.Background())
+	started := make(chan struct{}, 2)
+	for _, name := range []string{"a", "b"} {
+		l.Go(name, func(ctx context.Context) {
+			started <- struct{}{}
+			<-ctx.Done()
+		})
+	}
+	<-started
+	<-started
+	if got := l.Running(); len(got) != 2 || got[0] != "a" {
+		t.Errorf("running: %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := l.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l.Done():
+	default:
+		t.Error("Done not closed after Stop")
+	}
+	if l.Go("late", func(context.Context) { t.Error("worker started after Stop") }) {
+		t.Error("Go accepted a worker after Stop")
+	}
+	if err := l.Stop(ctx); err != nil {
+		t.Errorf("second Stop: %v", err)
+	}
+}
+
+func TestLifecycle_StopReportsStuckWorkers(t *testing.T) {
+	l := NewLifecycle(context.Background())
+	release := make(chan struct{})
+	l.Go("stuck", func(context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := l.Stop(ctx)
+	if err == nil || !strings.Contains(err.Error(), "stuck") {
+		t.Fatalf("expected an error naming the stuck worker, got %v", err)
+	}
+
+	close(release)
+	select {
+	case <-l.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done not closed once the worker returned")
+	}
+}
+
+func TestUploadTracker_DrainWaitsThenCancels(t *testing.T) {
+	tr := newUploadTracker()
+	ctx, done, ok := tr.begin(context.Background())
+	if !ok {
+		t.Fatal("upload refused before draining")
+	}
+
+	// An upload finishing within the deadline is not interrupted.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done()
+	}()
+	if n := tr.drain(context.Background()); n != 0 {
+		t.Errorf("interrupted %d uploads", n)
+	}
+	if uploadInterrupted(ctx) {
+		t.Error("finished upload reported as interrupted")
+	}
+	if _, _, ok := tr.begin(context.Background()); ok {
+		t.Error("upload accepted while draining")
+	}
+
+	// One still running at the deadline is cancelled with the cause.
+	tr = newUploadTracker()
+	ctx, done, _ = tr.begin(context.Background())
+	go func() {
+		<-ctx.Done()
+		done()
+	}()
+	deadline, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if n := tr.drain(deadline); n != 1 {
+		t.Errorf("interrupted %d uploads, want 1", n)
+	}
+	if !uploadInterrupted(ctx) {
+		t.Errorf("cause: %v", context.Cause(ctx))
+	}
+}
+
+func TestUploadTracker_Nil(t *testing.T) {
+	var tr *uploadTracker
+	ctx, done, ok := tr.begin(context.Background())
+	if !ok || ctx.Err() != nil {
+		t.Fatal("nil tracker refused an upload")
+	}
+	done()
+	if tr.drain(context.Background()) != 0 {
+		t.Error("nil tracker interrupted uploads")
+	}
+}
+
+func TestServerShutdown_DrainsUploadsAndStopsJobs(t *testing.T) {
+	prev := cleanupRun
+	cleanupRun = func(context.Context, CleanupConfig) {}
+	t.Cleanup(func() { cleanupRun = prev })
+
+	s := &Server{
+		httpServer:  &http.Server{},
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		health:      NewHealthRegistry(0),
+		jobs:        NewLifecycle(context.Background()),
+		uploads:     newUploadTracker(),
+		uploadDrain: 10 * time.Millisecond,
+	}
+	s.jobs.Go("cleanup", func(ctx context.Context) {
+		runCleanupJob(ctx, CleanupConfig{Enabled: true, Interval: time.Hour}, make(chan CleanupConfig))
+	})
+	upload, done, _ := s.uploads.begin(context.Background())
+	go func() {
+		<-upload.Done()
+		done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Error("background jobs still running after Shutdown")
+	}
+	if !uploadInterrupted(upload) {
+		t.Errorf("upload past the drain deadline not interrupted: %v", context.Cause(upload))
+	}
+}
@@ -3,8 +3,8 @@ package server
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -25,6 +25,9 @@ type createLinkResp struct {
 	ExpiresAt string `json:"expires_at"`
 }
 
+// maxLinkTTLSeconds is the longest lifetime of a download link.
+const maxLinkTTLSeconds = 86400
+
 // clampTTLSeconds enforces TTL constraints for download links.
 // Default: 5 minutes (300 seconds) if omitted or invalid.
 // Minimum: 60 seconds, Maximum: 24 hours (86400 seconds).
@@ -36,20 +39,24 @@ func clampTTLSeconds(n int) int {
 	if n <= 0 {
 		return 300
 	}
-	if n > 86400 {
-		return 86400
+	if n > maxLinkTTLSeconds {
+		return maxLinkTTLSeconds
 	}
 	return n
 }
 
+// requestOrigin returns the origin r was addressed to. The reverse-proxy
+// headers are only honoured with SetTrustProxyHeaders, as for clientIP.
 func requestOrigin(r *http.Request) string {
-	// Prefer reverse-proxy headers if present.
-	scheme := strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))
-	host := strings.TrimSpace(r.Header.Get("X-Forwarded-Host"))
-
-	// Some proxies set X-Forwarded-Host as a comma-separated list.
-	if i := strings.IndexByte(host, ','); i >= 0 {
-		host = strings.TrimSpace(host[:i])
+	var scheme, host string
+	if trustProxyHeaders.Load() {
+		scheme = strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))
+		host = strings.TrimSpace(r.Header.Get("X-Forwarded-Host"))
+
+		// Some proxies set X-Forwarded-Host as a comma-separated list.
+		if i := strings.IndexByte(host, ','); i >= 0 {
+			host = strings.TrimSpace(host[:i])
+		}
 	}
 
 	if scheme == "" {
@@ -70,8 +77,38 @@ func requestOrigin(r *http.Request) string {
 	return scheme + "://" + host
 }
 
+// publicBaseURL returns the origin used in links handed out to users:
+// configured (server.public_base_url) when set, else the request's origin.
+//
+// Milestone 8: prefer configured public base URL for deterministic links.
+// This is critical when deployed behind reverse proxies (e.g., Proxmox + Nginx/Traefik/Caddy).
+func publicBaseURL(configured string, r *http.Request) string {
+	base := strings.TrimSpace(configured)
+	base = strings.TrimRight(base, "/")
+	if base == "" {
+		base = requestOrigin(r)
+	}
+	return base
+}
+
+// errNoPublicBaseURL is returned by mailBaseURL when server.public_base_url
+// is not set.
+var errNoPublicBaseURL = errors.New("server.public_base_url is not set")
+
+// mailBaseURL returns the origin for links in outgoing mail. Unlike
+// publicBaseURL it never falls back to the request: its Host and forwarded
+// headers are chosen by the client, and a mailed token pointing at another
+// domain would hand that token to whoever controls it.
+func mailBaseURL(configured string) (string, error) {
+	base := strings.TrimRight(strings.TrimSpace(configured), "/")
+	if base == "" {
+		return "", errNoPublicBaseURL
+	}
+	return base, nil
+}
+
 func (cfg Config) createLinkHandler(db *sql.DB) http.Handler {
-	return cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return cfg.Auth.requireScope(ScopeLinksCreate, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -95,27 +132,54 @@ func (cfg Config) createLinkHandler(db *sql.DB) http.Handler {
 			return
 		}
 
-		// Ensure file exists and is in a state we allow for downloads.
-		// For now: require "hashed" (Milestone 5) so integrity is proven.
-		var status string
-		err = db.QueryRow(`SELECT status FROM files WHERE id = $1`, id).Scan(&status)
+		// Ensure file exists, the caller may share it, and it is in a state we
+		// allow for downloads. Require "hashed" (Milestone 5) so integrity is proven.
+		f, perm, err := fileAccess(r.Context(), db, PrincipalFromContext(r.Context()), id.String())
 		if err != nil {
-			if err == sql.ErrNoRows {
+			if err == errFileNotFound {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
-		if status != "hashed" && status != "ready" {
+		if perm == permNone {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		// Share viewers may download but not publish the file.
+		if perm < permView {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if f.Status != "hashed" && f.Status != "ready" {
 			http.Error(w, "invalid status", http.StatusConflict)
 			return
 		}
 
-		ttl := clampTTLSeconds(req.TTLSeconds)
+		// Org files use the organization's link TTL cap.
+		var policy OrgPolicy
+		if f.OrgID != "" {
+			if policy, err = loadOrgPolicy(r.Context(), db, f.OrgID); err != nil {
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+		}
+		ttl := policy.linkTTL(req.TTLSeconds)
 		expiresAt := time.Now().UTC().Add(time.Duration(ttl) * time.Second)
 
-		token, err := signDownloadToken(id.String(), expiresAt)
+		keyID, secret, err := downloadKeyRing{db, cfg.DownloadSecret}.signingKey(r.Context())
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		linkID := uuid.NewString()
+		token, err := signDownloadClaims(secret, downloadClaims{
+			FileID: id.String(),
+			Exp:    expiresAt.Unix(),
+			KeyID:  keyID,
+			LinkID: linkID,
+		})
 		if err != nil {
 			// If secret missing/misconfigured, this is a server error.
 			if err == errDownloadSecretMissing {
@@ -126,15 +190,24 @@ func (cfg Config) createLinkHandler(db *sql.DB) http.Handler {
 			return
 		}
 
-		// Milestone 8: prefer configured public base URL for deterministic links.
-		// This is critical when deployed behind reverse proxies (e.g., Proxmox + Nginx/Traefik/Caddy).
-		base := strings.TrimSpace(os.Getenv("SFD_PUBLIC_BASE_URL"))
-		base = strings.TrimRight(base, "/")
-		if base == "" {
-			base = requestOrigin(r)
+		// Recorded so the link can be listed and revoked (sfdctl links).
+		if err := recordLink(r.Context(), db, LinkInfo{
+			ID:        linkID,
+			FileID:    id.String(),
+			KeyID:     keyID,
+			CreatedBy: PrincipalFromContext(r.Context()).Subject,
+			ExpiresAt: expiresAt,
+		}); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
 		}
 
-		url := base + "/download?token=" + token
+		url := publicBaseURL(cfg.PublicBaseURL, r) + "/download?token=" + token
+		recordAudit(r.Context(), "link_created", map[string]string{
+			"file_id":    id.String(),
+			"link_id":    linkID,
+			"expires_at": expiresAt.Format(time.RFC3339),
+		})
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -20,13 +20,28 @@ func TestRequestOriginHeaderPreference(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "http://example.local/", nil)
 	r.Header.Set("X-Forwarded-Proto", "https")
 	r.Header.Set("X-Forwarded-Host", "files.example.com")
+	t.Cleanup(func() { SetTrustProxyHeaders(false, 1) })
 
+	if got := requestOrigin(r); got != "http://example.local" {
+		t.Fatalf("untrusted proxy headers: got %s", got)
+	}
+
+	SetTrustProxyHeaders(true, 1)
 	got := requestOrigin(r)
 	if !strings.HasPrefix(got, "https://") || !strings.Contains(got, "files.example.com") {
 		t.Fatalf("unexpected origin: %s", got)
 	}
 }
 
+func TestMailBaseURL_RequiresConfiguredURL(t *testing.T) {
+	if _, err := mailBaseURL(" "); err != errNoPublicBaseURL {
+		t.Fatalf("unset: got %v", err)
+	}
+	if got, err := mailBaseURL("https://files.example.com/"); err != nil || got != "https://files.example.com" {
+		t.Fatalf("configured: got %q, %v", got, err)
+	}
+}
+
 func TestRequestOriginFallbacks(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "http://example.local/", nil)
 	// no headers
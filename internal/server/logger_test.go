@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeLogLines parses JSON log output into one map per line.
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestParseLogConfig(t *testing.T) {
+	lc, err := ParseLogConfig("", "")
+	if err != nil || lc.Format != LogFormatJSON || lc.Level != slog.LevelInfo {
+		t.Fatalf("defaults: %+v, %v", lc, err)
+	}
+
+	lc, err = ParseLogConfig("TEXT", "debug")
+	if err != nil || lc.Format != LogFormatText || lc.Level != slog.LevelDebug {
+		t.Fatalf("text/debug: %+v, %v", lc, err)
+	}
+
+	if _, err := ParseLogConfig("xml", ""); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+	if _, err := ParseLogConfig("", "loud"); err == nil {
+		t.Error("unknown level should be rejected")
+	}
+}
+
+func TestNewLogger_RedactsPII(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LogConfig{Format: LogFormatJSON, Level: slog.LevelInfo})
+	logger.Info("mail for alice@example.com",
+		slog.String("email", "bob.smith@example.org"),
+		slog.String("password", "hunter2"),
+		errAttr(errors.New("smtp: 550 unknown user carol@mail.example.net")),
+	)
+	logger.Debug("below the level")
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected one line at info, got %d", len(lines))
+	}
+	l := lines[0]
+	if l["msg"] != "mail for a***@example.com" {
+		t.Errorf("msg = %v", l["msg"])
+	}
+	if l["email"] != "b***@example.org" {
+		t.Errorf("email = %v", l["email"])
+	}
+	if l["password"] != "[REDACTED]" {
+		t.Errorf("password = %v", l["password"])
+	}
+	if l[logKeyError] != "smtp: 550 unknown user c***@mail.example.net" {
+		t.Errorf("error = %v", l[logKeyError])
+	}
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), "bob.smith") {
+		t.Fatalf("PII leaked: %s", buf.String())
+	}
+}
+
+func TestLoggingMiddleware_RequestScopedFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LogConfig{Format: LogFormatJSON})
+
+	cfg := Config{Auth: AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}}
+	inner := cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addLogAttrs(r.Context(), slog.String(logKeyFileID, "file-1"))
+		logFor(r.Context(), "upload").Warn("something_odd")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	h := requestIDMiddleware(loggingMiddleware(logger, inner))
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.Header.Set("X-Request-Id", "rid-123")
+	tok, _, err := cfg.Auth.makeToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: cfg.Auth.cookieName(), Value: tok})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected handler line and access line, got %d: %s", len(lines), buf.String())
+	}
+	for _, l := range lines {
+		if l[logKeyRequestID] != "rid-123" || l[logKeyUserID] != "admin" || l[logKeyFileID] != "file-1" {
+			t.Errorf("missing request-scoped fields: %v", l)
+		}
+	}
+	if lines[0][logKeyComponent] != "upload" || lines[0]["msg"] != "something_odd" {
+		t.Errorf("handler line: %v", lines[0])
+	}
+	access := lines[1]
+	if access[logKeyComponent] != "http" || access["status"] != float64(http.StatusAccepted) || access["method"] != http.MethodPost {
+		t.Errorf("access line: %v", access)
+	}
+	if _, ok := access[logKeyDuration]; !ok {
+		t.Error("access line lacks duration_ms")
+	}
+}
+
+func TestLogFor_OutsideScopeUsesDefault(t *testing.T) {
+	// No scope: addLogAttrs is a no-op and logFor falls back to slog.Default().
+	ctx := context.Background()
+	addLogAttrs(ctx, slog.String(logKeyFileID, "x"))
+	if logFor(ctx, "cleanup") == nil {
+		t.Fatal("expected a logger")
+	}
+
+	var buf bytes.Buffer
+	ctx = withLogger(ctx, NewLogger(&buf, LogConfig{Format: LogFormatJSON}))
+	recordAudit(ctx, "login_failed", map[string]string{"username": "dave", "email": "dave@example.com"})
+	l := decodeLogLines(t, &buf)[0]
+	if l[logKeyComponent] != "audit" || l["event"] != "login_failed" || l["email"] != "d***@example.com" {
+		t.Fatalf("audit line: %v", l)
+	}
+}
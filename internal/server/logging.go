@@ -4,14 +4,23 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ctxKey string
 
-const requestIDKey ctxKey = "request_id"
+const (
+	requestIDKey ctxKey = "request_id"
+	principalKey ctxKey = "principal"
+	logScopeKey  ctxKey = "log_scope"
+	auditLogKey  ctxKey = "audit_log"
+)
 
 // RequestIDFromContext returns the request id if present.
 func RequestIDFromContext(ctx context.Context) string {
@@ -36,35 +45,102 @@ func generateRequestID() string {
 }
 
 // requestIDMiddleware ensures every request has a request id.
-// If the client supplies X-Request-Id, we keep it; otherwise we generate one.
+// If the client supplies X-Request-Id, we keep it; otherwise the trace id
+// is reused so logs and traces share one key, falling back to a random id
+// for untraced requests. The id is recorded on the server span either way.
 func requestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rid := r.Header.Get("X-Request-Id")
+		if rid == "" {
+			rid = traceIDFromContext(r.Context())
+		}
 		if rid == "" {
 			rid = generateRequestID()
 		}
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("sfd.request_id", rid))
 		ctx := context.WithValue(r.Context(), requestIDKey, rid)
 		w.Header().Set("X-Request-Id", rid)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// loggingMiddleware logs one line per request in a simple structured format.
-func loggingMiddleware(next http.Handler) http.Handler {
+// logScope is the request-scoped logging state. Middleware and handlers
+// add fields (user id, file id) as they learn them; every logger taken from
+// the context afterwards, including the access log line, carries them.
+type logScope struct {
+	base  *slog.Logger
+	mu    sync.Mutex
+	attrs []any
+}
+
+// withLogger starts a new log scope on ctx with base as the parent logger.
+// Background jobs use it to give their context the server's logger.
+func withLogger(ctx context.Context, base *slog.Logger, attrs ...slog.Attr) context.Context {
+	sc := &logScope{base: base}
+	for _, a := range attrs {
+		sc.attrs = append(sc.attrs, a)
+	}
+	return context.WithValue(ctx, logScopeKey, sc)
+}
+
+// addLogAttrs attaches attrs to the log scope in ctx; it is a no-op when
+// ctx has none.
+func addLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	sc, _ := ctx.Value(logScopeKey).(*logScope)
+	if sc == nil {
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, a := range attrs {
+		sc.attrs = append(sc.attrs, a)
+	}
+}
+
+// loggerFrom returns the scoped logger for ctx, or slog.Default() outside a
+// scope.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	sc, _ := ctx.Value(logScopeKey).(*logScope)
+	if sc == nil {
+		return slog.Default()
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.base.With(sc.attrs...)
+}
+
+// logFor returns the scoped logger for ctx tagged with component.
+func logFor(ctx context.Context, component string) *slog.Logger {
+	return loggerFrom(ctx).With(logKeyComponent, component)
+}
+
+// loggingMiddleware opens the request's log scope (request and trace id)
+// and writes one access log line per request once the handler returns.
+func loggingMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		rid := RequestIDFromContext(r.Context())
+		attrs := []slog.Attr{slog.String(logKeyRequestID, RequestIDFromContext(r.Context()))}
+		if tid := traceIDFromContext(r.Context()); tid != "" {
+			attrs = append(attrs, slog.String(logKeyTraceID, tid))
+		}
+		ctx := withLogger(r.Context(), logger, attrs...)
 
 		// Wrap ResponseWriter to capture status code.
 		lrw := &loggingResponseWriter{ResponseWriter: w, status: 200}
-		next.ServeHTTP(lrw, r)
+		next.ServeHTTP(lrw, r.WithContext(ctx))
 
-		ms := time.Since(start).Milliseconds()
-		log.Printf("rid=%s method=%s path=%s status=%d ms=%d remote=%s ua=%q",
-			rid, r.Method, r.URL.Path, lrw.status, ms, r.RemoteAddr, r.UserAgent())
-
-		// Record metrics
-		GetMetrics().RecordRequest(lrw.status)
+		level := slog.LevelInfo
+		if lrw.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logFor(ctx, "http").LogAttrs(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", lrw.status),
+			slog.Int64(logKeyDuration, time.Since(start).Milliseconds()),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
 	})
 }
 
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LoginThrottle tracks failed logins per account and per client IP in
+// Postgres, so that every replica sees the same counters.
+//
+// Once a key reaches its failure threshold it is locked out; each further
+// failure doubles the lockout, up to LockoutMax. Failures older than
+// FailureWindow are forgotten, and their rows are purged now and then.
+type LoginThrottle struct {
+	DB                 *sql.DB
+	AccountMaxFailures int
+	IPMaxFailures      int
+	FailureWindow      time.Duration
+	LockoutBase        time.Duration
+	LockoutMax         time.Duration
+	Metrics            *Metrics // lockout counter; nil disables recording
+
+	failures atomic.Uint64 // RecordFailure calls, for purging
+}
+
+// throttlePurgeEvery controls how often stale login_throttle rows are purged.
+const throttlePurgeEvery = 100
+
+// throttleState is the outcome of recording a failure for one key.
+type throttleState struct {
+	Key         string
+	Failures    int
+	LockedUntil time.Time
+	NewlyLocked bool
+}
+
+// NewLoginThrottle returns a LoginThrottle with conservative defaults:
+// 5 failures per account or 20 per IP within 15 minutes trigger a lockout
+// starting at 1 minute and capped at 1 hour.
+func NewLoginThrottle(db *sql.DB) *LoginThrottle {
+	return &LoginThrottle{
+		DB:                 db,
+		AccountMaxFailures: 5,
+		IPMaxFailures:      20,
+		FailureWindow:      15 * time.Minute,
+		LockoutBase:        1 * time.Minute,
+		LockoutMax:         1 * time.Hour,
+	}
+}
+
+func accountThrottleKey(username string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// accountKey returns the throttle key of the account a login names. Logins
+// may use the username or the email address; both map to the username so
+// that an account has a single counter. Unknown names are keyed as given.
+func (t *LoginThrottle) accountKey(ctx context.Context, login string) string {
+	login = strings.TrimSpace(login)
+	if t == nil || t.DB == nil || login == "" {
+		return accountThrottleKey(login)
+	}
+	var username string
+	err := t.DB.QueryRowContext(ctx,
+		`SELECT username FROM users WHERE username = $1 OR email = $1 ORDER BY username = $1 DESC LIMIT 1`,
+		login,
+	).Scan(&username)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logFor(ctx, "auth").Error("throttle_account_lookup_failed", errAttr(err))
+		}
+		return accountThrottleKey(login)
+	}
+	return accountThrottleKey(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// lockoutDuration returns how long a key is locked after failures
+// consecutive failures, given a threshold. It returns 0 below the threshold.
+func lockoutDuration(failures, threshold int, base, max time.Duration) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+	d := base
+	for i := threshold; i < failures; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// LockedUntil returns the latest active lockout among keys, or the zero time
+// when none of them is locked.
+func (t *LoginThrottle) LockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	var until time.Time
+	for _, k := range keys {
+		var lu sql.NullTime
+		err := t.DB.QueryRowContext(ctx,
+			`SELECT locked_until FROM login_throttle WHERE key = $1 AND locked_until > now()`,
+			k,
+		).Scan(&lu)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return time.Time{}, err
+		}
+		if lu.Valid && lu.Time.After(until) {
+			until = lu.Time
+		}
+	}
+	return until, nil
+}
+
+// RecordFailure increments the failure counter for key and applies a lockout
+// once threshold is reached.
+func (t *LoginThrottle) RecordFailure(ctx context.Context, key string, threshold int) (throttleState, error) {
+	st := throttleState{Key: key}
+
+	if t.failures.Add(1)%throttlePurgeEvery == 0 {
+		if err := t.purge(ctx); err != nil {
+			logFor(ctx, "auth").Error("throttle_purge_failed", errAttr(err))
+		}
+	}
+
+	var prevLocked sql.NullTime
+	err := t.DB.QueryRowContext(ctx, `
+		INSERT INTO login_throttle (key, failures, last_failure_at)
+		VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttle.last_failure_at < now() - make_interval(secs => $2) THEN 1
+				ELSE login_throttle.failures + 1
+			END,
+			last_failure_at = now()
+		RETURNING failures, locked_until
+	`, key, t.FailureWindow.Seconds()).Scan(&st.Failures, &prevLocked)
+	if err != nil {
+		return st, err
+	}
+
+	d := lockoutDuration(st.Failures, threshold, t.LockoutBase, t.LockoutMax)
+	if d == 0 {
+		return st, nil
+	}
+
+	st.LockedUntil = time.Now().Add(d)
+	st.NewlyLocked = !prevLocked.Valid || prevLocked.Time.Before(time.Now())
+	if _, err := t.DB.ExecContext(ctx,
+		`UPDATE login_throttle SET locked_until = $2 WHERE key = $1`,
+		key, st.LockedUntil,
+	); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// purge deletes rows whose failures have all expired and that are not
+// locked: the next failure would start them over at 1 anyway.
+func (t *LoginThrottle) purge(ctx context.Context) error {
+	_, err := t.DB.ExecContext(ctx, `
+		DELETE FROM login_throttle
+		WHERE last_failure_at < now() - make_interval(secs => $1)
+		  AND (locked_until IS NULL OR locked_until < now())
+	`, t.FailureWindow.Seconds())
+	return err
+}
+
+// Reset clears the counters for the given keys (successful login or an
+// administrator unlock). It reports how many keys had state.
+func (t *LoginThrottle) Reset(ctx context.Context, keys ...string) (int64, error) {
+	var n int64
+	for _, k := range keys {
+		res, err := t.DB.ExecContext(ctx, `DELETE FROM login_throttle WHERE key = $1`, k)
+		if err != nil {
+			return n, err
+		}
+		c, _ := res.RowsAffected()
+		n += c
+	}
+	return n, nil
+}
+
+// checkLogin reports whether a login to the account keyed account (see
+// accountKey) from ip may proceed and, if not, until when it is locked.
+// Errors fail open so that a database hiccup does not lock everybody out;
+// they are logged.
+func (t *LoginThrottle) checkLogin(ctx context.Context, account, ip string) time.Time {
+	if t == nil || t.DB == nil {
+		return time.Time{}
+	}
+	until, err := t.LockedUntil(ctx, account, ipThrottleKey(ip))
+	if err != nil {
+		logFor(ctx, "auth").Error("throttle_check_failed", errAttr(err))
+		return time.Time{}
+	}
+	return until
+}
+
+// loginFailed records a failed login against both the account and the IP and
+// emits audit events and metrics for any new lockout.
+func (t *LoginThrottle) loginFailed(ctx context.Context, account, ip string) {
+	if t == nil || t.DB == nil {
+		return
+	}
+	keys := []struct {
+		key       string
+		threshold int
+	}{
+		{account, t.AccountMaxFailures},
+		{ipThrottleKey(ip), t.IPMaxFailures},
+	}
+	for _, k := range keys {
+		st, err := t.RecordFailure(ctx, k.key, k.threshold)
+		if err != nil {
+			logFor(ctx, "auth").Error("throttle_record_failed", slog.String("throttle_key", k.key), errAttr(err))
+			continue
+		}
+		if st.NewlyLocked {
+			t.Metrics.RecordLoginLockout()
+			recordAudit(ctx, "login_lockout", map[string]string{
+				"key":          st.Key,
+				"failures":     strconv.Itoa(st.Failures),
+				"locked_until": st.LockedUntil.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+}
+
+// loginSucceeded clears the account counter. The IP counter is kept so that
+// interleaving one valid login does not reset a spraying attacker's budget.
+func (t *LoginThrottle) loginSucceeded(ctx context.Context, account string) {
+	if t == nil || t.DB == nil {
+		return
+	}
+	if _, err := t.Reset(ctx, account); err != nil {
+		logFor(ctx, "auth").Error("throttle_reset_failed", errAttr(err))
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	base, max := time.Minute, time.Hour
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{11, time.Hour},
+		{50, time.Hour},
+	}
+	for _, c := range cases {
+		if got := lockoutDuration(c.failures, 5, base, max); got != c.want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", c.failures, got, c.want)
+		}
+	}
+}
+
+func TestThrottleKeys(t *testing.T) {
+	if got := accountThrottleKey("  Alice "); got != "account:alice" {
+		t.Fatalf("unexpected account key: %s", got)
+	}
+	if got := ipThrottleKey("10.0.0.1"); got != "ip:10.0.0.1" {
+		t.Fatalf("unexpected ip key: %s", got)
+	}
+}
+
+func TestLoginThrottle_NilIsNoop(t *testing.T) {
+	var th *LoginThrottle
+	ctx := context.Background()
+	account := th.accountKey(ctx, "Admin")
+	if account != "account:admin" {
+		t.Fatalf("unexpected account key: %s", account)
+	}
+	if until := th.checkLogin(ctx, account, "127.0.0.1"); !until.IsZero() {
+		t.Fatalf("nil throttle should never lock, got %s", until)
+	}
+	th.loginFailed(ctx, account, "127.0.0.1")
+	th.loginSucceeded(ctx, account)
+}
+
+func TestLoginThrottle_AccountKeyAndPurge(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	th := NewLoginThrottle(db)
+
+	if _, err := db.Exec(`INSERT INTO users (email, username, password_hash) VALUES ('alice@example.com', 'Alice', 'x')`); err != nil {
+		t.Fatal(err)
+	}
+	byName, byEmail := th.accountKey(ctx, "Alice"), th.accountKey(ctx, "alice@example.com")
+	if byName != "account:alice" || byEmail != byName {
+		t.Fatalf("username and email keys differ: %q, %q", byName, byEmail)
+	}
+	if got := th.accountKey(ctx, "Nobody"); got != "account:nobody" {
+		t.Fatalf("unknown login: %q", got)
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO login_throttle (key, failures, last_failure_at, locked_until) VALUES
+		('ip:192.0.2.1', 3, now() - interval '1 hour', NULL),
+		('ip:192.0.2.2', 9, now() - interval '1 hour', now() + interval '1 hour'),
+		('ip:192.0.2.3', 1, now(), NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := th.purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM login_throttle WHERE key IN ('ip:192.0.2.2', 'ip:192.0.2.3')`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	var total int
+	_ = db.QueryRow(`SELECT count(*) FROM login_throttle`).Scan(&total)
+	if n != 2 || total != 2 {
+		t.Fatalf("purge kept %d rows (%d of the locked and recent ones), want only those 2", total, n)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MailMessage is a plain-text email sent by the server (verification,
+// password reset and similar account notifications).
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers account emails. Implementations must be safe for
+// concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg MailMessage) error
+}
+
+// LogMailer only logs that a message would have been sent. It never logs the
+// body, which may contain single-use tokens. It is the default when no real
+// transport is configured.
+type LogMailer struct{}
+
+// Send implements Mailer.
+func (LogMailer) Send(ctx context.Context, msg MailMessage) error {
+	logFor(ctx, "mailer").Warn("mail_not_sent_no_transport", slog.String("to", msg.To), slog.String("subject", msg.Subject))
+	return nil
+}
+
+// FileMailer writes each message to its own file in Dir. It is intended for
+// tests and local development, where the messages can be inspected directly.
+type FileMailer struct {
+	Dir string
+	seq atomic.Uint64
+}
+
+// Send implements Mailer.
+func (m *FileMailer) Send(_ context.Context, msg MailMessage) error {
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return fmt.Errorf("create mail dir: %w", err)
+	}
+	name := fmt.Sprintf("%d-%06d.eml", time.Now().UnixNano(), m.seq.Add(1))
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(formatMail("", msg)), 0o600)
+}
+
+// SMTPMailer sends messages through an SMTP relay using PLAIN auth when
+// credentials are configured.
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+// Send implements Mailer.
+func (m SMTPMailer) Send(_ context.Context, msg MailMessage) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return fmt.Errorf("bad smtp addr: %w", err)
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, []byte(formatMail(m.From, msg)))
+}
+
+// formatMail renders a minimal RFC 5322 message.
+func formatMail(from string, msg MailMessage) string {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	return b.String()
+}
+
+// MailConfig selects how account emails are delivered.
+type MailConfig struct {
+	SMTPAddr string // host:port; enables SMTP delivery
+	From     string // defaults to no-reply@localhost
+	Username string
+	Password string
+	Dir      string // file sink used when SMTPAddr is empty
+}
+
+// NewMailer selects a Mailer: SMTP when SMTPAddr is set, otherwise the file
+// sink when Dir is set, otherwise messages are only logged.
+func NewMailer(mc MailConfig) Mailer {
+	if mc.SMTPAddr != "" {
+		from := mc.From
+		if from == "" {
+			from = "no-reply@localhost"
+		}
+		return SMTPMailer{
+			Addr:     mc.SMTPAddr,
+			From:     from,
+			Username: mc.Username,
+			Password: mc.Password,
+		}
+	}
+	if mc.Dir != "" {
+		return &FileMailer{Dir: mc.Dir}
+	}
+	return LogMailer{}
+}
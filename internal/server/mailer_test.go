@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer_WritesMessage(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir}
+
+	msg := MailMessage{To: "user@example.com", Subject: "Hello", Body: "token=abc\n"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(entries))
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{"To: user@example.com", "Subject: Hello", "token=abc"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	if _, ok := NewMailer(MailConfig{}).(LogMailer); !ok {
+		t.Fatal("expected LogMailer by default")
+	}
+
+	if _, ok := NewMailer(MailConfig{Dir: t.TempDir()}).(*FileMailer); !ok {
+		t.Fatal("expected FileMailer when a mail dir is set")
+	}
+
+	m, ok := NewMailer(MailConfig{SMTPAddr: "smtp.example.com:587", Dir: t.TempDir()}).(SMTPMailer)
+	if !ok {
+		t.Fatal("expected SMTPMailer when an SMTP address is set")
+	}
+	if m.From == "" {
+		t.Fatal("expected default From address")
+	}
+}
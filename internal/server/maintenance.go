@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maintenanceCacheTTL is how long an instance reuses the flag it read, so
+// a change reaches every replica within this time.
+const maintenanceCacheTTL = 2 * time.Second
+
+// defaultMaintenanceMessage is sent when the operator gave none.
+const defaultMaintenanceMessage = "Secure File Drop is in read-only maintenance mode; downloads keep working. Please try again later."
+
+// Maintenance is the read-only maintenance flag stored in maintenance_mode.
+type Maintenance struct {
+	Enabled           bool      `json:"enabled"`
+	Message           string    `json:"message,omitempty"`
+	RetryAfterSeconds int       `json:"retry_after_seconds"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	UpdatedBy         string    `json:"updated_by,omitempty"`
+}
+
+// message returns the text shown to clients.
+func (m Maintenance) message() string {
+	if m.Message != "" {
+		return m.Message
+	}
+	return defaultMaintenanceMessage
+}
+
+// GetMaintenance reads the flag.
+func GetMaintenance(ctx context.Context, db *sql.DB) (Maintenance, error) {
+	var m Maintenance
+	err := db.QueryRowContext(ctx, `
+		SELECT enabled, message, retry_after_seconds, updated_at, updated_by
+		FROM maintenance_mode`,
+	).Scan(&m.Enabled, &m.Message, &m.RetryAfterSeconds, &m.UpdatedAt, &m.UpdatedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Maintenance{RetryAfterSeconds: 300}, nil
+	}
+	return m, err
+}
+
+// MaintenanceUpdate turns maintenance mode on or off. Message and
+// RetryAfter apply when turning it on; RetryAfter defaults to 5 minutes.
+type MaintenanceUpdate struct {
+	Enabled    bool
+	Message    string
+	RetryAfter time.Duration
+}
+
+var errInvalidRetryAfter = errors.New("retry_after must be between 1 second and 24 hours")
+
+// SetMaintenance stores the flag for every replica and audits the change.
+func SetMaintenance(ctx context.Context, db *sql.DB, u MaintenanceUpdate) (Maintenance, error) {
+	if u.RetryAfter == 0 {
+		u.RetryAfter = 5 * time.Minute
+	}
+	if u.RetryAfter < time.Second || u.RetryAfter > 24*time.Hour {
+		return Maintenance{}, errInvalidRetryAfter
+	}
+	if !u.Enabled {
+		u.Message = ""
+	}
+	actor := auditActor(ctx, nil)
+	m := Maintenance{Enabled: u.Enabled, Message: u.Message, RetryAfterSeconds: int(u.RetryAfter / time.Second), UpdatedBy: actor}
+	if err := db.QueryRowContext(ctx, `
+		INSERT INTO maintenance_mode (id, enabled, message, retry_after_seconds, updated_at, updated_by)
+		VALUES (TRUE, $1, $2, $3, now(), $4)
+		ON CONFLICT (id) DO UPDATE
+		SET enabled = EXCLUDED.enabled, message = EXCLUDED.message,
+		    retry_after_seconds = EXCLUDED.retry_after_seconds,
+		    updated_at = EXCLUDED.updated_at, updated_by = EXCLUDED.updated_by
+		RETURNING updated_at`,
+		m.Enabled, m.Message, m.RetryAfterSeconds, m.UpdatedBy,
+	).Scan(&m.UpdatedAt); err != nil {
+		return Maintenance{}, err
+	}
+
+	event := "maintenance_disabled"
+	if m.Enabled {
+		event = "maintenance_enabled"
+	}
+	recordAudit(ctx, event, map[string]string{
+		"message":     m.Message,
+		"retry_after": strconv.Itoa(m.RetryAfterSeconds),
+	})
+	return m, nil
+}
+
+// maintenanceMode caches the flag for the request path. A nil
+// maintenanceMode is never in maintenance.
+type maintenanceMode struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu      sync.Mutex
+	current Maintenance
+	readAt  time.Time
+}
+
+func newMaintenanceMode(db *sql.DB) *maintenanceMode {
+	if db == nil {
+		return nil
+	}
+	return &maintenanceMode{db: db, ttl: maintenanceCacheTTL}
+}
+
+// get returns the flag, read from the database at most once per TTL. When
+// the read fails the last known state stays in effect, so a database
+// outage neither enables nor lifts maintenance.
+func (mm *maintenanceMode) get(ctx context.Context) Maintenance {
+	if mm == nil {
+		return Maintenance{}
+	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if !mm.readAt.IsZero() && time.Since(mm.readAt) < mm.ttl {
+		return mm.current
+	}
+	m, err := GetMaintenance(ctx, mm.db)
+	mm.readAt = time.Now()
+	if err != nil {
+		logFor(ctx, "maintenance").Error("read_failed", errAttr(err))
+		return mm.current
+	}
+	mm.current = m
+	return m
+}
+
+// set replaces the cached flag after a change made by this instance.
+func (mm *maintenanceMode) set(m Maintenance) {
+	if mm == nil {
+		return
+	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.current, mm.readAt = m, time.Now()
+}
+
+// isWrite matches the requests maintenance mode blocks on user routes.
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// isDelete matches the admin requests maintenance mode blocks.
+func isDelete(r *http.Request) bool { return r.Method == http.MethodDelete }
+
+// guard answers requests matched by blocked with 503 while maintenance
+// mode is on, and passes everything else to next.
+func (mm *maintenanceMode) guard(blocked func(*http.Request) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked(r) {
+			if m := mm.get(r.Context()); m.Enabled {
+				writeMaintenance(w, m)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeMaintenance(w http.ResponseWriter, m Maintenance) {
+	w.Header().Set("Retry-After", strconv.Itoa(m.RetryAfterSeconds))
+	http.Error(w, m.message(), http.StatusServiceUnavailable)
+}
+
+// maintenanceRequest is the body of PUT /admin/maintenance.
+type maintenanceRequest struct {
+	Enabled           bool   `json:"enabled"`
+	Message           string `json:"message"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+}
+
+// AdminMaintenanceHandler handles /admin/maintenance: GET returns the flag,
+// PUT sets it.
+func (s *Server) AdminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		m   Maintenance
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		m, err = GetMaintenance(r.Context(), s.db)
+	case http.MethodPut:
+		var req maintenanceRequest
+		if derr := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); derr != nil || req.RetryAfterSeconds < 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		m, err = SetMaintenance(r.Context(), s.db, MaintenanceUpdate{
+			Enabled:    req.Enabled,
+			Message:    req.Message,
+			RetryAfter: time.Duration(req.RetryAfterSeconds) * time.Second,
+		})
+		if errors.Is(err, errInvalidRetryAfter) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err == nil {
+			s.maintenance.set(m)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		logFor(r.Context(), "admin").Error("maintenance_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachedMaintenance returns a maintenanceMode serving m from its cache, so
+// tests never reach the (absent) database.
+func cachedMaintenance(m Maintenance) *maintenanceMode {
+	mm := &maintenanceMode{ttl: time.Hour}
+	mm.set(m)
+	return mm
+}
+
+func TestMaintenanceMode_Nil(t *testing.T) {
+	var mm *maintenanceMode
+	if mm.get(context.Background()).Enabled {
+		t.Error("nil maintenance mode is enabled")
+	}
+	mm.set(Maintenance{Enabled: true}) // no-op
+	if newMaintenanceMode(nil) != nil {
+		t.Error("expected nil without a database")
+	}
+}
+
+func TestMaintenanceGuard(t *testing.T) {
+	on := cachedMaintenance(Maintenance{Enabled: true, Message: "migrating storage", RetryAfterSeconds: 120})
+	off := cachedMaintenance(Maintenance{RetryAfterSeconds: 120})
+
+	var reached atomic.Int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		reached.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		name    string
+		mm      *maintenanceMode
+		blocked func(*http.Request) bool
+		method  string
+		want    int
+	}{
+		{"write blocked", on, isWrite, http.MethodPost, http.StatusServiceUnavailable},
+		{"put blocked", on, isWrite, http.MethodPut, http.StatusServiceUnavailable},
+		{"read allowed", on, isWrite, http.MethodGet, http.StatusNoContent},
+		{"admin delete blocked", on, isDelete, http.MethodDelete, http.StatusServiceUnavailable},
+		{"admin patch allowed", on, isDelete, http.MethodPatch, http.StatusNoContent},
+		{"disabled", off, isWrite, http.MethodPost, http.StatusNoContent},
+		{"nil", nil, isWrite, http.MethodPost, http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := reached.Load()
+			rr := httptest.NewRecorder()
+			tc.mm.guard(tc.blocked, next).ServeHTTP(rr, httptest.NewRequest(tc.method, "/files", nil))
+			if rr.Code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, rr.Code)
+			}
+			if tc.want != http.StatusServiceUnavailable {
+				if reached.Load() != before+1 {
+					t.Error("request did not reach the handler")
+				}
+				return
+			}
+			if reached.Load() != before {
+				t.Error("blocked request reached the handler")
+			}
+			if got := rr.Header().Get("Retry-After"); got != "120" {
+				t.Errorf("Retry-After: %q", got)
+			}
+			if !strings.Contains(rr.Body.String(), "migrating storage") {
+				t.Errorf("body: %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestMaintenance_DefaultMessage(t *testing.T) {
+	rr := httptest.NewRecorder()
+	writeMaintenance(rr, Maintenance{Enabled: true, RetryAfterSeconds: 300})
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "300" {
+		t.Fatalf("got %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rr.Body.String(), "read-only maintenance mode") {
+		t.Errorf("body: %q", rr.Body.String())
+	}
+}
+
+func TestReadyHandler_ReportsMaintenance(t *testing.T) {
+	var runs atomic.Int32
+	h := NewHealthRegistry(0)
+	h.Register(countingCheck("db", true, &runs, nil))
+
+	rr := httptest.NewRecorder()
+	h.readyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rep := decodeHealthReport(t, rr); rep.Maintenance != nil {
+		t.Fatalf("maintenance reported while off: %+v", rep.Maintenance)
+	}
+
+	h.maintenance = cachedMaintenance(Maintenance{Enabled: true, Message: "db upgrade", RetryAfterSeconds: 60})
+	rr = httptest.NewRecorder()
+	h.readyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("maintenance must keep /ready up, got %d", rr.Code)
+	}
+	rep := decodeHealthReport(t, rr)
+	if rep.Maintenance == nil || !rep.Maintenance.Enabled || rep.Maintenance.Message != "db upgrade" {
+		t.Fatalf("maintenance: %+v", rep.Maintenance)
+	}
+}
+
+func TestRunCleanup_SkipsDuringMaintenance(t *testing.T) {
+	// No database: a paused job must not touch it.
+	runCleanup(context.Background(), CleanupConfig{maintenance: cachedMaintenance(Maintenance{Enabled: true})})
+}
+
+func TestSetMaintenance_InvalidRetryAfter(t *testing.T) {
+	if _, err := SetMaintenance(context.Background(), nil, MaintenanceUpdate{Enabled: true, RetryAfter: time.Millisecond}); err != errInvalidRetryAfter {
+		t.Fatalf("expected errInvalidRetryAfter, got %v", err)
+	}
+}
+
+func TestAdminMaintenanceHandler_Validation(t *testing.T) {
+	s := &Server{}
+	rr := httptest.NewRecorder()
+	s.AdminMaintenanceHandler(rr, httptest.NewRequest(http.MethodDelete, "/admin/maintenance", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE: expected 405, got %d", rr.Code)
+	}
+
+	for _, body := range []string{`{`, `{"enabled":true,"retry_after_seconds":-1}`, `{"enabled":true,"retry_after_seconds":999999}`} {
+		rr = httptest.NewRecorder()
+		s.AdminMaintenanceHandler(rr, httptest.NewRequest(http.MethodPut, "/admin/maintenance", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+}
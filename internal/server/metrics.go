@@ -1,79 +1,161 @@
 package server
 
 import (
+	"io"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
 
-// Metrics holds application metrics
+// Histogram bucket upper bounds. Durations are in seconds, sizes in bytes.
+var (
+	// Uploads and downloads of large files run for minutes.
+	transferDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 900, 1800}
+	// 64 KiB/s .. 1 GiB/s in powers of four.
+	throughputBuckets = exponentialBuckets(64<<10, 4, 8)
+	// 1 KiB .. 64 GiB in powers of four.
+	objectSizeBuckets = exponentialBuckets(1<<10, 4, 14)
+	// Hashing streams the whole object from storage, so it scales with size.
+	hashDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900}
+)
+
+// exponentialBuckets returns n bounds starting at start, each factor times
+// the previous one.
+func exponentialBuckets(start, factor float64, n int) []float64 {
+	b := make([]float64, n)
+	for i := range b {
+		b[i] = start
+		start *= factor
+	}
+	return b
+}
+
+// File statuses tracked by sfd_file_transitions_total. "deleted" covers
+// removals by users, admins and the cleanup job.
+var fileStatuses = []string{"pending", "stored", "hashed", "ready", "failed", "deleted"}
+
+// Metrics is a registry of application metrics. Create one with NewMetrics
+// and pass it to the components that record into it (Config.Registry wires
+// the server); all methods are safe on a nil *Metrics and then do nothing,
+// so components built without a registry need no special casing.
 type Metrics struct {
 	mu sync.RWMutex
 
 	// Upload metrics
-	uploadsTotal        int64
-	uploadBytesTotal    int64
-	uploadErrorsTotal   int64
-	uploadDurationTotal time.Duration
+	uploadsTotal      int64
+	uploadBytesTotal  int64
+	uploadErrorsTotal int64
+	uploadDuration    *histogram
+	uploadThroughput  *histogram
+	objectSize        *histogram
+	hashDuration      *histogram
 
 	// Download metrics
-	downloadsTotal        int64
-	downloadBytesTotal    int64
-	downloadErrorsTotal   int64
-	downloadDurationTotal time.Duration
+	downloadsTotal      int64
+	downloadBytesTotal  int64
+	downloadErrorsTotal int64
+	downloadDuration    *histogram
 
 	// Auth metrics
 	loginAttemptsTotal  int64
 	loginSuccessTotal   int64
 	loginFailuresTotal  int64
+	loginLockoutsTotal  int64
 	activeSessionsTotal int64
 
-	// File lifecycle metrics
-	filesPendingTotal int64
-	filesStoredTotal  int64
-	filesHashedTotal  int64
-	filesReadyTotal   int64
-	filesFailedTotal  int64
+	// File lifecycle metrics, by new status
+	fileTransitions map[string]int64
 
 	// System metrics
 	requestsTotal    int64
 	requestErrors5xx int64
 	requestErrors4xx int64
+
+	// Per-route HTTP metrics, keyed by label values
+	requests        map[requestKey]int64
+	requestDuration map[routeKey]*histogram
 }
 
-var globalMetrics = &Metrics{}
+// requestKey identifies an http_requests_total series.
+type requestKey struct {
+	method, route string
+	code          int
+}
 
-// GetMetrics returns the global metrics instance
-func GetMetrics() *Metrics {
-	return globalMetrics
+// routeKey identifies an http_request_duration_seconds series.
+type routeKey struct {
+	method, route string
 }
 
-// RecordUpload records a successful upload
+// NewMetrics returns an empty registry.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		uploadDuration:   newHistogram(transferDurationBuckets),
+		uploadThroughput: newHistogram(throughputBuckets),
+		objectSize:       newHistogram(objectSizeBuckets),
+		hashDuration:     newHistogram(hashDurationBuckets),
+		downloadDuration: newHistogram(transferDurationBuckets),
+		fileTransitions:  make(map[string]int64),
+		requests:         make(map[requestKey]int64),
+		requestDuration:  make(map[routeKey]*histogram),
+	}
+}
+
+// RecordUpload records a successful upload of bytes taking duration,
+// including its throughput and the stored object's size.
 func (m *Metrics) RecordUpload(bytes int64, duration time.Duration) {
+	if m == nil {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.uploadsTotal++
 	m.uploadBytesTotal += bytes
-	m.uploadDurationTotal += duration
+	m.uploadDuration.observe(duration.Seconds())
+	if s := duration.Seconds(); s > 0 {
+		m.uploadThroughput.observe(float64(bytes) / s)
+	}
+	m.objectSize.observe(float64(bytes))
 }
 
 // RecordUploadError records an upload error
 func (m *Metrics) RecordUploadError() {
+	if m == nil {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.uploadErrorsTotal++
 }
 
+// RecordHash records how long hashing a stored object took.
+func (m *Metrics) RecordHash(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hashDuration.observe(duration.Seconds())
+}
+
 // RecordDownload records a successful download
 func (m *Metrics) RecordDownload(bytes int64, duration time.Duration) {
+	if m == nil {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.downloadsTotal++
 	m.downloadBytesTotal += bytes
-	m.downloadDurationTotal += duration
+	m.downloadDuration.observe(duration.Seconds())
 }
 
 // RecordDownloadError records a download error
 func (m *Metrics) RecordDownloadError() {
+	if m == nil {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.downloadErrorsTotal++
@@ -81,6 +163,9 @@ func (m *Metrics) RecordDownloadError() {
 
 // RecordLoginAttempt records a login attempt
 func (m *Metrics) RecordLoginAttempt(success bool) {
+	if m == nil {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.loginAttemptsTotal++
@@ -91,38 +176,60 @@ func (m *Metrics) RecordLoginAttempt(success bool) {
 	}
 }
 
+// RecordLoginLockout records an account or IP being locked out
+func (m *Metrics) RecordLoginLockout() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loginLockoutsTotal++
+}
+
 // SetActiveSessions sets the current active sessions count
 func (m *Metrics) SetActiveSessions(count int64) {
+	if m == nil {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.activeSessionsTotal = count
 }
 
-// RecordFileStateTransition records a file state change
+// RecordFileStateTransition records a file entering newState. Unknown states
+// are ignored to keep the label set fixed.
 func (m *Metrics) RecordFileStateTransition(newState string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	switch newState {
-	case "pending":
-		m.filesPendingTotal++
-	case "stored":
-		m.filesStoredTotal++
-	case "hashed":
-		m.filesHashedTotal++
-	case "ready":
-		m.filesReadyTotal++
-	case "failed":
-		m.filesFailedTotal++
+	if m == nil {
+		return
+	}
+	for _, s := range fileStatuses {
+		if s == newState {
+			m.mu.Lock()
+			m.fileTransitions[newState]++
+			m.mu.Unlock()
+			return
+		}
 	}
 }
 
-// RecordRequest records an HTTP request
-func (m *Metrics) RecordRequest(statusCode int) {
+// RecordRequest records an HTTP request and its latency
+func (m *Metrics) RecordRequest(method, route string, statusCode int, duration time.Duration) {
+	if m == nil {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.requestsTotal++
 
+	m.requests[requestKey{method, route, statusCode}]++
+	rk := routeKey{method, route}
+	h := m.requestDuration[rk]
+	if h == nil {
+		h = newHistogram(requestDurationBuckets)
+		m.requestDuration[rk] = h
+	}
+	h.observe(duration.Seconds())
+
 	if statusCode >= 500 {
 		m.requestErrors5xx++
 	} else if statusCode >= 400 {
@@ -132,6 +239,9 @@ func (m *Metrics) RecordRequest(statusCode int) {
 
 // Snapshot returns a snapshot of current metrics
 func (m *Metrics) Snapshot() MetricsSnapshot {
+	if m == nil {
+		return MetricsSnapshot{}
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -139,27 +249,31 @@ func (m *Metrics) Snapshot() MetricsSnapshot {
 		UploadsTotal:          m.uploadsTotal,
 		UploadBytesTotal:      m.uploadBytesTotal,
 		UploadErrorsTotal:     m.uploadErrorsTotal,
-		UploadAvgDurationMs:   avgDuration(m.uploadDurationTotal, m.uploadsTotal),
+		UploadAvgDurationMs:   m.uploadDuration.meanMillis(),
 		DownloadsTotal:        m.downloadsTotal,
 		DownloadBytesTotal:    m.downloadBytesTotal,
 		DownloadErrorsTotal:   m.downloadErrorsTotal,
-		DownloadAvgDurationMs: avgDuration(m.downloadDurationTotal, m.downloadsTotal),
+		DownloadAvgDurationMs: m.downloadDuration.meanMillis(),
 		LoginAttemptsTotal:    m.loginAttemptsTotal,
 		LoginSuccessTotal:     m.loginSuccessTotal,
 		LoginFailuresTotal:    m.loginFailuresTotal,
+		LoginLockoutsTotal:    m.loginLockoutsTotal,
 		ActiveSessionsTotal:   m.activeSessionsTotal,
-		FilesPendingTotal:     m.filesPendingTotal,
-		FilesStoredTotal:      m.filesStoredTotal,
-		FilesHashedTotal:      m.filesHashedTotal,
-		FilesReadyTotal:       m.filesReadyTotal,
-		FilesFailedTotal:      m.filesFailedTotal,
+		FilesPendingTotal:     m.fileTransitions["pending"],
+		FilesStoredTotal:      m.fileTransitions["stored"],
+		FilesHashedTotal:      m.fileTransitions["hashed"],
+		FilesReadyTotal:       m.fileTransitions["ready"],
+		FilesFailedTotal:      m.fileTransitions["failed"],
+		FilesDeletedTotal:     m.fileTransitions["deleted"],
 		RequestsTotal:         m.requestsTotal,
 		RequestErrors5xx:      m.requestErrors5xx,
 		RequestErrors4xx:      m.requestErrors4xx,
 	}
 }
 
-// MetricsSnapshot represents a point-in-time snapshot of metrics
+// MetricsSnapshot represents a point-in-time snapshot of metrics for the
+// admin dashboard. Averages are derived from the histograms; use /metrics
+// for distributions.
 type MetricsSnapshot struct {
 	// Upload metrics
 	UploadsTotal        int64   `json:"uploads_total"`
@@ -177,6 +291,7 @@ type MetricsSnapshot struct {
 	LoginAttemptsTotal  int64 `json:"login_attempts_total"`
 	LoginSuccessTotal   int64 `json:"login_success_total"`
 	LoginFailuresTotal  int64 `json:"login_failures_total"`
+	LoginLockoutsTotal  int64 `json:"login_lockouts_total"`
 	ActiveSessionsTotal int64 `json:"active_sessions_total"`
 
 	// File lifecycle metrics
@@ -185,6 +300,7 @@ type MetricsSnapshot struct {
 	FilesHashedTotal  int64 `json:"files_hashed_total"`
 	FilesReadyTotal   int64 `json:"files_ready_total"`
 	FilesFailedTotal  int64 `json:"files_failed_total"`
+	FilesDeletedTotal int64 `json:"files_deleted_total"`
 
 	// System metrics
 	RequestsTotal    int64 `json:"requests_total"`
@@ -192,9 +308,84 @@ type MetricsSnapshot struct {
 	RequestErrors4xx int64 `json:"request_errors_4xx"`
 }
 
-func avgDuration(total time.Duration, count int64) float64 {
-	if count == 0 {
-		return 0
+// WritePrometheus writes all metrics in Prometheus text format, or in
+// OpenMetrics format when openMetrics is set.
+func (m *Metrics) WritePrometheus(w io.Writer, openMetrics bool) error {
+	if m == nil {
+		m = NewMetrics()
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := newPromWriter(w, openMetrics)
+	counter := func(name, help string, v int64) {
+		p.family(name, "counter", help)
+		p.sample(name, nil, float64(v))
+	}
+	hist := func(name, help string, h *histogram) {
+		p.family(name, "histogram", help)
+		p.histogram(name, nil, h)
 	}
-	return float64(total.Milliseconds()) / float64(count)
+
+	counter("sfd_uploads_total", "Completed uploads.", m.uploadsTotal)
+	counter("sfd_upload_bytes_total", "Bytes received in completed uploads.", m.uploadBytesTotal)
+	counter("sfd_upload_errors_total", "Failed uploads.", m.uploadErrorsTotal)
+	hist("sfd_upload_duration_seconds", "Time from request to hashed file for completed uploads.", m.uploadDuration)
+	hist("sfd_upload_throughput_bytes_per_second", "Upload throughput of completed uploads.", m.uploadThroughput)
+	hist("sfd_object_size_bytes", "Size of stored objects.", m.objectSize)
+	hist("sfd_hash_duration_seconds", "Time spent computing SHA-256 of stored objects.", m.hashDuration)
+	counter("sfd_downloads_total", "Completed downloads.", m.downloadsTotal)
+	counter("sfd_download_bytes_total", "Bytes sent in completed downloads.", m.downloadBytesTotal)
+	counter("sfd_download_errors_total", "Failed downloads.", m.downloadErrorsTotal)
+	hist("sfd_download_duration_seconds", "Duration of completed downloads.", m.downloadDuration)
+	counter("sfd_login_success_total", "Successful logins.", m.loginSuccessTotal)
+	counter("sfd_login_failures_total", "Failed logins.", m.loginFailuresTotal)
+	counter("sfd_login_lockouts_total", "Accounts or addresses locked out after repeated failures.", m.loginLockoutsTotal)
+
+	p.family("sfd_active_sessions", "gauge", "Active sessions.")
+	p.sample("sfd_active_sessions", nil, float64(m.activeSessionsTotal))
+
+	p.family("sfd_file_transitions_total", "counter", "File status transitions by new status.")
+	for _, s := range fileStatuses {
+		p.sample("sfd_file_transitions_total", []label{{"status", s}}, float64(m.fileTransitions[s]))
+	}
+
+	reqKeys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		a, b := reqKeys[i], reqKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	p.family("http_requests_total", "counter", "HTTP requests by method, route and status code.")
+	for _, k := range reqKeys {
+		p.sample("http_requests_total",
+			[]label{{"method", k.method}, {"route", k.route}, {"code", strconv.Itoa(k.code)}},
+			float64(m.requests[k]))
+	}
+
+	durKeys := make([]routeKey, 0, len(m.requestDuration))
+	for k := range m.requestDuration {
+		durKeys = append(durKeys, k)
+	}
+	sort.Slice(durKeys, func(i, j int) bool {
+		if durKeys[i].route != durKeys[j].route {
+			return durKeys[i].route < durKeys[j].route
+		}
+		return durKeys[i].method < durKeys[j].method
+	})
+	p.family("http_request_duration_seconds", "histogram", "HTTP request latency by method and route.")
+	for _, k := range durKeys {
+		p.histogram("http_request_duration_seconds",
+			[]label{{"method", k.method}, {"route", k.route}}, m.requestDuration[k])
+	}
+
+	return p.finish()
 }
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_NilRegistryIsNoop(t *testing.T) {
+	var m *Metrics
+	m.RecordUpload(1, time.Second)
+	m.RecordUploadError()
+	m.RecordHash(time.Second)
+	m.RecordDownload(1, time.Second)
+	m.RecordDownloadError()
+	m.RecordLoginAttempt(true)
+	m.RecordLoginLockout()
+	m.RecordFileStateTransition("hashed")
+	m.RecordRequest("GET", "/", 200, time.Millisecond)
+	if s := m.Snapshot(); s != (MetricsSnapshot{}) {
+		t.Fatalf("nil registry snapshot should be empty: %+v", s)
+	}
+}
+
+func TestMetrics_UploadHistograms(t *testing.T) {
+	m := NewMetrics()
+	m.RecordUpload(8<<20, 2*time.Second) // 8 MiB at 4 MiB/s
+	m.RecordHash(300 * time.Millisecond)
+
+	s := m.Snapshot()
+	if s.UploadsTotal != 1 || s.UploadBytesTotal != 8<<20 {
+		t.Fatalf("unexpected upload counters: %+v", s)
+	}
+	if s.UploadAvgDurationMs != 2000 {
+		t.Fatalf("avg duration = %v, want 2000", s.UploadAvgDurationMs)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`sfd_upload_duration_seconds_bucket{le="2.5"} 1`,
+		`sfd_upload_duration_seconds_bucket{le="1"} 0`,
+		`sfd_upload_throughput_bytes_per_second_bucket{le="4.194304e+06"} 1`,
+		`sfd_upload_throughput_bytes_per_second_bucket{le="1.048576e+06"} 0`,
+		`sfd_object_size_bytes_bucket{le="1.6777216e+07"} 1`,
+		`sfd_object_size_bytes_sum 8.388608e+06`,
+		`sfd_hash_duration_seconds_bucket{le="0.5"} 1`,
+		`sfd_hash_duration_seconds_count 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+}
+
+func TestMetrics_FileTransitions(t *testing.T) {
+	m := NewMetrics()
+	for _, s := range []string{"pending", "stored", "hashed", "deleted", "bogus"} {
+		m.RecordFileStateTransition(s)
+	}
+	s := m.Snapshot()
+	if s.FilesPendingTotal != 1 || s.FilesStoredTotal != 1 || s.FilesHashedTotal != 1 || s.FilesDeletedTotal != 1 {
+		t.Fatalf("unexpected transitions: %+v", s)
+	}
+
+	var buf bytes.Buffer
+	_ = m.WritePrometheus(&buf, false)
+	if strings.Contains(buf.String(), "bogus") {
+		t.Error("unknown statuses must not become label values")
+	}
+}
+
+func TestLoginHandler_RecordsMetrics(t *testing.T) {
+	m := NewMetrics()
+	a := AuthConfig{SessionSecret: "s", Metrics: m}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"x","password":"y"}`))
+	rr := httptest.NewRecorder()
+	a.loginHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+	if s := m.Snapshot(); s.LoginAttemptsTotal != 1 || s.LoginFailuresTotal != 1 {
+		t.Fatalf("login failure not recorded: %+v", s)
+	}
+}
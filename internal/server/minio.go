@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"os"
 	"strings"
 
 	"github.com/minio/minio-go/v7"
@@ -40,17 +39,21 @@ func normaliseEndpoint(raw string) (endpoint string, secure bool, err error) {
 	return raw, false, nil
 }
 
-func newMinioClient() (*minio.Client, string, error) {
-	rawEndpoint := os.Getenv("SFD_S3_ENDPOINT")
-	accessKey := os.Getenv("SFD_S3_ACCESS_KEY")
-	secretKey := os.Getenv("SFD_S3_SECRET_KEY")
-	bucket := os.Getenv("SFD_BUCKET")
+// StorageConfig locates the S3-compatible object store and its bucket.
+type StorageConfig struct {
+	Endpoint  string // "minio:9000" or "http(s)://host:port"
+	AccessKey string
+	SecretKey string
+	Bucket    string
+}
 
-	if rawEndpoint == "" || accessKey == "" || secretKey == "" || bucket == "" {
+func newMinioClient(sc StorageConfig) (*minio.Client, string, error) {
+	accessKey, secretKey, bucket := sc.AccessKey, sc.SecretKey, sc.Bucket
+	if sc.Endpoint == "" || accessKey == "" || secretKey == "" || bucket == "" {
 		return nil, "", fmt.Errorf("minio configuration incomplete")
 	}
 
-	endpoint, secure, err := normaliseEndpoint(rawEndpoint)
+	endpoint, secure, err := normaliseEndpoint(sc.Endpoint)
 	if err != nil {
 		return nil, "", err
 	}
@@ -74,3 +77,10 @@ func newMinioClient() (*minio.Client, string, error) {
 
 	return client, bucket, nil
 }
+
+// OpenStorage connects to the object store and checks that the bucket
+// exists. It is what tools outside the server (sfdctl) use to reach it.
+func OpenStorage(sc StorageConfig) (*minio.Client, error) {
+	mc, _, err := newMinioClient(sc)
+	return mc, err
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification kinds.
+const (
+	notifyFileShared = "file_shared"
+)
+
+// Notification is an in-app message for a user.
+type Notification struct {
+	ID        string     `json:"id"`
+	Kind      string     `json:"kind"`
+	Message   string     `json:"message"`
+	FileID    string     `json:"file_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// NotificationList is the response of GET /notifications.
+type NotificationList struct {
+	Notifications []Notification `json:"notifications"`
+	Unread        int            `json:"unread"`
+	Limit         int            `json:"limit"`
+	Offset        int            `json:"offset"`
+}
+
+// notifyUser stores an in-app notification for userID. fileID may be empty.
+func notifyUser(ctx context.Context, db *sql.DB, userID, kind, message, fileID string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notifications (id, user_id, kind, message, file_id)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New(), userID, kind, message, sql.NullString{String: fileID, Valid: fileID != ""})
+	return err
+}
+
+// notificationsHandler handles GET /notifications?unread=true&limit=&offset=
+// for the caller, newest first, with the total unread count.
+func (cfg Config) notificationsHandler(db *sql.DB) http.Handler {
+	return cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		subject := PrincipalFromContext(r.Context()).Subject
+		limit, offset := parsePagination(r.URL.Query())
+		unreadOnly := r.URL.Query().Get("unread") == "true"
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT id, kind, message, COALESCE(file_id::text, ''), created_at, read_at
+			FROM notifications
+			WHERE user_id::text = $1 AND (NOT $2 OR read_at IS NULL)
+			ORDER BY created_at DESC
+			LIMIT $3 OFFSET $4
+		`, subject, unreadOnly, limit, offset)
+		if err != nil {
+			logFor(r.Context(), "notifications").Error("list_failed", errAttr(err))
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := NotificationList{Notifications: []Notification{}, Limit: limit, Offset: offset}
+		for rows.Next() {
+			var (
+				n    Notification
+				read sql.NullTime
+			)
+			if err := rows.Scan(&n.ID, &n.Kind, &n.Message, &n.FileID, &n.CreatedAt, &read); err != nil {
+				logFor(r.Context(), "notifications").Error("scan_failed", errAttr(err))
+				continue
+			}
+			if read.Valid {
+				n.ReadAt = &read.Time
+			}
+			out.Notifications = append(out.Notifications, n)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := db.QueryRowContext(r.Context(),
+			`SELECT count(*) FROM notifications WHERE user_id::text = $1 AND read_at IS NULL`, subject,
+		).Scan(&out.Unread); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+}
+
+// notificationsReadHandler handles POST /notifications/read {"ids": [...]}.
+// An empty or missing ids list marks all of the caller's notifications read.
+func (cfg Config) notificationsReadHandler(db *sql.DB) http.Handler {
+	return cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		for _, id := range req.IDs {
+			if _, err := uuid.Parse(id); err != nil {
+				http.Error(w, "bad id", http.StatusBadRequest)
+				return
+			}
+		}
+
+		subject := PrincipalFromContext(r.Context()).Subject
+		var (
+			res sql.Result
+			err error
+		)
+		if len(req.IDs) == 0 {
+			res, err = db.ExecContext(r.Context(),
+				`UPDATE notifications SET read_at = now() WHERE user_id::text = $1 AND read_at IS NULL`, subject)
+		} else {
+			res, err = db.ExecContext(r.Context(),
+				`UPDATE notifications SET read_at = now()
+				 WHERE user_id::text = $1 AND read_at IS NULL AND id::text = ANY($2)`, subject, req.IDs)
+		}
+		if err != nil {
+			logFor(r.Context(), "notifications").Error("mark_read_failed", errAttr(err))
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		n, _ := res.RowsAffected()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]int64{"marked": n})
+	}))
+}
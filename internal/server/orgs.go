@@ -0,0 +1,685 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Organization membership roles. Owners manage members and settings;
+// members upload, link and delete org files; viewers list and link.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleMember = "member"
+	OrgRoleViewer = "viewer"
+)
+
+func validOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleMember || role == OrgRoleViewer
+}
+
+// OrgPolicy holds per-organization defaults applied to org files. Zero values
+// mean "no org-specific limit".
+type OrgPolicy struct {
+	MaxLinkTTLSeconds   int      `json:"max_link_ttl_seconds,omitempty"`
+	AllowedContentTypes []string `json:"allowed_content_types,omitempty"`
+	RetentionDays       int      `json:"retention_days,omitempty"`
+}
+
+// linkTTL applies the org cap on top of the global clampTTLSeconds bounds.
+func (p OrgPolicy) linkTTL(requested int) int {
+	ttl := clampTTLSeconds(requested)
+	if p.MaxLinkTTLSeconds > 0 && ttl > p.MaxLinkTTLSeconds {
+		ttl = p.MaxLinkTTLSeconds
+	}
+	return ttl
+}
+
+// Organization is the API representation of an organization.
+type Organization struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Slug      string      `json:"slug"`
+	CreatedAt time.Time   `json:"created_at"`
+	Role      string      `json:"role,omitempty"` // the caller's membership role
+	Policy    OrgPolicy   `json:"policy"`
+	Members   []OrgMember `json:"members,omitempty"`
+}
+
+// OrgMember is a membership entry.
+type OrgMember struct {
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify derives a URL-friendly identifier from an organization name.
+func slugify(name string) string {
+	s := slugInvalid.ReplaceAllString(strings.ToLower(name), "-")
+	s = strings.Trim(s, "-")
+	if len(s) > 50 {
+		s = strings.TrimRight(s[:50], "-")
+	}
+	return s
+}
+
+// loadOrgPolicy reads the policy columns of an organization.
+func loadOrgPolicy(ctx context.Context, q queryRower, orgID string) (OrgPolicy, error) {
+	var (
+		p       OrgPolicy
+		ttl     sql.NullInt64
+		types   sql.NullString
+		retDays sql.NullInt64
+	)
+	err := q.QueryRowContext(ctx,
+		`SELECT max_link_ttl_seconds, allowed_content_types, retention_days FROM organizations WHERE id = $1`,
+		orgID,
+	).Scan(&ttl, &types, &retDays)
+	if err != nil {
+		return p, err
+	}
+	p.MaxLinkTTLSeconds = int(ttl.Int64)
+	p.AllowedContentTypes = strings.Fields(types.String)
+	p.RetentionDays = int(retDays.Int64)
+	return p, nil
+}
+
+// orgRole returns subject's role in orgID, or "" when not a member.
+func orgRole(ctx context.Context, db *sql.DB, orgID, subject string) (string, error) {
+	var role string
+	err := db.QueryRowContext(ctx,
+		`SELECT role FROM org_members WHERE org_id = $1 AND user_id::text = $2`,
+		orgID, subject,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// orgsHandler handles GET /orgs (organizations the caller belongs to) and
+// POST /orgs {"name": "..."} (create; the caller becomes owner).
+//
+// Reads accept API tokens; changes require a session, like token management.
+func (cfg Config) orgsHandler(db *sql.DB) http.Handler {
+	return cfg.orgAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listOrgs(w, r, db)
+		case http.MethodPost:
+			createOrg(w, r, db)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+// orgAuth requires authentication for reads and a session for changes.
+func (cfg Config) orgAuth(next http.Handler) http.Handler {
+	read := cfg.Auth.requireAuth(next)
+	write := cfg.Auth.requireSession(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			read.ServeHTTP(w, r)
+			return
+		}
+		write.ServeHTTP(w, r)
+	})
+}
+
+func listOrgs(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT o.id, o.name, o.slug, o.created_at, m.role
+		FROM organizations o
+		JOIN org_members m ON m.org_id = o.id
+		WHERE m.user_id::text = $1
+		ORDER BY o.name
+	`, PrincipalFromContext(r.Context()).Subject)
+	if err != nil {
+		logFor(r.Context(), "orgs").Error("list_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt, &o.Role); err != nil {
+			logFor(r.Context(), "orgs").Error("scan_failed", errAttr(err))
+			continue
+		}
+		orgs = append(orgs, o)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(orgs)
+}
+
+func createOrg(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var req struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "bad name", http.StatusBadRequest)
+		return
+	}
+	slug := slugify(req.Slug)
+	if slug == "" {
+		slug = slugify(req.Name)
+	}
+	if slug == "" {
+		http.Error(w, "bad slug", http.StatusBadRequest)
+		return
+	}
+
+	subject := PrincipalFromContext(r.Context()).Subject
+	if _, err := uuid.Parse(subject); err != nil {
+		http.Error(w, "not a registered user", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	org := Organization{ID: uuid.New().String(), Name: req.Name, Slug: slug, Role: OrgRoleOwner}
+	err = tx.QueryRowContext(r.Context(), `
+		INSERT INTO organizations (id, name, slug, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`, org.ID, org.Name, org.Slug, subject).Scan(&org.CreatedAt)
+	if err != nil {
+		if pgErrorCode(err) == pgUniqueViolation {
+			http.Error(w, "slug already taken", http.StatusConflict)
+			return
+		}
+		logFor(r.Context(), "orgs").Error("create_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(),
+		`INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, 'owner')`,
+		org.ID, subject,
+	); err != nil {
+		logFor(r.Context(), "orgs").Error("owner_insert_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r.Context(), "org_created", map[string]string{
+		"actor":  subject,
+		"org_id": org.ID,
+		"slug":   org.Slug,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(org)
+}
+
+// orgHandler handles the per-organization endpoints:
+//
+//	GET    /orgs/{id}                      details, policy and members (viewer)
+//	PATCH  /orgs/{id}                      name and policy (owner)
+//	DELETE /orgs/{id}                      only when it owns no files (owner)
+//	GET    /orgs/{id}/files                org files (viewer)
+//	POST   /orgs/{id}/members              {"user": "<username or email>", "role": "..."} (owner)
+//	PATCH  /orgs/{id}/members/{user_id}    {"role": "..."} (owner)
+//	DELETE /orgs/{id}/members/{user_id}    (owner, or the member leaving)
+func (cfg Config) orgHandler(db *sql.DB) http.Handler {
+	return cfg.orgAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/"), "/")
+		if _, err := uuid.Parse(parts[0]); err != nil || len(parts) > 3 {
+			http.NotFound(w, r)
+			return
+		}
+		orgID := parts[0]
+
+		p := PrincipalFromContext(r.Context())
+		role, err := orgRole(r.Context(), db, orgID, p.Subject)
+		if err != nil {
+			logFor(r.Context(), "orgs").Error("role_lookup_failed", errAttr(err))
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if role == "" && p.Role == RoleAdmin {
+			role = OrgRoleOwner
+		}
+		if role == "" {
+			// Do not reveal whether the organization exists.
+			http.NotFound(w, r)
+			return
+		}
+
+		sub := ""
+		if len(parts) > 1 {
+			sub = parts[1]
+		}
+		switch {
+		case sub == "" && r.Method == http.MethodGet:
+			getOrg(w, r, db, orgID, role)
+		case sub == "" && r.Method == http.MethodPatch:
+			requireOrgRole(w, role, OrgRoleOwner, func() { updateOrg(w, r, db, orgID) })
+		case sub == "" && r.Method == http.MethodDelete:
+			requireOrgRole(w, role, OrgRoleOwner, func() { deleteOrg(w, r, db, orgID) })
+		case sub == "files" && len(parts) == 2 && r.Method == http.MethodGet:
+			listOrgFiles(w, r, db, orgID)
+		case sub == "members" && len(parts) == 2 && r.Method == http.MethodPost:
+			requireOrgRole(w, role, OrgRoleOwner, func() { addOrgMember(w, r, db, orgID) })
+		case sub == "members" && len(parts) == 3:
+			memberID := parts[2]
+			if _, err := uuid.Parse(memberID); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			switch r.Method {
+			case http.MethodPatch:
+				requireOrgRole(w, role, OrgRoleOwner, func() { setOrgMemberRole(w, r, db, orgID, memberID) })
+			case http.MethodDelete:
+				if memberID == p.Subject {
+					removeOrgMember(w, r, db, orgID, memberID)
+					return
+				}
+				requireOrgRole(w, role, OrgRoleOwner, func() { removeOrgMember(w, r, db, orgID, memberID) })
+			default:
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		case sub == "" || sub == "files" || sub == "members":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// requireOrgRole runs fn when have is at least want.
+func requireOrgRole(w http.ResponseWriter, have, want string, fn func()) {
+	if orgRolePerm(have) < orgRolePerm(want) {
+		http.Error(w, "insufficient organization role", http.StatusForbidden)
+		return
+	}
+	fn()
+}
+
+func getOrg(w http.ResponseWriter, r *http.Request, db *sql.DB, orgID, role string) {
+	org := Organization{ID: orgID, Role: role, Members: []OrgMember{}}
+	err := db.QueryRowContext(r.Context(),
+		`SELECT name, slug, created_at FROM organizations WHERE id = $1`, orgID,
+	).Scan(&org.Name, &org.Slug, &org.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if org.Policy, err = loadOrgPolicy(r.Context(), db, orgID); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT u.id, u.username, u.email, m.role, m.created_at
+		FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY u.username
+	`, orgID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m OrgMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			continue
+		}
+		org.Members = append(org.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(org)
+}
+
+// updateOrgReq uses pointers so that omitted fields stay unchanged; a zero
+// or empty policy value clears the limit.
+type updateOrgReq struct {
+	Name                *string   `json:"name"`
+	MaxLinkTTLSeconds   *int      `json:"max_link_ttl_seconds"`
+	AllowedContentTypes *[]string `json:"allowed_content_types"`
+	RetentionDays       *int      `json:"retention_days"`
+}
+
+func nullIfZero(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n > 0}
+}
+
+func updateOrg(w http.ResponseWriter, r *http.Request, db *sql.DB, orgID string) {
+	var req updateOrgReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		sets []string
+		args = []any{orgID}
+	)
+	add := func(col string, v any) {
+		args = append(args, v)
+		sets = append(sets, col+" = $"+strconv.Itoa(len(args)))
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > 100 {
+			http.Error(w, "bad name", http.StatusBadRequest)
+			return
+		}
+		add("name", name)
+	}
+	if req.MaxLinkTTLSeconds != nil {
+		if *req.MaxLinkTTLSeconds < 0 {
+			http.Error(w, "bad max_link_ttl_seconds", http.StatusBadRequest)
+			return
+		}
+		add("max_link_ttl_seconds", nullIfZero(*req.MaxLinkTTLSeconds))
+	}
+	if req.AllowedContentTypes != nil {
+		var types []string
+		for _, t := range *req.AllowedContentTypes {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t == "" {
+				continue
+			}
+			if strings.ContainsAny(t, " \t,") || !strings.Contains(t, "/") {
+				http.Error(w, "bad allowed_content_types", http.StatusBadRequest)
+				return
+			}
+			types = append(types, t)
+		}
+		add("allowed_content_types", sql.NullString{String: strings.Join(types, " "), Valid: len(types) > 0})
+	}
+	if req.RetentionDays != nil {
+		if *req.RetentionDays < 0 {
+			http.Error(w, "bad retention_days", http.StatusBadRequest)
+			return
+		}
+		add("retention_days", nullIfZero(*req.RetentionDays))
+	}
+	if len(sets) == 0 {
+		http.Error(w, "nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.ExecContext(r.Context(),
+		`UPDATE organizations SET `+strings.Join(sets, ", ")+` WHERE id = $1`, args...,
+	); err != nil {
+		logFor(r.Context(), "orgs").Error("update_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r.Context(), "org_updated", map[string]string{
+		"actor":  PrincipalFromContext(r.Context()).Subject,
+		"org_id": orgID,
+	})
+	getOrg(w, r, db, orgID, OrgRoleOwner)
+}
+
+func deleteOrg(w http.ResponseWriter, r *http.Request, db *sql.DB, orgID string) {
+	_, err := db.ExecContext(r.Context(), `DELETE FROM organizations WHERE id = $1`, orgID)
+	if err != nil {
+		if pgErrorCode(err) == pgForeignKeyViolation {
+			http.Error(w, "organization still owns files", http.StatusConflict)
+			return
+		}
+		logFor(r.Context(), "orgs").Error("delete_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r.Context(), "org_deleted", map[string]string{
+		"actor":  PrincipalFromContext(r.Context()).Subject,
+		"org_id": orgID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OrgFileInfo is a file in an organization listing.
+type OrgFileInfo struct {
+	ID          string     `json:"id"`
+	OrigName    string     `json:"orig_name"`
+	ContentType string     `json:"content_type"`
+	SizeBytes   int64      `json:"size_bytes"`
+	Status      string     `json:"status"`
+	SHA256Hex   string     `json:"sha256_hex,omitempty"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func listOrgFiles(w http.ResponseWriter, r *http.Request, db *sql.DB, orgID string) {
+	limit, offset := parsePagination(r.URL.Query())
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT id, orig_name, content_type, size_bytes, status,
+		       COALESCE(sha256_hex, ''), created_by, created_at, expires_at
+		FROM files
+		WHERE org_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, orgID, limit, offset)
+	if err != nil {
+		logFor(r.Context(), "orgs").Error("list_files_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	files := []OrgFileInfo{}
+	for rows.Next() {
+		var (
+			f       OrgFileInfo
+			expires sql.NullTime
+		)
+		if err := rows.Scan(&f.ID, &f.OrigName, &f.ContentType, &f.SizeBytes, &f.Status,
+			&f.SHA256Hex, &f.CreatedBy, &f.CreatedAt, &expires); err != nil {
+			logFor(r.Context(), "orgs").Error("scan_file_failed", errAttr(err))
+			continue
+		}
+		if expires.Valid {
+			f.ExpiresAt = &expires.Time
+		}
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(files)
+}
+
+func addOrgMember(w http.ResponseWriter, r *http.Request, db *sql.DB, orgID string) {
+	var req struct {
+		User string `json:"user"`
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = OrgRoleMember
+	}
+	if !validOrgRole(req.Role) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+
+	var userID string
+	err := db.QueryRowContext(r.Context(),
+		`SELECT id FROM users WHERE (username = $1 OR email = lower($1)) AND is_active = TRUE`,
+		strings.TrimSpace(req.User),
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := db.ExecContext(r.Context(), `
+		INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO NOTHING
+	`, orgID, userID, req.Role)
+	if err != nil {
+		logFor(r.Context(), "orgs").Error("add_member_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "already a member", http.StatusConflict)
+		return
+	}
+
+	recordAudit(r.Context(), "org_member_added", map[string]string{
+		"actor":   PrincipalFromContext(r.Context()).Subject,
+		"org_id":  orgID,
+		"user_id": userID,
+		"role":    req.Role,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"user_id": userID,
+		"role":    req.Role,
+	})
+}
+
+// lastOwner reports whether userID is the only owner of orgID, in which case
+// demoting or removing them would orphan the organization.
+func lastOwner(ctx context.Context, db *sql.DB, orgID, userID string) (bool, error) {
+	var last bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM org_members WHERE org_id = $1 AND user_id::text = $2 AND role = 'owner')
+		   AND (SELECT COUNT(*) FROM org_members WHERE org_id = $1 AND role = 'owner') = 1
+	`, orgID, userID).Scan(&last)
+	return last, err
+}
+
+func setOrgMemberRole(w http.ResponseWriter, r *http.Request, db *sql.DB, orgID, userID string) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !validOrgRole(req.Role) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+	if req.Role != OrgRoleOwner {
+		last, err := lastOwner(r.Context(), db, orgID, userID)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if last {
+			http.Error(w, "organization needs at least one owner", http.StatusConflict)
+			return
+		}
+	}
+
+	res, err := db.ExecContext(r.Context(),
+		`UPDATE org_members SET role = $3 WHERE org_id = $1 AND user_id = $2`,
+		orgID, userID, req.Role)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "not a member", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r.Context(), "org_member_role_changed", map[string]string{
+		"actor":   PrincipalFromContext(r.Context()).Subject,
+		"org_id":  orgID,
+		"user_id": userID,
+		"role":    req.Role,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"user_id": userID,
+		"role":    req.Role,
+	})
+}
+
+func removeOrgMember(w http.ResponseWriter, r *http.Request, db *sql.DB, orgID, userID string) {
+	last, err := lastOwner(r.Context(), db, orgID, userID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if last {
+		http.Error(w, "organization needs at least one owner", http.StatusConflict)
+		return
+	}
+
+	res, err := db.ExecContext(r.Context(),
+		`DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "not a member", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r.Context(), "org_member_removed", map[string]string{
+		"actor":   PrincipalFromContext(r.Context()).Subject,
+		"org_id":  orgID,
+		"user_id": userID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
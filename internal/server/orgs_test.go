@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Acme Corp":         "acme-corp",
+		"  Hello, World!  ": "hello-world",
+		"R&D / Lab 42":      "r-d-lab-42",
+		"---":               "",
+		"ÜberTeam":          "berteam",
+		"a-very-long-name-that-keeps-going-and-going-past-fifty": "a-very-long-name-that-keeps-going-and-going-past-f",
+	}
+	for in, want := range cases {
+		if got := slugify(in); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOrgPolicyLinkTTL(t *testing.T) {
+	cases := []struct {
+		max, requested, want int
+	}{
+		{0, 0, 300},
+		{0, 100000, 86400},
+		{600, 0, 300},
+		{600, 3600, 600},
+		{600, 120, 120},
+	}
+	for _, c := range cases {
+		got := OrgPolicy{MaxLinkTTLSeconds: c.max}.linkTTL(c.requested)
+		if got != c.want {
+			t.Errorf("max=%d requested=%d: got %d, want %d", c.max, c.requested, got, c.want)
+		}
+	}
+}
+
+func TestContentTypeAllowed(t *testing.T) {
+	allowed := []string{"application/pdf", "image/*"}
+	cases := map[string]bool{
+		"application/pdf":           true,
+		"Application/PDF":           true,
+		"image/png":                 true,
+		"text/plain; charset=utf-8": false,
+		"imagex/png":                false,
+		"":                          false,
+	}
+	for ct, want := range cases {
+		if got := contentTypeAllowed(allowed, ct); got != want {
+			t.Errorf("contentTypeAllowed(%q) = %v, want %v", ct, got, want)
+		}
+	}
+	if !contentTypeAllowed(nil, "anything/at-all") {
+		t.Error("empty allowlist should admit everything")
+	}
+}
+
+func TestFilePermFor(t *testing.T) {
+	alice := Principal{Subject: "alice", Role: RoleUser}
+	admin := Principal{Subject: "root", Role: RoleAdmin}
+	personal := fileRecord{CreatedBy: "alice"}
+	orgFile := fileRecord{CreatedBy: "bob", OrgID: "org-1"}
+
+	cases := []struct {
+		name string
+		p    Principal
+		f    fileRecord
+		role string
+		want filePerm
+	}{
+		{"uploader", alice, personal, "", permOwner},
+		{"stranger", Principal{Subject: "eve"}, personal, "", permNone},
+		{"anonymous", Principal{}, fileRecord{}, "", permNone},
+		{"admin", admin, personal, "", permOwner},
+		{"org owner", alice, orgFile, OrgRoleOwner, permOwner},
+		{"org member", alice, orgFile, OrgRoleMember, permEdit},
+		{"org viewer", alice, orgFile, OrgRoleViewer, permView},
+		{"non-member", alice, orgFile, "", permNone},
+		{"uploader left org", Principal{Subject: "bob"}, orgFile, "", permNone},
+	}
+	for _, c := range cases {
+		if got := filePermFor(c.p, c.f, c.role, ""); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRequireOrgRole(t *testing.T) {
+	cases := []struct {
+		have, want string
+		ok         bool
+	}{
+		{OrgRoleOwner, OrgRoleOwner, true},
+		{OrgRoleOwner, OrgRoleMember, true},
+		{OrgRoleMember, OrgRoleOwner, false},
+		{OrgRoleViewer, OrgRoleMember, false},
+		{"", OrgRoleViewer, false},
+	}
+	for _, c := range cases {
+		called := false
+		rr := httptest.NewRecorder()
+		requireOrgRole(rr, c.have, c.want, func() { called = true })
+		if called != c.ok {
+			t.Errorf("have=%q want=%q: called=%v", c.have, c.want, called)
+		}
+		if !c.ok && rr.Code != http.StatusForbidden {
+			t.Errorf("have=%q want=%q: status %d, want 403", c.have, c.want, rr.Code)
+		}
+	}
+}
+
+func TestOrgHandlersRequireAuth(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}}
+	for _, h := range []http.Handler{cfg.orgsHandler(nil), cfg.orgHandler(nil)} {
+		req := httptest.NewRequest(http.MethodGet, "/orgs/", nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401", rr.Code)
+		}
+	}
+}
+
+func TestFileItemHandlerRejectsBadID(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}}
+	tok, _, err := cfg.Auth.makeToken("admin")
+	if err != nil {
+		t.Fatalf("makeToken error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/files/not-a-uuid", nil)
+	req.AddCookie(&http.Cookie{Name: cfg.Auth.cookieName(), Value: tok})
+	rr := httptest.NewRecorder()
+	cfg.fileItemHandler(nil, nil, "").ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", rr.Code)
+	}
+}
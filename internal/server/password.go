@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordParams are the Argon2id cost parameters used for new hashes.
+// Memory is in KiB.
+type PasswordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultPasswordParams returns the built-in Argon2id parameters
+// (64 MiB, 3 passes, 2 lanes), comfortably above the OWASP minimum.
+func DefaultPasswordParams() PasswordParams {
+	return PasswordParams{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+var (
+	passwordParamsMu sync.RWMutex
+	passwordParams   = DefaultPasswordParams()
+)
+
+// SetPasswordParams replaces the parameters used for new hashes. Existing
+// hashes with other parameters are upgraded on the user's next login.
+func SetPasswordParams(p PasswordParams) {
+	passwordParamsMu.Lock()
+	defer passwordParamsMu.Unlock()
+	passwordParams = p
+}
+
+func currentPasswordParams() PasswordParams {
+	passwordParamsMu.RLock()
+	defer passwordParamsMu.RUnlock()
+	return passwordParams
+}
+
+// Validate rejects parameters too weak to protect stored passwords.
+func (p PasswordParams) Validate() error {
+	if p.Memory < 8*1024 {
+		return errors.New("argon2 memory must be at least 8192 KiB")
+	}
+	if p.Iterations < 1 {
+		return errors.New("argon2 iterations must be at least 1")
+	}
+	if p.Parallelism < 1 {
+		return errors.New("argon2 parallelism must be at least 1")
+	}
+	return nil
+}
+
+var errBadPasswordHash = errors.New("malformed password hash")
+
+// hashPassword returns an Argon2id hash of password in PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//
+// with salt and key in unpadded standard base64.
+func hashPassword(password string) (string, error) {
+	p := currentPasswordParams()
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// parseArgon2Hash decodes a PHC-format Argon2id hash.
+func parseArgon2Hash(hash string) (p PasswordParams, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errBadPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errBadPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, errBadPasswordHash
+	}
+
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, errBadPasswordHash
+	}
+	if key, err = enc.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, errBadPasswordHash
+	}
+	// An empty key would match any password, and zero costs make
+	// argon2.IDKey panic.
+	if len(salt) == 0 || len(key) == 0 || p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, errBadPasswordHash
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// verifyPassword compares a password with its hash, dispatching on the hash
+// prefix: Argon2id for new hashes, bcrypt for accounts created before the
+// switch.
+func verifyPassword(password, hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		p, salt, key, err := parseArgon2Hash(hash)
+		if err != nil {
+			return false
+		}
+		got := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return subtle.ConstantTimeCompare(got, key) == 1
+	case isBcryptHash(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	default:
+		return false
+	}
+}
+
+// passwordNeedsRehash reports whether hash should be replaced with a fresh
+// hash under the current parameters (legacy bcrypt, or outdated Argon2id cost).
+func passwordNeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return true
+	}
+	p, _, _, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	cur := currentPasswordParams()
+	return p.Memory != cur.Memory || p.Iterations != cur.Iterations ||
+		p.Parallelism != cur.Parallelism || p.KeyLength != cur.KeyLength ||
+		p.SaltLength != cur.SaltLength
+}
+
+// rehashPassword transparently upgrades a user's stored hash after a
+// successful login. The update is conditional on the old hash so that a
+// concurrent password change is never overwritten.
+func rehashPassword(ctx context.Context, db *sql.DB, userID, password, oldHash string) {
+	newHash, err := hashPassword(password)
+	if err != nil {
+		logFor(ctx, "auth").Error("rehash_failed", errAttr(err))
+		return
+	}
+	if _, err := db.ExecContext(ctx,
+		`UPDATE users SET password_hash = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND password_hash = $2`,
+		userID, oldHash, newHash,
+	); err != nil {
+		logFor(ctx, "auth").Error("rehash_update_failed", errAttr(err))
+	}
+}
+
+// EnsureAdminUser migrates the legacy SFD_ADMIN_USER/SFD_ADMIN_PASS pair into
+// a real admin account with a stored Argon2id hash. It only creates the
+// account when no user with that username exists; afterwards the environment
+// password is no longer consulted, so a password changed via /password/change
+// sticks. An existing account is promoted only when no active admin exists.
+func EnsureAdminUser(ctx context.Context, db *sql.DB, username, email, password string) error {
+	if username == "" || password == "" {
+		return nil
+	}
+
+	var (
+		existingID   string
+		existingHash string
+	)
+	err := db.QueryRowContext(ctx,
+		`SELECT id, password_hash FROM users WHERE username = $1`, username,
+	).Scan(&existingID, &existingHash)
+	if err == nil {
+		if !verifyPassword(password, existingHash) {
+			logFor(ctx, "backend").Warn("admin_account_exists_env_password_ignored", slog.String("username", username))
+		}
+		// Never leave the instance without an administrator (e.g. right after
+		// the roles migration, when every account starts out as a user).
+		_, err := db.ExecContext(ctx, `
+			UPDATE users SET role = 'admin', updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin' AND is_active = TRUE)
+		`, existingID)
+		return err
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (id, email, username, password_hash, email_verified_at, role)
+		VALUES ($1, $2, $3, $4, $5, 'admin')
+	`, uuid.New(), email, username, hash, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("create admin user: %w", err)
+	}
+	logFor(ctx, "backend").Info("admin_account_created", slog.String("username", username))
+	return nil
+}
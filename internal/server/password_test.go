@@ -0,0 +1,135 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useTestPasswordParams swaps in cheap Argon2id parameters for the test.
+func useTestPasswordParams(t *testing.T) PasswordParams {
+	t.Helper()
+	prev := currentPasswordParams()
+	p := PasswordParams{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	SetPasswordParams(p)
+	t.Cleanup(func() { SetPasswordParams(prev) })
+	return p
+}
+
+func TestHashPassword_Argon2idRoundTrip(t *testing.T) {
+	useTestPasswordParams(t)
+
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Fatalf("unexpected PHC string: %s", hash)
+	}
+	if !verifyPassword("correct horse", hash) {
+		t.Fatal("expected password to verify")
+	}
+	if verifyPassword("wrong horse", hash) {
+		t.Fatal("expected wrong password to fail")
+	}
+
+	other, _ := hashPassword("correct horse")
+	if other == hash {
+		t.Fatal("expected a fresh salt per hash")
+	}
+	if passwordNeedsRehash(hash) {
+		t.Fatal("hash with current params should not need rehash")
+	}
+}
+
+func TestVerifyPassword_LegacyBcrypt(t *testing.T) {
+	useTestPasswordParams(t)
+
+	b, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := string(b)
+	if !verifyPassword("hunter22", hash) {
+		t.Fatal("expected bcrypt hash to verify")
+	}
+	if verifyPassword("hunter23", hash) {
+		t.Fatal("expected wrong password to fail")
+	}
+	if !passwordNeedsRehash(hash) {
+		t.Fatal("bcrypt hash should be marked for rehash")
+	}
+}
+
+func TestPasswordNeedsRehash_ChangedParams(t *testing.T) {
+	p := useTestPasswordParams(t)
+
+	hash, err := hashPassword("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Iterations = 2
+	SetPasswordParams(p)
+	if !passwordNeedsRehash(hash) {
+		t.Fatal("expected rehash after iterations change")
+	}
+	// Old hashes still verify: parameters are read from the PHC string.
+	if !verifyPassword("pw", hash) {
+		t.Fatal("old hash should still verify")
+	}
+}
+
+func TestVerifyPassword_MalformedHashes(t *testing.T) {
+	for _, h := range []string{
+		"",
+		"plaintext",
+		"$argon2id$v=19$m=8192,t=1,p=1$onlysalt",
+		"$argon2id$v=18$m=8192,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$garbage$c2FsdA$a2V5",
+		"$argon2id$v=19$m=8192,t=1,p=1$!!!$a2V5",
+		"$argon2i$v=19$m=8192,t=1,p=1$c2FsdA$a2V5",
+	} {
+		if verifyPassword("pw", h) {
+			t.Errorf("verifyPassword accepted %q", h)
+		}
+	}
+}
+
+func TestParseArgon2Hash_RejectsDegenerateHashes(t *testing.T) {
+	cases := []struct{ name, hash string }{
+		{"empty salt", "$argon2id$v=19$m=8192,t=1,p=1$$a2V5"},
+		{"empty key", "$argon2id$v=19$m=8192,t=1,p=1$c2FsdA$"},
+		{"zero memory", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5"},
+		{"zero iterations", "$argon2id$v=19$m=8192,t=0,p=1$c2FsdA$a2V5"},
+		{"zero parallelism", "$argon2id$v=19$m=8192,t=1,p=0$c2FsdA$a2V5"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, _, _, err := parseArgon2Hash(c.hash); err != errBadPasswordHash {
+				t.Fatalf("parseArgon2Hash: got %v, want errBadPasswordHash", err)
+			}
+			// Must neither match nor panic.
+			if verifyPassword("anything", c.hash) {
+				t.Fatal("verifyPassword accepted the hash")
+			}
+		})
+	}
+}
+
+func TestPasswordParams_Validate(t *testing.T) {
+	p := DefaultPasswordParams()
+	p.Memory, p.Iterations, p.Parallelism = 32768, 4, 1
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.Memory = 1024
+	if err := p.Validate(); err == nil {
+		t.Fatal("expected error for too little memory")
+	}
+	p.Memory, p.Iterations = 32768, 0
+	if err := p.Validate(); err == nil {
+		t.Fatal("expected error for zero iterations")
+	}
+}
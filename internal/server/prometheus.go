@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Content types for the two text exposition formats. Prometheus negotiates
+// OpenMetrics through the Accept header; other scrapers get the classic
+// 0.0.4 text format.
+const (
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	contentTypePromText    = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// requestDurationBuckets are upper bounds in seconds. Uploads and downloads
+// of large files run for minutes, so the tail is long.
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// histogram is a cumulative-bucket histogram. It is not safe for concurrent
+// use; callers hold the owning Metrics lock.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative; len(bounds)+1 with +Inf last
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// meanMillis returns the mean observation, taken to be in seconds, in
+// milliseconds.
+func (h *histogram) meanMillis() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count) * 1000
+}
+
+// label is one name="value" pair; order is preserved in the output.
+type label struct{ name, value string }
+
+// promWriter renders metric families in either exposition format.
+type promWriter struct {
+	w           *bufio.Writer
+	openMetrics bool
+}
+
+func newPromWriter(w io.Writer, openMetrics bool) *promWriter {
+	return &promWriter{w: bufio.NewWriter(w), openMetrics: openMetrics}
+}
+
+// family writes the HELP and TYPE lines. name is the sample name; for
+// counters in OpenMetrics the family name drops the "_total" suffix.
+func (p *promWriter) family(name, typ, help string) {
+	if p.openMetrics && typ == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(p.w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(p.w, "# TYPE %s %s\n", name, typ)
+}
+
+func (p *promWriter) sample(name string, labels []label, v float64) {
+	p.w.WriteString(name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			p.w.WriteString(l.name)
+			p.w.WriteString(`="`)
+			p.w.WriteString(escapeLabelValue(l.value))
+			p.w.WriteByte('"')
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteByte(' ')
+	p.w.WriteString(formatFloat(v))
+	p.w.WriteByte('\n')
+}
+
+// histogram writes the _bucket, _sum and _count samples of h.
+func (p *promWriter) histogram(name string, labels []label, h *histogram) {
+	var cum uint64
+	for i, c := range h.counts {
+		cum += c
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		p.sample(name+"_bucket", append(labels[:len(labels):len(labels)], label{"le", le}), float64(cum))
+	}
+	p.sample(name+"_sum", labels, h.sum)
+	p.sample(name+"_count", labels, float64(h.count))
+}
+
+// finish terminates the exposition and flushes it.
+func (p *promWriter) finish() error {
+	if p.openMetrics {
+		p.w.WriteString("# EOF\n")
+	}
+	return p.w.Flush()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(v string) string { return labelEscaper.Replace(v) }
+func escapeHelp(v string) string       { return helpEscaper.Replace(v) }
+
+// wantsOpenMetrics reports whether the scraper asked for OpenMetrics.
+func wantsOpenMetrics(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+}
+
+// MetricsConfig controls how /metrics may be scraped.
+//
+// Token, when set, lets a scraper authenticate with
+// "Authorization: Bearer <token>" instead of an admin credential. Addr, when
+// set, additionally serves /metrics on a separate listener (e.g. ":9090"),
+// intended for a network only the monitoring system can reach; the token is
+// still enforced there when configured.
+type MetricsConfig struct {
+	Token string
+	Addr  string
+}
+
+// validScrapeToken reports whether r carries the configured scrape token.
+func (mc MetricsConfig) validScrapeToken(r *http.Request) bool {
+	tok, ok := bearerToken(r)
+	return ok && mc.Token != "" && subtle.ConstantTimeCompare([]byte(tok), []byte(mc.Token)) == 1
+}
+
+// prometheusHandler writes the metrics in text exposition format.
+func prometheusHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		om := wantsOpenMetrics(r)
+		if om {
+			w.Header().Set("Content-Type", contentTypeOpenMetrics)
+		} else {
+			w.Header().Set("Content-Type", contentTypePromText)
+		}
+		_ = m.WritePrometheus(w, om)
+	})
+}
+
+// metricsHandler serves GET /metrics on the main listener: the scrape token
+// when configured, otherwise (or additionally) an admin:read credential.
+func (cfg Config) metricsHandler(mc MetricsConfig) http.Handler {
+	prom := prometheusHandler(cfg.Registry)
+	protected := cfg.Auth.requireScope(ScopeAdminRead, prom)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mc.validScrapeToken(r) {
+			prom.ServeHTTP(w, r)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
+}
+
+// scrapeHandler serves /metrics on the dedicated metrics listener. Only the
+// scrape token is checked there (when configured): the listener is meant to
+// be reachable by the monitoring system alone.
+func (mc MetricsConfig) scrapeHandler(m *Metrics) http.Handler {
+	mux := http.NewServeMux()
+	prom := prometheusHandler(m)
+	mux.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mc.Token != "" && !mc.validScrapeToken(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		prom.ServeHTTP(w, r)
+	}))
+	return mux
+}
+
+// normaliseMethod bounds the method label to the standard verbs.
+func normaliseMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "OTHER"
+}
+
+// metricsMiddleware records request count and latency per method, route and
+// status code. The route label is the ServeMux pattern that handles the
+// request (e.g. "/files/"), which keeps cardinality bounded regardless of
+// ids in the path.
+func metricsMiddleware(m *Metrics, mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		srw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(srw, r)
+		m.RecordRequest(normaliseMethod(r.Method), route, srw.status, time.Since(start))
+	})
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus_TextFormat(t *testing.T) {
+	m := NewMetrics()
+	m.RecordLoginAttempt(true)
+	m.RecordRequest("GET", "/files/", 200, 30*time.Millisecond)
+	m.RecordRequest("GET", "/files/", 404, 2*time.Second)
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf, false); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE sfd_login_success_total counter\nsfd_login_success_total 1\n",
+		"# TYPE sfd_active_sessions gauge\n",
+		`sfd_file_transitions_total{status="hashed"} 0`,
+		"# TYPE http_requests_total counter\n",
+		`http_requests_total{method="GET",route="/files/",code="200"} 1`,
+		`http_requests_total{method="GET",route="/files/",code="404"} 1`,
+		"# TYPE http_request_duration_seconds histogram\n",
+		`http_request_duration_seconds_bucket{method="GET",route="/files/",le="0.05"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/files/",le="2.5"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/files/",le="+Inf"} 2`,
+		`http_request_duration_seconds_sum{method="GET",route="/files/"} 2.03`,
+		`http_request_duration_seconds_count{method="GET",route="/files/"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "# EOF") {
+		t.Error("text format must not contain the OpenMetrics terminator")
+	}
+}
+
+func TestWritePrometheus_OpenMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewMetrics().WritePrometheus(&buf, true); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "# TYPE sfd_uploads counter\nsfd_uploads_total 0\n") {
+		t.Errorf("counter family should drop _total in OpenMetrics:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Error("OpenMetrics output must end with # EOF")
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	got := escapeLabelValue("a\"b\\c\nd")
+	if want := `a\"b\\c\nd`; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestMetricsHandler_ScrapeToken(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{SessionSecret: "s"}}
+	h := cfg.metricsHandler(MetricsConfig{Token: "scrape-secret"})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-secret")
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeOpenMetrics {
+		t.Errorf("content type %q", ct)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", rr.Code)
+	}
+}
+
+func TestScrapeHandler(t *testing.T) {
+	open := MetricsConfig{}.scrapeHandler(NewMetrics())
+	rr := httptest.NewRecorder()
+	open.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != contentTypePromText {
+		t.Fatalf("open listener: status %d, content type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	guarded := MetricsConfig{Token: "t"}.scrapeHandler(NewMetrics())
+	rr = httptest.NewRecorder()
+	guarded.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	guarded.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for other paths, got %d", rr.Code)
+	}
+}
+
+func TestMetricsMiddleware_RouteLabel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/widgets/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	m := NewMetrics()
+	h := metricsMiddleware(m, mux, mux)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/widgets/123", nil))
+
+	m.mu.RLock()
+	n := m.requests[requestKey{"OTHER", "/widgets/", http.StatusTeapot}]
+	m.mu.RUnlock()
+	if n != 1 {
+		t.Fatalf("expected one request recorded under the mux pattern, got %d", n)
+	}
+	if m.Snapshot().RequestErrors4xx != 1 {
+		t.Fatal("4xx counter not incremented")
+	}
+}
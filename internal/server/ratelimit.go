@@ -0,0 +1,425 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rate limit key kinds. A rule's key decides which bucket a request draws from.
+const (
+	RateKeyIP   = "ip"   // client IP (see clientIP)
+	RateKeyUser = "user" // authenticated subject or API token; falls back to IP
+	RateKeyLink = "link" // signed download token in ?token=
+)
+
+// RateLimitRule is a token-bucket policy for one route: Limit requests per
+// Period, with bursts of up to Limit, bucketed by Key.
+type RateLimitRule struct {
+	Route  string
+	Key    string
+	Limit  int
+	Period time.Duration
+}
+
+func (r RateLimitRule) rate() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// rateDecision is the result of taking a token from a bucket.
+type rateDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // time until one token is available (denied only)
+	Reset      time.Duration // time until the bucket is full again
+}
+
+// RateLimitStore holds token buckets. Implementations must be safe for
+// concurrent use.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, rate float64, burst int) (rateDecision, error)
+}
+
+// refillTokens returns the bucket level after elapsed seconds at rate,
+// capped at burst.
+func refillTokens(tokens, elapsed, rate float64, burst int) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(burst), tokens+elapsed*rate)
+}
+
+// takeToken consumes one token from a bucket holding tokens, returning the new
+// level and the decision to report to the client.
+func takeToken(tokens, rate float64, burst int) (float64, rateDecision) {
+	d := rateDecision{Limit: burst}
+	if tokens >= 1 {
+		tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	d.Remaining = int(math.Floor(tokens))
+	d.Reset = time.Duration((float64(burst) - tokens) / rate * float64(time.Second))
+	return tokens, d
+}
+
+// MemoryRateLimitStore keeps buckets in process memory. It is suitable for a
+// single replica.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memBucket
+	now     func() time.Time
+}
+
+type memBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  int
+}
+
+// memStoreSweepAt is the bucket count above which full buckets are evicted.
+const memStoreSweepAt = 10000
+
+// NewMemoryRateLimitStore returns an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memBucket), now: time.Now}
+}
+
+// Take implements RateLimitStore.
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, rate float64, burst int) (rateDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= memStoreSweepAt {
+			s.sweep(now)
+		}
+		b = &memBucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.rate, b.burst = rate, burst
+
+	tokens := refillTokens(b.tokens, now.Sub(b.last).Seconds(), rate, burst)
+	tokens, d := takeToken(tokens, rate, burst)
+	b.tokens, b.last = tokens, now
+	return d, nil
+}
+
+// sweep drops buckets that have refilled completely; they are
+// indistinguishable from new ones.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for k, b := range s.buckets {
+		if refillTokens(b.tokens, now.Sub(b.last).Seconds(), b.rate, b.burst) >= float64(b.burst) {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+// PostgresRateLimitStore keeps buckets in the rate_limit_buckets table so
+// that all replicas share them. Time is taken from the database clock.
+type PostgresRateLimitStore struct {
+	DB    *sql.DB
+	calls atomic.Uint64
+}
+
+// pgStorePurgeEvery controls how often idle buckets are purged.
+const pgStorePurgeEvery = 1000
+
+// Take implements RateLimitStore.
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, rate float64, burst int) (rateDecision, error) {
+	if s.calls.Add(1)%pgStorePurgeEvery == 0 {
+		if _, err := s.DB.ExecContext(ctx,
+			`DELETE FROM rate_limit_buckets WHERE updated_at < now() - interval '1 day'`,
+		); err != nil {
+			logFor(ctx, "ratelimit").Error("purge_failed", errAttr(err))
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return rateDecision{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (key) DO NOTHING
+	`, key, float64(burst)); err != nil {
+		return rateDecision{}, err
+	}
+
+	var tokens, elapsed float64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT tokens, EXTRACT(EPOCH FROM (now() - updated_at))::float8
+		FROM rate_limit_buckets WHERE key = $1 FOR UPDATE
+	`, key).Scan(&tokens, &elapsed); err != nil {
+		return rateDecision{}, err
+	}
+
+	tokens = refillTokens(tokens, elapsed, rate, burst)
+	tokens, d := takeToken(tokens, rate, burst)
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE rate_limit_buckets SET tokens = $2, updated_at = now() WHERE key = $1`,
+		key, tokens,
+	); err != nil {
+		return rateDecision{}, err
+	}
+	return d, tx.Commit()
+}
+
+// RateLimiter applies per-route rules from a shared store. Rules are read
+// on every request, so SetRules takes effect immediately.
+type RateLimiter struct {
+	Store RateLimitStore
+	Auth  AuthConfig
+	Rules map[string][]RateLimitRule // by route name; guarded by mu
+
+	mu sync.RWMutex
+}
+
+// SetRules replaces the rules with the defaults merged with spec (see
+// parseRateLimitRules). On a parse error the current rules are kept.
+func (l *RateLimiter) SetRules(spec string) error {
+	overrides, err := parseRateLimitRules(spec)
+	if err != nil {
+		return err
+	}
+	rules := mergeRateLimitRules(DefaultRateLimitRules(), overrides)
+	l.mu.Lock()
+	l.Rules = rules
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *RateLimiter) rulesFor(route string) []RateLimitRule {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.Rules[route]
+}
+
+// DefaultRateLimitRules returns the built-in per-route policies.
+func DefaultRateLimitRules() []RateLimitRule {
+	return []RateLimitRule{
+		{Route: "login", Key: RateKeyIP, Limit: 20, Period: time.Minute},
+		{Route: "register", Key: RateKeyIP, Limit: 5, Period: time.Hour},
+		{Route: "password", Key: RateKeyIP, Limit: 10, Period: 15 * time.Minute},
+		{Route: "files", Key: RateKeyUser, Limit: 120, Period: time.Minute},
+		{Route: "upload", Key: RateKeyUser, Limit: 60, Period: time.Minute},
+		{Route: "links", Key: RateKeyUser, Limit: 60, Period: time.Minute},
+		{Route: "download", Key: RateKeyLink, Limit: 30, Period: time.Minute},
+		{Route: "download", Key: RateKeyIP, Limit: 120, Period: time.Minute},
+	}
+}
+
+// parseRateLimitRules parses SFD_RATE_LIMITS overrides of the form
+//
+//	route:key=limit/period[;route:key=limit/period...]
+//
+// e.g. "login:ip=10/1m;links:user=100/1h". A limit of 0 disables the rule.
+func parseRateLimitRules(spec string) ([]RateLimitRule, error) {
+	var rules []RateLimitRule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		target, quota, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: missing '='", part)
+		}
+		route, key, ok := strings.Cut(strings.TrimSpace(target), ":")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("rate limit %q: expected route:key", part)
+		}
+		switch key {
+		case RateKeyIP, RateKeyUser, RateKeyLink:
+		default:
+			return nil, fmt.Errorf("rate limit %q: unknown key %q", part, key)
+		}
+		limitStr, periodStr, ok := strings.Cut(strings.TrimSpace(quota), "/")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: expected limit/period", part)
+		}
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("rate limit %q: bad limit", part)
+		}
+		period, err := time.ParseDuration(periodStr)
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("rate limit %q: bad period", part)
+		}
+		rules = append(rules, RateLimitRule{Route: route, Key: key, Limit: limit, Period: period})
+	}
+	return rules, nil
+}
+
+// mergeRateLimitRules applies overrides on top of base, matching on route and
+// key. Rules with a zero limit are dropped.
+func mergeRateLimitRules(base, overrides []RateLimitRule) map[string][]RateLimitRule {
+	type id struct{ route, key string }
+	order := []id{}
+	byID := map[id]RateLimitRule{}
+	for _, r := range append(append([]RateLimitRule{}, base...), overrides...) {
+		k := id{r.Route, r.Key}
+		if _, ok := byID[k]; !ok {
+			order = append(order, k)
+		}
+		byID[k] = r
+	}
+
+	out := map[string][]RateLimitRule{}
+	for _, k := range order {
+		if r := byID[k]; r.Limit > 0 {
+			out[r.Route] = append(out[r.Route], r)
+		}
+	}
+	return out
+}
+
+// RateLimitConfig selects the rate limiter behaviour.
+type RateLimitConfig struct {
+	Disabled bool   // turns limiting off entirely
+	Store    string // "memory" (default) or "postgres" for multi-replica
+	Rules    string // per-route overrides, see parseRateLimitRules
+}
+
+// NewRateLimiter builds a RateLimiter with the default rules merged with
+// rc.Rules. It returns nil when rate limiting is disabled.
+func NewRateLimiter(db *sql.DB, auth AuthConfig, rc RateLimitConfig) (*RateLimiter, error) {
+	if rc.Disabled {
+		return nil, nil
+	}
+
+	overrides, err := parseRateLimitRules(rc.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	var store RateLimitStore
+	switch rc.Store {
+	case "", "memory":
+		store = NewMemoryRateLimitStore()
+	case "postgres":
+		if db == nil {
+			return nil, fmt.Errorf("rate limit store postgres requires a database")
+		}
+		store = &PostgresRateLimitStore{DB: db}
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", rc.Store)
+	}
+
+	return &RateLimiter{
+		Store: store,
+		Auth:  auth,
+		Rules: mergeRateLimitRules(DefaultRateLimitRules(), overrides),
+	}, nil
+}
+
+// bucketKey derives the bucket for a request under rule, or "" when the rule
+// does not apply (e.g. a link rule on a request without a token).
+func (l *RateLimiter) bucketKey(r *http.Request, rule RateLimitRule) string {
+	prefix := rule.Route + ":" + rule.Key + ":"
+	switch rule.Key {
+	case RateKeyIP:
+		return prefix + clientIP(r)
+	case RateKeyUser:
+		// Verify sessions so that forged cookies cannot mint fresh buckets.
+		// Bearer tokens are keyed by their hash; invalid ones are rejected
+		// by requireAuth further down the chain.
+		if tok, ok := bearerToken(r); ok {
+			return prefix + "token:" + hashToken(tok)[:16]
+		}
+		if c, err := r.Cookie(l.Auth.cookieName()); err == nil {
+			if sp, err := l.Auth.verifyToken(c.Value); err == nil {
+				return prefix + "sub:" + sp.Sub
+			}
+		}
+		return prefix + "ip:" + clientIP(r)
+	case RateKeyLink:
+		tok := r.URL.Query().Get("token")
+		if tok == "" {
+			return ""
+		}
+		return prefix + hashToken(tok)[:16]
+	}
+	return ""
+}
+
+// Limit wraps next with the rules configured for route. A nil limiter
+// passes requests straight through, as does a route without rules.
+func (l *RateLimiter) Limit(route string, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules := l.rulesFor(route)
+		var (
+			tightest *rateDecision
+			rule     RateLimitRule
+		)
+		for _, ru := range rules {
+			key := l.bucketKey(r, ru)
+			if key == "" {
+				continue
+			}
+			d, err := l.Store.Take(r.Context(), key, ru.rate(), ru.Limit)
+			if err != nil {
+				// Fail open: an unavailable store must not take the service down.
+				logFor(r.Context(), "ratelimit").Error("store_error", slog.String("route", route), errAttr(err))
+				continue
+			}
+			if tightest == nil || moreRestrictive(d, *tightest) {
+				dd := d
+				tightest, rule = &dd, ru
+			}
+		}
+
+		if tightest != nil {
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.Reset)))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Limit, ceilSeconds(rule.Period)))
+
+			if !tightest.Allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// moreRestrictive reports whether a should be reported instead of b: denials
+// win over allowances, then the longer wait or the fewer remaining tokens.
+func moreRestrictive(a, b rateDecision) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore_TokenBucket(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewMemoryRateLimitStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// 2 requests per second, burst 2.
+	for i := 0; i < 2; i++ {
+		d, _ := s.Take(ctx, "k", 2, 2)
+		if !d.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	d, _ := s.Take(ctx, "k", 2, 2)
+	if d.Allowed {
+		t.Fatal("third request should be denied")
+	}
+	if d.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("unexpected retry after: %s", d.RetryAfter)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if d, _ := s.Take(ctx, "k", 2, 2); !d.Allowed {
+		t.Fatal("request after refill should be allowed")
+	}
+
+	if d, _ := s.Take(ctx, "other", 2, 2); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("independent key should have its own bucket: %+v", d)
+	}
+}
+
+func TestMemoryRateLimitStore_Sweep(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewMemoryRateLimitStore()
+	s.now = func() time.Time { return now }
+
+	_, _ = s.Take(context.Background(), "a", 1, 1)
+	now = now.Add(2 * time.Second)
+	s.sweep(now)
+	if len(s.buckets) != 0 {
+		t.Fatalf("expected refilled bucket to be swept, have %d", len(s.buckets))
+	}
+}
+
+func TestParseRateLimitRules(t *testing.T) {
+	rules, err := parseRateLimitRules(" login:ip=10/1m ; links:user=0/1h")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Limit != 10 || rules[0].Period != time.Minute || rules[1].Key != RateKeyUser {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	for _, bad := range []string{"login", "login=1/1m", "login:host=1/1m", "login:ip=x/1m", "login:ip=1/soon", "login:ip=1"} {
+		if _, err := parseRateLimitRules(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestMergeRateLimitRules(t *testing.T) {
+	base := []RateLimitRule{
+		{Route: "login", Key: RateKeyIP, Limit: 20, Period: time.Minute},
+		{Route: "links", Key: RateKeyUser, Limit: 60, Period: time.Minute},
+	}
+	overrides := []RateLimitRule{
+		{Route: "login", Key: RateKeyIP, Limit: 5, Period: time.Minute},
+		{Route: "links", Key: RateKeyUser, Limit: 0, Period: time.Minute},
+	}
+	got := mergeRateLimitRules(base, overrides)
+	if len(got["login"]) != 1 || got["login"][0].Limit != 5 {
+		t.Fatalf("override not applied: %+v", got["login"])
+	}
+	if len(got["links"]) != 0 {
+		t.Fatalf("zero limit should disable rule: %+v", got["links"])
+	}
+}
+
+func TestRateLimiter_Limit(t *testing.T) {
+	l := &RateLimiter{
+		Store: NewMemoryRateLimitStore(),
+		Rules: map[string][]RateLimitRule{
+			"register": {{Route: "register", Key: RateKeyIP, Limit: 1, Period: time.Hour}},
+		},
+	}
+	h := l.Limit("register", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/register", nil)
+		req.RemoteAddr = "198.51.100.4:1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := do()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", first.Code)
+	}
+	if first.Header().Get("RateLimit-Limit") != "1" || first.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("unexpected headers: %v", first.Header())
+	}
+
+	second := do()
+	if second.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", second.Code)
+	}
+	if second.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("unexpected Retry-After: %q", second.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimiter_NilAndUnknownRoutePassThrough(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var nilLimiter *RateLimiter
+	if nilLimiter.Limit("login", next) == nil {
+		t.Fatal("nil limiter must return next")
+	}
+
+	l := &RateLimiter{Store: NewMemoryRateLimitStore(), Rules: map[string][]RateLimitRule{}}
+	rr := httptest.NewRecorder()
+	l.Limit("unknown", next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Header().Get("RateLimit-Limit") != "" {
+		t.Fatal("route without rules should not set headers")
+	}
+}
+
+func TestRateLimiter_BucketKey(t *testing.T) {
+	auth := AuthConfig{SessionSecret: "s", SessionTTL: time.Hour}
+	l := &RateLimiter{Auth: auth}
+
+	r := httptest.NewRequest(http.MethodGet, "/download", nil)
+	r.RemoteAddr = "192.0.2.1:1"
+	if k := l.bucketKey(r, RateLimitRule{Route: "download", Key: RateKeyLink}); k != "" {
+		t.Fatalf("link rule without token should not apply, got %q", k)
+	}
+	if k := l.bucketKey(r, RateLimitRule{Route: "links", Key: RateKeyUser}); k != "links:user:ip:192.0.2.1" {
+		t.Fatalf("anonymous user key should fall back to ip, got %q", k)
+	}
+
+	tok, _, _ := auth.makeToken("alice")
+	r.AddCookie(&http.Cookie{Name: auth.cookieName(), Value: tok})
+	if k := l.bucketKey(r, RateLimitRule{Route: "links", Key: RateKeyUser}); k != "links:user:sub:alice" {
+		t.Fatalf("unexpected session key: %q", k)
+	}
+}
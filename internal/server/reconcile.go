@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ReconcileOptions controls Reconcile.
+type ReconcileOptions struct {
+	// Fix removes orphan objects and marks files whose object is missing
+	// as failed, so cleanup deletes them.
+	Fix bool
+	// MinAge leaves objects younger than this alone: an upload in flight
+	// may not have its row committed yet.
+	MinAge time.Duration
+}
+
+// OrphanObject is a stored object no file row refers to.
+type OrphanObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// MissingObject is a file row whose object is not in the bucket.
+type MissingObject struct {
+	FileID    string `json:"file_id"`
+	ObjectKey string `json:"object_key"`
+	Status    string `json:"status"`
+}
+
+// ReconcileReport lists the differences between the files table and the
+// bucket.
+type ReconcileReport struct {
+	Orphans []OrphanObject  `json:"orphans"`
+	Missing []MissingObject `json:"missing"`
+	Fixed   bool            `json:"fixed"`
+}
+
+// Clean reports whether database and bucket agree.
+func (r ReconcileReport) Clean() bool {
+	return len(r.Orphans) == 0 && len(r.Missing) == 0
+}
+
+// Reconcile compares the files table with the bucket.
+func Reconcile(ctx context.Context, db *sql.DB, mc *minio.Client, bucket string, opts ReconcileOptions) (ReconcileReport, error) {
+	// Rows first: an object uploaded after this query is younger than any
+	// sensible MinAge, so it is not reported as an orphan.
+	rows, err := db.QueryContext(ctx, `SELECT id, object_key, status FROM files`)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+	var files []MissingObject
+	for rows.Next() {
+		var f MissingObject
+		if err := rows.Scan(&f.FileID, &f.ObjectKey, &f.Status); err != nil {
+			rows.Close()
+			return ReconcileReport{}, err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return ReconcileReport{}, err
+	}
+
+	var objects []OrphanObject
+	for obj := range mc.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			return ReconcileReport{}, obj.Err
+		}
+		objects = append(objects, OrphanObject{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified})
+	}
+
+	report := reconcileDiff(files, objects, time.Now().Add(-opts.MinAge))
+	if !opts.Fix || report.Clean() {
+		return report, nil
+	}
+
+	logger := logFor(ctx, "reconcile")
+	for _, o := range report.Orphans {
+		if err := mc.RemoveObject(ctx, bucket, o.Key, minio.RemoveObjectOptions{}); err != nil {
+			return report, err
+		}
+		logger.Info("orphan_object_removed", slog.String("object_key", o.Key))
+	}
+	for _, m := range report.Missing {
+		if _, err := db.ExecContext(ctx, `UPDATE files SET status = 'failed' WHERE id = $1`, m.FileID); err != nil {
+			return report, err
+		}
+		logger.Info("missing_object_marked_failed", slog.String(logKeyFileID, m.FileID))
+	}
+	report.Fixed = true
+	recordAudit(ctx, "storage_reconciled", map[string]string{
+		"orphans_removed": strconv.Itoa(len(report.Orphans)),
+		"files_failed":    strconv.Itoa(len(report.Missing)),
+	})
+	return report, nil
+}
+
+// reconcileDiff finds objects without rows (last modified before cutoff)
+// and rows past upload whose object is missing. Pending rows have no
+// object yet, and failed rows are left to cleanup.
+func reconcileDiff(files []MissingObject, objects []OrphanObject, cutoff time.Time) ReconcileReport {
+	report := ReconcileReport{Orphans: []OrphanObject{}, Missing: []MissingObject{}}
+	referenced := make(map[string]bool, len(files))
+	for _, f := range files {
+		referenced[f.ObjectKey] = true
+	}
+	stored := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		stored[o.Key] = true
+		if !referenced[o.Key] && o.LastModified.Before(cutoff) {
+			report.Orphans = append(report.Orphans, o)
+		}
+	}
+	for _, f := range files {
+		if f.Status == "pending" || f.Status == "failed" {
+			continue
+		}
+		if !stored[f.ObjectKey] {
+			report.Missing = append(report.Missing, f)
+		}
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].Key < report.Orphans[j].Key })
+	sort.Slice(report.Missing, func(i, j int) bool { return report.Missing[i].FileID < report.Missing[j].FileID })
+	return report
+}
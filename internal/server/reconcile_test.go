@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconcileDiff(t *testing.T) {
+	now := time.Now()
+	old, recent := now.Add(-2*time.Hour), now.Add(-time.Minute)
+	files := []MissingObject{
+		{FileID: "1", ObjectKey: "a", Status: "ready"},
+		{FileID: "2", ObjectKey: "b", Status: "hashed"}, // object gone
+		{FileID: "3", ObjectKey: "c", Status: "pending"},
+		{FileID: "4", ObjectKey: "d", Status: "failed"},
+	}
+	objects := []OrphanObject{
+		{Key: "a", LastModified: old},
+		{Key: "z", LastModified: old},    // orphan
+		{Key: "y", LastModified: recent}, // may be an upload in flight
+	}
+
+	r := reconcileDiff(files, objects, now.Add(-time.Hour))
+	if len(r.Orphans) != 1 || r.Orphans[0].Key != "z" {
+		t.Errorf("orphans: %+v", r.Orphans)
+	}
+	if len(r.Missing) != 1 || r.Missing[0].FileID != "2" {
+		t.Errorf("missing: %+v", r.Missing)
+	}
+	if r.Clean() {
+		t.Error("report with differences is clean")
+	}
+
+	if r := reconcileDiff(files[:1], objects[:1], now); !r.Clean() {
+		t.Errorf("matching sets: %+v", r)
+	}
+}
@@ -1,29 +1,33 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // RegisterRequest represents the JSON payload for user registration
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	InviteCode string `json:"invite_code,omitempty"`
 }
 
 // RegisterResponse is the JSON response after successful registration
 type RegisterResponse struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	ID                   string `json:"id"`
+	Email                string `json:"email"`
+	Username             string `json:"username"`
+	VerificationRequired bool   `json:"verification_required"`
 }
 
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
@@ -69,24 +73,13 @@ func validateUsername(username string) (bool, string) {
 	return true, ""
 }
 
-// hashPassword generates a bcrypt hash of the password
-func hashPassword(password string) (string, error) {
-	// bcrypt cost of 12 is a good balance of security and performance
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
-}
-
-// verifyPassword compares a password with its hash
-func verifyPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
-// RegisterHandler handles POST /register requests for user registration
+// RegisterHandler handles POST /register requests for user registration,
+// subject to the configured RegistrationPolicy. GET reports the active mode.
 func (cfg Config) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		cfg.registrationStatus(w)
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -102,6 +95,7 @@ func (cfg Config) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
 	req.Username = strings.TrimSpace(req.Username)
 	req.Password = strings.TrimSpace(req.Password)
+	req.InviteCode = strings.TrimSpace(req.InviteCode)
 
 	// Validate email
 	if !validateEmail(req.Email) {
@@ -121,6 +115,25 @@ func (cfg Config) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Enforce the registration mode
+	policy := RegistrationPolicy{Mode: RegistrationOpen}
+	if cfg.Registration != nil {
+		policy = *cfg.Registration
+	}
+	switch err := policy.checkOpenModes(req.Email); err {
+	case nil:
+	case errRegistrationClosed:
+		http.Error(w, "Registration is closed", http.StatusForbidden)
+		return
+	default:
+		http.Error(w, "Email domain is not allowed", http.StatusForbidden)
+		return
+	}
+	if policy.Mode == RegistrationInvite && req.InviteCode == "" {
+		http.Error(w, "Invite code required", http.StatusForbidden)
+		return
+	}
+
 	// Check if user already exists
 	var exists bool
 	err := cfg.DB.QueryRow(
@@ -128,7 +141,7 @@ func (cfg Config) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		req.Email, req.Username,
 	).Scan(&exists)
 	if err != nil {
-		log.Printf("register: db check failed: %v", err)
+		logFor(r.Context(), "register").Error("db_check_failed", errAttr(err))
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -140,42 +153,104 @@ func (cfg Config) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// Hash password
 	passwordHash, err := hashPassword(req.Password)
 	if err != nil {
-		log.Printf("register: hash failed: %v", err)
+		logFor(r.Context(), "register").Error("hash_failed", errAttr(err))
 		http.Error(w, "Failed to process password", http.StatusInternalServerError)
 		return
 	}
 
+	tx, err := cfg.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Redeem the invite in the same transaction so a code is used once.
+	role := RoleUser
+	var invite redeemedInvite
+	if policy.Mode == RegistrationInvite {
+		invite, err = claimInvite(r.Context(), tx, req.InviteCode, req.Email)
+		if err != nil {
+			if errors.Is(err, errInviteInvalid) {
+				http.Error(w, "Invalid or expired invite code", http.StatusForbidden)
+				return
+			}
+			logFor(r.Context(), "register").Error("invite_lookup_failed", errAttr(err))
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		role = invite.Role
+	}
+
+	// An invite bound to this address was mailed to it, which proves
+	// ownership just like the verification link would.
+	var verifiedAt *time.Time
+	if invite.Email != "" {
+		now := time.Now().UTC()
+		verifiedAt = &now
+	}
+
 	// Create user
 	userID := uuid.New()
-	_, err = cfg.DB.Exec(`
-		INSERT INTO users (id, email, username, password_hash)
-		VALUES ($1, $2, $3, $4)
-	`, userID, req.Email, req.Username, passwordHash)
+	_, err = tx.ExecContext(r.Context(), `
+		INSERT INTO users (id, email, username, password_hash, role, email_verified_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, userID, req.Email, req.Username, passwordHash, role, verifiedAt)
 	if err != nil {
-		log.Printf("register: insert failed: %v", err)
+		logFor(r.Context(), "register").Error("insert_failed", errAttr(err))
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	if invite.ID != "" {
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE invites SET used_at = now(), used_by = $2 WHERE id = $1`,
+			invite.ID, userID,
+		); err != nil {
+			logFor(r.Context(), "register").Error("invite_update_failed", errAttr(err))
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logFor(r.Context(), "register").Error("commit_failed", errAttr(err))
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("register: created user %s (%s)", req.Username, req.Email)
+	logFor(r.Context(), "register").Info("user_created", slog.String("username", req.Username), slog.String("email", req.Email))
+	recordAudit(r.Context(), "user_registered", map[string]string{
+		"user_id":   userID.String(),
+		"mode":      policy.Mode,
+		"invite_id": invite.ID,
+		"role":      role,
+	})
+
+	// New accounts cannot upload until the address is confirmed. A mail
+	// failure is not fatal: the user can request another link after login.
+	if verifiedAt == nil {
+		if err := cfg.sendVerificationEmail(r, userID.String(), req.Email); err != nil {
+			logFor(r.Context(), "register").Error("verification_mail_failed", errAttr(err))
+		}
+	}
 
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(RegisterResponse{
-		ID:       userID.String(),
-		Email:    req.Email,
-		Username: req.Username,
+		ID:                   userID.String(),
+		Email:                req.Email,
+		Username:             req.Username,
+		VerificationRequired: verifiedAt == nil,
 	})
 }
 
 // authenticateUser checks credentials against the database
-func authenticateUser(db *sql.DB, username, password string) (string, bool) {
+func authenticateUser(ctx context.Context, db *sql.DB, username, password string) (string, bool) {
 	var userID string
 	var passwordHash string
 
-	err := db.QueryRow(
-		"SELECT id, password_hash FROM users WHERE (username = $1 OR email = $1) AND is_active = TRUE",
+	err := db.QueryRowContext(ctx,
+		"SELECT id, password_hash FROM users WHERE (username = $1 OR email = $1) AND is_active = TRUE AND NOT password_reset_required",
 		username,
 	).Scan(&userID, &passwordHash)
 
@@ -183,7 +258,7 @@ func authenticateUser(db *sql.DB, username, password string) (string, bool) {
 		if err == sql.ErrNoRows {
 			return "", false
 		}
-		log.Printf("auth: db query failed: %v", err)
+		logFor(ctx, "auth").Error("db_query_failed", errAttr(err))
 		return "", false
 	}
 
@@ -191,8 +266,13 @@ func authenticateUser(db *sql.DB, username, password string) (string, bool) {
 		return "", false
 	}
 
+	// Upgrade bcrypt or outdated Argon2id hashes while the plaintext is at hand.
+	if passwordNeedsRehash(passwordHash) {
+		rehashPassword(ctx, db, userID, password, passwordHash)
+	}
+
 	// Update last login
-	_, _ = db.Exec("UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = $1", userID)
+	_, _ = db.ExecContext(ctx, "UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = $1", userID)
 
 	return userID, true
 }
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Registration modes for registration.mode (SFD_REGISTRATION_MODE).
+const (
+	RegistrationOpen   = "open"   // anyone may register
+	RegistrationClosed = "closed" // /register is disabled
+	RegistrationInvite = "invite" // a valid invite code is required
+	RegistrationDomain = "domain" // only addresses in AllowedDomains
+)
+
+// Invite lifetimes. Invites are meant to be redeemed promptly.
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+var (
+	errRegistrationClosed = errors.New("registration is closed")
+	errDomainNotAllowed   = errors.New("email domain not allowed")
+	errInviteInvalid      = errors.New("invalid or expired invite code")
+)
+
+// RegistrationPolicy decides who may create an account via /register.
+type RegistrationPolicy struct {
+	Mode           string
+	AllowedDomains []string // lowercased, for RegistrationDomain
+}
+
+// NewRegistrationPolicy builds a policy for mode (default open) and, for
+// domain mode, the allowed email domains.
+func NewRegistrationPolicy(mode string, domains []string) (RegistrationPolicy, error) {
+	p := RegistrationPolicy{Mode: strings.ToLower(strings.TrimSpace(mode))}
+	if p.Mode == "" {
+		p.Mode = RegistrationOpen
+	}
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "@")
+		if d != "" {
+			p.AllowedDomains = append(p.AllowedDomains, d)
+		}
+	}
+
+	switch p.Mode {
+	case RegistrationOpen, RegistrationClosed, RegistrationInvite:
+	case RegistrationDomain:
+		if len(p.AllowedDomains) == 0 {
+			return p, fmt.Errorf("registration mode domain requires at least one allowed domain")
+		}
+	default:
+		return p, fmt.Errorf("unknown registration mode %q", p.Mode)
+	}
+	return p, nil
+}
+
+// domainAllowed reports whether email's domain is on the allowlist. Matching
+// is exact: "example.com" does not admit "mail.example.com".
+func (p RegistrationPolicy) domainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range p.AllowedDomains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOpenModes enforces the modes that need no database access.
+func (p RegistrationPolicy) checkOpenModes(email string) error {
+	switch p.Mode {
+	case RegistrationClosed:
+		return errRegistrationClosed
+	case RegistrationDomain:
+		if !p.domainAllowed(email) {
+			return errDomainNotAllowed
+		}
+	}
+	return nil
+}
+
+// redeemedInvite is an invite claimed inside a registration transaction.
+type redeemedInvite struct {
+	ID    string
+	Email string // empty when the invite was not bound to an address
+	Role  string
+}
+
+// claimInvite locks the unused, unexpired invite matching code for update.
+// The caller marks it used in the same transaction.
+func claimInvite(ctx context.Context, tx *sql.Tx, code, email string) (redeemedInvite, error) {
+	var (
+		inv        redeemedInvite
+		inviteMail sql.NullString
+	)
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, email, role FROM invites
+		WHERE code_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		FOR UPDATE
+	`, hashToken(code)).Scan(&inv.ID, &inviteMail, &inv.Role)
+	if err == sql.ErrNoRows {
+		return inv, errInviteInvalid
+	}
+	if err != nil {
+		return inv, err
+	}
+	if inviteMail.Valid {
+		if !strings.EqualFold(inviteMail.String, email) {
+			return inv, errInviteInvalid
+		}
+		inv.Email = inviteMail.String
+	}
+	return inv, nil
+}
+
+// registrationStatus answers GET /register so the UI can adapt its form to
+// the active mode. The domain allowlist is not disclosed.
+func (cfg Config) registrationStatus(w http.ResponseWriter) {
+	mode := RegistrationOpen
+	if cfg.Registration != nil {
+		mode = cfg.Registration.Mode
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"mode":            mode,
+		"invite_required": mode == RegistrationInvite,
+	})
+}
+
+// InviteInfo describes an invite in admin listings. The code itself is only
+// returned once, at creation.
+type InviteInfo struct {
+	ID        string     `json:"id"`
+	Email     string     `json:"email,omitempty"`
+	Role      string     `json:"role"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedBy    string     `json:"used_by,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Status    string     `json:"status"` // active, used, revoked or expired
+}
+
+func (i *InviteInfo) setStatus(now time.Time) {
+	switch {
+	case i.RevokedAt != nil:
+		i.Status = "revoked"
+	case i.UsedAt != nil:
+		i.Status = "used"
+	case !now.Before(i.ExpiresAt):
+		i.Status = "expired"
+	default:
+		i.Status = "active"
+	}
+}
+
+type createInviteReq struct {
+	Email      string `json:"email"`
+	Role       string `json:"role"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+func clampInviteTTL(seconds int64) time.Duration {
+	if seconds <= 0 {
+		return defaultInviteTTL
+	}
+	d := time.Duration(seconds) * time.Second
+	if d > maxInviteTTL {
+		return maxInviteTTL
+	}
+	return d
+}
+
+// AdminInvitesHandler handles GET /admin/invites (list; ?status=active shows
+// only redeemable invites) and POST /admin/invites (create).
+func (s *Server) AdminInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.adminListInvites(w, r)
+	case http.MethodPost:
+		s.adminCreateInvite(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) adminCreateInvite(w http.ResponseWriter, r *http.Request) {
+	var req createInviteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
+	if req.Email != "" && !validateEmail(req.Email) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleUser
+	}
+	if req.Role != RoleUser && req.Role != RoleAdmin {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomURLToken()
+	if err != nil {
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+	id := uuid.New().String()
+	expiresAt := time.Now().Add(clampInviteTTL(req.TTLSeconds)).UTC()
+	actor := PrincipalFromContext(r.Context()).Subject
+
+	var email sql.NullString
+	if req.Email != "" {
+		email = sql.NullString{String: req.Email, Valid: true}
+	}
+	if _, err := s.db.ExecContext(r.Context(), `
+		INSERT INTO invites (id, code_hash, email, role, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, id, hashToken(code), email, req.Role, actor, expiresAt); err != nil {
+		logFor(r.Context(), "admin").Error("invites_insert_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	link := publicBaseURL(s.publicBaseURL, r) + "/?invite=" + code
+	emailSent := false
+	if req.Email != "" {
+		m := s.mailer
+		if m == nil {
+			m = LogMailer{}
+		}
+		// The mailed link never comes from the request, unlike invite_url.
+		base, err := mailBaseURL(s.publicBaseURL)
+		if err == nil {
+			err = m.Send(r.Context(), MailMessage{
+				To:      req.Email,
+				Subject: "You are invited to Secure File Drop",
+				Body: "You have been invited to create a Secure File Drop account. Register here:\n\n" + base + "/?invite=" + code +
+					"\n\nThe invitation can be used once and expires on " + expiresAt.Format(time.RFC1123) + ".\n",
+			})
+		}
+		if err != nil {
+			logFor(r.Context(), "admin").Error("invites_mail_failed", errAttr(err))
+		}
+		emailSent = err == nil
+	}
+
+	recordAudit(r.Context(), "invite_created", map[string]string{
+		"actor":     actor,
+		"invite_id": id,
+		"email":     req.Email,
+		"role":      req.Role,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":         id,
+		"code":       code,
+		"invite_url": link,
+		"email":      req.Email,
+		"role":       req.Role,
+		"expires_at": expiresAt,
+		"email_sent": emailSent,
+	})
+}
+
+func (s *Server) adminListInvites(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r.URL.Query())
+	cond := ""
+	switch r.URL.Query().Get("status") {
+	case "", "all":
+	case "active":
+		cond = "WHERE used_at IS NULL AND revoked_at IS NULL AND expires_at > now()"
+	default:
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT id, COALESCE(email, ''), role, created_by, created_at, expires_at,
+		       used_at, COALESCE(used_by::text, ''), revoked_at
+		FROM invites `+cond+`
+		ORDER BY created_at DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		logFor(r.Context(), "admin").Error("invites_query_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	invites := []InviteInfo{}
+	for rows.Next() {
+		var (
+			inv           InviteInfo
+			used, revoked sql.NullTime
+		)
+		if err := rows.Scan(&inv.ID, &inv.Email, &inv.Role, &inv.CreatedBy, &inv.CreatedAt,
+			&inv.ExpiresAt, &used, &inv.UsedBy, &revoked); err != nil {
+			logFor(r.Context(), "admin").Error("invites_scan_failed", errAttr(err))
+			continue
+		}
+		if used.Valid {
+			inv.UsedAt = &used.Time
+		}
+		if revoked.Valid {
+			inv.RevokedAt = &revoked.Time
+		}
+		inv.setStatus(now)
+		invites = append(invites, inv)
+	}
+	if err := rows.Err(); err != nil {
+		logFor(r.Context(), "admin").Error("invites_rows_error", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(invites); err != nil {
+		logFor(r.Context(), "admin").Error("invites_encode_failed", errAttr(err))
+	}
+}
+
+// AdminRevokeInviteHandler handles DELETE /admin/invites/{id}. Used invites
+// cannot be revoked; revoking twice is a no-op.
+func (s *Server) AdminRevokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/invites/"), "/")
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	res, err := s.db.ExecContext(r.Context(),
+		`UPDATE invites SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		logFor(r.Context(), "admin").Error("invites_revoke_failed", errAttr(err))
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Invite not found or already used", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r.Context(), "invite_revoked", map[string]string{
+		"actor":     PrincipalFromContext(r.Context()).Subject,
+		"invite_id": id,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRegistrationPolicy(t *testing.T) {
+	p, err := NewRegistrationPolicy("", nil)
+	if err != nil || p.Mode != RegistrationOpen {
+		t.Fatalf("expected open default, got %+v (%v)", p, err)
+	}
+
+	if _, err := NewRegistrationPolicy("Domain", nil); err == nil {
+		t.Fatal("expected error for domain mode without domains")
+	}
+
+	p, err = NewRegistrationPolicy("Domain", []string{" Example.com", "@corp.example ", ""})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p.AllowedDomains) != 2 || p.AllowedDomains[0] != "example.com" || p.AllowedDomains[1] != "corp.example" {
+		t.Fatalf("unexpected domains: %v", p.AllowedDomains)
+	}
+
+	if _, err := NewRegistrationPolicy("sometimes", nil); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestRegistrationPolicy_CheckOpenModes(t *testing.T) {
+	domain := RegistrationPolicy{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}
+	if err := domain.checkOpenModes("alice@EXAMPLE.com"); err != nil {
+		t.Fatalf("expected allowed domain, got %v", err)
+	}
+	for _, email := range []string{"alice@mail.example.com", "alice@example.com.evil.io", "alice@other.org"} {
+		if err := domain.checkOpenModes(email); err != errDomainNotAllowed {
+			t.Errorf("%s: expected errDomainNotAllowed, got %v", email, err)
+		}
+	}
+
+	if err := (RegistrationPolicy{Mode: RegistrationClosed}).checkOpenModes("a@example.com"); err != errRegistrationClosed {
+		t.Fatalf("expected closed, got %v", err)
+	}
+	if err := (RegistrationPolicy{Mode: RegistrationOpen}).checkOpenModes("a@example.com"); err != nil {
+		t.Fatalf("expected open, got %v", err)
+	}
+}
+
+func TestRegisterHandler_EnforcesModeBeforeDB(t *testing.T) {
+	body := `{"email":"alice@other.org","username":"alice","password":"password123"}`
+	cases := []struct {
+		policy RegistrationPolicy
+		want   string
+	}{
+		{RegistrationPolicy{Mode: RegistrationClosed}, "Registration is closed"},
+		{RegistrationPolicy{Mode: RegistrationDomain, AllowedDomains: []string{"example.com"}}, "Email domain is not allowed"},
+		{RegistrationPolicy{Mode: RegistrationInvite}, "Invite code required"},
+	}
+	for _, c := range cases {
+		policy := c.policy
+		cfg := Config{Registration: &policy}
+		rr := httptest.NewRecorder()
+		cfg.RegisterHandler(rr, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), c.want) {
+			t.Errorf("mode %s: got %d %q", policy.Mode, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestRegisterHandler_GetReportsMode(t *testing.T) {
+	cfg := Config{Registration: &RegistrationPolicy{Mode: RegistrationInvite}}
+	rr := httptest.NewRecorder()
+	cfg.RegisterHandler(rr, httptest.NewRequest(http.MethodGet, "/register", nil))
+
+	var got struct {
+		Mode           string `json:"mode"`
+		InviteRequired bool   `json:"invite_required"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != RegistrationInvite || !got.InviteRequired {
+		t.Fatalf("unexpected status: %+v", got)
+	}
+}
+
+func TestClampInviteTTL(t *testing.T) {
+	if clampInviteTTL(0) != defaultInviteTTL {
+		t.Fatal("expected default TTL")
+	}
+	if clampInviteTTL(3600) != time.Hour {
+		t.Fatal("expected 1h")
+	}
+	if clampInviteTTL(int64(365*24*3600)) != maxInviteTTL {
+		t.Fatal("expected max TTL")
+	}
+}
+
+func TestInviteInfo_Status(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	cases := []struct {
+		inv  InviteInfo
+		want string
+	}{
+		{InviteInfo{ExpiresAt: future}, "active"},
+		{InviteInfo{ExpiresAt: past}, "expired"},
+		{InviteInfo{ExpiresAt: future, UsedAt: &past}, "used"},
+		{InviteInfo{ExpiresAt: future, UsedAt: &past, RevokedAt: &past}, "revoked"},
+	}
+	for _, c := range cases {
+		c.inv.setStatus(now)
+		if c.inv.Status != c.want {
+			t.Errorf("got %s, want %s", c.inv.Status, c.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RuntimeSettings is the part of the configuration a running server can
+// change without a restart (SIGHUP or POST /admin/config/reload), so that
+// tuning limits does not kill in-flight uploads.
+type RuntimeSettings struct {
+	MaxUploadBytes  int64 // 0 means no limit
+	CleanupEnabled  bool
+	CleanupInterval time.Duration
+	CleanupMaxAge   time.Duration
+	RateLimits      string // per-route overrides, see parseRateLimitRules
+	LogLevel        slog.Level
+}
+
+// validate rejects settings that would break a running server. Nothing is
+// applied unless all of them pass.
+func (rs RuntimeSettings) validate() error {
+	if rs.MaxUploadBytes < 0 {
+		return errors.New("max upload bytes must not be negative")
+	}
+	if rs.CleanupEnabled {
+		if rs.CleanupInterval < time.Second {
+			return fmt.Errorf("cleanup interval must be at least 1s, got %s", rs.CleanupInterval)
+		}
+		if rs.CleanupMaxAge < time.Minute {
+			return fmt.Errorf("cleanup max age must be at least 1m, got %s", rs.CleanupMaxAge)
+		}
+	}
+	if _, err := parseRateLimitRules(rs.RateLimits); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SettingChange is one runtime setting changed by a reload.
+type SettingChange struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// changesFrom lists the settings that differ between old and rs, keyed by
+// their configuration file names.
+func (rs RuntimeSettings) changesFrom(old RuntimeSettings) []SettingChange {
+	out := []SettingChange{}
+	add := func(key, o, n string) {
+		if o != n {
+			out = append(out, SettingChange{Key: key, Old: o, New: n})
+		}
+	}
+	add("server.max_upload_bytes", strconv.FormatInt(old.MaxUploadBytes, 10), strconv.FormatInt(rs.MaxUploadBytes, 10))
+	add("cleanup.enabled", strconv.FormatBool(old.CleanupEnabled), strconv.FormatBool(rs.CleanupEnabled))
+	add("cleanup.interval", old.CleanupInterval.String(), rs.CleanupInterval.String())
+	add("cleanup.max_age", old.CleanupMaxAge.String(), rs.CleanupMaxAge.String())
+	add("rate_limit.rules", old.RateLimits, rs.RateLimits)
+	add("log.level", old.LogLevel.String(), rs.LogLevel.String())
+	return out
+}
+
+// ReloadSource re-reads the configuration for a reload. It returns the new
+// runtime settings and the keys of changed settings that only take effect
+// after a restart.
+type ReloadSource func() (RuntimeSettings, []string, error)
+
+// ReloadResult reports what a successful reload changed.
+type ReloadResult struct {
+	Changed         []SettingChange `json:"changed"`
+	RestartRequired []string        `json:"restart_required"`
+}
+
+// errReloadUnavailable is returned when the server was built without a
+// ReloadSource.
+var errReloadUnavailable = errors.New("configuration reload is not available")
+
+// runtimeConfig holds the live RuntimeSettings. It is shared by pointer
+// between the Server and the handler closures, which receive Config by
+// value.
+type runtimeConfig struct {
+	p atomic.Pointer[RuntimeSettings]
+}
+
+func newRuntimeConfig(rs RuntimeSettings) *runtimeConfig {
+	rc := &runtimeConfig{}
+	rc.p.Store(&rs)
+	return rc
+}
+
+func (rc *runtimeConfig) load() RuntimeSettings { return *rc.p.Load() }
+
+// maxUploadBytes returns the current upload limit; it follows reloads when
+// cfg was wired by New.
+func (cfg Config) maxUploadBytes() int64 {
+	if cfg.runtime != nil {
+		return cfg.runtime.load().MaxUploadBytes
+	}
+	return cfg.MaxUploadBytes
+}
+
+// Reload re-reads the configuration through the ReloadSource and applies
+// the runtime settings atomically: the new values are validated first and,
+// should applying any of them fail, the previous settings are restored.
+// Every attempt is recorded in the audit trail. trigger names the caller
+// ("signal", "admin") in logs and audit events.
+func (s *Server) Reload(ctx context.Context, trigger string) (ReloadResult, error) {
+	if s.reloadSource == nil {
+		return ReloadResult{}, errReloadUnavailable
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if auditLogFrom(ctx) == nil {
+		ctx = withAuditLog(withLogger(ctx, s.logger), s.audit)
+	}
+	fields := map[string]string{"trigger": trigger}
+	if PrincipalFromContext(ctx).Subject == "" {
+		fields["actor"] = "system"
+	}
+	logger := logFor(ctx, "config")
+
+	res, err := s.reload()
+	if err != nil {
+		logger.Error("reload_failed", slog.String("trigger", trigger), errAttr(err))
+		fields["error"] = err.Error()
+		recordAudit(ctx, "config_reload_failed", fields)
+		return ReloadResult{}, err
+	}
+
+	changed := make([]string, 0, len(res.Changed))
+	for _, c := range res.Changed {
+		fields[c.Key] = c.Old + " -> " + c.New
+		changed = append(changed, c.Key)
+	}
+	fields["changed"] = strings.Join(changed, ",")
+	if len(res.RestartRequired) > 0 {
+		fields["restart_required"] = strings.Join(res.RestartRequired, ",")
+		logger.Warn("reload_restart_required", slog.Any("keys", res.RestartRequired))
+	}
+	logger.Info("reloaded", slog.String("trigger", trigger), slog.Any("changed", changed))
+	recordAudit(ctx, "config_reloaded", fields)
+	return res, nil
+}
+
+// reload reads, validates and applies new settings, restoring the previous
+// ones if applying fails part way.
+func (s *Server) reload() (ReloadResult, error) {
+	next, restart, err := s.reloadSource()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	if err := next.validate(); err != nil {
+		return ReloadResult{}, err
+	}
+	prev := s.runtime.load()
+	if err := s.applySettings(next); err != nil {
+		if rerr := s.applySettings(prev); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("rollback: %w", rerr))
+		}
+		return ReloadResult{}, err
+	}
+	return ReloadResult{Changed: next.changesFrom(prev), RestartRequired: append([]string{}, restart...)}, nil
+}
+
+// applySettings pushes rs to the rate limiter, the log level and the
+// cleanup job, then publishes it to the handlers.
+func (s *Server) applySettings(rs RuntimeSettings) error {
+	if s.rateLimiter != nil {
+		if err := s.rateLimiter.SetRules(rs.RateLimits); err != nil {
+			return err
+		}
+	}
+	if s.logLevel != nil {
+		s.logLevel.Set(rs.LogLevel)
+	}
+	s.runtime.p.Store(&rs)
+
+	// Replace any update the cleanup job has not picked up yet.
+	select {
+	case <-s.cleanupUpdates:
+	default:
+	}
+	s.cleanupUpdates <- s.cleanupConfig()
+	return nil
+}
+
+// AdminConfigReloadHandler handles POST /admin/config/reload: it reloads
+// the configuration like SIGHUP and returns the changed settings.
+func (s *Server) AdminConfigReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, err := s.Reload(r.Context(), "admin")
+	if errors.Is(err, errReloadUnavailable) {
+		http.Error(w, "Configuration reload not available", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		// The previous configuration stays in effect; report why.
+		http.Error(w, "Configuration reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
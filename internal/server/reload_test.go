@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newReloadServer builds the parts of a Server that Reload touches (New
+// needs a reachable object store) with reloads coming from *next; a nil
+// next makes the source fail.
+func newReloadServer(t *testing.T, next *RuntimeSettings, restart []string) (*Server, *slog.LevelVar) {
+	t.Helper()
+	rl, err := NewRateLimiter(nil, AuthConfig{}, RateLimitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	level := new(slog.LevelVar)
+	s := &Server{
+		bucket: "sfd",
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		runtime: newRuntimeConfig(RuntimeSettings{
+			MaxUploadBytes:  1 << 20,
+			CleanupEnabled:  true,
+			CleanupInterval: time.Hour,
+			CleanupMaxAge:   24 * time.Hour,
+			LogLevel:        slog.LevelInfo,
+		}),
+		reloadSource: func() (RuntimeSettings, []string, error) {
+			if next == nil {
+				return RuntimeSettings{}, nil, errors.New("config file: no such file")
+			}
+			return *next, restart, nil
+		},
+		rateLimiter:    rl,
+		logLevel:       level,
+		cleanupUpdates: make(chan CleanupConfig, 1),
+	}
+	return s, level
+}
+
+func TestReload_AppliesRuntimeSettings(t *testing.T) {
+	next := RuntimeSettings{
+		MaxUploadBytes:  5 << 20,
+		CleanupEnabled:  true,
+		CleanupInterval: 10 * time.Minute,
+		CleanupMaxAge:   24 * time.Hour,
+		RateLimits:      "login:ip=3/1m",
+		LogLevel:        slog.LevelDebug,
+	}
+	s, level := newReloadServer(t, &next, []string{"server.addr"})
+
+	res, err := s.Reload(context.Background(), "signal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]SettingChange{}
+	for _, c := range res.Changed {
+		keys[c.Key] = c
+	}
+	if len(keys) != 4 || keys["server.max_upload_bytes"].New != "5242880" || keys["cleanup.interval"].Old != "1h0m0s" {
+		t.Fatalf("changes: %+v", res.Changed)
+	}
+	if len(res.RestartRequired) != 1 || res.RestartRequired[0] != "server.addr" {
+		t.Errorf("restart required: %v", res.RestartRequired)
+	}
+
+	if got := s.runtime.load().MaxUploadBytes; got != 5<<20 {
+		t.Errorf("upload limit not applied: %d", got)
+	}
+	if level.Level() != slog.LevelDebug {
+		t.Errorf("log level not applied: %s", level.Level())
+	}
+	if r := s.rateLimiter.rulesFor("login"); len(r) != 1 || r[0].Limit != 3 {
+		t.Errorf("rate limit rules not applied: %+v", r)
+	}
+	if r := s.rateLimiter.rulesFor("upload"); len(r) != 1 {
+		t.Errorf("default rules should remain: %+v", r)
+	}
+	select {
+	case c := <-s.cleanupUpdates:
+		if c.Interval != 10*time.Minute || c.Bucket != "sfd" {
+			t.Errorf("cleanup update: %+v", c)
+		}
+	default:
+		t.Error("cleanup job was not sent the new settings")
+	}
+}
+
+func TestReload_InvalidSettingsKeepCurrent(t *testing.T) {
+	next := RuntimeSettings{MaxUploadBytes: 5 << 20, RateLimits: "login:host=1/1m"}
+	s, _ := newReloadServer(t, &next, nil)
+	before := s.runtime.load()
+
+	if _, err := s.Reload(context.Background(), "signal"); err == nil {
+		t.Fatal("invalid rate limit rules should fail the reload")
+	}
+	if s.runtime.load() != before {
+		t.Errorf("settings changed after a failed reload: %+v", s.runtime.load())
+	}
+
+	next = RuntimeSettings{CleanupEnabled: true, CleanupInterval: 0, CleanupMaxAge: time.Hour}
+	if _, err := s.Reload(context.Background(), "signal"); err == nil {
+		t.Fatal("zero cleanup interval should fail the reload")
+	}
+
+	s, _ = newReloadServer(t, nil, nil)
+	if _, err := s.Reload(context.Background(), "signal"); err == nil {
+		t.Fatal("source error should fail the reload")
+	}
+	select {
+	case <-s.cleanupUpdates:
+		t.Error("failed reload must not reconfigure the cleanup job")
+	default:
+	}
+}
+
+func TestAdminConfigReloadHandler(t *testing.T) {
+	next := RuntimeSettings{MaxUploadBytes: 2 << 20, LogLevel: slog.LevelWarn}
+	s, _ := newReloadServer(t, &next, nil)
+
+	rr := httptest.NewRecorder()
+	s.AdminConfigReloadHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/config/reload", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET: expected 405, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	s.AdminConfigReloadHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var res ReloadResult
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Changed) == 0 || res.RestartRequired == nil {
+		t.Errorf("result: %+v", res)
+	}
+
+	next.RateLimits = "bogus"
+	rr = httptest.NewRecorder()
+	s.AdminConfigReloadHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid config: expected 422, got %d", rr.Code)
+	}
+
+	s.reloadSource = nil
+	rr = httptest.NewRecorder()
+	s.AdminConfigReloadHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil))
+	if rr.Code != http.StatusNotImplemented {
+		t.Fatalf("no source: expected 501, got %d", rr.Code)
+	}
+}
+
+func TestRunCleanupJob_FollowsUpdates(t *testing.T) {
+	runs := make(chan CleanupConfig, 16)
+	prev := cleanupRun
+	cleanupRun = func(_ context.Context, c CleanupConfig) { runs <- c }
+	t.Cleanup(func() { cleanupRun = prev })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan CleanupConfig, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runCleanupJob(ctx, CleanupConfig{Enabled: false, Interval: time.Hour}, updates)
+	}()
+
+	wait := func(what string) CleanupConfig {
+		t.Helper()
+		select {
+		case c := <-runs:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+			return CleanupConfig{}
+		}
+	}
+
+	// Enabling runs at once, then on the new interval.
+	updates <- CleanupConfig{Enabled: true, Interval: 10 * time.Millisecond, MaxAge: time.Hour}
+	wait("the run on enable")
+	if c := wait("a tick"); c.Interval != 10*time.Millisecond {
+		t.Errorf("tick used stale settings: %+v", c)
+	}
+
+	// Disabling stops the ticks.
+	updates <- CleanupConfig{Enabled: false, Interval: 10 * time.Millisecond}
+	time.Sleep(30 * time.Millisecond)
+	for len(runs) > 0 {
+		<-runs
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(runs) != 0 {
+		t.Errorf("disabled job still ran %d times", len(runs))
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job did not stop on cancel")
+	}
+}
+
+func TestRateLimiter_SetRules(t *testing.T) {
+	l := &RateLimiter{Store: NewMemoryRateLimitStore(), Rules: map[string][]RateLimitRule{}}
+	h := l.Limit("register", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/register", nil)
+		req.RemoteAddr = "198.51.100.9:1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if do() != http.StatusOK || do() != http.StatusOK {
+		t.Fatal("no rules: requests should pass")
+	}
+
+	// Rules set after the handler was wrapped apply to the next request.
+	if err := l.SetRules("register:ip=1/1h"); err != nil {
+		t.Fatal(err)
+	}
+	if do() != http.StatusOK || do() != http.StatusTooManyRequests {
+		t.Fatal("new rule should limit the route")
+	}
+
+	if err := l.SetRules("register:ip"); err == nil {
+		t.Fatal("malformed rules should be rejected")
+	}
+	if r := l.rulesFor("register"); len(r) != 1 || r[0].Limit != 1 {
+		t.Fatalf("rules changed after a rejected update: %+v", r)
+	}
+}
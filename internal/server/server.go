@@ -4,11 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // BuildInfo contains build-time metadata embedded into the server.
@@ -25,10 +31,94 @@ type BuildInfo struct {
 // Addr is the listen address (e.g. ":8080"). Auth and DB are required
 // for production use; other values are validated during startup.
 type Config struct {
-	Addr  string // e.g. ":8080"
-	Build BuildInfo
-	Auth  AuthConfig
-	DB    *sql.DB
+	Addr   string // e.g. ":8080"
+	Build  BuildInfo
+	Auth   AuthConfig
+	DB     *sql.DB
+	Mailer Mailer // account emails; defaults to LogMailer
+
+	// PublicBaseURL is the origin used in links handed out to users; when
+	// empty the request's own origin is used.
+	PublicBaseURL string
+
+	// MaxUploadBytes caps an upload request body; 0 means no limit. It can
+	// change on reload.
+	MaxUploadBytes int64
+
+	// DownloadSecret signs download link tokens.
+	DownloadSecret string
+
+	// WebFS holds index.html and the static assets of the web UI, usually
+	// the embedded web.Static(). WebDir, when set, replaces it with a
+	// directory read on every request, for live development. The UI is
+	// not served when both are empty.
+	WebFS  fs.FS
+	WebDir string
+
+	// Storage locates the object store; New panics when it is incomplete.
+	Storage StorageConfig
+
+	// Cleanup configures the stale file cleanup job; the zero value
+	// disables it. Its DB, MinIO and Metrics fields are filled in by New.
+	// Enabled, Interval and MaxAge can change on reload.
+	Cleanup CleanupConfig
+
+	// RateLimiter applies per-route request budgets; defaults to
+	// NewRateLimiter(DB, Auth, RateLimit). RateLimit.Rules can change on
+	// reload.
+	RateLimiter *RateLimiter
+	RateLimit   RateLimitConfig
+
+	// Registration controls who may use /register; defaults to open
+	// registration.
+	Registration *RegistrationPolicy
+
+	// Metrics controls scrape authentication and the optional dedicated
+	// metrics listener; defaults to no scrape token (admin:read required)
+	// and no dedicated listener.
+	Metrics *MetricsConfig
+
+	// Registry receives the application metrics; defaults to NewMetrics().
+	Registry *Metrics
+
+	// Tracing selects the span exporter; defaults to no export. It is
+	// ignored when TracerProvider is set.
+	Tracing *TracingConfig
+
+	// TracerProvider receives request and upload spans; defaults to the
+	// provider built from Tracing (a no-op provider when tracing is off).
+	TracerProvider trace.TracerProvider
+
+	// Logger is the parent of every request-scoped and background logger;
+	// defaults to slog.Default().
+	Logger *slog.Logger
+
+	// LogLevel is the level variable behind Logger (LogConfig.LevelVar);
+	// when set, reloads change it. Nil leaves the level fixed.
+	LogLevel *slog.LevelVar
+
+	// Reload re-reads the configuration for SIGHUP and
+	// /admin/config/reload; nil disables reloading.
+	Reload ReloadSource
+
+	// Audit stores audit events in the audit_events hash chain; defaults to
+	// NewAuditLog(DB) when DB is set. Without it events are only logged.
+	Audit *AuditLog
+
+	// Health controls /ready caching and the shutdown drain delay;
+	// defaults to a 2s cache and no delay.
+	Health *HealthConfig
+
+	// TLS serves HTTPS on Addr, with HSTS and an optional redirect
+	// listener; nil or disabled serves plain HTTP (TLS at a proxy).
+	TLS *TLSConfig
+
+	// runtime holds the reloadable settings once New has run; handler
+	// closures read it through their copy of Config.
+	runtime *runtimeConfig
+
+	// uploads tracks uploads in flight for Shutdown; set by New.
+	uploads *uploadTracker
 }
 
 // Server is the application HTTP server with its dependencies.
@@ -40,7 +130,47 @@ type Server struct {
 	db          *sql.DB
 	minio       *minio.Client
 	bucket      string
-	cleanupDone chan struct{}
+	throttle    *LoginThrottle
+	mailer      Mailer
+	metrics     *Metrics
+	logger      *slog.Logger
+	audit       *AuditLog
+	jobs        *Lifecycle     // background workers started by Start
+	uploads     *uploadTracker // uploads in flight, drained by Shutdown
+	uploadDrain time.Duration  // how long Shutdown waits for uploads
+
+	// leaders elect the replica running each singleton job, by job name.
+	leaders map[string]*leaderElector
+
+	// runtime holds the reloadable settings; Reload swaps them under
+	// reloadMu and hands cleanup changes to the job via cleanupUpdates.
+	runtime        *runtimeConfig
+	reloadMu       sync.Mutex
+	reloadSource   ReloadSource
+	rateLimiter    *RateLimiter
+	logLevel       *slog.LevelVar
+	cleanupUpdates chan CleanupConfig
+
+	// publicBaseURL is Config.PublicBaseURL for admin-issued links.
+	publicBaseURL string
+
+	// health backs /ready and /health/deep; Shutdown flips it to draining.
+	health     *HealthRegistry
+	drainDelay time.Duration
+
+	// maintenance caches the read-only flag shared through the database.
+	maintenance *maintenanceMode
+
+	// metricsServer serves /metrics on MetricsConfig.Addr; nil when unset.
+	metricsServer *http.Server
+
+	// redirectServer sends plain HTTP on TLSConfig.RedirectAddr to HTTPS;
+	// nil when unset.
+	redirectServer *http.Server
+
+	// traceShutdown flushes and stops the tracer provider built in New;
+	// nil when the provider was injected.
+	traceShutdown func(context.Context) error
 }
 
 // New constructs and returns an initialized Server wiring handlers and
@@ -49,17 +179,93 @@ type Server struct {
 func New(cfg Config) *Server {
 	mux := http.NewServeMux()
 
-	// Minimal web UI (Milestone 7)
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
+	if cfg.Mailer == nil {
+		cfg.Mailer = LogMailer{}
+	}
+	if cfg.Registry == nil {
+		cfg.Registry = NewMetrics()
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.Audit == nil && cfg.DB != nil {
+		cfg.Audit = NewAuditLog(cfg.DB)
+	}
+	if cfg.Auth.Metrics == nil {
+		cfg.Auth.Metrics = cfg.Registry
+	}
+	if cfg.Auth.Throttle == nil && cfg.DB != nil {
+		cfg.Auth.Throttle = NewLoginThrottle(cfg.DB)
+	}
+	if cfg.Auth.Throttle != nil && cfg.Auth.Throttle.Metrics == nil {
+		cfg.Auth.Throttle.Metrics = cfg.Registry
+	}
+	rl := cfg.RateLimiter
+	if rl == nil {
+		var err error
+		if rl, err = NewRateLimiter(cfg.DB, cfg.Auth, cfg.RateLimit); err != nil {
+			// fail fast: a mistyped policy must not silently disable limits
+			panic(err)
 		}
-		http.ServeFile(w, r, "/app/web/static/index.html")
-	})
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("/app/web/static"))))
+	}
+
+	if cfg.Registration == nil {
+		cfg.Registration = &RegistrationPolicy{Mode: RegistrationOpen}
+	}
+
+	runtime := RuntimeSettings{
+		MaxUploadBytes:  cfg.MaxUploadBytes,
+		CleanupEnabled:  cfg.Cleanup.Enabled,
+		CleanupInterval: cfg.Cleanup.Interval,
+		CleanupMaxAge:   cfg.Cleanup.MaxAge,
+		RateLimits:      cfg.RateLimit.Rules,
+		LogLevel:        slog.LevelInfo,
+	}
+	if cfg.LogLevel != nil {
+		runtime.LogLevel = cfg.LogLevel.Level()
+	}
+	cfg.runtime = newRuntimeConfig(runtime)
+	cfg.uploads = newUploadTracker()
+
+	if cfg.Metrics == nil {
+		cfg.Metrics = &MetricsConfig{}
+	}
+
+	if cfg.Health == nil {
+		cfg.Health = &HealthConfig{CacheTTL: 2 * time.Second}
+	}
 
-	mc, bucket, err := newMinioClient()
+	var traceShutdown func(context.Context) error
+	if cfg.TracerProvider == nil {
+		if cfg.Tracing == nil {
+			tc, err := NewTracingConfig(TraceExporterNone, "")
+			if err != nil {
+				panic(err)
+			}
+			cfg.Tracing = &tc
+		}
+		tp, shutdown, err := cfg.Tracing.newTracerProvider(context.Background(), cfg.Build)
+		if err != nil {
+			panic(err)
+		}
+		cfg.TracerProvider, traceShutdown = tp, shutdown
+	}
+
+	// Minimal web UI (Milestone 7): index at /, assets under /static/
+	if webFS, live := cfg.WebFS, false; webFS != nil || cfg.WebDir != "" {
+		if cfg.WebDir != "" {
+			webFS, live = os.DirFS(cfg.WebDir), true
+		}
+		ui, err := newWebUI(webFS, live)
+		if err != nil {
+			// fail fast: a missing UI would only show up in the browser
+			panic(fmt.Errorf("web UI: %w", err))
+		}
+		mux.Handle("/", ui.indexHandler())
+		mux.Handle("/static/", ui.staticHandler())
+	}
+
+	mc, bucket, err := newMinioClient(cfg.Storage)
 	if err != nil {
 		// fail fast: uploads depend on MinIO; do not start in a half-configured state
 		panic(err)
@@ -74,34 +280,16 @@ func New(cfg Config) *Server {
 		})
 	})
 
-	// Ready endpoint: dependencies are reachable (Postgres and MinIO).
-	mux.HandleFunc("/ready", func(w http.ResponseWriter, _ *http.Request) {
-		if cfg.DB == nil {
-			http.Error(w, "db not configured", http.StatusServiceUnavailable)
-			return
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-
-		// Check Postgres
-		if err := cfg.DB.PingContext(ctx); err != nil {
-			http.Error(w, "db not ready", http.StatusServiceUnavailable)
-			return
-		}
+	// Ready endpoint: cached dependency checks (Postgres, migrations, MinIO,
+	// hash tool, temp dir, download secret); 503 once shutdown has begun.
+	health := NewHealthRegistry(cfg.Health.CacheTTL)
+	registerDefaultChecks(health, cfg.DB, mc, bucket, cfg.DownloadSecret)
+	maint := newMaintenanceMode(cfg.DB)
+	health.maintenance = maint
+	mux.Handle("/ready", health.readyHandler())
 
-		// Check MinIO
-		exists, err := mc.BucketExists(ctx, bucket)
-		if err != nil || !exists {
-			http.Error(w, "minio not ready", http.StatusServiceUnavailable)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"status": "ok",
-		})
-	})
+	// Deep health: every check run now, with latency and error detail (protected)
+	mux.Handle("/health/deep", cfg.Auth.requireScope(ScopeAdminRead, health.deepHandler()))
 
 	// Version endpoint (no secrets)
 	mux.HandleFunc("/version", func(w http.ResponseWriter, _ *http.Request) {
@@ -113,45 +301,80 @@ func New(cfg Config) *Server {
 		})
 	})
 
-	// Metrics endpoint (protected)
-	mux.Handle("/metrics", cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		snapshot := GetMetrics().Snapshot()
+	// Metrics endpoint (Prometheus/OpenMetrics; scrape token or admin:read)
+	mux.Handle("/metrics", cfg.metricsHandler(*cfg.Metrics))
+
+	// JSON metrics snapshot for the admin dashboard (protected)
+	mux.Handle("/admin/metrics", cfg.Auth.requireScope(ScopeAdminRead, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		snapshot := cfg.Registry.Snapshot()
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(snapshot)
 	})))
 
 	// Login endpoint (POST JSON {username,password})
-	mux.HandleFunc("/login", cfg.Auth.loginHandler())
+	mux.Handle("/login", rl.Limit("login", cfg.Auth.loginHandler()))
 
 	// Register endpoint (POST JSON {email,username,password})
-	mux.HandleFunc("/register", cfg.RegisterHandler)
+	mux.Handle("/register", maint.guard(isWrite, rl.Limit("register", http.HandlerFunc(cfg.RegisterHandler))))
 
 	// Protected endpoint for verification only
-	mux.Handle("/me", cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	mux.Handle("/me", cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := PrincipalFromContext(r.Context())
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(map[string]any{
-			"status": "ok",
+			"status":  "ok",
+			"subject": p.Subject,
+			"role":    p.Role,
 		})
 	})))
 
+	// Email verification (GET from mailed link, POST from API clients)
+	mux.HandleFunc("/verify-email", cfg.verifyEmailHandler)
+	mux.Handle("/verify-email/resend", cfg.resendVerificationHandler())
+
+	// Password recovery and change
+	mux.Handle("/password/forgot", rl.Limit("password", http.HandlerFunc(cfg.forgotPasswordHandler)))
+	mux.Handle("/password/reset", rl.Limit("password", http.HandlerFunc(cfg.resetPasswordHandler)))
+	mux.Handle("/password/change", cfg.changePasswordHandler())
+
+	// Personal API tokens (session only): list/create and revoke
+	mux.Handle("/tokens", cfg.tokensHandler(cfg.DB))
+	mux.Handle("/tokens/", cfg.revokeTokenHandler(cfg.DB))
+
 	// Create file record (metadata only; proves DB writes end-to-end)
-	mux.Handle("/files", cfg.createFileHandler(cfg.DB))
+	mux.Handle("/files", maint.guard(isWrite, rl.Limit("files", cfg.createFileHandler(cfg.DB))))
+	mux.Handle("/files/", maint.guard(isWrite, rl.Limit("files", cfg.fileItemHandler(cfg.DB, mc, bucket))))
+
+	// In-app notifications (e.g. files shared with the caller)
+	mux.Handle("/notifications", cfg.notificationsHandler(cfg.DB))
+	mux.Handle("/notifications/read", cfg.notificationsReadHandler(cfg.DB))
+
+	// Organizations (shared file spaces)
+	mux.Handle("/orgs", cfg.orgsHandler(cfg.DB))
+	mux.Handle("/orgs/", cfg.orgHandler(cfg.DB))
 
 	// Stream upload to MinIO (pending -> stored)
-	mux.Handle("/upload", cfg.uploadHandler(cfg.DB, mc, bucket))
+	mux.Handle("/upload", maint.guard(isWrite, rl.Limit("upload", cfg.uploadHandler(cfg.DB, mc, bucket))))
 
 	// Create signed, expiring download links (Milestone 6)
-	mux.Handle("/links", cfg.createLinkHandler(cfg.DB))
+	mux.Handle("/links", maint.guard(isWrite, rl.Limit("links", cfg.createLinkHandler(cfg.DB))))
+
+	// Download file via signed token (Milestone 6), or by id for users with access
+	mux.Handle("/download", rl.Limit("download", cfg.downloadHandler(cfg.DB, mc, bucket)))
 
-	// Download file via signed token (Milestone 6)
-	mux.Handle("/download", cfg.downloadHandler(cfg.DB, mc, bucket))
+	// CSRF token re-issue for the current session
+	mux.Handle("/csrf", cfg.Auth.csrfHandler())
 
-	// Wrap middleware: requestID -> logging -> mux
+	// Wrap middleware: tracing -> requestID -> logging -> audit -> metrics -> csrf -> mux
 	var handler http.Handler = mux
-	handler = loggingMiddleware(handler)
+	handler = cfg.Auth.csrfMiddleware(handler)
+	handler = metricsMiddleware(cfg.Registry, mux, handler)
+	handler = auditMiddleware(cfg.Audit, handler)
+	handler = loggingMiddleware(cfg.Logger, handler)
 	handler = requestIDMiddleware(handler)
+	handler = tracingMiddleware(cfg.TracerProvider, mux, handler)
 
 	s := &http.Server{
 		Addr:              cfg.Addr,
@@ -159,55 +382,241 @@ func New(cfg Config) *Server {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
+	var redirect http.Handler
+	if cfg.TLS != nil && cfg.TLS.Enabled() {
+		tc, rh, err := cfg.TLS.serverTLS(cfg.Logger, cfg.Addr, cfg.PublicBaseURL)
+		if err != nil {
+			// fail fast: never fall back to plain HTTP when HTTPS was asked for
+			panic(err)
+		}
+		s.TLSConfig, redirect = tc, rh
+		if cfg.TLS.HSTSMaxAge > 0 {
+			s.Handler = hstsMiddleware(cfg.TLS.HSTSMaxAge, handler)
+		}
+	}
+
 	srv := &Server{
 		httpServer:  s,
 		db:          cfg.DB,
 		minio:       mc,
 		bucket:      bucket,
-		cleanupDone: make(chan struct{}),
+		throttle:    cfg.Auth.Throttle,
+		mailer:      cfg.Mailer,
+		metrics:     cfg.Registry,
+		logger:      cfg.Logger,
+		audit:       cfg.Audit,
+		jobs:        NewLifecycle(withAuditLog(withLogger(context.Background(), cfg.Logger), cfg.Audit)),
+		uploads:     cfg.uploads,
+		uploadDrain: cfg.Health.UploadDrain,
+		health:      health,
+		drainDelay:  cfg.Health.DrainDelay,
+		maintenance: maint,
+
+		runtime:        cfg.runtime,
+		reloadSource:   cfg.Reload,
+		rateLimiter:    rl,
+		logLevel:       cfg.LogLevel,
+		cleanupUpdates: make(chan CleanupConfig, 1),
+
+		publicBaseURL: cfg.PublicBaseURL,
+
+		traceShutdown: traceShutdown,
+	}
+	if cfg.DB != nil {
+		srv.leaders = map[string]*leaderElector{
+			"cleanup": newLeaderElector(cfg.DB, "cleanup", cleanupLockKey),
+		}
+	}
+	if cfg.Metrics.Addr != "" {
+		srv.metricsServer = &http.Server{
+			Addr:              cfg.Metrics.Addr,
+			Handler:           cfg.Metrics.scrapeHandler(cfg.Registry),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+	}
+	if redirect != nil && cfg.TLS.RedirectAddr != "" {
+		srv.redirectServer = &http.Server{
+			Addr:              cfg.TLS.RedirectAddr,
+			Handler:           redirect,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
 	}
 
 	// Admin endpoints (protected) - registered after Server creation
-	mux.Handle("/admin/files", cfg.Auth.requireAuth(http.HandlerFunc(srv.AdminListFilesHandler)))
+	mux.Handle("/admin/files", cfg.Auth.requireScope(ScopeAdminRead, http.HandlerFunc(srv.AdminListFilesHandler)))
 	mux.HandleFunc("/admin/files/", func(w http.ResponseWriter, r *http.Request) {
-		cfg.Auth.requireAuth(http.HandlerFunc(srv.AdminDeleteFileHandler)).ServeHTTP(w, r)
+		cfg.Auth.requireScope(ScopeAdminWrite, maint.guard(isDelete, http.HandlerFunc(srv.AdminDeleteFileHandler))).ServeHTTP(w, r)
+	})
+	mux.Handle("/admin/cleanup", cfg.Auth.requireScope(ScopeAdminWrite, maint.guard(isWrite, http.HandlerFunc(srv.AdminManualCleanupHandler))))
+	mux.Handle("/admin/jobs", cfg.Auth.requireScope(ScopeAdminRead, http.HandlerFunc(srv.AdminJobsHandler)))
+	mux.Handle("/admin/config/reload", cfg.Auth.requireScope(ScopeAdminWrite, http.HandlerFunc(srv.AdminConfigReloadHandler)))
+	mux.HandleFunc("/admin/lockouts", func(w http.ResponseWriter, r *http.Request) {
+		scope := ScopeAdminRead
+		if r.Method != http.MethodGet {
+			scope = ScopeAdminWrite
+		}
+		cfg.Auth.requireScope(scope, http.HandlerFunc(srv.AdminLockoutsHandler)).ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/admin/invites", func(w http.ResponseWriter, r *http.Request) {
+		scope := ScopeAdminRead
+		if r.Method != http.MethodGet {
+			scope = ScopeAdminWrite
+		}
+		cfg.Auth.requireScope(scope, http.HandlerFunc(srv.AdminInvitesHandler)).ServeHTTP(w, r)
+	})
+	mux.Handle("/admin/invites/", cfg.Auth.requireScope(ScopeAdminWrite, maint.guard(isDelete, http.HandlerFunc(srv.AdminRevokeInviteHandler))))
+	mux.Handle("/admin/users", cfg.Auth.requireScope(ScopeAdminRead, http.HandlerFunc(srv.AdminUsersHandler)))
+	mux.Handle("/admin/audit", cfg.Auth.requireScope(ScopeAdminRead, http.HandlerFunc(srv.AdminAuditHandler)))
+	mux.Handle("/admin/audit/", cfg.Auth.requireScope(ScopeAdminRead, http.HandlerFunc(srv.AdminAuditHandler)))
+	mux.HandleFunc("/admin/users/", func(w http.ResponseWriter, r *http.Request) {
+		scope := ScopeAdminRead
+		if r.Method != http.MethodGet {
+			scope = ScopeAdminWrite
+		}
+		cfg.Auth.requireScope(scope, maint.guard(isDelete, http.HandlerFunc(srv.AdminUserHandler))).ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/admin/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		scope := ScopeAdminRead
+		if r.Method != http.MethodGet {
+			scope = ScopeAdminWrite
+		}
+		cfg.Auth.requireScope(scope, http.HandlerFunc(srv.AdminMaintenanceHandler)).ServeHTTP(w, r)
 	})
-	mux.Handle("/admin/cleanup", cfg.Auth.requireAuth(http.HandlerFunc(srv.AdminManualCleanupHandler)))
 
 	return srv
 }
 
-// Start begins serving HTTP on the configured address and starts background jobs.
+// Start begins serving HTTP (HTTPS when TLS is configured) on the configured
+// address and starts background jobs.
 // It blocks until the listener returns an error (or Shutdown is called).
 func (s *Server) Start() error {
-	// Start cleanup job in background
-	cleanupCfg := GetCleanupConfigFromEnv(s.db, s.minio, s.bucket)
-	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
-
-	go func() {
-		defer close(s.cleanupDone)
-		StartCleanupJob(cleanupCtx, cleanupCfg)
-	}()
-
-	// Store cancel func for shutdown
-	go func() {
-		<-s.cleanupDone
-		cleanupCancel()
-	}()
+	s.startJobs()
 
 	ln, err := net.Listen("tcp", s.httpServer.Addr)
 	if err != nil {
-		cleanupCancel()
+		_ = s.jobs.Stop(context.Background())
 		return err
 	}
+
+	if s.metricsServer != nil {
+		mln, err := net.Listen("tcp", s.metricsServer.Addr)
+		if err != nil {
+			_ = ln.Close()
+			_ = s.jobs.Stop(context.Background())
+			return err
+		}
+		go func() {
+			if err := s.metricsServer.Serve(mln); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("metrics_listener_failed", slog.String(logKeyComponent, "backend"), errAttr(err))
+			}
+		}()
+	}
+
+	if s.redirectServer != nil {
+		rln, err := net.Listen("tcp", s.redirectServer.Addr)
+		if err != nil {
+			_ = ln.Close()
+			_ = s.jobs.Stop(context.Background())
+			return err
+		}
+		go func() {
+			if err := s.redirectServer.Serve(rln); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("redirect_listener_failed", slog.String(logKeyComponent, "backend"), errAttr(err))
+			}
+		}()
+	}
+
+	if s.httpServer.TLSConfig != nil {
+		// Certificates come from TLSConfig.GetCertificate, not files.
+		return s.httpServer.ServeTLS(ln, "", "")
+	}
 	return s.httpServer.Serve(ln)
 }
 
+// startJobs starts the background workers; Shutdown stops them. Singleton
+// jobs run only on the replica holding their advisory lock.
+func (s *Server) startJobs() {
+	for name, e := range s.leaders {
+		s.jobs.Go("leader:"+name, e.run)
+	}
+	cleanupCfg := s.cleanupConfig()
+	s.jobs.Go("cleanup", func(ctx context.Context) {
+		runCleanupJob(ctx, cleanupCfg, s.cleanupUpdates)
+	})
+	s.jobs.Go("resume_uploads", func(ctx context.Context) {
+		resumeInterruptedUploads(ctx, s.db, s.minio, s.bucket, s.metrics)
+	})
+}
+
+// Done is closed once Shutdown has stopped every background worker, so
+// tests can wait for a clean shutdown.
+func (s *Server) Done() <-chan struct{} { return s.jobs.Done() }
+
+// cleanupConfig returns the current cleanup settings wired to the
+// server's database, object store and metrics.
+func (s *Server) cleanupConfig() CleanupConfig {
+	rs := s.runtime.load()
+	return CleanupConfig{
+		Enabled:     rs.CleanupEnabled,
+		Interval:    rs.CleanupInterval,
+		MaxAge:      rs.CleanupMaxAge,
+		DB:          s.db,
+		MinioClient: s.minio,
+		Bucket:      s.bucket,
+		Metrics:     s.metrics,
+		leader:      s.leaders["cleanup"],
+		maintenance: s.maintenance,
+	}
+}
+
 // Shutdown gracefully shuts down the HTTP server and background jobs
 // using the provided context (respecting the deadline/timeout supplied by the caller).
+// Uploads in flight get up to HealthConfig.UploadDrain to finish; the rest
+// are cancelled and their rows marked interrupted.
 func (s *Server) Shutdown(ctx context.Context) error {
-	// Signal cleanup job to stop (via cleanupDone channel close will happen)
-	// The cleanup job checks context.Done() which we handle in Start()
+	// Fail readiness first and keep serving for the drain delay, so load
+	// balancers stop routing new requests before listeners close.
+	s.health.SetDraining()
+	if s.drainDelay > 0 {
+		s.logger.Info("draining", slog.String(logKeyComponent, "backend"), slog.Int64(logKeyDuration, s.drainDelay.Milliseconds()))
+		t := time.NewTimer(s.drainDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+
+	if s.metricsServer != nil {
+		_ = s.metricsServer.Shutdown(ctx)
+	}
+	if s.redirectServer != nil {
+		_ = s.redirectServer.Shutdown(ctx)
+	}
+
+	// Close the listener while uploads drain; the HTTP server waits for
+	// their requests, which end once they finish or are cancelled.
+	drainCtx, cancel := context.WithTimeout(ctx, s.uploadDrain)
+	defer cancel()
+	interrupted := make(chan int, 1)
+	go func() { interrupted <- s.uploads.drain(drainCtx) }()
+	err := s.httpServer.Shutdown(ctx)
+	if n := <-interrupted; n > 0 {
+		s.logger.Warn("uploads_interrupted", slog.String(logKeyComponent, "backend"), slog.Int("count", n))
+	}
 
-	return s.httpServer.Shutdown(ctx)
+	if jerr := s.jobs.Stop(ctx); jerr != nil {
+		s.logger.Error("jobs_shutdown_failed", slog.String(logKeyComponent, "backend"), errAttr(jerr))
+		if err == nil {
+			err = jerr
+		}
+	}
+
+	// Flush spans after the last request has finished.
+	if s.traceShutdown != nil {
+		if terr := s.traceShutdown(ctx); terr != nil {
+			s.logger.Error("trace_shutdown_failed", slog.String(logKeyComponent, "backend"), errAttr(terr))
+		}
+	}
+	return err
 }
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileShare is a grant of one file to one user.
+type FileShare struct {
+	UserID     string    `json:"user_id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// SharedFileInfo is an entry of the "shared with me" listing.
+type SharedFileInfo struct {
+	ID          string    `json:"id"`
+	OrigName    string    `json:"orig_name"`
+	ContentType string    `json:"content_type"`
+	SizeBytes   int64     `json:"size_bytes"`
+	Status      string    `json:"status"`
+	SHA256Hex   string    `json:"sha256_hex,omitempty"`
+	Permission  string    `json:"permission"`
+	SharedBy    string    `json:"shared_by"`
+	SharedAt    time.Time `json:"shared_at"`
+}
+
+func validSharePerm(perm string) bool {
+	return perm == SharePermViewer || perm == SharePermEditor
+}
+
+// displayName returns the username for a user id subject, or the subject
+// itself (the env admin) when it is not a stored account.
+func displayName(ctx context.Context, q queryRower, subject string) string {
+	if _, err := uuid.Parse(subject); err != nil {
+		return subject
+	}
+	var name string
+	if err := q.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1`, subject).Scan(&name); err != nil {
+		return subject
+	}
+	return name
+}
+
+// requireFileOwner loads file id and answers 404/403 unless the caller may
+// manage its access. It reports whether the caller may proceed.
+func requireFileOwner(w http.ResponseWriter, r *http.Request, db *sql.DB, id string) (fileRecord, bool) {
+	f, perm, err := fileAccess(r.Context(), db, PrincipalFromContext(r.Context()), id)
+	if err != nil {
+		if err == errFileNotFound {
+			http.Error(w, "not found", http.StatusNotFound)
+			return f, false
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return f, false
+	}
+	if perm == permNone {
+		http.Error(w, "not found", http.StatusNotFound)
+		return f, false
+	}
+	if perm < permOwner {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return f, false
+	}
+	return f, true
+}
+
+func listShares(w http.ResponseWriter, r *http.Request, db *sql.DB, fileID string) {
+	if _, ok := requireFileOwner(w, r, db, fileID); !ok {
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT u.id, u.username, u.email, s.permission, s.created_by, s.created_at
+		FROM file_shares s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.file_id = $1
+		ORDER BY s.created_at
+	`, fileID)
+	if err != nil {
+		logFor(r.Context(), "shares").Error("list_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	shares := []FileShare{}
+	for rows.Next() {
+		var s FileShare
+		if err := rows.Scan(&s.UserID, &s.Username, &s.Email, &s.Permission, &s.CreatedBy, &s.CreatedAt); err != nil {
+			logFor(r.Context(), "shares").Error("scan_failed", errAttr(err))
+			continue
+		}
+		shares = append(shares, s)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(shares)
+}
+
+// createShare grants (or changes) a user's access to a file, notifies them
+// in-app and, when requested, by email.
+func (cfg Config) createShare(w http.ResponseWriter, r *http.Request, db *sql.DB, fileID string) {
+	var req struct {
+		User        string `json:"user"`
+		Permission  string `json:"permission"`
+		NotifyEmail bool   `json:"notify_email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Permission == "" {
+		req.Permission = SharePermViewer
+	}
+	if !validSharePerm(req.Permission) {
+		http.Error(w, "invalid permission", http.StatusBadRequest)
+		return
+	}
+
+	f, ok := requireFileOwner(w, r, db, fileID)
+	if !ok {
+		return
+	}
+
+	var userID, email string
+	err := db.QueryRowContext(r.Context(),
+		`SELECT id, email FROM users WHERE (username = $1 OR email = lower($1)) AND is_active = TRUE`,
+		strings.TrimSpace(req.User),
+	).Scan(&userID, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	p := PrincipalFromContext(r.Context())
+	if userID == p.Subject || userID == f.CreatedBy {
+		http.Error(w, "user already owns the file", http.StatusConflict)
+		return
+	}
+
+	var inserted bool
+	err = db.QueryRowContext(r.Context(), `
+		INSERT INTO file_shares (file_id, user_id, permission, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (file_id, user_id) DO UPDATE SET permission = EXCLUDED.permission
+		RETURNING xmax = 0
+	`, fileID, userID, req.Permission, p.Subject).Scan(&inserted)
+	if err != nil {
+		logFor(r.Context(), "shares").Error("insert_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r.Context(), "file_shared", map[string]string{
+		"actor":      p.Subject,
+		"file_id":    fileID,
+		"user_id":    userID,
+		"permission": req.Permission,
+	})
+
+	// Notify only on new grants; a permission change is not news.
+	emailSent := false
+	if inserted {
+		msg := fmt.Sprintf("%s shared %q with you (%s)", displayName(r.Context(), db, p.Subject), f.OrigName, req.Permission)
+		if err := notifyUser(r.Context(), db, userID, notifyFileShared, msg, fileID); err != nil {
+			logFor(r.Context(), "shares").Error("notification_failed", errAttr(err))
+		}
+		if req.NotifyEmail {
+			err := cfg.mailer().Send(r.Context(), MailMessage{
+				To:      email,
+				Subject: "A file was shared with you on Secure File Drop",
+				Body:    shareMailBody(msg, cfg.PublicBaseURL, fileID),
+			})
+			if err != nil {
+				logFor(r.Context(), "shares").Error("mail_failed", errAttr(err))
+			} else {
+				emailSent = true
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if inserted {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"file_id":    fileID,
+		"user_id":    userID,
+		"permission": req.Permission,
+		"email_sent": emailSent,
+	})
+}
+
+// shareMailBody is the share notification mail. The download link is only
+// included when server.public_base_url is set: a link built from the
+// request would let the sharer point the recipient at any domain.
+func shareMailBody(msg, baseURL, fileID string) string {
+	base, err := mailBaseURL(baseURL)
+	if err != nil {
+		return msg + ".\n\nSign in to Secure File Drop to download it.\n"
+	}
+	return msg + ".\n\nSign in and download it here:\n\n" + base + "/download?id=" + fileID + "\n"
+}
+
+// revokeShare removes a grant. File owners may revoke any share; recipients
+// may drop their own.
+func revokeShare(w http.ResponseWriter, r *http.Request, db *sql.DB, fileID, userID string) {
+	if _, err := uuid.Parse(userID); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	p := PrincipalFromContext(r.Context())
+	if userID != p.Subject {
+		if _, ok := requireFileOwner(w, r, db, fileID); !ok {
+			return
+		}
+	}
+
+	res, err := db.ExecContext(r.Context(),
+		`DELETE FROM file_shares WHERE file_id = $1 AND user_id = $2`, fileID, userID)
+	if err != nil {
+		logFor(r.Context(), "shares").Error("delete_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r.Context(), "file_unshared", map[string]string{
+		"actor":   p.Subject,
+		"file_id": fileID,
+		"user_id": userID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listSharedWithMe handles GET /files/shared: files other users shared
+// directly with the caller, newest first.
+func listSharedWithMe(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	limit, offset := parsePagination(r.URL.Query())
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT f.id, f.orig_name, f.content_type, f.size_bytes, f.status,
+		       COALESCE(f.sha256_hex, ''), s.permission,
+		       COALESCE(u.username, s.created_by), s.created_at
+		FROM file_shares s
+		JOIN files f ON f.id = s.file_id
+		LEFT JOIN users u ON u.id::text = s.created_by
+		WHERE s.user_id::text = $1
+		ORDER BY s.created_at DESC
+		LIMIT $2 OFFSET $3
+	`, PrincipalFromContext(r.Context()).Subject, limit, offset)
+	if err != nil {
+		logFor(r.Context(), "shares").Error("list_shared_failed", errAttr(err))
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	files := []SharedFileInfo{}
+	for rows.Next() {
+		var f SharedFileInfo
+		if err := rows.Scan(&f.ID, &f.OrigName, &f.ContentType, &f.SizeBytes, &f.Status,
+			&f.SHA256Hex, &f.Permission, &f.SharedBy, &f.SharedAt); err != nil {
+			logFor(r.Context(), "shares").Error("scan_failed", errAttr(err))
+			continue
+		}
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(files)
+}
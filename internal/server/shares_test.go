@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilePermFor_Shares(t *testing.T) {
+	carol := Principal{Subject: "carol", Role: RoleUser}
+	personal := fileRecord{CreatedBy: "alice"}
+	orgFile := fileRecord{CreatedBy: "bob", OrgID: "org-1"}
+
+	cases := []struct {
+		name        string
+		f           fileRecord
+		role, share string
+		want        filePerm
+	}{
+		{"viewer share", personal, "", SharePermViewer, permRead},
+		{"editor share", personal, "", SharePermEditor, permEdit},
+		{"unknown share", personal, "", "owner", permNone},
+		{"org viewer plus editor share", orgFile, OrgRoleViewer, SharePermEditor, permEdit},
+		{"org owner plus viewer share", orgFile, OrgRoleOwner, SharePermViewer, permOwner},
+		{"share on org file without membership", orgFile, "", SharePermViewer, permRead},
+	}
+	for _, c := range cases {
+		if got := filePermFor(carol, c.f, c.role, c.share); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func sessionRequest(t *testing.T, cfg Config, method, target, body string) *http.Request {
+	t.Helper()
+	tok, _, err := cfg.Auth.makeToken(cfg.Auth.AdminUser)
+	if err != nil {
+		t.Fatalf("makeToken error: %v", err)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: cfg.Auth.cookieName(), Value: tok})
+	return req
+}
+
+func TestFileItemHandler_Routing(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}}
+	h := cfg.fileItemHandler(nil, nil, "")
+	id := "6f1c2b7e-8a4d-4c1e-9b0a-2d3e4f5a6b7c"
+
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/files/shared", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/files/" + id + "/links", http.StatusNotFound},
+		{http.MethodGet, "/files/" + id + "/shares/a/b", http.StatusNotFound},
+		{http.MethodPut, "/files/" + id, http.StatusMethodNotAllowed},
+		{http.MethodPatch, "/files/" + id + "/shares", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/files/" + id + "/shares/not-a-uuid", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, sessionRequest(t, cfg, c.method, c.path, ""))
+		if rr.Code != c.want {
+			t.Errorf("%s %s: status %d, want %d", c.method, c.path, rr.Code, c.want)
+		}
+	}
+}
+
+func TestFileItemHandler_RequiresAuth(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{SessionSecret: "s"}}
+	req := httptest.NewRequest(http.MethodGet, "/files/shared", nil)
+	rr := httptest.NewRecorder()
+	cfg.fileItemHandler(nil, nil, "").ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestDownloadByID_RequiresAuth(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{SessionSecret: "s"}}
+	req := httptest.NewRequest(http.MethodGet, "/download?id=6f1c2b7e-8a4d-4c1e-9b0a-2d3e4f5a6b7c", nil)
+	rr := httptest.NewRecorder()
+	cfg.downloadHandler(nil, nil, "").ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestNotificationsReadHandler_Validation(t *testing.T) {
+	cfg := Config{Auth: AuthConfig{AdminUser: "admin", SessionSecret: "s", SessionTTL: time.Hour}}
+	h := cfg.notificationsReadHandler(nil)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, sessionRequest(t, cfg, http.MethodGet, "/notifications/read", ""))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, sessionRequest(t, cfg, http.MethodPost, "/notifications/read", `{"ids":["nope"]}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("bad id: status %d, want 400", rr.Code)
+	}
+}
+
+func TestShareMailBody_LinksOnlyToConfiguredURL(t *testing.T) {
+	body := shareMailBody("bob shared \"a.txt\" with you (viewer)", "https://files.example.com/", "f1")
+	if !strings.Contains(body, "https://files.example.com/download?id=f1") {
+		t.Errorf("configured: %s", body)
+	}
+	if body := shareMailBody("bob shared \"a.txt\" with you (viewer)", "", "f1"); strings.Contains(body, "http") {
+		t.Errorf("unset base URL still links: %s", body)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	sfddb "secure-file-drop/internal/db"
+)
+
+// openTestDB returns a migrated database with every table emptied, or skips
+// the test unless SFD_TEST_DATABASE_URL names a throwaway Postgres database.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("SFD_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("SFD_TEST_DATABASE_URL not set")
+	}
+	db, err := OpenDB(dsn)
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := sfddb.RunMigrations(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	if _, err := db.Exec(`
+		DO $$
+		DECLARE t text;
+		BEGIN
+			FOR t IN SELECT tablename FROM pg_tables
+			         WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'
+			LOOP
+				EXECUTE format('TRUNCATE %I CASCADE', t);
+			END LOOP;
+		END $$`); err != nil {
+		t.Fatalf("reset test database: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO maintenance_mode (id) VALUES (TRUE)`); err != nil {
+		t.Fatalf("seed test database: %v", err)
+	}
+	return db
+}
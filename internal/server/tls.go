@@ -0,0 +1,241 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig enables HTTPS on the main listener for deployments without a
+// TLS-terminating proxy. Certificates come either from CertFile/KeyFile,
+// re-read when the files change, or from an ACME CA for ACMEDomains. The
+// zero value serves plain HTTP.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	ACMEDomains  []string
+	ACMEEmail    string
+	ACMECacheDir string // keeps the account key and certificates across restarts
+	// ACMEDirectoryURL defaults to Let's Encrypt; point it at a staging or
+	// local CA (e.g. Pebble) for testing. ACMECAFile then holds the PEM
+	// roots trusted for that directory's own HTTPS endpoint.
+	ACMEDirectoryURL string
+	ACMECAFile       string
+
+	// RedirectAddr, when set, serves plain HTTP that redirects to HTTPS
+	// (and answers ACME http-01 challenges).
+	RedirectAddr string
+
+	// HSTSMaxAge is sent as Strict-Transport-Security on HTTPS responses;
+	// 0 omits the header.
+	HSTSMaxAge time.Duration
+}
+
+// Enabled reports whether HTTPS is configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || len(c.ACMEDomains) > 0
+}
+
+// certCheckInterval bounds how often handshakes look for new certificate
+// files.
+const certCheckInterval = 10 * time.Second
+
+// serverTLS builds the listener TLS configuration and the handler for the
+// redirect listener. httpsAddr and publicBaseURL decide where redirects
+// point.
+func (c TLSConfig) serverTLS(logger *slog.Logger, httpsAddr, publicBaseURL string) (*tls.Config, http.Handler, error) {
+	redirect := httpsRedirect(httpsAddr, publicBaseURL)
+	switch {
+	case c.CertFile != "" && len(c.ACMEDomains) > 0:
+		return nil, nil, errors.New("tls: certificate files and ACME are mutually exclusive")
+	case c.CertFile != "":
+		if c.KeyFile == "" {
+			return nil, nil, errors.New("tls: key file is required with a certificate file")
+		}
+		certs, err := newCertReloader(c.CertFile, c.KeyFile, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}, redirect, nil
+	case len(c.ACMEDomains) > 0:
+		m, err := c.acmeManager()
+		if err != nil {
+			return nil, nil, err
+		}
+		tc := m.TLSConfig()
+		tc.MinVersion = tls.VersionTLS12
+		return tc, m.HTTPHandler(redirect), nil
+	}
+	return nil, nil, errors.New("tls: no certificate source configured")
+}
+
+// acmeManager obtains and renews certificates for ACMEDomains only.
+func (c TLSConfig) acmeManager() (*autocert.Manager, error) {
+	if c.ACMECacheDir == "" {
+		return nil, errors.New("tls: ACME cache directory is required")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.ACMEDomains...),
+		Cache:      autocert.DirCache(c.ACMECacheDir),
+		Email:      c.ACMEEmail,
+	}
+	if c.ACMEDirectoryURL != "" || c.ACMECAFile != "" {
+		client := &acme.Client{DirectoryURL: c.ACMEDirectoryURL}
+		if c.ACMECAFile != "" {
+			pem, err := os.ReadFile(c.ACMECAFile)
+			if err != nil {
+				return nil, fmt.Errorf("tls: ACME CA file: %w", err)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("tls: ACME CA file %s: no PEM certificates", c.ACMECAFile)
+			}
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+			client.HTTPClient = &http.Client{Transport: transport}
+		}
+		m.Client = client
+	}
+	return m, nil
+}
+
+// certReloader serves a certificate/key pair from disk and reloads it when
+// either file changes, so renewed certificates (certbot, cert-manager)
+// apply without a restart. A pair that fails to load keeps the previous
+// one in service and is retried on the next check.
+type certReloader struct {
+	certFile, keyFile string
+	checkEvery        time.Duration
+	logger            *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	stamp   string // modification times and sizes of the loaded files
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, checkEvery: certCheckInterval, logger: logger}
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	if err := r.load(stamp); err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checked) >= r.checkEvery {
+		r.checked = now
+		r.reloadIfChanged()
+	}
+	return r.cert, nil
+}
+
+// reloadIfChanged loads the files again when their stamp differs from the
+// loaded pair. The caller holds mu.
+func (r *certReloader) reloadIfChanged() {
+	logger := r.logger.With(slog.String(logKeyComponent, "tls"))
+	stamp, err := r.fileStamp()
+	if err != nil {
+		logger.Error("certificate_reload_failed", errAttr(err))
+		return
+	}
+	if stamp == r.stamp {
+		return
+	}
+	if err := r.load(stamp); err != nil {
+		// Often a renewal caught between writing the certificate and the key.
+		logger.Error("certificate_reload_failed", errAttr(err))
+		return
+	}
+	logger.Info("certificate_reloaded", slog.Time("not_after", r.cert.Leaf.NotAfter))
+}
+
+func (r *certReloader) load(stamp string) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.stamp = &cert, stamp
+	return nil
+}
+
+func (r *certReloader) fileStamp() (string, error) {
+	var b strings.Builder
+	for _, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%d/%d;", fi.ModTime().UnixNano(), fi.Size())
+	}
+	return b.String(), nil
+}
+
+// httpsRedirect sends plain-HTTP requests to the same path over HTTPS: on
+// the origin of publicBaseURL when it is an https URL, else on the request
+// host with the port of httpsAddr. GET and HEAD get 301; other methods get
+// 308 so clients repeat them with the same body.
+func httpsRedirect(httpsAddr, publicBaseURL string) http.Handler {
+	origin := ""
+	if u, err := url.Parse(publicBaseURL); err == nil && u.Scheme == "https" && u.Host != "" {
+		origin = "https://" + u.Host
+	}
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := origin
+		if target == "" {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if port != "" && port != "443" {
+				host = net.JoinHostPort(host, port)
+			} else if strings.Contains(host, ":") {
+				host = "[" + host + "]" // IPv6 literal
+			}
+			target = "https://" + host
+		}
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target+r.URL.RequestURI(), code)
+	})
+}
+
+// hstsMiddleware tells browsers to use HTTPS only for maxAge. The header
+// is only meaningful, and only sent, on responses served over TLS.
+func hstsMiddleware(maxAge time.Duration, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
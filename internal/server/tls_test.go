@@ -0,0 +1,255 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 with the given
+// serial to dir and returns its paths and parsed form.
+func writeCert(t *testing.T, dir string, serial int64) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "sfd test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	return certFile, keyFile, cert
+}
+
+func discardLogger() *slog.Logger { return slog.New(slog.NewTextHandler(io.Discard, nil)) }
+
+func TestTLS_ServesAndReloadsCertificateFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeCert(t, dir, 1)
+
+	tc, _, err := TLSConfig{CertFile: certFile, KeyFile: keyFile}.serverTLS(discardLogger(), "127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check the files on every handshake instead of every 10s.
+	certs, err := newCertReloader(certFile, keyFile, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs.checkEvery = 0
+	tc.GetCertificate = certs.GetCertificate
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: hstsMiddleware(time.Hour, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "ok")
+		})),
+		TLSConfig: tc,
+	}
+	go func() { _ = srv.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	// get connects afresh and returns the serial the server presented.
+	get := func(trusted *x509.Certificate) *big.Int {
+		t.Helper()
+		roots := x509.NewCertPool()
+		roots.AddCert(trusted)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get("https://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if got := resp.Header.Get("Strict-Transport-Security"); got != "max-age=3600" {
+			t.Errorf("HSTS header: %q", got)
+		}
+		return resp.TLS.PeerCertificates[0].SerialNumber
+	}
+	if serial := get(first); serial.Int64() != 1 {
+		t.Fatalf("serial %s", serial)
+	}
+
+	// Renew in place; the next handshake sees it.
+	_, _, second := writeCert(t, dir, 2)
+	future := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if serial := get(second); serial.Int64() != 2 {
+		t.Fatalf("renewed certificate not served: serial %s", serial)
+	}
+}
+
+func TestCertReloader_KeepsCertificateOnBadRenewal(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, 7)
+	r, err := newCertReloader(certFile, keyFile, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.checkEvery = 0
+
+	// A certificate written without its key (mid-renewal) must not replace
+	// the working pair.
+	_, otherKey, _ := writeCert(t, t.TempDir(), 8)
+	data, _ := os.ReadFile(otherKey)
+	if err := os.WriteFile(keyFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(keyFile, later, later)
+	cert, err := r.GetCertificate(nil)
+	if err != nil || cert.Leaf.SerialNumber.Int64() != 7 {
+		t.Fatalf("kept certificate: %v %v", cert.Leaf.SerialNumber, err)
+	}
+
+	// Once the matching certificate lands, the pair loads.
+	_, _, _ = writeCert(t, dir, 9)
+	even := time.Now().Add(2 * time.Minute)
+	_ = os.Chtimes(certFile, even, even)
+	_ = os.Chtimes(keyFile, even, even)
+	if cert, _ := r.GetCertificate(nil); cert.Leaf.SerialNumber.Int64() != 9 {
+		t.Fatalf("renewed certificate not loaded: %v", cert.Leaf.SerialNumber)
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile, discardLogger()); err == nil {
+		t.Fatal("missing certificate should fail at startup")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		name, httpsAddr, base, method, host, uri string
+		code                                     int
+		want                                     string
+	}{
+		{"default port", ":443", "", http.MethodGet, "files.example.com", "/download?token=x", http.StatusMovedPermanently, "https://files.example.com/download?token=x"},
+		{"custom port", ":8443", "", http.MethodGet, "files.example.com:8080", "/", http.StatusMovedPermanently, "https://files.example.com:8443/"},
+		{"post keeps method", ":443", "", http.MethodPost, "files.example.com", "/login", http.StatusPermanentRedirect, "https://files.example.com/login"},
+		{"public base url", ":8443", "https://drop.example.com", http.MethodGet, "10.0.0.5", "/x", http.StatusMovedPermanently, "https://drop.example.com/x"},
+		{"ipv6", ":443", "", http.MethodGet, "[::1]:80", "/", http.StatusMovedPermanently, "https://[::1]/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "http://"+tc.host+tc.uri, nil)
+			rr := httptest.NewRecorder()
+			httpsRedirect(tc.httpsAddr, tc.base).ServeHTTP(rr, req)
+			if rr.Code != tc.code || rr.Header().Get("Location") != tc.want {
+				t.Fatalf("got %d %q, want %d %q", rr.Code, rr.Header().Get("Location"), tc.code, tc.want)
+			}
+		})
+	}
+}
+
+func TestHSTSMiddleware_OnlyOverTLS(t *testing.T) {
+	h := hstsMiddleware(365*24*time.Hour, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://files.example.com/", nil))
+	if rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS must not be sent over plain HTTP")
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "https://files.example.com/", nil))
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("HSTS header: %q", got)
+	}
+}
+
+func TestTLS_ACME(t *testing.T) {
+	// A directory on a local stand-in CA with its own root, as with Pebble.
+	_, _, caCert := writeCert(t, t.TempDir(), 3)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := TLSConfig{
+		ACMEDomains:      []string{"files.example.com"},
+		ACMECacheDir:     t.TempDir(),
+		ACMEDirectoryURL: "https://127.0.0.1:14000/dir",
+		ACMECAFile:       caFile,
+	}
+	m, err := cfg.acmeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Client == nil || m.Client.DirectoryURL != cfg.ACMEDirectoryURL || m.Client.HTTPClient == nil {
+		t.Fatalf("ACME client not pointed at the configured directory: %+v", m.Client)
+	}
+
+	tc, redirect, err := cfg.serverTLS(discardLogger(), ":443", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(tc.NextProtos, "acme-tls/1") {
+		t.Errorf("tls-alpn-01 not offered: %v", tc.NextProtos)
+	}
+	// Only configured names are ever requested from the CA.
+	if _, err := tc.GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.example.com"}); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("unlisted host: %v", err)
+	}
+
+	// The redirect listener answers http-01 challenges and redirects the rest.
+	rr := httptest.NewRecorder()
+	redirect.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://files.example.com/.well-known/acme-challenge/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown challenge: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	redirect.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://files.example.com/", nil))
+	if rr.Code != http.StatusMovedPermanently {
+		t.Errorf("redirect: %d", rr.Code)
+	}
+
+	for _, bad := range []TLSConfig{
+		{ACMEDomains: []string{"files.example.com"}},
+		{ACMEDomains: []string{"files.example.com"}, ACMECacheDir: t.TempDir(), ACMECAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{ACMEDomains: []string{"files.example.com"}, CertFile: "cert.pem", KeyFile: "key.pem"},
+	} {
+		if _, _, err := bad.serverTLS(discardLogger(), ":443", ""); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+}
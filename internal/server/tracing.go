@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope reported on every span.
+const tracerName = "secure-file-drop/internal/server"
+
+// Trace exporters selectable with trace.exporter (SFD_TRACE_EXPORTER).
+const (
+	TraceExporterNone   = "none"
+	TraceExporterOTLP   = "otlp"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+)
+
+// tracePropagator reads and writes W3C trace context (traceparent,
+// tracestate) and baggage headers.
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// TracingConfig selects where spans are exported.
+//
+// Exporter is one of none (default), otlp, stdout or file. The OTLP
+// exporter speaks HTTP/protobuf and is configured with the standard
+// OTEL_EXPORTER_OTLP_* variables (endpoint, headers, insecure, ...).
+// File is the path the file exporter appends one JSON span per line to.
+// Sampling follows OTEL_TRACES_SAMPLER / OTEL_TRACES_SAMPLER_ARG.
+type TracingConfig struct {
+	Exporter    string
+	File        string
+	ServiceName string
+}
+
+// NewTracingConfig builds a TracingConfig for exporter (empty means none)
+// and file. An unknown exporter or a file exporter without a path is an
+// error.
+func NewTracingConfig(exporter, file string) (TracingConfig, error) {
+	tc := TracingConfig{
+		Exporter:    strings.ToLower(strings.TrimSpace(exporter)),
+		File:        strings.TrimSpace(file),
+		ServiceName: "secure-file-drop",
+	}
+	if tc.Exporter == "" {
+		tc.Exporter = TraceExporterNone
+	}
+	switch tc.Exporter {
+	case TraceExporterNone, TraceExporterOTLP, TraceExporterStdout:
+	case TraceExporterFile:
+		if tc.File == "" {
+			return TracingConfig{}, fmt.Errorf("trace exporter file requires a trace file path")
+		}
+	default:
+		return TracingConfig{}, fmt.Errorf("invalid trace exporter %q (want none, otlp, stdout or file)", tc.Exporter)
+	}
+	return tc, nil
+}
+
+// newTracerProvider builds the tracer provider for tc. The returned
+// shutdown function flushes buffered spans and releases the exporter; it
+// must be called once on server shutdown.
+func (tc TracingConfig) newTracerProvider(ctx context.Context, build BuildInfo) (trace.TracerProvider, func(context.Context) error, error) {
+	var (
+		exp     sdktrace.SpanExporter
+		closeFn func() error
+		err     error
+	)
+	switch tc.Exporter {
+	case "", TraceExporterNone:
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case TraceExporterOTLP:
+		exp, err = otlptracehttp.New(ctx)
+	case TraceExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case TraceExporterFile:
+		f, ferr := os.OpenFile(tc.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if ferr != nil {
+			return nil, nil, fmt.Errorf("open trace file: %w", ferr)
+		}
+		closeFn = f.Close
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", tc.Exporter)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("create %s trace exporter: %w", tc.Exporter, err)
+	}
+
+	// Attributes first so OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES win.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(tc.ServiceName),
+			semconv.ServiceVersion(build.Version),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	shutdown := func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closeFn != nil {
+			if cerr := closeFn(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+	return tp, shutdown, nil
+}
+
+// startSpan starts a child of the span in ctx using that span's tracer
+// provider, so helpers below the HTTP layer need no tracer of their own.
+// Without a span in ctx the no-op provider is used.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err (if any) on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceIDFromContext returns the hex trace id of the current span, or ""
+// when the request is not traced.
+func traceIDFromContext(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// tracingMiddleware continues the caller's W3C trace (or starts a new one)
+// and wraps the request in a server span named after the method and the
+// ServeMux pattern, like the metrics route label.
+func tracingMiddleware(tp trace.TracerProvider, mux *http.ServeMux, next http.Handler) http.Handler {
+	tracer := tp.Tracer(tracerName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		method := normaliseMethod(r.Method)
+
+		ctx, span := tracer.Start(ctx, method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		srw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(srw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(srw.status))
+		if srw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(srw.status))
+		}
+	})
+}
+
+// dbSpan starts a span for a single statement against table.
+func dbSpan(ctx context.Context, name, operation, table string) (context.Context, trace.Span) {
+	return startSpan(ctx, name,
+		semconv.DBSystemNamePostgreSQL,
+		semconv.DBOperationName(operation),
+		semconv.DBCollectionName(table),
+	)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestTracer() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	sr := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)), sr
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingMiddleware_ContinuesW3CTrace(t *testing.T) {
+	tp, sr := newTestTracer()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	h := tracingMiddleware(tp, mux, requestIDMiddleware(mux))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/files/abc", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "GET /files/" {
+		t.Errorf("span name %q", s.Name())
+	}
+	if got := s.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("trace id %s, want %s", got, traceID)
+	}
+	if got := s.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent span id %s", got)
+	}
+	if s.Status().Code != codes.Error {
+		t.Error("5xx responses should mark the span as an error")
+	}
+
+	// Without X-Request-Id the trace id doubles as the request id.
+	if rid := rr.Header().Get("X-Request-Id"); rid != traceID {
+		t.Errorf("X-Request-Id %q, want trace id", rid)
+	}
+	if v, ok := spanAttr(s, "sfd.request_id"); !ok || v.AsString() != traceID {
+		t.Errorf("sfd.request_id attribute = %v", v.AsString())
+	}
+}
+
+func TestTracingMiddleware_KeepsClientRequestID(t *testing.T) {
+	tp, sr := newTestTracer()
+	mux := http.NewServeMux()
+	h := tracingMiddleware(tp, mux, requestIDMiddleware(mux))
+
+	req := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	req.Header.Set("X-Request-Id", "client-rid")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rid := rr.Header().Get("X-Request-Id"); rid != "client-rid" {
+		t.Fatalf("X-Request-Id %q", rid)
+	}
+	s := sr.Ended()[0]
+	if s.Name() != "GET unmatched" {
+		t.Errorf("span name %q", s.Name())
+	}
+	if v, _ := spanAttr(s, "sfd.request_id"); v.AsString() != "client-rid" {
+		t.Errorf("sfd.request_id attribute = %q", v.AsString())
+	}
+}
+
+func TestRunHashTool_RecordsSpan(t *testing.T) {
+	tp, sr := newTestTracer()
+	setHashTool(t, filepath.Join(t.TempDir(), "missing-sfd-hash"))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	if _, err := runHashTool(ctx, "/dev/null"); err == nil {
+		t.Fatal("expected an error from a missing hash tool")
+	}
+	parent.End()
+
+	var exec sdktrace.ReadOnlySpan
+	for _, s := range sr.Ended() {
+		if s.Name() == "hash.exec" {
+			exec = s
+		}
+	}
+	if exec == nil {
+		t.Fatal("hash.exec span not recorded")
+	}
+	if exec.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("hash.exec should be a child of the caller's span")
+	}
+	if exec.Status().Code != codes.Error {
+		t.Error("failed exec should mark the span as an error")
+	}
+}
+
+func TestNewTracingConfig(t *testing.T) {
+	tc, err := NewTracingConfig("", "")
+	if err != nil || tc.Exporter != TraceExporterNone {
+		t.Fatalf("default: %+v, %v", tc, err)
+	}
+
+	if _, err := NewTracingConfig("zipkin", ""); err == nil {
+		t.Error("unknown exporter should be rejected")
+	}
+
+	if _, err := NewTracingConfig("file", ""); err == nil {
+		t.Error("file exporter without a trace file should be rejected")
+	}
+}
+
+func TestFileExporter_WritesSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	tc := TracingConfig{Exporter: TraceExporterFile, File: path, ServiceName: "sfd-test"}
+	tp, shutdown, err := tc.newTracerProvider(context.Background(), BuildInfo{Version: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tp.Tracer("test").Start(context.Background(), "upload.put_object")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Name":"upload.put_object"`) || !strings.Contains(string(b), "sfd-test") {
+		t.Fatalf("unexpected trace file contents: %s", b)
+	}
+}
@@ -5,14 +5,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // uploadResp is the JSON response returned after a successful file upload.
@@ -23,17 +23,6 @@ type uploadResp struct {
 	Status    string `json:"status"`
 }
 
-// maxUploadBytes reads the SFD_MAX_UPLOAD_BYTES environment variable and
-// returns the maximum allowed upload size in bytes. Returns 0 if not set
-// (meaning no limit). Returns an error if the value cannot be parsed.
-func maxUploadBytes() (int64, error) {
-	raw := os.Getenv("SFD_MAX_UPLOAD_BYTES")
-	if raw == "" {
-		return 0, nil // no limit configured
-	}
-	return strconv.ParseInt(raw, 10, 64)
-}
-
 // uploadHandler handles POST /upload?id={uuid} requests for streaming file uploads to MinIO.
 // It validates the file ID exists in the database with status "pending", reads the multipart
 // form data, streams it directly to MinIO, then updates the database status to "stored".
@@ -41,21 +30,18 @@ func maxUploadBytes() (int64, error) {
 //
 // Required query parameter: id (UUID of file record created via /files)
 // Required form field: file (the binary file data)
-// Authentication: Required (checked by requireAuth middleware)
+// Authentication: Required, scope files:write and a verified email (checked by middleware)
 func (cfg Config) uploadHandler(db *sql.DB, mc *minio.Client, bucket string) http.Handler {
-	return cfg.Auth.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return cfg.Auth.requireScope(ScopeFilesWrite, cfg.Auth.requireVerified(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Only accept POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		start := time.Now()
+		m := cfg.Registry
 
-		limit, err := maxUploadBytes()
-		if err != nil {
-			http.Error(w, "server misconfigured", http.StatusInternalServerError)
-			return
-		}
-		if limit > 0 {
+		if limit := cfg.maxUploadBytes(); limit > 0 {
 			r.Body = http.MaxBytesReader(w, r.Body, limit)
 		}
 
@@ -70,29 +56,58 @@ func (cfg Config) uploadHandler(db *sql.DB, mc *minio.Client, bucket string) htt
 			http.Error(w, "bad id", http.StatusBadRequest)
 			return
 		}
+		addLogAttrs(r.Context(), slog.String(logKeyFileID, id.String()))
 
-		var objectKey string
-		var status string
-		err = db.QueryRow(
-			`SELECT object_key, status FROM files WHERE id = $1`,
-			id,
-		).Scan(&objectKey, &status)
+		f, perm, err := fileAccess(r.Context(), db, PrincipalFromContext(r.Context()), id.String())
 		if err != nil {
-			if err == sql.ErrNoRows {
+			if err == errFileNotFound {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
+		if perm < permEdit {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		objectKey := f.ObjectKey
 
-		if status != "pending" {
+		if f.Status != "pending" {
 			http.Error(w, "invalid status", http.StatusConflict)
 			return
 		}
 
+		// Shutdown waits for registered uploads, up to its drain deadline.
+		uploadCtx, uploadDone, ok := cfg.uploads.begin(r.Context())
+		if !ok {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer uploadDone()
+
+		// From here on the request is a genuine upload attempt: any early
+		// return counts as an upload error.
+		succeeded := false
+		defer func() {
+			if !succeeded {
+				m.RecordUploadError()
+			}
+		}()
+
+		var policy OrgPolicy
+		if f.OrgID != "" {
+			if policy, err = loadOrgPolicy(r.Context(), db, f.OrgID); err != nil {
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_, parseSpan := startSpan(r.Context(), "upload.multipart")
 		mr, err := r.MultipartReader()
 		if err != nil {
+			endSpan(parseSpan, err)
 			http.Error(w, "bad multipart", http.StatusBadRequest)
 			return
 		}
@@ -106,6 +121,7 @@ func (cfg Config) uploadHandler(db *sql.DB, mc *minio.Client, bucket string) htt
 				break
 			}
 			if err != nil {
+				endSpan(parseSpan, err)
 				http.Error(w, "bad multipart", http.StatusBadRequest)
 				return
 			}
@@ -119,32 +135,65 @@ func (cfg Config) uploadHandler(db *sql.DB, mc *minio.Client, bucket string) htt
 			contentType = part.Header.Get("Content-Type")
 			break
 		}
+		parseSpan.SetAttributes(attribute.Bool("sfd.upload.file_part", filePart != nil))
+		endSpan(parseSpan, nil)
 
 		if filePart == nil {
 			http.Error(w, "missing file", http.StatusBadRequest)
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+		// Enforce the org allowlist on the type actually sent, falling back
+		// to the type declared when the record was created.
+		effectiveType := contentType
+		if effectiveType == "" {
+			effectiveType = f.ContentType
+		}
+		if !contentTypeAllowed(policy.AllowedContentTypes, effectiveType) {
+			http.Error(w, "content type not allowed", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(uploadCtx, 5*time.Minute)
 		defer cancel()
 
-		_, err = mc.PutObject(
-			ctx,
+		putCtx, putSpan := startSpan(ctx, "upload.put_object",
+			attribute.String("sfd.file_id", id.String()),
+			attribute.String("sfd.bucket", bucket),
+			attribute.String("sfd.object_key", objectKey),
+		)
+		info, err := mc.PutObject(
+			putCtx,
 			bucket,
 			objectKey,
 			filePart,
 			-1,
 			minio.PutObjectOptions{ContentType: contentType},
 		)
+		putSpan.SetAttributes(attribute.Int64("sfd.object_size", info.Size))
+		endSpan(putSpan, err)
+		if err != nil && uploadInterrupted(ctx) {
+			// Still pending: the client can upload again once the
+			// service is back, or cleanup removes the row.
+			_, _ = execFilesUpdate(ctx, db, "db.files.mark_interrupted",
+				`UPDATE files SET interrupted_at = now() WHERE id = $1 AND status = 'pending'`,
+				id,
+			)
+			logFor(r.Context(), "upload").Warn("upload_interrupted", slog.String("stage", "put_object"))
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "upload interrupted by shutdown; retry", http.StatusServiceUnavailable)
+			return
+		}
 		if err != nil {
 			// Mark the file as failed in case of storage errors.
-			_, _ = db.Exec(
+			if res, err := execFilesUpdate(ctx, db, "db.files.mark_failed",
 				`UPDATE files SET status = 'failed' WHERE id = $1 AND status = 'pending'`,
 				id,
-			)
+			); err == nil {
+				recordTransition(m, res, "failed")
+			}
 
-			rid := RequestIDFromContext(r.Context())
-			log.Printf("rid=%s msg=putobject err=%v", rid, err)
+			logFor(r.Context(), "upload").Error("put_object_failed", errAttr(err))
 
 			// If MaxBytesReader tripped, surface 413.
 			if r.Body != nil {
@@ -158,39 +207,66 @@ func (cfg Config) uploadHandler(db *sql.DB, mc *minio.Client, bucket string) htt
 			return
 		}
 
-		_, err = db.Exec(
-			`UPDATE files SET status = 'stored' WHERE id = $1 AND status = 'pending'`,
+		res, err := execFilesUpdate(ctx, db, "db.files.mark_stored",
+			`UPDATE files SET status = 'stored', interrupted_at = NULL WHERE id = $1 AND status = 'pending'`,
 			id,
 		)
 		if err != nil {
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
+		recordTransition(m, res, "stored")
 
+		hashStart := time.Now()
 		shaHex, _, hashBytes, herr := sha256FromMinioObject(ctx, mc, bucket, objectKey)
+		m.RecordHash(time.Since(hashStart))
+		if herr != nil && uploadInterrupted(ctx) {
+			// The object is complete; hashing resumes when the server
+			// starts again (resumeInterruptedUploads).
+			_, _ = execFilesUpdate(ctx, db, "db.files.mark_interrupted",
+				`UPDATE files SET interrupted_at = now() WHERE id = $1 AND status = 'stored'`,
+				id,
+			)
+			succeeded = true
+			logFor(r.Context(), "upload").Warn("upload_interrupted", slog.String("stage", "hash"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(uploadResp{
+				ID:        id.String(),
+				ObjectKey: objectKey,
+				Status:    "stored",
+			})
+			return
+		}
 		if herr != nil {
-			_, _ = db.Exec(
+			if res, err := execFilesUpdate(ctx, db, "db.files.mark_failed",
 				`UPDATE files SET status = 'failed' WHERE id = $1 AND status = 'stored'`,
 				id,
-			)
-			rid := RequestIDFromContext(r.Context())
-			log.Printf("rid=%s msg=hashing_failed err=%v", rid, herr)
+			); err == nil {
+				recordTransition(m, res, "failed")
+			}
+			logFor(r.Context(), "upload").Error("hashing_failed", errAttr(herr))
 			http.Error(w, "hashing failed", http.StatusBadGateway)
 			return
 		}
 
-		_, err = db.Exec(
-			`UPDATE files SET sha256_hex = $2, sha256_bytes = $3, status = 'hashed' WHERE id = $1 AND status = 'stored'`,
-			id,
-			shaHex,
-			hashBytes,
-		)
+		// Org retention starts counting once the content is in place; the
+		// cleanup job removes the file after expires_at.
+		res, err = markHashed(ctx, db, id.String(), shaHex, hashBytes, policy.RetentionDays)
 		if err != nil {
-			rid := RequestIDFromContext(r.Context())
-			log.Printf("rid=%s msg=db_update_hash err=%v", rid, err)
+			logFor(r.Context(), "upload").Error("db_update_hash_failed", errAttr(err))
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
+		recordTransition(m, res, "hashed")
+		succeeded = true
+		m.RecordUpload(int64(hashBytes), time.Since(start))
+		recordAudit(r.Context(), "file_uploaded", map[string]string{
+			"file_id": id.String(),
+			"org_id":  f.OrgID,
+			"bytes":   strconv.FormatUint(hashBytes, 10),
+			"sha256":  shaHex,
+		})
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -199,5 +275,157 @@ func (cfg Config) uploadHandler(db *sql.DB, mc *minio.Client, bucket string) htt
 			ObjectKey: objectKey,
 			Status:    "hashed",
 		})
-	}))
+	})))
+}
+
+// markHashed records the hash of a stored file and starts its org
+// retention.
+func markHashed(ctx context.Context, db execer, id, shaHex string, size uint64, retentionDays int) (sql.Result, error) {
+	return execFilesUpdate(ctx, db, "db.files.mark_hashed",
+		`UPDATE files
+		 SET sha256_hex = $2, sha256_bytes = $3, status = 'hashed', interrupted_at = NULL,
+		     expires_at = CASE WHEN $4::int > 0 THEN now() + make_interval(days => $4::int) ELSE expires_at END
+		 WHERE id = $1 AND status = 'stored'`,
+		id,
+		shaHex,
+		size,
+		retentionDays,
+	)
+}
+
+// resumeInterruptedUploads hashes the files whose upload was interrupted
+// by a shutdown after the object was stored. It runs at startup; replicas
+// starting together claim files with FOR UPDATE SKIP LOCKED, so each is
+// hashed once. A file that still fails to hash is marked failed for
+// cleanup. Runs that found files are recorded in job_runs.
+func resumeInterruptedUploads(ctx context.Context, db *sql.DB, mc *minio.Client, bucket string, m *Metrics) {
+	var (
+		run               *JobRun
+		processed, failed int
+		after             resumeCursor
+	)
+	for {
+		ok, hashed, err := resumeNext(ctx, db, mc, bucket, m, &after)
+		if err != nil {
+			if ctx.Err() == nil {
+				logFor(ctx, "upload").Error("resume_failed", errAttr(err))
+			}
+			run.finish(ctx, db, processed, failed, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		if run == nil {
+			run = startJobRun(ctx, db, "resume_uploads", "startup")
+		}
+		if hashed {
+			processed++
+		} else {
+			failed++
+		}
+	}
+	run.finish(ctx, db, processed, failed, nil)
+}
+
+// resumeCursor is the keyset position of resumeNext.
+type resumeCursor struct {
+	interruptedAt time.Time
+	id            string
+}
+
+// resumeNext claims the next interrupted stored file after the cursor and
+// hashes it, holding the row lock until its status is updated. ok is false
+// when none is left; hashed is false when the file was marked failed.
+func resumeNext(ctx context.Context, db *sql.DB, mc *minio.Client, bucket string, m *Metrics, after *resumeCursor) (ok, hashed bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var objectKey, orgID string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, object_key, COALESCE(org_id::text, ''), interrupted_at
+		FROM files
+		WHERE status = 'stored' AND interrupted_at IS NOT NULL
+		  AND (interrupted_at, id::text) > ($1, $2)
+		ORDER BY interrupted_at, id::text
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`,
+		after.interruptedAt, after.id,
+	).Scan(&after.id, &objectKey, &orgID, &after.interruptedAt)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	fileLog := logFor(ctx, "upload").With(logKeyFileID, after.id)
+	var policy OrgPolicy
+	if orgID != "" {
+		if policy, err = loadOrgPolicy(ctx, tx, orgID); err != nil {
+			return false, false, err
+		}
+	}
+	hashStart := time.Now()
+	shaHex, _, size, herr := sha256FromMinioObject(ctx, mc, bucket, objectKey)
+	m.RecordHash(time.Since(hashStart))
+	if herr != nil {
+		if ctx.Err() != nil {
+			return false, false, ctx.Err() // still interrupted; the next start picks it up
+		}
+		res, err := execFilesUpdate(ctx, tx, "db.files.mark_failed",
+			`UPDATE files SET status = 'failed' WHERE id = $1 AND status = 'stored'`,
+			after.id,
+		)
+		if err != nil {
+			return false, false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return false, false, err
+		}
+		recordTransition(m, res, "failed")
+		fileLog.Error("resume_hashing_failed", errAttr(herr))
+		return true, false, nil
+	}
+	res, err := markHashed(ctx, tx, after.id, shaHex, size, policy.RetentionDays)
+	if err != nil {
+		return false, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, false, err
+	}
+	recordTransition(m, res, "hashed")
+	fileLog.Info("upload_resumed")
+	recordAudit(ctx, "file_upload_resumed", map[string]string{
+		"actor":   "system",
+		"file_id": after.id,
+		"org_id":  orgID,
+		"bytes":   strconv.FormatUint(size, 10),
+		"sha256":  shaHex,
+	})
+	return true, true, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// execFilesUpdate runs a status UPDATE on files inside a span named name.
+func execFilesUpdate(ctx context.Context, db execer, name, query string, args ...any) (sql.Result, error) {
+	_, span := dbSpan(ctx, name, "UPDATE", "files")
+	res, err := db.Exec(query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+// recordTransition counts a status change when the guarded UPDATE res
+// actually changed a row.
+func recordTransition(m *Metrics, res sql.Result, status string) {
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		m.RecordFileStateTransition(status)
+	}
 }
@@ -75,50 +75,6 @@ func TestUploadHandler_InvalidUUID(t *testing.T) {
 	}
 }
 
-func TestUploadHandler_MaxBytesValidation(t *testing.T) {
-	tests := []struct {
-		name        string
-		envValue    string
-		shouldError bool
-	}{
-		{
-			name:        "valid limit",
-			envValue:    "1048576",
-			shouldError: false,
-		},
-		{
-			name:        "empty value (no limit)",
-			envValue:    "",
-			shouldError: false,
-		},
-		{
-			name:        "invalid format",
-			envValue:    "not-a-number",
-			shouldError: true,
-		},
-		{
-			name:        "negative value",
-			envValue:    "-1",
-			shouldError: false, // parsed successfully, but semantically questionable
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			t.Setenv("SFD_MAX_UPLOAD_BYTES", tt.envValue)
-
-			_, err := maxUploadBytes()
-
-			if tt.shouldError && err == nil {
-				t.Errorf("Expected error for %s, got nil", tt.name)
-			}
-			if !tt.shouldError && err != nil {
-				t.Errorf("Expected no error for %s, got %v", tt.name, err)
-			}
-		})
-	}
-}
-
 func TestUploadHandler_MultipartParsing(t *testing.T) {
 	// Create a multipart body with a file part
 	body := &bytes.Buffer{}
@@ -208,32 +164,6 @@ func TestUploadHandler_StatusValidation(t *testing.T) {
 	}
 }
 
-func TestMaxUploadBytes(t *testing.T) {
-	t.Setenv("SFD_MAX_UPLOAD_BYTES", "5242880")
-
-	limit, err := maxUploadBytes()
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if limit != 5242880 {
-		t.Errorf("Expected limit 5242880, got %d", limit)
-	}
-}
-
-func TestMaxUploadBytes_NotSet(t *testing.T) {
-	t.Setenv("SFD_MAX_UPLOAD_BYTES", "")
-
-	limit, err := maxUploadBytes()
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-
-	if limit != 0 {
-		t.Errorf("Expected limit 0 (no limit), got %d", limit)
-	}
-}
-
 // Mock DB for integration-style test (without actual DB)
 type mockDB struct {
 	queryRowFunc func(query string, args ...any) *sql.Row
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Cache policies: the index is revalidated on every load so a new release
+// shows up at once; assets are not fingerprinted, so they are cached
+// briefly and then revalidated by ETag.
+const (
+	webIndexCacheControl = "no-cache"
+	webAssetCacheControl = "public, max-age=3600, must-revalidate"
+)
+
+// webCompressMin is the smallest asset worth compressing at load time.
+const webCompressMin = 1024
+
+// webEncodings are the precompressed variants looked up beside each file,
+// in order of preference.
+var webEncodings = []struct{ name, suffix string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// webAsset is one UI file with its precompressed variants.
+type webAsset struct {
+	contentType string
+	etag        string
+	modTime     time.Time
+	body        []byte
+	variants    []webVariant // in webEncodings order
+}
+
+type webVariant struct {
+	encoding string
+	etag     string
+	body     []byte
+}
+
+// webUI serves the files of an fs.FS. Embedded files are loaded, hashed
+// and compressed once; a live file system (SFD_WEB_DIR) is read on every
+// request so edits show up without a restart.
+type webUI struct {
+	fsys   fs.FS
+	live   bool
+	assets map[string]*webAsset
+}
+
+// newWebUI loads the UI in fsys, which must contain index.html.
+func newWebUI(fsys fs.FS, live bool) (*webUI, error) {
+	u := &webUI{fsys: fsys, live: live, assets: map[string]*webAsset{}}
+	if _, err := fs.Stat(fsys, "index.html"); err != nil {
+		return nil, err
+	}
+	if live {
+		return u, nil
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isWebVariant(name) {
+			return err
+		}
+		a, err := loadWebAsset(fsys, name)
+		if err != nil {
+			return err
+		}
+		u.assets[name] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func isWebVariant(name string) bool {
+	for _, e := range webEncodings {
+		if strings.HasSuffix(name, e.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadWebAsset reads name and its variants. Without a precompressed
+// variant, compressible assets get a gzip variant built here.
+func loadWebAsset(fsys fs.FS, name string) (*webAsset, error) {
+	body, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	a := &webAsset{body: body, etag: webETag(body, "")}
+	if st, err := fs.Stat(fsys, name); err == nil {
+		a.modTime = st.ModTime()
+	}
+	a.contentType = mime.TypeByExtension(path.Ext(name))
+	if a.contentType == "" {
+		a.contentType = http.DetectContentType(body)
+	}
+
+	for _, e := range webEncodings {
+		vb, err := fs.ReadFile(fsys, name+e.suffix)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.variants = append(a.variants, webVariant{encoding: e.name, etag: webETag(body, e.name), body: vb})
+	}
+	if len(a.variants) == 0 && len(body) >= webCompressMin && compressible(a.contentType) {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		_, _ = zw.Write(body)
+		if err := zw.Close(); err == nil && buf.Len() < len(body) {
+			a.variants = append(a.variants, webVariant{encoding: "gzip", etag: webETag(body, "gzip"), body: buf.Bytes()})
+		}
+	}
+	return a, nil
+}
+
+// webETag is a strong ETag over the uncompressed content; each encoding
+// gets its own, as the bytes sent differ.
+func webETag(body []byte, encoding string) string {
+	sum := sha256.Sum256(body)
+	tag := hex.EncodeToString(sum[:8])
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return `"` + tag + `"`
+}
+
+func compressible(contentType string) bool {
+	ct, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(ct, "text/"),
+		ct == "application/javascript", ct == "application/json",
+		ct == "image/svg+xml", ct == "application/manifest+json":
+		return true
+	}
+	return false
+}
+
+// asset returns the named file; directories and missing files are
+// fs.ErrNotExist.
+func (u *webUI) asset(name string) (*webAsset, error) {
+	if !fs.ValidPath(name) || name == "." || isWebVariant(name) {
+		return nil, fs.ErrNotExist
+	}
+	if !u.live {
+		a, ok := u.assets[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return a, nil
+	}
+	if st, err := fs.Stat(u.fsys, name); err != nil || st.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	return loadWebAsset(u.fsys, name)
+}
+
+// indexHandler serves index.html at /.
+func (u *webUI) indexHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		u.serve(w, r, "index.html", webIndexCacheControl)
+	})
+}
+
+// staticHandler serves the files under /static/.
+func (u *webUI) staticHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/static/")
+		cacheControl := webAssetCacheControl
+		if name == "index.html" {
+			cacheControl = webIndexCacheControl
+		}
+		u.serve(w, r, name, cacheControl)
+	})
+}
+
+func (u *webUI) serve(w http.ResponseWriter, r *http.Request, name, cacheControl string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a, err := u.asset(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logFor(r.Context(), "web").Error("asset_read_failed", errAttr(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", a.contentType)
+	h.Set("Cache-Control", cacheControl)
+	body, etag := a.body, a.etag
+	if len(a.variants) > 0 {
+		h.Add("Vary", "Accept-Encoding")
+		accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+		for _, v := range a.variants {
+			if accepted[v.encoding] {
+				h.Set("Content-Encoding", v.encoding)
+				body, etag = v.body, v.etag
+				break
+			}
+		}
+	}
+	h.Set("ETag", etag)
+	// ServeContent answers If-None-Match, ranges and HEAD.
+	http.ServeContent(w, r, "", a.modTime, bytes.NewReader(body))
+}
+
+// acceptedEncodings parses Accept-Encoding, leaving out codings with q=0.
+func acceptedEncodings(header string) map[string]bool {
+	out := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding == "" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok && strings.Trim(q, "0.") == "" {
+			continue
+		}
+		out[strings.ToLower(coding)] = true
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestWebUI(t *testing.T) *webUI {
+	t.Helper()
+	u, err := newWebUI(fstest.MapFS{
+		"index.html":     {Data: []byte("<!doctype html>" + strings.Repeat("<p>hello</p>", 200))},
+		"app.js":         {Data: []byte(strings.Repeat("console.log(1);", 100))},
+		"app.js.br":      {Data: []byte("brotli bytes")},
+		"logo.png":       {Data: []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("x", 2000))},
+		"small.css":      {Data: []byte("body{}")},
+		"img/.gitignore": {Data: []byte("")},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func webGet(h http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWebUI_Index(t *testing.T) {
+	u := newTestWebUI(t)
+	rr := webGet(u.indexHandler(), "/", nil)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Body.String(), "<!doctype html>") {
+		t.Fatalf("GET /: %d %.40q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Cache-Control"); got != webIndexCacheControl {
+		t.Errorf("Cache-Control %q", got)
+	}
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type %q", got)
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" || rr.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("identity response: ETag %q, Content-Encoding %q", etag, rr.Header().Get("Content-Encoding"))
+	}
+
+	if rr := webGet(u.indexHandler(), "/", map[string]string{"If-None-Match": etag}); rr.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: %d", rr.Code)
+	}
+	if rr := webGet(u.indexHandler(), "/nope", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("GET /nope: %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rr = httptest.NewRecorder()
+	u.indexHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /: %d", rr.Code)
+	}
+}
+
+func TestWebUI_Variants(t *testing.T) {
+	u := newTestWebUI(t)
+	h := u.staticHandler()
+
+	// Compressed at load time.
+	rr := webGet(h, "/static/index.html", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("headers: %v", rr.Header())
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if !strings.HasPrefix(string(body), "<!doctype html>") {
+		t.Errorf("gzip body %.40q", body)
+	}
+	identity := webGet(h, "/static/index.html", nil).Header().Get("ETag")
+	if etag := rr.Header().Get("ETag"); etag == identity {
+		t.Error("gzip variant shares the identity ETag")
+	}
+
+	// A precompressed variant is preferred; q=0 refuses a coding.
+	rr = webGet(h, "/static/app.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	if rr.Header().Get("Content-Encoding") != "br" || rr.Body.String() != "brotli bytes" {
+		t.Errorf("br variant: %q %q", rr.Header().Get("Content-Encoding"), rr.Body.String())
+	}
+	if got := rr.Header().Get("Cache-Control"); got != webAssetCacheControl {
+		t.Errorf("asset Cache-Control %q", got)
+	}
+	rr = webGet(h, "/static/app.js", map[string]string{"Accept-Encoding": "br;q=0"})
+	if rr.Header().Get("Content-Encoding") != "" || !strings.HasPrefix(rr.Body.String(), "console.log") {
+		t.Errorf("br;q=0 served %q", rr.Header().Get("Content-Encoding"))
+	}
+
+	// Images and small files are not compressed.
+	for _, p := range []string{"/static/logo.png", "/static/small.css"} {
+		rr = webGet(h, p, map[string]string{"Accept-Encoding": "gzip"})
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Vary") != "" {
+			t.Errorf("%s: %d %v", p, rr.Code, rr.Header())
+		}
+	}
+
+	// Variants, directories and unknown files are not served.
+	for _, p := range []string{"/static/app.js.br", "/static/img", "/static/", "/static/missing.js", "/static/../index.html"} {
+		if rr := webGet(h, p, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", p, rr.Code)
+		}
+	}
+}
+
+func TestWebUI_LiveDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := newWebUI(os.DirFS(dir), true); err == nil {
+		t.Fatal("directory without index.html accepted")
+	}
+	index := filepath.Join(dir, "index.html")
+	if err := os.WriteFile(index, []byte("v1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	u, err := newWebUI(os.DirFS(dir), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := webGet(u.indexHandler(), "/", nil)
+	if err := os.WriteFile(index, []byte("v2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	second := webGet(u.indexHandler(), "/", nil)
+	if second.Body.String() != "v2" || first.Header().Get("ETag") == second.Header().Get("ETag") {
+		t.Errorf("edit not picked up: %q, ETags %s %s", second.Body.String(), first.Header().Get("ETag"), second.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,20 @@
+// Package web embeds the browser UI served by the backend.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static
+var files embed.FS
+
+// Static returns the UI files: index.html and the assets beside it,
+// including any precompressed .br or .gz variants.
+func Static() fs.FS {
+	sub, err := fs.Sub(files, "static")
+	if err != nil {
+		panic(err) // "static" is a valid path; fs.Sub cannot fail
+	}
+	return sub
+}
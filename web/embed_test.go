@@ -0,0 +1,13 @@
+package web
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestStatic_EmbedsIndex(t *testing.T) {
+	b, err := fs.ReadFile(Static(), "index.html")
+	if err != nil || len(b) == 0 {
+		t.Fatalf("index.html not embedded: %v", err)
+	}
+}